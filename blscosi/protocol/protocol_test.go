@@ -11,8 +11,10 @@ import (
 	"go.dedis.ch/cothority/v3"
 	"go.dedis.ch/kyber/v3/pairing"
 	"go.dedis.ch/kyber/v3/sign"
+	"go.dedis.ch/kyber/v3/sign/bls"
 	"go.dedis.ch/onet/v3"
 	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
 )
 
 const FailureProtocolName = "FailureProtocol"
@@ -95,6 +97,49 @@ func TestProtocol_25_5(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestProtocol_ResponseObserver(t *testing.T) {
+	local := onet.NewLocalTest(cothority.Suite)
+	defer local.CloseAll()
+	servers, _, tree := local.GenTree(5, false)
+
+	signers := make(chan *network.ServerIdentity, len(servers))
+	for _, srv := range servers {
+		RegisterResponseObserver(srv.ServerIdentity.ID,
+			func(signer *network.ServerIdentity, msg, data, sig []byte) {
+				public := signer.ServicePublic(testServiceName)
+				require.NoError(t, bls.Verify(testSuite, public, msg, sig))
+				signers <- signer
+			})
+		defer RegisterResponseObserver(srv.ServerIdentity.ID, nil)
+	}
+
+	services := local.GetServices(servers, testServiceID)
+	rootService := services[0].(*testService)
+	pi, err := rootService.CreateProtocol(DefaultProtocolName, tree)
+	require.NoError(t, err)
+	cosiProtocol := pi.(*BlsCosi)
+	cosiProtocol.CreateProtocol = rootService.CreateProtocol
+	cosiProtocol.Msg = []byte{0xFF}
+	cosiProtocol.Timeout = testTimeout
+	cosiProtocol.Threshold = len(servers)
+	require.NoError(t, cosiProtocol.SetNbrSubTree(1))
+	require.NoError(t, cosiProtocol.Start())
+	_, err = getAndVerifySignature(cosiProtocol, cosiProtocol.Msg,
+		sign.NewThresholdPolicy(len(servers)))
+	require.NoError(t, err)
+
+	// The root and the sub-leader aggregate their own signature, the
+	// sub-leader observes the ones of the three leaves.
+	for i := 0; i < 3; i++ {
+		select {
+		case <-signers:
+		case <-time.After(testTimeout):
+			t.Fatal("missing response")
+		}
+	}
+	require.Len(t, signers, 0)
+}
+
 func runProtocol(nbrNodes, nbrSubTrees, threshold int) (BlsSignature, *onet.Roster, error) {
 	local := onet.NewLocalTest(cothority.Suite)
 	defer local.CloseAll()
@@ -12,6 +12,7 @@ import (
 	"go.dedis.ch/kyber/v3/sign/bls"
 	"go.dedis.ch/onet/v3"
 	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
 )
 
 // sub_protocol is run by each sub-leader and each node once, and n times by
@@ -46,6 +47,43 @@ type SubBlsCosi struct {
 	Aggregate AggregateFn
 }
 
+// ResponseObserver is called by a sub-leader with the individual signature of
+// a child, once it has been verified against the message of the
+// announcement. It must not block the protocol.
+type ResponseObserver func(signer *network.ServerIdentity, msg, data,
+	sig []byte)
+
+var responseObservers = struct {
+	sync.Mutex
+	m map[network.ServerIdentityID]ResponseObserver
+}{m: make(map[network.ServerIdentityID]ResponseObserver)}
+
+// RegisterResponseObserver sets the observer of the responses verified by
+// the node with the given identity, for all the protocols it runs. A nil
+// observer removes it.
+func RegisterResponseObserver(id network.ServerIdentityID,
+	obs ResponseObserver) {
+	responseObservers.Lock()
+	defer responseObservers.Unlock()
+	if obs == nil {
+		delete(responseObservers.m, id)
+		return
+	}
+	responseObservers.m[id] = obs
+}
+
+// observeResponse passes a verified response to the observer of the node,
+// if any.
+func (p *SubBlsCosi) observeResponse(signer *network.ServerIdentity,
+	sig []byte) {
+	responseObservers.Lock()
+	obs := responseObservers.m[p.ServerIdentity().ID]
+	responseObservers.Unlock()
+	if obs != nil {
+		obs(signer, p.Msg, p.Data, sig)
+	}
+}
+
 // NewDefaultSubProtocol is the default sub-protocol function used for registration
 // with an always-true verification.
 func NewDefaultSubProtocol(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
@@ -281,6 +319,7 @@ func (p *SubBlsCosi) dispatchSubLeader() error {
 					if err := p.Verify(p.suite, public, p.Msg, reply.Signature); err == nil {
 						responses[pubIndex] = &reply.Response
 						done++
+						p.observeResponse(reply.ServerIdentity, []byte(reply.Signature))
 					}
 				} else {
 					log.Warnf("Duplicate message from %v", reply.ServerIdentity)
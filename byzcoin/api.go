@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin/trie"
 	"go.dedis.ch/cothority/v3/darc"
 	"go.dedis.ch/cothority/v3/darc/expression"
 	"go.dedis.ch/cothority/v3/skipchain"
@@ -37,6 +38,8 @@ type Client struct {
 	noncesSI map[uint64]*network.ServerIdentity
 	// Used for SendProtobufParallel. If it is nil, default values will be used.
 	options *onet.ParallelOptions
+	// anchorPath is the file holding the trust anchor of a light client.
+	anchorPath string
 }
 
 // NewClient instantiates a new ByzCoin client.
@@ -203,8 +206,8 @@ func (c *Client) AddTransactionAndWait(tx ClientTransaction, wait int) (*AddTxRe
 			return reply, xerrors.Errorf("proof verification: %v", err)
 		}
 
-		if c.Latest == nil || c.Latest.Index < reply.Proof.Latest.Index {
-			c.Latest = &reply.Proof.Latest
+		if err := c.setLatest(&reply.Proof.Latest); err != nil {
+			return reply, xerrors.Errorf("storing latest block: %v", err)
 		}
 	}
 
@@ -274,6 +277,104 @@ func (c *Client) GetProofAfter(key []byte, full bool, block *skipchain.SkipBlock
 	return rep, cothority.ErrorOrNil(err, "request failed")
 }
 
+// GetProofAt returns a proof for the key in the state of the chain after
+// the block with the given index, starting from the genesis block. The proof
+// can prove the existence or the absence of the key at that time. Note that
+// the integrity of the proof is verified, and that the latest block of the
+// proof is the requested block.
+func (c *Client) GetProofAt(key []byte, index int) (*GetProofResponse, error) {
+	if c.Genesis == nil {
+		if err := c.fetchGenesis(); err != nil {
+			return nil, xerrors.Errorf("fetching genesis block: %v", err)
+		}
+	}
+
+	decoder := func(buf []byte, msg interface{}) error {
+		err := protobuf.Decode(buf, msg)
+		if err != nil {
+			return xerrors.Errorf("decoding: %+v", err)
+		}
+
+		gpr, ok := msg.(*GetProofResponse)
+		if !ok {
+			return xerrors.New("couldn't cast msg")
+		}
+
+		if err := gpr.Proof.VerifyFromBlock(c.Genesis); err != nil {
+			return xerrors.Errorf("proof verification: %+v", err)
+		}
+
+		if gpr.Proof.Latest.Index != index {
+			return xerrors.New("proof is not for the requested block")
+		}
+
+		return nil
+	}
+
+	req := &GetProofAt{
+		Version: CurrentVersion,
+		Key:     key,
+		ID:      c.Genesis.Hash,
+		Index:   index,
+	}
+	reply := &GetProofResponse{}
+	_, err := c.SendProtobufParallelWithDecoder(c.Roster.List, req, reply, c.options, decoder)
+	if err != nil {
+		return nil, xerrors.Errorf("sending: %+v", err)
+	}
+	return reply, nil
+}
+
+// GetInstanceHistory returns all the versions of the instance stored by the
+// nodes, and verifies them against the genesis block. The history must end
+// with the current state of the instance in a block at least as recent as
+// the latest block known to the client.
+func (c *Client) GetInstanceHistory(iid InstanceID) (*GetInstanceHistoryResponse, error) {
+	if c.Genesis == nil {
+		if err := c.fetchGenesis(); err != nil {
+			return nil, xerrors.Errorf("fetching genesis block: %v", err)
+		}
+	}
+	latest := c.getLatestKnownBlock()
+
+	decoder := func(buf []byte, msg interface{}) error {
+		err := protobuf.Decode(buf, msg)
+		if err != nil {
+			return xerrors.Errorf("decoding: %+v", err)
+		}
+
+		rep, ok := msg.(*GetInstanceHistoryResponse)
+		if !ok {
+			return xerrors.New("couldn't cast msg")
+		}
+
+		if err := rep.Verify(c.Genesis, iid); err != nil {
+			return xerrors.Errorf("history verification: %+v", err)
+		}
+
+		if rep.Proof.Latest.Index < latest.Index {
+			return xerrors.New("latest block in proof is too old")
+		}
+
+		return nil
+	}
+
+	req := &GetInstanceHistory{
+		SkipChainID: c.Genesis.SkipChainID(),
+		InstanceID:  iid,
+	}
+	reply := &GetInstanceHistoryResponse{}
+	_, err := c.SendProtobufParallelWithDecoder(c.Roster.List, req, reply, c.options, decoder)
+	if err != nil {
+		return nil, xerrors.Errorf("sending: %+v", err)
+	}
+
+	if err := c.setLatest(&reply.Proof.Latest); err != nil {
+		return nil, xerrors.Errorf("storing latest block: %v", err)
+	}
+	return reply, nil
+}
+
 // GetUpdates returns only new proofs.
 // The client sends a list of instances/version pairs,
 // and the server returns only proofs for the instances that have been
@@ -284,6 +385,10 @@ func (c *Client) GetProofAfter(key []byte, full bool, block *skipchain.SkipBlock
 func (c *Client) GetUpdates(keyVer []IDVersion, flags GetUpdatesFlags,
 	latest skipchain.SkipBlockID) (rep *GetUpdatesReply,
 	err error) {
+	if c.isLight() {
+		return c.getUpdatesLight(keyVer, flags)
+	}
+
 	rep = &GetUpdatesReply{}
 	if latest == nil {
 		if c.Latest == nil {
@@ -304,6 +409,11 @@ func (c *Client) GetUpdates(keyVer []IDVersion, flags GetUpdatesFlags,
 }
 
 func (c *Client) getProofRaw(key []byte, from, include *skipchain.SkipBlock) (*GetProofResponse, error) {
+	if include == nil && c.isLight() {
+		// A light client never goes back to an older block.
+		include = c.Latest
+	}
+
 	decoder := func(buf []byte, msg interface{}) error {
 		err := protobuf.Decode(buf, msg)
 		if err != nil {
@@ -342,13 +452,148 @@ func (c *Client) getProofRaw(key []byte, from, include *skipchain.SkipBlock) (*G
 		return nil, xerrors.Errorf("sending: %+v", err)
 	}
 
-	if c.Latest == nil || c.Latest.Index < reply.Proof.Latest.Index {
-		c.Latest = &reply.Proof.Latest
+	if err := c.setLatest(&reply.Proof.Latest); err != nil {
+		return nil, xerrors.Errorf("storing latest block: %v", err)
+	}
+
+	return reply, nil
+}
+
+// GetProofs returns a single proof for all the keys, starting from the
+// genesis block. The proof can prove the existence or the absence of each
+// key, and the standalone proof of a key is given by MultiProof.Proof. Note
+// that the integrity of the proof is verified.
+func (c *Client) GetProofs(keys ...[]byte) (*GetProofsResponse, error) {
+	if c.Genesis == nil {
+		if err := c.fetchGenesis(); err != nil {
+			return nil, xerrors.Errorf("fetching genesis block: %v", err)
+		}
+	}
+	var include *skipchain.SkipBlock
+	if c.isLight() {
+		// A light client never goes back to an older block.
+		include = c.Latest
+	}
+
+	decoder := func(buf []byte, msg interface{}) error {
+		err := protobuf.Decode(buf, msg)
+		if err != nil {
+			return xerrors.Errorf("decoding: %+v", err)
+		}
+
+		rep, ok := msg.(*GetProofsResponse)
+		if !ok {
+			return xerrors.New("couldn't cast msg")
+		}
+
+		if err := rep.Proofs.VerifyFromBlock(c.Genesis); err != nil {
+			return xerrors.Errorf("proof verification: %+v", err)
+		}
+
+		if include != nil && rep.Proofs.Latest.Index < include.Index {
+			return xerrors.New("latest block in proof is too old")
+		}
+
+		// Every key must have a complete path in the proof.
+		if _, err := rep.Proofs.InclusionProof.Proofs(keys); err != nil {
+			return xerrors.Errorf("incomplete proof: %+v", err)
+		}
+
+		return nil
+	}
+
+	req := &GetProofs{
+		Version: CurrentVersion,
+		Keys:    keys,
+		ID:      c.Genesis.Hash,
+	}
+	if include != nil {
+		req.MustContainBlock = include.Hash
+	}
+
+	reply := &GetProofsResponse{}
+	_, err := c.SendProtobufParallelWithDecoder(c.Roster.List, req, reply, c.options, decoder)
+	if err != nil {
+		return nil, xerrors.Errorf("sending: %+v", err)
+	}
+
+	if err := c.setLatest(&reply.Proofs.Latest); err != nil {
+		return nil, xerrors.Errorf("storing latest block: %v", err)
 	}
 
 	return reply, nil
 }
 
+// GetRangeProof returns a proof of all the instances whose ID is in the
+// range [start, end), starting from the genesis block. If end is empty, the
+// range has no upper bound. The instances are read from the proof with
+// RangeProof.Range. Note that the integrity and the completeness of the
+// proof are verified.
+func (c *Client) GetRangeProof(start, end []byte) (*GetRangeProofResponse, error) {
+	if c.Genesis == nil {
+		if err := c.fetchGenesis(); err != nil {
+			return nil, xerrors.Errorf("fetching genesis block: %v", err)
+		}
+	}
+	var include *skipchain.SkipBlock
+	if c.isLight() {
+		// A light client never goes back to an older block.
+		include = c.Latest
+	}
+
+	decoder := func(buf []byte, msg interface{}) error {
+		err := protobuf.Decode(buf, msg)
+		if err != nil {
+			return xerrors.Errorf("decoding: %+v", err)
+		}
+
+		rep, ok := msg.(*GetRangeProofResponse)
+		if !ok {
+			return xerrors.New("couldn't cast msg")
+		}
+
+		if err := rep.Proof.VerifyFromBlock(c.Genesis); err != nil {
+			return xerrors.Errorf("proof verification: %+v", err)
+		}
+
+		if include != nil && rep.Proof.Latest.Index < include.Index {
+			return xerrors.New("latest block in proof is too old")
+		}
+
+		_, _, err = rep.Proof.Range(start, end)
+		return err
+	}
+
+	req := &GetRangeProof{
+		Version: CurrentVersion,
+		ID:      c.Genesis.Hash,
+		Start:   start,
+		End:     end,
+	}
+	if include != nil {
+		req.MustContainBlock = include.Hash
+	}
+
+	reply := &GetRangeProofResponse{}
+	_, err := c.SendProtobufParallelWithDecoder(c.Roster.List, req, reply, c.options, decoder)
+	if err != nil {
+		return nil, xerrors.Errorf("sending: %+v", err)
+	}
+
+	if err := c.setLatest(&reply.Proof.Latest); err != nil {
+		return nil, xerrors.Errorf("storing latest block: %v", err)
+	}
+
+	return reply, nil
+}
+
+// GetPrefixProof returns a proof of all the instances whose ID starts with
+// the prefix, like GetRangeProof.
+func (c *Client) GetPrefixProof(prefix []byte) (*GetRangeProofResponse, error) {
+	rep, err := c.GetRangeProof(prefix, trie.PrefixEnd(prefix))
+	return rep, cothority.ErrorOrNil(err, "request failed")
+}
+
 // GetDeferredData makes a request to retrieve the deferred instruction data
 // and return the reply if the proof can be verified.
 func (c *Client) GetDeferredData(instrID InstanceID) (*DeferredData, error) {
@@ -391,6 +636,10 @@ func (c *Client) GetDeferredDataAfter(instrID InstanceID, barrier *skipchain.Ski
 // CheckAuthorization verifies which actions the given set of identities can
 // execute in the given darc.
 func (c *Client) CheckAuthorization(dID darc.ID, ids ...darc.Identity) ([]darc.Action, error) {
+	if c.isLight() {
+		return c.checkAuthorizationLight(dID, ids...)
+	}
+
 	reply := &CheckAuthorizationResponse{}
 	_, err := c.SendProtobufParallel(c.Roster.List, &CheckAuthorization{
 		Version:    CurrentVersion,
@@ -408,6 +657,112 @@ func (c *Client) CheckAuthorization(dID darc.ID, ids ...darc.Identity) ([]darc.A
 	return ret, nil
 }
 
+// SimulateTransaction asks the nodes to execute the transaction on the
+// latest state of the chain, without storing it. The transaction doesn't
+// need to be signed: the reply tells whether the signatures are accepted,
+// in addition to the state changes and coins the transaction would create.
+func (c *Client) SimulateTransaction(tx ClientTransaction) (*SimulateTransactionResponse, error) {
+	if c.isLight() {
+		return nil, ErrorUnverifiable
+	}
+
+	reply := &SimulateTransactionResponse{}
+	_, err := c.SendProtobufParallel(c.Roster.List, &SimulateTransaction{
+		Version:     CurrentVersion,
+		SkipchainID: c.ID,
+		Transaction: tx,
+	}, reply, c.options)
+	if err != nil {
+		return nil, xerrors.Errorf("request: %v", err)
+	}
+	return reply, nil
+}
+
+// GetTxReceipt returns the receipt of the transaction whose instructions
+// have the given hash. The inclusion of the transaction in the chain is
+// verified, starting from the genesis block.
+func (c *Client) GetTxReceipt(txHash []byte) (*GetTxReceiptResponse, error) {
+	if c.Genesis == nil {
+		if err := c.fetchGenesis(); err != nil {
+			return nil, xerrors.Errorf("fetching genesis block: %v", err)
+		}
+	}
+
+	reply := &GetTxReceiptResponse{}
+	_, err := c.SendProtobufParallel(c.Roster.List, &GetTxReceipt{
+		Version:     CurrentVersion,
+		SkipchainID: c.ID,
+		TxHash:      txHash,
+	}, reply, c.options)
+	if err != nil {
+		return nil, xerrors.Errorf("request: %v", err)
+	}
+	if err := reply.Verify(c.Genesis, txHash); err != nil {
+		return nil, xerrors.Errorf("verifying receipt: %v", err)
+	}
+	return reply, nil
+}
+
+// ListInstances returns one page of the instances matching the filters of
+// the request, which is sent for the chain of the client. If the proofs are
+// asked for, they are verified from the genesis block, together with the
+// contract and the darc of every listed instance. A light client must ask
+// for the proofs.
+func (c *Client) ListInstances(req ListInstances) (*ListInstancesResponse, error) {
+	if !req.WithProofs && c.isLight() {
+		return nil, ErrorUnverifiable
+	}
+	if c.Genesis == nil {
+		if err := c.fetchGenesis(); err != nil {
+			return nil, xerrors.Errorf("fetching genesis block: %v", err)
+		}
+	}
+	req.ByzCoinID = c.ID
+	var include *skipchain.SkipBlock
+	if c.isLight() {
+		// A light client never goes back to an older block.
+		include = c.Latest
+	}
+
+	decoder := func(buf []byte, msg interface{}) error {
+		err := protobuf.Decode(buf, msg)
+		if err != nil {
+			return xerrors.Errorf("decoding: %+v", err)
+		}
+
+		rep, ok := msg.(*ListInstancesResponse)
+		if !ok {
+			return xerrors.New("couldn't cast msg")
+		}
+		if !req.WithProofs || len(rep.Instances) == 0 {
+			return nil
+		}
+		if rep.Proofs == nil {
+			return xerrors.New("missing proofs")
+		}
+		if err := rep.Proofs.VerifyFromBlock(c.Genesis); err != nil {
+			return xerrors.Errorf("proof verification: %+v", err)
+		}
+		if include != nil && rep.Proofs.Latest.Index < include.Index {
+			return xerrors.New("latest block in proof is too old")
+		}
+		return rep.verifyInstances(&req)
+	}
+
+	reply := &ListInstancesResponse{}
+	_, err := c.SendProtobufParallelWithDecoder(c.Roster.List, &req, reply,
+		c.options, decoder)
+	if err != nil {
+		return nil, xerrors.Errorf("sending: %+v", err)
+	}
+	if reply.Proofs != nil {
+		if err := c.setLatest(&reply.Proofs.Latest); err != nil {
+			return nil, xerrors.Errorf("storing latest block: %v", err)
+		}
+	}
+	return reply, nil
+}
+
 // GetGenDarc uses the GetProof method to fetch the latest version of the
 // Genesis Darc from ByzCoin and parses it.
 func (c *Client) GetGenDarc() (*darc.Darc, error) {
@@ -542,8 +897,20 @@ func (c *Client) WaitProof(id InstanceID, interval time.Duration, value []byte)
 // It contacts any random node by default. A specific node can be chosen by
 // using `c.UseNode`.
 func (c *Client) StreamTransactions(handler func(StreamingResponse, error)) error {
-	req := StreamingRequest{
-		ID: c.ID,
+	return c.StreamFilteredTransactions(StreamingRequest{}, handler)
+}
+
+// StreamFilteredTransactions works like StreamTransactions, but only the
+// blocks or the transactions matching the filters of req are sent. The ID
+// of req is set to the ID of the client if it is empty. If TxResultsOnly is
+// set, the transactions are verified against the header of the block.
+func (c *Client) StreamFilteredTransactions(req StreamingRequest,
+	handler func(StreamingResponse, error)) error {
+	if c.isLight() {
+		return ErrorUnverifiable
+	}
+	if req.ID == nil {
+		req.ID = c.ID
 	}
 	n := int(rand.Int31n(int32(len(c.Roster.List))))
 	if c.options != nil {
@@ -564,17 +931,61 @@ func (c *Client) StreamTransactions(handler func(StreamingResponse, error)) erro
 			return nil
 		}
 
+		err := xerrors.Errorf("got a corrupted block from %v", c.Roster.List[0])
 		if resp.Block.CalculateHash().Equal(resp.Block.Hash) {
+			err = nil
+			if req.TxResultsOnly {
+				err = resp.VerifyTxResults()
+			}
+		}
+		if err == nil {
 			// send the block only if the integrity is correct
 			handler(resp, nil)
 		} else {
-			err := xerrors.Errorf("got a corrupted block from %v", c.Roster.List[0])
 			log.Warnf("%+v", err)
 			handler(StreamingResponse{}, err)
 		}
 	}
 }
 
+// StreamEvents sends a streaming request to the service and calls the
+// handler with the events of every new block matching the filters of req.
+// The ID of req is set to the ID of the client if it is empty. This
+// function blocks, the streaming stops if the client or the service stops.
+// The events are not verified, this needs the header of the block.
+//
+// It contacts any random node by default. A specific node can be chosen by
+// using `c.UseNode`.
+func (c *Client) StreamEvents(req StreamEventsRequest,
+	handler func(StreamEventsResponse, error)) error {
+	if c.isLight() {
+		return ErrorUnverifiable
+	}
+	if req.ID == nil {
+		req.ID = c.ID
+	}
+	n := int(rand.Int31n(int32(len(c.Roster.List))))
+	if c.options != nil {
+		if c.options.DontShuffle {
+			n = c.options.StartNode
+		}
+	}
+
+	conn, err := c.Stream(c.Roster.List[n], &req)
+	if err != nil {
+		handler(StreamEventsResponse{}, err)
+		return xerrors.Errorf("stream error: %v", err)
+	}
+	for {
+		resp := StreamEventsResponse{}
+		if err := conn.ReadMessage(&resp); err != nil {
+			handler(StreamEventsResponse{}, err)
+			return nil
+		}
+		handler(resp, nil)
+	}
+}
+
 func (c *Client) signerCounterDecoder(buf []byte, data interface{}) error {
 	err := protobuf.Decode(buf, data)
 	if err != nil {
@@ -616,6 +1027,10 @@ func (c *Client) signerCounterDecoder(buf []byte, data interface{}) error {
 // n, then the next instruction that the same signer signs must be on counter
 // n+1.
 func (c *Client) GetSignerCounters(ids ...string) (*GetSignerCountersResponse, error) {
+	if c.isLight() {
+		return c.getSignerCountersLight(ids...)
+	}
+
 	req := GetSignerCounters{
 		SkipchainID: c.ID,
 		SignerIDs:   ids,
@@ -676,9 +1091,78 @@ func (c *Client) DownloadState(byzcoinID skipchain.SkipBlockID, nonce uint64, le
 	return
 }
 
+// GetSnapshot asks the node for the description of its latest snapshot of
+// the chain. The description must be checked against other nodes and the
+// block of the snapshot before being used.
+func (c *Client) GetSnapshot(si *network.ServerIdentity) (*GetSnapshotResponse, error) {
+	reply := &GetSnapshotResponse{}
+	err := c.SendProtobuf(si, &GetSnapshot{ByzCoinID: c.ID}, reply)
+	if err != nil {
+		return nil, cothority.ErrorOrNil(err, "request failed")
+	}
+	return reply, nil
+}
+
+// GetSnapshotChunk asks the node for one chunk of its snapshot of the block
+// with the given index. The chunk must be checked against its hash in the
+// description of the snapshot.
+func (c *Client) GetSnapshotChunk(si *network.ServerIdentity, index int,
+	chunk int) (*GetSnapshotChunkResponse, error) {
+	reply := &GetSnapshotChunkResponse{}
+	err := c.SendProtobuf(si, &GetSnapshotChunk{
+		ByzCoinID: c.ID,
+		Index:     index,
+		Chunk:     chunk,
+	}, reply)
+	if err != nil {
+		return nil, cothority.ErrorOrNil(err, "request failed")
+	}
+	return reply, nil
+}
+
+// GetNodeRole asks a node for its role. Only the archive nodes keep the bodies
+// of all the blocks, so PaginateBlocks should be sent to one of them to get
+// old blocks.
+func (c *Client) GetNodeRole(si *network.ServerIdentity) (*GetNodeRoleResponse, error) {
+	reply := &GetNodeRoleResponse{}
+	err := c.SendProtobuf(si, &GetNodeRole{}, reply)
+	if err != nil {
+		return nil, cothority.ErrorOrNil(err, "request failed")
+	}
+	return reply, nil
+}
+
+// GetEvidence asks a node for the evidence of misbehaviour it collected for
+// the chain. The evidence can be registered with the evidence contract.
+func (c *Client) GetEvidence(si *network.ServerIdentity) (*GetEvidenceResponse, error) {
+	reply := &GetEvidenceResponse{}
+	err := c.SendProtobuf(si, &GetEvidence{SkipChainID: c.ID}, reply)
+	if err != nil {
+		return nil, cothority.ErrorOrNil(err, "request failed")
+	}
+	return reply, nil
+}
+
+// GetViewChanges asks a node for the latest count view-changes it recorded
+// for the chain, and the state of its view-change controller. If count is 0,
+// all the recorded view-changes are returned.
+func (c *Client) GetViewChanges(si *network.ServerIdentity, count int) (*GetViewChangesResponse, error) {
+	reply := &GetViewChangesResponse{}
+	err := c.SendProtobuf(si, &GetViewChanges{SkipChainID: c.ID, Count: count},
+		reply)
+	if err != nil {
+		return nil, cothority.ErrorOrNil(err, "request failed")
+	}
+	return reply, nil
+}
+
 // ResolveInstanceID resolves the instance ID using the given darc ID and name.
 // The name must be already set by calling the naming contract.
 func (c *Client) ResolveInstanceID(darcID darc.ID, name string) (InstanceID, error) {
+	if c.isLight() {
+		return InstanceID{}, ErrorUnverifiable
+	}
+
 	req := ResolveInstanceID{
 		SkipChainID: c.ID,
 		DarcID:      darcID,
@@ -778,6 +1262,76 @@ func DebugRemove(si *network.ServerIdentity, byzcoinID skipchain.SkipBlockID) er
 	return cothority.ErrorOrNil(err, "request failed")
 }
 
+// SetNodeRole asks the conode to change its role, see Service.SetNodeRole.
+// The private key of si must be set.
+func SetNodeRole(si *network.ServerIdentity, role NodeRole,
+	window int) (*GetNodeRoleResponse, error) {
+	cl := onet.NewClient(cothority.Suite, ServiceName)
+	current := &GetNodeRoleResponse{}
+	err := cl.SendProtobuf(si, &GetNodeRole{}, current)
+	if err != nil {
+		return nil, cothority.ErrorOrNil(err, "request failed")
+	}
+	counter := current.Counter + 1
+	sig, err := schnorr.Sign(cothority.Suite, si.GetPrivate(),
+		NodeRoleMessage(role, window, counter))
+	if err != nil {
+		return nil, xerrors.Errorf("sign error: %v", err)
+	}
+	request := &ChangeNodeRole{
+		Role:          role,
+		PruningWindow: window,
+		Counter:       counter,
+		Signature:     sig,
+	}
+	reply := &GetNodeRoleResponse{}
+	err = cl.SendProtobuf(si, request, reply)
+	if err != nil {
+		return nil, cothority.ErrorOrNil(err, "request failed")
+	}
+	return reply, nil
+}
+
+// Observe asks the conode to follow the given byzcoin-instance as an observer.
+// The genesis block and the new blocks are fetched from the given roster. The
+// conode then answers the read-only requests for the chain, and forwards the
+// transactions to the roster.
+func Observe(si *network.ServerIdentity, roster *onet.Roster,
+	byzcoinID skipchain.SkipBlockID) (*ObserveResponse, error) {
+	sig, err := schnorr.Sign(cothority.Suite, si.GetPrivate(),
+		observeMessage(byzcoinID))
+	if err != nil {
+		return nil, xerrors.Errorf("sign error: %v", err)
+	}
+	request := &ObserveRequest{
+		ByzCoinID: byzcoinID,
+		Roster:    *roster,
+		Signature: sig,
+	}
+	reply := &ObserveResponse{}
+	err = onet.NewClient(cothority.Suite, ServiceName).SendProtobuf(si, request, reply)
+	if err != nil {
+		return nil, cothority.ErrorOrNil(err, "request failed")
+	}
+	return reply, nil
+}
+
+// StopObserving asks the conode to stop following the given
+// byzcoin-instance.
+func StopObserving(si *network.ServerIdentity, byzcoinID skipchain.SkipBlockID) error {
+	sig, err := schnorr.Sign(cothority.Suite, si.GetPrivate(),
+		stopObservingMessage(byzcoinID))
+	if err != nil {
+		return xerrors.Errorf("sign error: %v", err)
+	}
+	request := &StopObservingRequest{
+		ByzCoinID: byzcoinID,
+		Signature: sig,
+	}
+	err = onet.NewClient(cothority.Suite, ServiceName).SendProtobuf(si, request, nil)
+	return cothority.ErrorOrNil(err, "request failed")
+}
+
 // DefaultGenesisMsg creates the message that is used to for creating the
 // genesis Darc and block. It will contain rules for spawning and evolving the
 // darc contract.
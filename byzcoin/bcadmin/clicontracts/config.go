@@ -1,6 +1,7 @@
 package clicontracts
 
 import (
+	"encoding/hex"
 	"strings"
 	"time"
 
@@ -85,6 +86,33 @@ func ConfigInvokeUpdateConfig(c *cli.Context) error {
 		config.MaxBlockSize = maxBlockSize
 	}
 
+	// GasLimits
+	if c.IsSet("maxBlockGas") || c.IsSet("maxTxGas") {
+		if config.GasLimits == nil {
+			config.GasLimits = &byzcoin.GasLimits{}
+		}
+		if c.IsSet("maxBlockGas") {
+			config.GasLimits.MaxBlockGas = c.Uint64("maxBlockGas")
+		}
+		if c.IsSet("maxTxGas") {
+			config.GasLimits.MaxTxGas = c.Uint64("maxTxGas")
+		}
+		if config.GasLimits.MaxBlockGas == 0 {
+			config.GasLimits = nil
+		}
+	}
+
+	// FeeBeneficiary
+	feeBeneficiary := c.String("feeBeneficiary")
+	if feeBeneficiary != "" {
+		benefBuf, err := hex.DecodeString(feeBeneficiary)
+		if err != nil {
+			return xerrors.Errorf("failed to decode the feeBeneficiary: %v", err)
+		}
+		benef := byzcoin.NewInstanceID(benefBuf)
+		config.FeeBeneficiary = &benef
+	}
+
 	// DarcContractIDs
 	// we need the IDs to be separated by commas
 	darcContractIDs := c.String("darcContractIDs")
@@ -323,6 +323,18 @@ var cmds = cli.Commands{
 										Name:  "maxBlockSize",
 										Usage: "maxBlockSize (optional)",
 									},
+									cli.Uint64Flag{
+										Name:  "maxBlockGas",
+										Usage: "maxBlockGas, 0 disables gas metering (optional)",
+									},
+									cli.Uint64Flag{
+										Name:  "maxTxGas",
+										Usage: "maxTxGas (optional)",
+									},
+									cli.StringFlag{
+										Name:  "feeBeneficiary",
+										Usage: "hex instance ID of the coin receiving the fees (optional)",
+									},
 									cli.StringFlag{
 										Name:  "darcContractIDs",
 										Usage: "darcContractIDs separated by comas (optional)",
@@ -839,6 +851,23 @@ var cmds = cli.Commands{
 				ArgsUsage: "bc.cfg key-file",
 				Action:    debugCounters,
 			},
+			{
+				Name:      "viewchanges",
+				Usage:     "shows the view-changes recorded by a node and its view-change state",
+				ArgsUsage: "bc.cfg",
+				Action:    debugViewChanges,
+				Flags: []cli.Flag{
+					cli.IntFlag{
+						Name:  "server",
+						Usage: "which server number from the roster to contact",
+					},
+					cli.IntFlag{
+						Name:  "count",
+						Value: 10,
+						Usage: "how many of the latest view-changes to show (0 = all)",
+					},
+				},
+			},
 		},
 	},
 
@@ -961,6 +990,19 @@ var cmds = cli.Commands{
 		Action:    mint,
 	},
 
+	{
+		Name:      "noderole",
+		Usage:     "set which blocks a conode keeps: all of them (archive) or only the recent bodies (full)",
+		ArgsUsage: "private.toml (archive | full)",
+		Action:    nodeRole,
+		Flags: []cli.Flag{
+			cli.IntFlag{
+				Name:  "window",
+				Usage: "how many recent blocks a full node keeps with their body",
+			},
+		},
+	},
+
 	{
 		Name:    "qr",
 		Usage:   "generates a QRCode containing the description of the BC Config",
@@ -25,11 +25,13 @@ import (
 	"go.dedis.ch/cothority/v3/byzcoin"
 	"go.dedis.ch/cothority/v3/byzcoin/bcadmin/lib"
 	"go.dedis.ch/cothority/v3/byzcoin/contracts"
+	"go.dedis.ch/cothority/v3/byzcoin/viewchange"
 	"go.dedis.ch/cothority/v3/darc"
 	"go.dedis.ch/cothority/v3/darc/expression"
 	_ "go.dedis.ch/cothority/v3/eventlog"
 	_ "go.dedis.ch/cothority/v3/personhood"
 	"go.dedis.ch/cothority/v3/skipchain"
+	_ "go.dedis.ch/cothority/v3/wasm"
 	"go.dedis.ch/onet/v3"
 	"go.dedis.ch/onet/v3/app"
 	"go.dedis.ch/onet/v3/cfgpath"
@@ -1282,6 +1284,36 @@ func debugRemove(c *cli.Context) error {
 	return nil
 }
 
+func nodeRole(c *cli.Context) error {
+	if c.NArg() < 2 {
+		return xerrors.New("please give the following arguments: private.toml (archive | full)")
+	}
+
+	ccfg, err := app.LoadCothority(c.Args().First())
+	if err != nil {
+		return err
+	}
+	si, err := ccfg.GetServerIdentity()
+	if err != nil {
+		return err
+	}
+	role, err := byzcoin.ParseNodeRole(c.Args().Get(1))
+	if err != nil {
+		return err
+	}
+	resp, err := byzcoin.SetNodeRole(si, role, c.Int("window"))
+	if err != nil {
+		return err
+	}
+	if resp.Role == byzcoin.NodeRoleFull {
+		log.Infof("%s is now a full node keeping the bodies of the last %d "+
+			"blocks", si.Address, resp.PruningWindow)
+	} else {
+		log.Infof("%s is now an archive node", si.Address)
+	}
+	return nil
+}
+
 func debugCounters(c *cli.Context) error {
 	if c.NArg() < 2 {
 		return xerrors.New("please give the following arguments: bc-xxx.cfg key-xxx.cfg")
@@ -1303,6 +1335,60 @@ func debugCounters(c *cli.Context) error {
 	return nil
 }
 
+func debugViewChanges(c *cli.Context) error {
+	if c.NArg() < 1 {
+		return xerrors.New("please give the following argument: bc-xxx.cfg")
+	}
+	cfg, cl, err := lib.LoadConfig(c.Args().First())
+	if err != nil {
+		return err
+	}
+	sn := c.Int("server")
+	if sn < 0 || sn >= len(cfg.Roster.List) {
+		return xerrors.Errorf("server number must be between 0 and %d",
+			len(cfg.Roster.List)-1)
+	}
+	resp, err := cl.GetViewChanges(cfg.Roster.List[sn], c.Int("count"))
+	if err != nil {
+		return err
+	}
+
+	for _, r := range resp.Records {
+		log.Infof("Block %d (%x): leader %s -> %s\n"+
+			"\tView: %x, leader index %d, %d proofs\n"+
+			"\tPrevious block: %s, view-change block: %s",
+			r.BlockIndex, r.BlockID, r.OldLeader.Address, r.NewLeader.Address,
+			r.ViewID, r.LeaderIndex, len(r.Proof),
+			time.Unix(0, r.PreviousTimestamp), time.Unix(0, r.Timestamp))
+		if r.Started != 0 {
+			log.Infof("\tStarted: %s, duration: %s", time.Unix(0, r.Started),
+				time.Duration(r.Timestamp-r.Started))
+		}
+	}
+	if len(resp.Records) == 0 {
+		log.Info("No view-changes recorded")
+	}
+
+	st := resp.Status
+	if !st.Running {
+		log.Info("View-change controller is not running")
+		return nil
+	}
+	state := "initial"
+	switch st.State {
+	case viewchange.StateSentRequest:
+		state = "sent request"
+	case viewchange.StateStartedTimer:
+		state = "started timer"
+	}
+	log.Infof("View-change controller: leader index %d, state %s, "+
+		"%d/%d requests", st.LeaderIndex, state, st.Requests, st.Threshold)
+	if st.Since != 0 {
+		log.Infof("\tView-change pending since %s", time.Unix(0, st.Since))
+	}
+	return nil
+}
+
 func darcAdd(c *cli.Context) error {
 	bcArg := c.String("bc")
 	if bcArg == "" {
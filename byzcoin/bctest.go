@@ -144,7 +144,7 @@ func (b *BCTest) SendInst(args *TxArgs,
 		b.SignerCounter++
 	}
 	ctx := NewClientTransaction(CurrentVersion, inst...)
-	h := ctx.Instructions.Hash()
+	h := ctx.Hash()
 	for i := range ctx.Instructions {
 		require.NoError(b.T, ctx.Instructions[i].SignWith(h, b.Signer))
 	}
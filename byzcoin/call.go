@@ -0,0 +1,149 @@
+package byzcoin
+
+import (
+	"golang.org/x/xerrors"
+)
+
+// MaxCallDepth is the maximum number of nested calls between contracts
+// during the execution of one instruction.
+const MaxCallDepth = 8
+
+// callEnv is given to the contracts in their GlobalState to allow them to
+// call other instances.
+type callEnv struct {
+	service *Service
+	ctxHash []byte
+	depth   int
+}
+
+// CallContext lets a contract call other instances synchronously while it
+// executes an instruction. Every call runs on a scratch copy of the state
+// holding the changes of the previous calls, so the caller can read their
+// results with State.
+//
+// The instruction of the callee is signed by the signers of the caller's
+// instruction, and must be allowed by the darc of the callee, like any other
+// instruction. The caller must return the state changes of the calls, given
+// by StateChanges, before its own state changes.
+type CallContext struct {
+	gs     globalState
+	caller Instruction
+	scs    StateChanges
+}
+
+// NewCallContext returns a CallContext for the contract executing the caller
+// instruction. rst must be the state given to the contract.
+func NewCallContext(rst ReadOnlyStateTrie, caller Instruction) (*CallContext,
+	error) {
+	gs, ok := rst.(globalState)
+	if !ok || gs.calls == nil {
+		return nil, xerrors.New("this state doesn't support calls")
+	}
+	return &CallContext{gs: gs, caller: caller}, nil
+}
+
+// State returns the state with the changes of the successful calls.
+func (cc *CallContext) State() GlobalState {
+	return cc.gs
+}
+
+// StateChanges returns the state changes of all the successful calls.
+func (cc *CallContext) StateChanges() StateChanges {
+	return cc.scs
+}
+
+// Call executes the instruction of the callee with the given coins and
+// returns its state changes and coins. If the call fails, the state is left
+// unchanged and the caller can decide to go on or to return the error.
+func (cc *CallContext) Call(callee Instruction, coins []Coin) (StateChanges,
+	[]Coin, error) {
+	callee.SignerIdentities = cc.caller.SignerIdentities
+	callee.SignerCounter = cc.caller.SignerCounter
+	callee.Signatures = cc.caller.Signatures
+	callee.version = cc.caller.version
+	callee.synthetic = cc.caller.synthetic
+	ctxHash := cc.gs.calls.ctxHash
+	return cc.call(callee, coins, func(gs GlobalState, c Contract) error {
+		return c.VerifyInstruction(gs, callee, ctxHash)
+	})
+}
+
+// call executes the instruction of the callee after verifying it with the
+// given function.
+func (cc *CallContext) call(callee Instruction, coins []Coin,
+	verify func(GlobalState, Contract) error) (scs StateChanges, cout []Coin,
+	err error) {
+	env := cc.gs.calls
+	if env.depth >= MaxCallDepth {
+		return nil, nil, xerrors.Errorf("more than %d nested calls",
+			MaxCallDepth)
+	}
+	defer func() {
+		if re := recover(); re != nil {
+			err = xerrors.Errorf("call panicked: %v", re)
+		}
+	}()
+
+	gs := cc.gs
+	gs.calls = &callEnv{service: env.service, ctxHash: env.ctxHash,
+		depth: env.depth + 1}
+	c, err := env.service.loadContract(gs, callee)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("calling %s: %v", callee.Action(), err)
+	}
+	if err = verify(gs, c); err != nil {
+		return nil, nil, xerrors.Errorf("call verification failed: %v", err)
+	}
+	scs, cout, err = env.service.runContract(gs, c, coins, callee)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("calling %s: %v", callee.Action(), err)
+	}
+
+	rst := cc.gs.ReadOnlyStateTrie
+	for _, sc := range scs {
+		_, _, _, _, err := rst.GetValues(sc.InstanceID)
+		exists := err == nil
+		if err != nil && !xerrors.Is(err, errKeyNotSet) {
+			return nil, nil, xerrors.Errorf("reading trie: %v", err)
+		}
+		switch {
+		case sc.StateAction == GenerateInstruction:
+			err = xerrors.New("calls cannot generate instructions")
+		case sc.StateAction == EmitEvent:
+			_, err = decodeEvent(sc)
+		case sc.StateAction == Create && exists:
+			err = xerrors.Errorf("tried to create existing instanceID %x",
+				sc.InstanceID)
+		case sc.StateAction == Update && !exists:
+			err = xerrors.Errorf("tried to update non-existing instanceID %x",
+				sc.InstanceID)
+		case sc.StateAction == Remove && !exists:
+			err = xerrors.Errorf("tried to remove non-existing instanceID %x",
+				sc.InstanceID)
+		default:
+			rst, err = storeToReplica(rst, StateChanges{sc})
+		}
+		if err != nil {
+			return nil, nil, xerrors.Errorf("calling %s: %v", callee.Action(),
+				err)
+		}
+	}
+	cc.gs.ReadOnlyStateTrie = rst
+	cc.scs = append(cc.scs, scs...)
+	return scs, cout, nil
+}
+
+// storeToReplica stores the state changes in a replica of the trie. The
+// state changes are not charged as gas here, as they will be charged with the
+// state changes of the caller.
+func storeToReplica(rst ReadOnlyStateTrie, scs StateChanges) (
+	ReadOnlyStateTrie, error) {
+	if m, ok := rst.(*meteredStateTrie); ok {
+		replica, err := m.ReadOnlyStateTrie.StoreAllToReplica(scs)
+		if err != nil {
+			return nil, err
+		}
+		return newMeteredStateTrie(replica, m.meter), nil
+	}
+	return rst.StoreAllToReplica(scs)
+}
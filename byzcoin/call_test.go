@@ -0,0 +1,111 @@
+package byzcoin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+const callerContract = "caller"
+
+// callerContractFunc calls the instance given in the "target" argument with
+// the contract and command of the arguments, forwarding all the arguments.
+// The "value" argument must come first, so that the dummy contract stores it.
+func callerContractFunc(rst ReadOnlyStateTrie, inst Instruction,
+	coins []Coin) ([]StateChange, []Coin, error) {
+	_, _, _, darcID, err := rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return nil, nil, err
+	}
+	if inst.GetType() == SpawnType {
+		return StateChanges{NewStateChange(Create, inst.DeriveID(""),
+			callerContract, nil, darcID)}, coins, nil
+	}
+
+	args := inst.Invoke.Args
+	target := NewInstanceID(args.Search("target"))
+	cc, err := NewCallContext(rst, inst)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, cout, err := cc.Call(Instruction{
+		InstanceID: target,
+		Invoke: &Invoke{
+			ContractID: string(args.Search("contract")),
+			Command:    string(args.Search("command")),
+			Args:       args,
+		},
+	}, coins)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The result of the call is visible to the caller.
+	value, _, _, _, err := cc.State().GetValues(target.Slice())
+	if err != nil {
+		return nil, nil, err
+	}
+	if string(value) != string(args.Search("value")) {
+		return nil, nil, xerrors.New("the call didn't change the state")
+	}
+	return append(cc.StateChanges(), NewStateChange(Update,
+		inst.InstanceID, callerContract, value, darcID)), cout, nil
+}
+
+func TestNewCallContext(t *testing.T) {
+	_, err := NewCallContext(NewROSTSimul(), Instruction{})
+	require.Error(t, err)
+}
+
+func TestService_Call(t *testing.T) {
+	b := newBCT(t, nil)
+	for _, s := range b.Services {
+		s.testRegisterContract(callerContract, adaptor(callerContractFunc))
+	}
+	b.AddGenesisRules("spawn:"+callerContract,
+		"invoke:"+callerContract+".call",
+		"invoke:"+DummyContractName+".update")
+	b.CreateByzCoin()
+	defer b.CloseAll()
+
+	ctx, _ := b.SpawnDummy(nil)
+	dummyID := NewInstanceID(ctx.Instructions[0].Hash())
+	ctx, _ = b.SendInst(nil, Instruction{
+		InstanceID: NewInstanceID(b.GenesisDarc.GetBaseID()),
+		Spawn:      &Spawn{ContractID: callerContract},
+	})
+	callerID := ctx.Instructions[0].DeriveID("")
+
+	call := func(target InstanceID, contract, command string) string {
+		_, resp := b.SendInst(&TxArgs{Wait: 10}, Instruction{
+			InstanceID: callerID,
+			Invoke: &Invoke{
+				ContractID: callerContract,
+				Command:    "call",
+				Args: Arguments{
+					{Name: "value", Value: []byte("called")},
+					{Name: "target", Value: target.Slice()},
+					{Name: "contract", Value: []byte(contract)},
+					{Name: "command", Value: []byte(command)},
+				},
+			},
+		})
+		if resp.Error != "" {
+			b.SignerCounter--
+		}
+		return resp.Error
+	}
+
+	require.Empty(t, call(dummyID, DummyContractName, "update"))
+	requireDummyValue(t, b, dummyID, "called")
+	requireDummyValue(t, b, callerID, "called")
+
+	// The callee's darc must allow the instruction.
+	require.Contains(t, call(dummyID, DummyContractName, "forbidden"),
+		"does not exist")
+
+	// A contract calling itself is stopped.
+	require.Contains(t, call(callerID, callerContract, "call"),
+		"nested calls")
+}
@@ -0,0 +1,81 @@
+package byzcoin
+
+import (
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// ContractEvidenceID denotes a contract registering the evidence of the
+// misbehaviour of a node, as returned by GetEvidence.
+//
+// The conflicting view-change requests of an Evidence are spawned with the
+// "evidence" argument, and the conflicting forward-link signatures of a
+// ConflictingSignature with the "signature" argument. They are verified
+// against the blocks of the chain, and stored in the instance given by their
+// InstanceID, so that the same misbehaviour is only registered once. The
+// instances can be listed with the instance index, and cannot be changed nor
+// deleted.
+var ContractEvidenceID = "evidence"
+
+// contractEvidence doesn't decode its value, as an instance cannot be
+// changed.
+type contractEvidence struct {
+	BasicContract
+}
+
+func contractEvidenceFromBytes(in []byte) (Contract, error) {
+	return &contractEvidence{}, nil
+}
+
+func (c *contractEvidence) Spawn(rst ReadOnlyStateTrie, inst Instruction,
+	coins []Coin) ([]StateChange, []Coin, error) {
+	_, _, _, darcID, err := rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return nil, nil, xerrors.Errorf("reading trie: %v", err)
+	}
+	rosc, ok := rst.(ReadOnlySkipChain)
+	if !ok {
+		return nil, nil, xerrors.New("need the skipchain to verify evidence")
+	}
+
+	var buf []byte
+	var iid InstanceID
+	if arg := inst.Spawn.Args.Search("signature"); arg != nil {
+		var cs ConflictingSignature
+		if err := protobuf.Decode(arg, &cs); err != nil {
+			return nil, nil, xerrors.Errorf("couldn't decode signatures: %v",
+				err)
+		}
+		if err := cs.verifyOnChain(rosc); err != nil {
+			return nil, nil, xerrors.Errorf("invalid signatures: %v", err)
+		}
+		iid = cs.InstanceID()
+		buf, err = protobuf.Encode(&cs)
+	} else {
+		var ev Evidence
+		err = protobuf.DecodeWithConstructors(inst.Spawn.Args.Search("evidence"),
+			&ev, network.DefaultConstructors(cothority.Suite))
+		if err != nil {
+			return nil, nil, xerrors.Errorf("couldn't decode evidence: %v", err)
+		}
+		var genesis *skipchain.SkipBlock
+		genesis, err = rosc.GetGenesisBlock()
+		if err != nil {
+			return nil, nil, xerrors.Errorf("getting genesis block: %v", err)
+		}
+		if err := ev.Verify(genesis.SkipChainID()); err != nil {
+			return nil, nil, xerrors.Errorf("invalid evidence: %v", err)
+		}
+		iid = ev.InstanceID()
+		buf, err = protobuf.Encode(&ev)
+	}
+	if err != nil {
+		return nil, nil, xerrors.Errorf("couldn't encode instance data: %v",
+			err)
+	}
+	return StateChanges{NewStateChange(Create, iid, ContractEvidenceID, buf,
+		darcID)}, coins, nil
+}
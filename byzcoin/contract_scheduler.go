@@ -0,0 +1,371 @@
+package byzcoin
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// maxScheduledPerBlock is the maximum number of scheduled instructions the
+// leader adds to the queue at once.
+var maxScheduledPerBlock = 50
+
+// ContractSchedulerID denotes a contract holding an instruction, signed in
+// advance, that is executed once the chain reaches a block index or a
+// timestamp.
+//
+// The instruction is spawned with the "instruction" argument, and at least one
+// of the "block_index" and "timestamp" arguments, encoded as 64-bit
+// little-endian integers. Its signers sign the hash given by
+// ScheduledInstruction.Hash, and their counters are ignored. The contract of
+// the instruction must support deferred instructions, see
+// VerifyDeferredInstruction, which is the case of the deferred contract.
+//
+// Once the instruction is due, the leader adds a transaction with the
+// "execute" command to the block. It is verified by all the nodes, and can
+// also be sent by anyone. An event tells whether the instruction succeeded.
+// A pending instruction is cancelled with a delete, allowed by the darc of the
+// instance, or with the "cancel" command signed by all the signers of the
+// instruction.
+//
+// The instance is closed, but not removed, once the instruction is executed
+// or cancelled. As the signed instruction is public, this makes sure that it
+// cannot be scheduled again.
+var ContractSchedulerID = "scheduler"
+
+// ScheduledInstruction is stored in a scheduler instance.
+type ScheduledInstruction struct {
+	// Instruction is executed when it is due.
+	Instruction Instruction
+	// BlockIndex is the index of the first block where the instruction can
+	// be executed, or 0.
+	BlockIndex uint64
+	// Timestamp is the timestamp, in nanoseconds, from which the
+	// instruction can be executed, or 0.
+	Timestamp int64
+	// Closed is set once the instruction has been executed or cancelled.
+	Closed bool
+}
+
+// String returns a human readable string representation of the scheduled
+// instruction.
+func (si ScheduledInstruction) String() string {
+	out := new(strings.Builder)
+	fmt.Fprintf(out, "- Instruction: %s\n", si.Instruction.Action())
+	fmt.Fprintf(out, "-- Instance: %x\n", si.Instruction.InstanceID[:])
+	fmt.Fprintf(out, "- Block index: %d\n", si.BlockIndex)
+	fmt.Fprintf(out, "- Timestamp: %d\n", si.Timestamp)
+	fmt.Fprintf(out, "- Closed: %t\n", si.Closed)
+	return out.String()
+}
+
+// Hash returns the hash the signers of the instruction must sign. It
+// doesn't depend on the signers nor on their counters. The version of the
+// instruction must be set, e.g., with NewClientTransaction.
+func (si ScheduledInstruction) Hash() []byte {
+	h := sha256.New()
+	si.Instruction.hashType(h)
+	h.Write([]byte(ContractSchedulerID))
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, si.BlockIndex)
+	h.Write(buf)
+	binary.LittleEndian.PutUint64(buf, uint64(si.Timestamp))
+	h.Write(buf)
+	return h.Sum(nil)
+}
+
+// InstanceID returns the ID of the scheduler instance holding the scheduled
+// instruction. The same instruction can only be scheduled once for a given
+// block index and timestamp, so that it is never executed twice.
+func (si ScheduledInstruction) InstanceID() InstanceID {
+	return NewInstanceID(si.Hash())
+}
+
+// isDue returns whether the instruction can be executed in the block
+// following the state, with the given timestamp.
+func (si ScheduledInstruction) isDue(index int, timestamp int64) bool {
+	return si.BlockIndex <= uint64(index+1) && si.Timestamp <= timestamp
+}
+
+type contractScheduler struct {
+	BasicContract
+	ScheduledInstruction
+}
+
+func contractSchedulerFromBytes(in []byte) (Contract, error) {
+	c := &contractScheduler{}
+	err := protobuf.Decode(in, &c.ScheduledInstruction)
+	if err != nil {
+		return nil, xerrors.Errorf("couldn't unmarshal instance data: %v", err)
+	}
+	return c, nil
+}
+
+// VerifyInstruction accepts the execute command without signatures if the
+// instruction is due, and the cancel command if it is signed by all the
+// signers of the instruction.
+func (c *contractScheduler) VerifyInstruction(rst ReadOnlyStateTrie,
+	inst Instruction, ctxHash []byte) error {
+	if inst.GetType() == SpawnType {
+		return c.BasicContract.VerifyInstruction(rst, inst, ctxHash)
+	}
+	if c.Closed {
+		return xerrors.New("instruction has already been executed or " +
+			"cancelled")
+	}
+	if inst.GetType() == DeleteType {
+		return c.BasicContract.VerifyInstruction(rst, inst, ctxHash)
+	}
+
+	switch inst.Invoke.Command {
+	case "execute":
+		if !c.isDue(rst.GetIndex(), timestampOf(rst)) {
+			return xerrors.New("instruction is not due yet")
+		}
+		return nil
+	case "cancel":
+		return c.verifyCancel(rst, inst, ctxHash)
+	default:
+		return xerrors.New("scheduler contract can only execute or cancel")
+	}
+}
+
+// verifyCancel makes sure that all the signers of the scheduled instruction
+// signed the cancel instruction, with their current counters.
+func (c *contractScheduler) verifyCancel(rst ReadOnlyStateTrie,
+	inst Instruction, ctxHash []byte) error {
+	if len(inst.SignerIdentities) != len(inst.Signatures) {
+		return xerrors.New("length of identities does not match the length " +
+			"of signatures")
+	}
+	err := verifySignerCounters(rst, inst.SignerCounter, inst.SignerIdentities)
+	if err != nil {
+		return xerrors.Errorf("signer counter: %v", err)
+	}
+	signed := make(map[string]bool)
+	for i, id := range inst.SignerIdentities {
+		if id.Verify(ctxHash, inst.Signatures[i]) == nil {
+			signed[id.String()] = true
+		}
+	}
+	for _, id := range c.Instruction.SignerIdentities {
+		if !signed[id.String()] {
+			return xerrors.Errorf("missing signature of %s", id)
+		}
+	}
+	return nil
+}
+
+func (c *contractScheduler) Spawn(rst ReadOnlyStateTrie, inst Instruction,
+	coins []Coin) ([]StateChange, []Coin, error) {
+	_, _, _, darcID, err := rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return nil, nil, xerrors.Errorf("reading trie: %v", err)
+	}
+
+	var si ScheduledInstruction
+	err = protobuf.Decode(inst.Spawn.Args.Search("instruction"),
+		&si.Instruction)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("couldn't decode instruction: %v", err)
+	}
+	si.Instruction.version = rst.GetVersion()
+	if buf := inst.Spawn.Args.Search("block_index"); buf != nil {
+		if len(buf) != 8 {
+			return nil, nil, xerrors.New("block_index must be 8 bytes")
+		}
+		si.BlockIndex = binary.LittleEndian.Uint64(buf)
+	}
+	if buf := inst.Spawn.Args.Search("timestamp"); buf != nil {
+		if len(buf) != 8 {
+			return nil, nil, xerrors.New("timestamp must be 8 bytes")
+		}
+		si.Timestamp = int64(binary.LittleEndian.Uint64(buf))
+	}
+	if si.BlockIndex == 0 && si.Timestamp == 0 {
+		return nil, nil, xerrors.New("need a block_index or a timestamp")
+	}
+	if si.isDue(rst.GetIndex(), timestampOf(rst)) {
+		return nil, nil, xerrors.New("instruction must be scheduled in the" +
+			" future")
+	}
+	if si.Instruction.GetType() == InvalidInstrType {
+		return nil, nil, xerrors.New("invalid instruction")
+	}
+	// The signatures are checked again when the instruction is executed,
+	// with the darc of that moment.
+	err = si.Instruction.VerifyWithOption(rst, si.Hash(),
+		&VerificationOptions{IgnoreCounters: true})
+	if err != nil {
+		return nil, nil, xerrors.Errorf("verifying instruction: %v", err)
+	}
+
+	buf, err := protobuf.Encode(&si)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("couldn't encode instance data: %v",
+			err)
+	}
+	return StateChanges{NewStateChange(Create, si.InstanceID(),
+		ContractSchedulerID, buf, darcID)}, coins, nil
+}
+
+// Invoke executes the scheduled instruction and closes the instance. If the
+// instruction fails, the instance is closed all the same, and a "failed"
+// event holds the error. Else an "executed" event is emitted.
+//
+// The cancel command closes the instance with a "cancelled" event.
+func (c *contractScheduler) Invoke(rst ReadOnlyStateTrie, inst Instruction,
+	coins []Coin) ([]StateChange, []Coin, error) {
+	if inst.Invoke.Command == "cancel" {
+		scs, err := c.close(rst, inst, "cancelled", nil)
+		return scs, coins, err
+	}
+	cc, err := NewCallContext(rst, inst)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("executing instruction: %v", err)
+	}
+
+	c.Instruction.version = rst.GetVersion()
+	si := c.Instruction
+	hash := c.Hash()
+	cout := coins
+	event := "executed"
+	var data []byte
+	_, callCoins, err := cc.call(si, coins,
+		func(gs GlobalState, callee Contract) error {
+			return callee.VerifyDeferredInstruction(gs, si, hash)
+		})
+	if err != nil {
+		event = "failed"
+		data = []byte(err.Error())
+	} else {
+		cout = callCoins
+	}
+
+	scs, err := c.close(rst, inst, event, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return append(cc.StateChanges(), scs...), cout, nil
+}
+
+// Delete cancels the scheduled instruction. The instance is closed instead of
+// being removed.
+func (c *contractScheduler) Delete(rst ReadOnlyStateTrie, inst Instruction,
+	coins []Coin) ([]StateChange, []Coin, error) {
+	scs, err := c.close(rst, inst, "cancelled", nil)
+	return scs, coins, err
+}
+
+// close returns the state changes emitting the event and closing the
+// instance.
+func (c *contractScheduler) close(rst ReadOnlyStateTrie, inst Instruction,
+	event string, data []byte) (StateChanges, error) {
+	_, _, _, darcID, err := rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return nil, xerrors.Errorf("reading trie: %v", err)
+	}
+	ev, err := NewEvent(inst.InstanceID, ContractSchedulerID, event, data)
+	if err != nil {
+		return nil, err
+	}
+	si := c.ScheduledInstruction
+	si.Closed = true
+	buf, err := protobuf.Encode(&si)
+	if err != nil {
+		return nil, xerrors.Errorf("couldn't encode instance data: %v", err)
+	}
+	return StateChanges{ev, NewStateChange(Update, inst.InstanceID,
+		ContractSchedulerID, buf, darcID)}, nil
+}
+
+// timestampOf returns the timestamp of the block being created, if the
+// state gives it.
+func timestampOf(rst ReadOnlyStateTrie) int64 {
+	if tr, ok := rst.(TimeReader); ok {
+		return tr.GetCurrentBlockTimestamp()
+	}
+	return 0
+}
+
+// isScheduledTx returns whether the transaction executes a scheduled
+// instruction, as created by scheduledTransactions.
+func isScheduledTx(tx ClientTransaction) bool {
+	if len(tx.Instructions) != 1 {
+		return false
+	}
+	inst := tx.Instructions[0]
+	return inst.Invoke != nil && inst.Invoke.ContractID == ContractSchedulerID &&
+		inst.Invoke.Command == "execute" && len(inst.Signatures) == 0
+}
+
+// scheduledTransactions returns the transactions executing the scheduled
+// instructions that are due in the next block. The scheduler instances are
+// found with the instance index, and every transaction is verified again
+// when the block is created.
+func (s *Service) scheduledTransactions(
+	scID skipchain.SkipBlockID) []ClientTransaction {
+	st, err := s.getStateTrie(scID)
+	if err != nil {
+		log.Error(s.ServerIdentity(), "couldn't get state trie:", err)
+		return nil
+	}
+	// The verifiers only know the timestamps of the blocks, so the one of
+	// the latest block is used instead of the clock of the leader.
+	latest, err := s.db().GetLatestByID(scID)
+	if err != nil {
+		log.Error(s.ServerIdentity(), "couldn't get latest block:", err)
+		return nil
+	}
+	header, err := decodeBlockHeader(latest)
+	if err != nil {
+		log.Error(s.ServerIdentity(), "couldn't decode header:", err)
+		return nil
+	}
+	timestamp := header.Timestamp
+
+	var txs []ClientTransaction
+	req := &ListInstances{ByzCoinID: scID, ContractID: ContractSchedulerID}
+	for {
+		instances, next, err := s.instanceIndex.list(req, maxListInstances)
+		if err != nil {
+			log.Lvl2(s.ServerIdentity(), "couldn't list scheduled "+
+				"instructions:", err)
+			return txs
+		}
+		for _, li := range instances {
+			buf, _, contractID, _, err := st.GetValues(li.InstanceID.Slice())
+			if err != nil || contractID != ContractSchedulerID {
+				continue
+			}
+			var si ScheduledInstruction
+			if err := protobuf.Decode(buf, &si); err != nil {
+				continue
+			}
+			if si.Closed || !si.isDue(st.GetIndex(), timestamp) {
+				continue
+			}
+			txs = append(txs, NewClientTransaction(st.GetVersion(),
+				Instruction{
+					InstanceID: li.InstanceID,
+					Invoke: &Invoke{
+						ContractID: ContractSchedulerID,
+						Command:    "execute",
+					},
+				}))
+			if len(txs) == maxScheduledPerBlock {
+				return txs
+			}
+		}
+		if next == nil {
+			return txs
+		}
+		req.Start = next
+	}
+}
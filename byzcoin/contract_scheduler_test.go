@@ -0,0 +1,187 @@
+package byzcoin
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/protobuf"
+)
+
+const deferrableContract = "deferrable"
+
+// deferrableDummy is a dummy contract that accepts deferred instructions.
+type deferrableDummy struct {
+	dummyContract
+}
+
+func (dd *deferrableDummy) VerifyDeferredInstruction(rst ReadOnlyStateTrie,
+	inst Instruction, ctxHash []byte) error {
+	return inst.VerifyWithOption(rst, ctxHash,
+		&VerificationOptions{IgnoreCounters: true})
+}
+
+func TestScheduledInstruction_Hash(t *testing.T) {
+	ctx := NewClientTransaction(CurrentVersion, Instruction{
+		InstanceID: NewInstanceID([]byte("target")),
+		Invoke:     &Invoke{ContractID: "a", Command: "b"},
+	})
+	si := ScheduledInstruction{Instruction: ctx.Instructions[0],
+		BlockIndex: 10}
+	hash := si.Hash()
+
+	// The signers are not part of the hash, but the schedule is.
+	si.Instruction.SignerIdentities = []darc.Identity{
+		darc.NewSignerEd25519(nil, nil).Identity()}
+	si.Instruction.SignerCounter = []uint64{3}
+	require.Equal(t, hash, si.Hash())
+	si.Timestamp = 1
+	require.NotEqual(t, hash, si.Hash())
+
+	require.False(t, si.isDue(8, 1))
+	require.False(t, si.isDue(9, 0))
+	require.True(t, si.isDue(9, 1))
+}
+
+func TestService_Scheduler(t *testing.T) {
+	b := newBCT(t, nil)
+	for _, s := range b.Services {
+		s.testRegisterContract(deferrableContract,
+			func(in []byte) (Contract, error) {
+				return &deferrableDummy{dummyContract{Data: in}}, nil
+			})
+	}
+	b.AddGenesisRules("spawn:"+deferrableContract,
+		"invoke:"+deferrableContract+".update",
+		"spawn:"+ContractSchedulerID,
+		"delete:"+ContractSchedulerID)
+	b.CreateByzCoin()
+	defer b.CloseAll()
+
+	darcID := NewInstanceID(b.GenesisDarc.GetBaseID())
+	ctx, _ := b.SendInst(nil, Instruction{
+		InstanceID: darcID,
+		Spawn: &Spawn{
+			ContractID: deferrableContract,
+			Args:       Arguments{{Name: "data", Value: []byte("anyvalue")}},
+		},
+	})
+	target := NewInstanceID(ctx.Instructions[0].Hash())
+
+	// spawn returns the error of the transaction spawning the scheduled
+	// instruction.
+	var spawn func(si ScheduledInstruction) string
+	schedule := func(value string, at time.Time) (InstanceID,
+		ScheduledInstruction) {
+		ctx := NewClientTransaction(CurrentVersion, Instruction{
+			InstanceID: target,
+			Invoke: &Invoke{
+				ContractID: deferrableContract,
+				Command:    "update",
+				Args:       Arguments{{Name: "value", Value: []byte(value)}},
+			},
+			SignerIdentities: []darc.Identity{b.Signer.Identity()},
+			SignerCounter:    []uint64{0},
+		})
+		si := ScheduledInstruction{Instruction: ctx.Instructions[0],
+			Timestamp: at.UnixNano()}
+		require.NoError(t, si.Instruction.SignWith(si.Hash(), b.Signer))
+		require.Empty(t, spawn(si))
+		return si.InstanceID(), si
+	}
+	spawn = func(si ScheduledInstruction) string {
+		instrBuf, err := protobuf.Encode(&si.Instruction)
+		require.NoError(t, err)
+		timestamp := make([]byte, 8)
+		binary.LittleEndian.PutUint64(timestamp, uint64(si.Timestamp))
+
+		_, resp := b.SendInst(&TxArgs{Wait: 10}, Instruction{
+			InstanceID: darcID,
+			Spawn: &Spawn{
+				ContractID: ContractSchedulerID,
+				Args: Arguments{
+					{Name: "instruction", Value: instrBuf},
+					{Name: "timestamp", Value: timestamp},
+				},
+			},
+		})
+		if resp.Error != "" {
+			b.SignerCounter--
+		}
+		return resp.Error
+	}
+	requireClosed := func(id InstanceID) {
+		st, err := b.Services[0].getStateTrie(b.Genesis.SkipChainID())
+		require.NoError(t, err)
+		buf, _, _, _, err := st.GetValues(id.Slice())
+		require.NoError(t, err)
+		var si ScheduledInstruction
+		require.NoError(t, protobuf.Decode(buf, &si))
+		require.True(t, si.Closed)
+	}
+
+	// The leader executes the instruction once a block reaches its
+	// timestamp, without any other transaction afterwards.
+	at := time.Now().Add(2 * time.Second)
+	id, si := schedule("scheduled", at)
+	time.Sleep(time.Until(at))
+	b.SpawnDummy(nil)
+	var value []byte
+	for i := 0; i < 20 && string(value) != "scheduled"; i++ {
+		time.Sleep(b.PropagationInterval)
+		st, err := b.Services[0].getStateTrie(b.Genesis.SkipChainID())
+		require.NoError(t, err)
+		value, _, _, _, err = st.GetValues(target.Slice())
+		require.NoError(t, err)
+	}
+	require.Equal(t, "scheduled", string(value))
+	requireClosed(id)
+
+	// An instruction cannot be executed before it's due, but it can be
+	// cancelled.
+	id, si = schedule("later", time.Now().Add(time.Hour))
+	_, resp := b.SendInst(&TxArgs{Wait: 10}, Instruction{
+		InstanceID: id,
+		Invoke:     &Invoke{ContractID: ContractSchedulerID, Command: "execute"},
+	})
+	require.Contains(t, resp.Error, "not due")
+	b.SignerCounter--
+	b.SendInst(nil, Instruction{
+		InstanceID: id,
+		Delete:     &Delete{ContractID: ContractSchedulerID},
+	})
+	requireClosed(id)
+	requireDummyValue(t, b, target, "scheduled")
+
+	// The cancelled instruction cannot be scheduled again.
+	require.Contains(t, spawn(si), "existing instance")
+
+	// The signers of the instruction can cancel it too.
+	id, _ = schedule("cancelled", time.Now().Add(time.Hour))
+	b.SendInst(nil, Instruction{
+		InstanceID: id,
+		Invoke:     &Invoke{ContractID: ContractSchedulerID, Command: "cancel"},
+	})
+	requireClosed(id)
+	_, resp = b.SendInst(&TxArgs{Wait: 10}, Instruction{
+		InstanceID: id,
+		Invoke:     &Invoke{ContractID: ContractSchedulerID, Command: "cancel"},
+	})
+	require.Contains(t, resp.Error, "already been executed")
+	b.SignerCounter--
+}
+
+func TestIsScheduledTx(t *testing.T) {
+	tx := NewClientTransaction(CurrentVersion, Instruction{
+		InstanceID: NewInstanceID([]byte("scheduled")),
+		Invoke:     &Invoke{ContractID: ContractSchedulerID, Command: "execute"},
+	})
+	require.True(t, isScheduledTx(tx))
+	tx.Instructions[0].Signatures = [][]byte{{1}}
+	require.False(t, isScheduledTx(tx))
+	tx.Instructions[0].Signatures = nil
+	tx.Instructions[0].Invoke.Command = "cancel"
+	require.False(t, isScheduledTx(tx))
+}
@@ -0,0 +1,118 @@
+package byzcoin
+
+import (
+	"golang.org/x/xerrors"
+)
+
+// activeContractRegistry is the registry as seen by the instructions of a
+// block: the contracts are searched in the versions active in this block.
+type activeContractRegistry struct {
+	*contractRegistry
+	active map[string]int
+}
+
+// Search looks up the active version of the contract and returns the
+// constructor function if it exists and nil otherwise.
+func (r activeContractRegistry) Search(contractID string) (ContractFn, bool) {
+	return r.SearchVersion(contractID, r.active[contractID])
+}
+
+// registryAt returns the registry used by the instructions of the block
+// following the state.
+func (s *Service) registryAt(gs ReadOnlyStateTrie) ReadOnlyContractRegistry {
+	config, err := gs.LoadConfig()
+	if err != nil {
+		// Before the genesis transaction, there is no config and only
+		// the versions 0 are used.
+		return s.contracts
+	}
+	active := config.activeContractVersions(gs.GetIndex() + 1)
+	if len(active) == 0 {
+		return s.contracts
+	}
+	return activeContractRegistry{
+		contractRegistry: s.contracts,
+		active:           active,
+	}
+}
+
+// activeContractVersions returns the version of every contract with a
+// version other than 0 in the block with the given index.
+func (c ChainConfig) activeContractVersions(index int) map[string]int {
+	active := make(map[string]int)
+	from := make(map[string]int)
+	for _, cv := range c.ContractVersions {
+		if cv.BlockIndex > index {
+			continue
+		}
+		if f, ok := from[cv.ContractID]; ok && f > cv.BlockIndex {
+			continue
+		}
+		from[cv.ContractID] = cv.BlockIndex
+		active[cv.ContractID] = cv.Version
+	}
+	for id, version := range active {
+		if version == 0 {
+			delete(active, id)
+		}
+	}
+	return active
+}
+
+// checkContractVersions makes sure that the new config doesn't change the
+// versions of the contracts used up to the block with the given index, so
+// that a version can only be activated in a later block, and that every
+// version is in the registry.
+func (c ChainConfig) checkContractVersions(old *ChainConfig, index int,
+	registry ReadOnlyContractRegistry) error {
+	type activation struct {
+		contractID string
+		blockIndex int
+	}
+	seen := make(map[activation]int)
+	for _, cv := range c.ContractVersions {
+		if cv.ContractID == "" {
+			return xerrors.New("empty contract ID")
+		}
+		if cv.Version < 0 || cv.BlockIndex < 0 {
+			return xerrors.New("negative version or block index")
+		}
+		if _, ok := registry.SearchVersion(cv.ContractID, cv.Version); !ok {
+			return xerrors.Errorf("unknown version %d of %s", cv.Version,
+				cv.ContractID)
+		}
+		a := activation{cv.ContractID, cv.BlockIndex}
+		if _, ok := seen[a]; ok {
+			return xerrors.Errorf("two versions of %s from block %d",
+				cv.ContractID, cv.BlockIndex)
+		}
+		seen[a] = cv.Version
+	}
+
+	var oldVersions []ContractVersion
+	if old != nil {
+		oldVersions = old.ContractVersions
+	}
+	past := 0
+	for _, cv := range oldVersions {
+		if cv.BlockIndex > index {
+			continue
+		}
+		past++
+		v, ok := seen[activation{cv.ContractID, cv.BlockIndex}]
+		if !ok || v != cv.Version {
+			return xerrors.Errorf("cannot change the version of %s from "+
+				"block %d", cv.ContractID, cv.BlockIndex)
+		}
+	}
+	for _, cv := range c.ContractVersions {
+		if cv.BlockIndex <= index {
+			past--
+		}
+	}
+	if past != 0 {
+		return xerrors.Errorf("versions can only be activated after block %d",
+			index)
+	}
+	return nil
+}
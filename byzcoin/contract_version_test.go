@@ -0,0 +1,175 @@
+package byzcoin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/protobuf"
+)
+
+func TestContractRegistry_Versions(t *testing.T) {
+	cr := newContractRegistry()
+	require.Error(t, cr.registerVersion("a", 1, dummyContractFromBytes, false))
+	require.NoError(t, cr.register("a", dummyContractFromBytes, false))
+	require.NoError(t, cr.registerVersion("a", 1, dummyContractFromBytes, false))
+	require.Error(t, cr.registerVersion("a", 1, dummyContractFromBytes, false))
+	require.Error(t, cr.registerVersion("a", -1, dummyContractFromBytes, false))
+
+	_, exists := cr.SearchVersion("a", 1)
+	require.True(t, exists)
+	_, exists = cr.SearchVersion("a", 2)
+	require.False(t, exists)
+
+	clone := cr.clone()
+	_, exists = clone.SearchVersion("a", 1)
+	require.True(t, exists)
+}
+
+func TestChainConfig_ContractVersions(t *testing.T) {
+	c := ChainConfig{ContractVersions: []ContractVersion{
+		{ContractID: "a", Version: 1, BlockIndex: 5},
+		{ContractID: "a", Version: 2, BlockIndex: 10},
+		{ContractID: "a", Version: 0, BlockIndex: 15},
+		{ContractID: "b", Version: 3, BlockIndex: 7},
+	}}
+	require.Equal(t, map[string]int{}, c.activeContractVersions(4))
+	require.Equal(t, map[string]int{"a": 1}, c.activeContractVersions(5))
+	require.Equal(t, map[string]int{"a": 1, "b": 3}, c.activeContractVersions(9))
+	require.Equal(t, map[string]int{"a": 2, "b": 3}, c.activeContractVersions(10))
+	require.Equal(t, map[string]int{"b": 3}, c.activeContractVersions(15))
+
+	cr := newContractRegistry()
+	require.NoError(t, cr.register("a", dummyContractFromBytes, false))
+	require.NoError(t, cr.register("b", dummyContractFromBytes, false))
+	for _, v := range []int{1, 2, 3} {
+		require.NoError(t, cr.registerVersion("a", v, dummyContractFromBytes, false))
+	}
+	require.NoError(t, cr.registerVersion("b", 3, dummyContractFromBytes, false))
+
+	// The versions from block 10 and later can still be changed.
+	newC := c
+	newC.ContractVersions = append([]ContractVersion{},
+		c.ContractVersions[0], c.ContractVersions[3],
+		ContractVersion{ContractID: "a", Version: 3, BlockIndex: 12})
+	require.NoError(t, newC.checkContractVersions(&c, 9, cr))
+	// But not once they are used.
+	require.Error(t, newC.checkContractVersions(&c, 10, cr))
+	// A version cannot be activated in the current block.
+	require.Error(t, c.checkContractVersions(&ChainConfig{}, 5, cr))
+	require.NoError(t, c.checkContractVersions(&ChainConfig{}, 4, cr))
+
+	newC.ContractVersions = append(newC.ContractVersions,
+		ContractVersion{ContractID: "a", Version: 4, BlockIndex: 12})
+	require.Error(t, newC.checkContractVersions(&c, 9, cr))
+
+	// The versions must be in the registry.
+	newC.ContractVersions = append([]ContractVersion{},
+		c.ContractVersions[0], c.ContractVersions[3],
+		ContractVersion{ContractID: "a", Version: 5, BlockIndex: 12})
+	require.Error(t, newC.checkContractVersions(&c, 9, cr))
+	newC.ContractVersions[2].ContractID = "c"
+	newC.ContractVersions[2].Version = 0
+	require.Error(t, newC.checkContractVersions(&c, 9, cr))
+}
+
+func TestService_ContractVersions(t *testing.T) {
+	b := newBCTRun(t, nil)
+	defer b.CloseAll()
+
+	for _, s := range b.Services {
+		require.NoError(t, s.contracts.registerVersion(DummyContractName, 1,
+			func(in []byte) (Contract, error) {
+				return &dummyContractV1{dummyContract{Data: in}}, nil
+			}, true))
+	}
+
+	ctx, _ := b.SpawnDummy(nil)
+	requireDummyValue(t, b, NewInstanceID(ctx.Instructions[0].Hash()),
+		"anyvalue")
+
+	scID := b.Genesis.SkipChainID()
+	latest, err := b.Services[0].db().GetLatestByID(scID)
+	require.NoError(t, err)
+	config, err := b.Services[0].LoadConfig(scID)
+	require.NoError(t, err)
+	// The version can only be activated after the block of the update.
+	config.ContractVersions = []ContractVersion{{
+		ContractID: DummyContractName,
+		Version:    1,
+		BlockIndex: latest.Index + 2,
+	}}
+	buf, err := protobuf.Encode(config)
+	require.NoError(t, err)
+	b.SendInst(nil, Instruction{
+		InstanceID: ConfigInstanceID,
+		Invoke: &Invoke{
+			ContractID: ContractConfigID,
+			Command:    "update_config",
+			Args:       Arguments{{Name: "config", Value: buf}},
+		},
+	})
+
+	ctx, _ = b.SpawnDummy(nil)
+	requireDummyValue(t, b, NewInstanceID(ctx.Instructions[0].Hash()),
+		"v1:anyvalue")
+
+	// Versions in use cannot be changed anymore.
+	config.ContractVersions = nil
+	buf, err = protobuf.Encode(config)
+	require.NoError(t, err)
+	_, resp := b.SendInst(&TxArgs{Wait: 10}, Instruction{
+		InstanceID: ConfigInstanceID,
+		Invoke: &Invoke{
+			ContractID: ContractConfigID,
+			Command:    "update_config",
+			Args:       Arguments{{Name: "config", Value: buf}},
+		},
+	})
+	require.Contains(t, resp.Error, "contract versions")
+	b.SignerCounter--
+
+	// Versions that are not in the registry cannot be activated.
+	config.ContractVersions = []ContractVersion{{
+		ContractID: DummyContractName,
+		Version:    1,
+		BlockIndex: latest.Index + 2,
+	}, {
+		ContractID: DummyContractName,
+		Version:    2,
+		BlockIndex: latest.Index + 100,
+	}}
+	buf, err = protobuf.Encode(config)
+	require.NoError(t, err)
+	_, resp = b.SendInst(&TxArgs{Wait: 10}, Instruction{
+		InstanceID: ConfigInstanceID,
+		Invoke: &Invoke{
+			ContractID: ContractConfigID,
+			Command:    "update_config",
+			Args:       Arguments{{Name: "config", Value: buf}},
+		},
+	})
+	require.Contains(t, resp.Error, "unknown version 2")
+}
+
+func requireDummyValue(t *testing.T, b *BCTest, id InstanceID, value string) {
+	st, err := b.Services[0].getStateTrie(b.Genesis.SkipChainID())
+	require.NoError(t, err)
+	v, _, _, _, err := st.GetValues(id.Slice())
+	require.NoError(t, err)
+	require.Equal(t, value, string(v))
+}
+
+// dummyContractV1 prefixes the value of the spawned instances.
+type dummyContractV1 struct {
+	dummyContract
+}
+
+func (dc *dummyContractV1) Spawn(cdb ReadOnlyStateTrie, inst Instruction,
+	c []Coin) ([]StateChange, []Coin, error) {
+	sc, cout, err := dc.dummyContract.Spawn(cdb, inst, c)
+	if err != nil {
+		return nil, nil, err
+	}
+	sc[0].Value = append([]byte("v1:"), sc[0].Value...)
+	return sc, cout, nil
+}
@@ -80,6 +80,7 @@ func (b BasicContract) FormatMethod(instr Instruction) string {
 // ReadOnlyContractRegistry is the read-only interface for the contract registry.
 type ReadOnlyContractRegistry interface {
 	Search(contractID string) (ContractFn, bool)
+	SearchVersion(contractID string, version int) (ContractFn, bool)
 }
 
 // ContractWithRegistry is an interface to detect contracts that need a reference
@@ -97,6 +98,8 @@ type ContractFn func(in []byte) (Contract, error)
 // can be added for the global call.
 type contractRegistry struct {
 	registry map[string]ContractFn
+	// versions holds the versions other than 0 of the contracts
+	versions map[string]map[int]ContractFn
 	locked   bool
 	sync.Mutex
 }
@@ -124,6 +127,48 @@ func (cr *contractRegistry) register(contractID string, f ContractFn, ignoreLock
 	return nil
 }
 
+// registerVersion stores a version of a contract whose version 0 is already
+// in the registry, with the same rules as register.
+func (cr *contractRegistry) registerVersion(contractID string, version int,
+	f ContractFn, ignoreLock bool) error {
+	if version == 0 {
+		return cr.register(contractID, f, ignoreLock)
+	}
+	if version < 0 {
+		return xerrors.New("negative version")
+	}
+
+	cr.Lock()
+	defer cr.Unlock()
+	if cr.locked && !ignoreLock {
+		return xerrors.New("contract registry is locked")
+	}
+	if _, exists := cr.registry[contractID]; !exists {
+		return xerrors.New("version 0 of the contract is not registered")
+	}
+	if _, exists := cr.versions[contractID][version]; exists {
+		return xerrors.New("contract version already registered")
+	}
+	if cr.versions[contractID] == nil {
+		cr.versions[contractID] = make(map[int]ContractFn)
+	}
+	cr.versions[contractID][version] = f
+	return nil
+}
+
+// SearchVersion looks up the version of the contract and returns the
+// constructor function if it exists and nil otherwise.
+func (cr *contractRegistry) SearchVersion(contractID string,
+	version int) (ContractFn, bool) {
+	if version == 0 {
+		return cr.Search(contractID)
+	}
+	cr.Lock()
+	fn, exists := cr.versions[contractID][version]
+	cr.Unlock()
+	return fn, exists
+}
+
 // Search looks up the contract ID and returns the constructor function
 // if it exists and nil otherwise.
 func (cr *contractRegistry) Search(contractID string) (ContractFn, bool) {
@@ -148,6 +193,12 @@ func (cr *contractRegistry) clone() *contractRegistry {
 	for key, value := range cr.registry {
 		clone.registry[key] = value
 	}
+	for key, versions := range cr.versions {
+		clone.versions[key] = make(map[int]ContractFn)
+		for version, value := range versions {
+			clone.versions[key][version] = value
+		}
+	}
 	cr.Unlock()
 
 	return clone
@@ -156,6 +207,7 @@ func (cr *contractRegistry) clone() *contractRegistry {
 func newContractRegistry() *contractRegistry {
 	return &contractRegistry{
 		registry: make(map[string]ContractFn),
+		versions: make(map[string]map[int]ContractFn),
 		locked:   false,
 	}
 }
@@ -170,6 +222,17 @@ func RegisterGlobalContract(contractID string, f ContractFn) error {
 	return cothority.ErrorOrNil(err, "registration failed")
 }
 
+// RegisterGlobalContractVersion stores a version of the contract in the
+// global registry. The contract must already be registered with
+// RegisterGlobalContract, which is its version 0. A version is only used by a
+// chain from the block given in the ContractVersions of its config, so that
+// the blocks before it are always replayed with the same implementation.
+func RegisterGlobalContractVersion(contractID string, version int,
+	f ContractFn) error {
+	err := globalContractRegistry.registerVersion(contractID, version, f, false)
+	return cothority.ErrorOrNil(err, "registration failed")
+}
+
 // RegisterContract stores the contract in the service registry which
 // makes it only available to byzcoin.
 //
@@ -324,6 +387,7 @@ var ConfigInstanceID = InstanceID{}
 type contractConfig struct {
 	BasicContract
 	ChainConfig
+	contracts ReadOnlyContractRegistry
 }
 
 var _ Contract = (*contractConfig)(nil)
@@ -338,6 +402,12 @@ func contractConfigFromBytes(in []byte) (Contract, error) {
 	return c, nil
 }
 
+// SetRegistry keeps the reference of the contract registry, to check the
+// versions of the contracts of a new config.
+func (c *contractConfig) SetRegistry(r ReadOnlyContractRegistry) {
+	c.contracts = r
+}
+
 type darcContractIDs struct {
 	IDs []string
 }
@@ -358,6 +428,11 @@ func (c *contractConfig) VerifyInstruction(rst ReadOnlyStateTrie, inst Instructi
 		return nil
 	}
 
+	// The leader rotation is checked by the contract.
+	if inst.GetType() == InvokeType && inst.Invoke.Command == "rotate_leader" {
+		return nil
+	}
+
 	err = inst.Verify(rst, msg)
 	return cothority.ErrorOrNil(err, "instruction verification failed")
 }
@@ -471,6 +546,7 @@ func (c *contractConfig) Spawn(rst ReadOnlyStateTrie, inst Instruction, coins []
 // Invoke offers the following functions:
 //   - Invoke:update_config
 //   - Invoke:view_change
+//   - Invoke:rotate_leader
 //
 // Invoke:update_config should have the following input argument:
 //   - config ChainConfig
@@ -478,6 +554,11 @@ func (c *contractConfig) Spawn(rst ReadOnlyStateTrie, inst Instruction, coins []
 // Invoke:view_change sould have the following input arguments:
 //   - newview viewchange.NewViewReq
 //   - multisig []byte
+//
+// Invoke:rotate_leader hands over to the next leader when the term of the
+// current leader is over, see LeaderRotation. It needs no signature and has
+// the following input argument:
+//   - index uint64 - the index of the block, in little-endian
 func (c *contractConfig) Invoke(rst ReadOnlyStateTrie, inst Instruction, coins []Coin) ([]StateChange, []Coin, error) {
 	// Find the darcID for this instance.
 	var darcID darc.ID
@@ -510,6 +591,28 @@ func (c *contractConfig) Invoke(rst ReadOnlyStateTrie, inst Instruction, coins [
 		if err = newConfig.sanityCheck(oldConfig); err != nil {
 			return nil, nil, xerrors.Errorf("sanity check: %v", err)
 		}
+		if c.contracts == nil {
+			return nil, nil, xerrors.New("contracts registry is missing due to bad initialization")
+		}
+		// The instruction is executed in the block following the state.
+		err = newConfig.checkContractVersions(oldConfig, rst.GetIndex()+1,
+			c.contracts)
+		if err != nil {
+			return nil, nil, xerrors.Errorf("contract versions: %v", err)
+		}
+		if newConfig.LeaderRotation != nil {
+			newConfig = newConfig.withLeaderStart(oldConfig, rst.GetIndex()+1)
+			configBuf, err = protobuf.Encode(&newConfig)
+			if err != nil {
+				return nil, nil, xerrors.Errorf("encoding config: %v", err)
+			}
+		}
+		if newConfig.FeeBeneficiary != nil {
+			_, _, _, err = loadFeeCoin(rst, *newConfig.FeeBeneficiary)
+			if err != nil {
+				return nil, nil, xerrors.Errorf("fee beneficiary: %v", err)
+			}
+		}
 		var val []byte
 		val, _, _, _, err = rst.GetValues(darcID)
 		if err != nil {
@@ -574,6 +677,9 @@ func (c *contractConfig) Invoke(rst ReadOnlyStateTrie, inst Instruction, coins [
 
 		sc, err := updateRosterScs(rst, darcID, req.Roster)
 		return sc, coins, cothority.ErrorOrNil(err, "roster scs")
+	case "rotate_leader":
+		sc, err := rotateLeader(rst, inst)
+		return sc, coins, cothority.ErrorOrNil(err, "leader rotation")
 	default:
 		return nil, nil, xerrors.New("invalid invoke command: " + inst.Invoke.Command)
 	}
@@ -584,8 +690,10 @@ func updateRosterScs(rst ReadOnlyStateTrie, darcID darc.ID, newRoster onet.Roste
 	if err != nil {
 		return nil, xerrors.Errorf("reading trie: %v", err)
 	}
-	config.Roster = newRoster
-	configBuf, err := protobuf.Encode(config)
+	newConfig := *config
+	newConfig.Roster = newRoster
+	newConfig = newConfig.withLeaderStart(config, rst.GetIndex()+1)
+	configBuf, err := protobuf.Encode(&newConfig)
 	if err != nil {
 		return nil, xerrors.Errorf("encoding: %v", err)
 	}
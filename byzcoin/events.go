@@ -0,0 +1,186 @@
+package byzcoin
+
+import (
+	"crypto/sha256"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+func init() {
+	network.RegisterMessages(&StreamEventsRequest{}, &StreamEventsResponse{})
+}
+
+// NewEvent returns a state change emitting an event for the given instance.
+// A contract can return it from Spawn, Invoke or Delete, together with the
+// other state changes. The event is stored in the block if the transaction
+// is accepted.
+func NewEvent(iID InstanceID, contractID string, name string,
+	data []byte) (StateChange, error) {
+	buf, err := protobuf.Encode(&Event{
+		ContractID: contractID,
+		InstanceID: iID,
+		Name:       name,
+		Data:       data,
+	})
+	if err != nil {
+		return StateChange{}, xerrors.Errorf("encoding event: %v", err)
+	}
+	return NewStateChange(EmitEvent, iID, contractID, buf, nil), nil
+}
+
+// decodeEvent returns the event of an EmitEvent state change.
+func decodeEvent(sc StateChange) (Event, error) {
+	var ev Event
+	if err := protobuf.Decode(sc.Value, &ev); err != nil {
+		return ev, xerrors.Errorf("decoding event: %v", err)
+	}
+	if ev.ContractID != sc.ContractID ||
+		!ev.InstanceID.Equal(NewInstanceID(sc.InstanceID)) {
+		return ev, xerrors.New("event doesn't match its state change")
+	}
+	return ev, nil
+}
+
+// eventStateChange returns the EmitEvent state change of an event stored in
+// a block.
+func eventStateChange(ev Event) (StateChange, error) {
+	return NewEvent(ev.InstanceID, ev.ContractID, ev.Name, ev.Data)
+}
+
+// events returns the events emitted by the accepted transactions, in the
+// order of the transactions.
+func (txr TxResults) events() ([]Event, error) {
+	var evs []Event
+	for i, tx := range txr {
+		if !tx.Accepted {
+			continue
+		}
+		for _, sc := range tx.stateChanges {
+			if sc.StateAction != EmitEvent {
+				continue
+			}
+			ev, err := decodeEvent(sc)
+			if err != nil {
+				return nil, xerrors.Errorf("transaction %d: %v", i, err)
+			}
+			ev.TxIndex = i
+			evs = append(evs, ev)
+		}
+	}
+	return evs, nil
+}
+
+// eventsHash returns the sha256 of the events, or nil if there are none,
+// so that the header of a block without events doesn't change.
+func eventsHash(evs []Event) []byte {
+	if len(evs) == 0 {
+		return nil
+	}
+	h := sha256.New()
+	for _, ev := range evs {
+		buf, err := protobuf.Encode(&ev)
+		if err != nil {
+			log.Lvl2("Couldn't marshal event")
+		}
+		h.Write(buf)
+	}
+	return h.Sum(nil)
+}
+
+// matches returns true if the event passes all the filters of the request.
+func (req *StreamEventsRequest) matches(ev Event) bool {
+	if len(req.ContractIDs) > 0 {
+		found := false
+		for _, cid := range req.ContractIDs {
+			found = found || cid == ev.ContractID
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(req.InstanceIDs) > 0 {
+		found := false
+		for _, iid := range req.InstanceIDs {
+			found = found || iid.Equal(ev.InstanceID)
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(req.Names) > 0 {
+		found := false
+		for _, name := range req.Names {
+			found = found || name == ev.Name
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// filterEvents returns the events of the block matching the request, or nil
+// if there are none.
+func (req *StreamEventsRequest) filterEvents(sb *skipchain.SkipBlock) (*StreamEventsResponse, error) {
+	var body DataBody
+	if err := protobuf.Decode(sb.Payload, &body); err != nil {
+		return nil, cothority.ErrorOrNil(err, "decoding body")
+	}
+	resp := &StreamEventsResponse{
+		BlockID:    sb.Hash,
+		BlockIndex: sb.Index,
+	}
+	for _, ev := range body.Events {
+		if req.matches(ev) {
+			resp.Events = append(resp.Events, ev)
+		}
+	}
+	if len(resp.Events) == 0 {
+		return nil, nil
+	}
+	return resp, nil
+}
+
+// StreamEvents streams the events of every new block matching the filters
+// of the request, until the client closes the connection. Blocks without
+// matching events are skipped.
+func (s *Service) StreamEvents(msg *StreamEventsRequest) (chan *StreamEventsResponse, chan bool, error) {
+	stopChan := make(chan bool)
+	outChan := make(chan *StreamEventsResponse)
+	key := string(msg.ID)
+	blocks := s.streamingMan.newListener(key)
+
+	go func() {
+		defer close(outChan)
+		defer s.streamingMan.removeListener(key, blocks)
+		if !s.tasks.add(1) {
+			return
+		}
+		defer s.tasks.done()
+
+		forwardBlocks(blocks, stopChan,
+			func(sb *skipchain.SkipBlock) bool {
+				resp, err := msg.filterEvents(sb)
+				if err != nil {
+					log.Errorf("%s: couldn't get events of block %x: %v",
+						s.ServerIdentity(), sb.Hash, err)
+					return true
+				}
+				if resp == nil {
+					return true
+				}
+				select {
+				case outChan <- resp:
+					return true
+				case <-stopChan:
+					return false
+				}
+			})
+	}()
+	return outChan, stopChan, nil
+}
@@ -0,0 +1,112 @@
+package byzcoin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/protobuf"
+)
+
+const eventContract = "eventContract"
+
+// eventContractFunc emits an event before creating the new instance, to
+// make sure the event doesn't change the version of the instance.
+func eventContractFunc(rst ReadOnlyStateTrie, inst Instruction, c []Coin) ([]StateChange, []Coin, error) {
+	_, _, _, darcID, err := rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return nil, nil, err
+	}
+	iid := NewInstanceID(inst.Hash())
+	ev, err := NewEvent(iid, eventContract, "spawned",
+		inst.Spawn.Args.Search("data"))
+	if err != nil {
+		return nil, nil, err
+	}
+	return []StateChange{
+		ev,
+		NewStateChange(Create, iid, eventContract, []byte{}, darcID),
+	}, c, nil
+}
+
+func TestStreamEventsRequest_Matches(t *testing.T) {
+	ev := Event{
+		ContractID: "a",
+		InstanceID: NewInstanceID([]byte{1}),
+		Name:       "spawned",
+	}
+	require.True(t, (&StreamEventsRequest{}).matches(ev))
+	require.True(t, (&StreamEventsRequest{
+		ContractIDs: []string{"b", "a"},
+		Names:       []string{"spawned"},
+	}).matches(ev))
+	require.False(t, (&StreamEventsRequest{
+		ContractIDs: []string{"a"},
+		InstanceIDs: []InstanceID{NewInstanceID([]byte{2})},
+	}).matches(ev))
+	require.False(t, (&StreamEventsRequest{
+		Names: []string{"deleted"},
+	}).matches(ev))
+}
+
+func TestService_StreamEvents(t *testing.T) {
+	b := newBCT(t, nil)
+	for _, s := range b.Services {
+		s.testRegisterContract(eventContract, adaptor(eventContractFunc))
+	}
+	b.AddGenesisRules("spawn:" + eventContract)
+	b.CreateByzCoin()
+	defer b.CloseAll()
+
+	events, stop, err := b.Services[0].StreamEvents(&StreamEventsRequest{
+		ID:          b.Genesis.SkipChainID(),
+		ContractIDs: []string{eventContract},
+	})
+	require.NoError(t, err)
+	defer close(stop)
+
+	// Blocks without matching events are not sent.
+	b.SpawnDummy(nil)
+	ctx, _ := b.SendInst(nil, Instruction{
+		InstanceID: NewInstanceID(b.GenesisDarc.GetBaseID()),
+		Spawn: &Spawn{
+			ContractID: eventContract,
+			Args:       Arguments{{Name: "data", Value: []byte("event")}},
+		},
+	})
+	iid := NewInstanceID(ctx.Instructions[0].Hash())
+
+	var resp *StreamEventsResponse
+	select {
+	case resp = <-events:
+	case <-time.After(10 * b.PropagationInterval):
+		require.Fail(t, "didn't get the event")
+	}
+	require.Equal(t, 2, resp.BlockIndex)
+	require.Equal(t, 1, len(resp.Events))
+	require.Equal(t, "spawned", resp.Events[0].Name)
+	require.Equal(t, []byte("event"), resp.Events[0].Data)
+	require.True(t, iid.Equal(resp.Events[0].InstanceID))
+
+	// The events are committed in the header of the block.
+	sb, err := b.Services[0].db().GetLatestByID(b.Genesis.SkipChainID())
+	require.NoError(t, err)
+	require.True(t, sb.Hash.Equal(resp.BlockID))
+	header, err := decodeBlockHeader(sb)
+	require.NoError(t, err)
+	var body DataBody
+	require.NoError(t, protobuf.Decode(sb.Payload, &body))
+	require.NotNil(t, header.EventsHash)
+	require.Equal(t, header.EventsHash, eventsHash(body.Events))
+
+	// The event doesn't change the version of the instance and is not
+	// stored with its state changes.
+	resp2, err := b.Services[0].GetAllInstanceVersion(&GetAllInstanceVersion{
+		SkipChainID: b.Genesis.SkipChainID(),
+		InstanceID:  iid,
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(resp2.StateChanges))
+	require.Equal(t, Create, resp2.StateChanges[0].StateChange.StateAction)
+	require.Equal(t, uint64(0), resp2.StateChanges[0].StateChange.Version)
+}
@@ -0,0 +1,463 @@
+package byzcoin
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin/viewchange"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/kyber/v3/sign/bls"
+	"go.dedis.ch/kyber/v3/sign/schnorr"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+	"golang.org/x/xerrors"
+)
+
+// evidenceWindow is the number of blocks during which the view-change
+// requests of the nodes are kept to be compared with new ones.
+const evidenceWindow = 16
+
+// maxEvidence is the maximum number of evidences kept in memory for a chain.
+// The oldest ones are forgotten first.
+const maxEvidence = 100
+
+// Verify makes sure that the evidence holds two conflicting view-change
+// requests of the signer, for blocks of the given chain.
+func (ev Evidence) Verify(scID skipchain.SkipBlockID) error {
+	if len(ev.Views) != 2 {
+		return xerrors.New("need two views")
+	}
+	for _, sv := range ev.Views {
+		if err := sv.verify(scID, ev.Signer); err != nil {
+			return xerrors.Errorf("view of block %x: %v", sv.Block.Hash, err)
+		}
+	}
+	first, second := ev.Views[0], ev.Views[1]
+	if first.LeaderIndex != second.LeaderIndex {
+		return xerrors.New("views have different leader indexes")
+	}
+	if first.Block.Index != second.Block.Index {
+		return xerrors.New("blocks have different indexes")
+	}
+	if first.Block.Hash.Equal(second.Block.Hash) {
+		return xerrors.New("views are the same")
+	}
+	return nil
+}
+
+// InstanceID returns the ID of the instance registering the evidence. It
+// doesn't depend on the order of the views, so that an evidence can only be
+// registered once.
+func (ev Evidence) InstanceID() InstanceID {
+	h := sha256.New()
+	h.Write([]byte(ContractEvidenceID))
+	h.Write(ev.Signer)
+	hashes := make([][]byte, len(ev.Views))
+	for i, sv := range ev.Views {
+		hashes[i] = sv.Block.Hash
+	}
+	if len(hashes) == 2 && bytes.Compare(hashes[0], hashes[1]) > 0 {
+		hashes[0], hashes[1] = hashes[1], hashes[0]
+	}
+	for _, hash := range hashes {
+		h.Write(hash)
+	}
+	if len(ev.Views) > 0 {
+		buf := make([]byte, 4)
+		binary.LittleEndian.PutUint32(buf, uint32(ev.Views[0].LeaderIndex))
+		h.Write(buf)
+	}
+	return NewInstanceID(h.Sum(nil))
+}
+
+// verify checks that the block is from the chain and that the signer is in
+// its roster and signed the view-change request.
+func (sv SignedView) verify(scID skipchain.SkipBlockID, signer []byte) error {
+	if sv.Block.SkipBlockFix == nil {
+		return xerrors.New("missing block")
+	}
+	if !sv.Block.Hash.Equal(sv.Block.CalculateHash()) {
+		return xerrors.New("wrong block hash")
+	}
+	if !sv.Block.SkipChainID().Equal(scID) {
+		return xerrors.New("block is from another chain")
+	}
+	if sv.Block.Roster == nil {
+		return xerrors.New("missing roster")
+	}
+	for _, si := range sv.Block.Roster.List {
+		public, err := si.Public.MarshalBinary()
+		if err != nil || !bytes.Equal(public, signer) {
+			continue
+		}
+		// The IDs of the roster are not part of the block hash.
+		id := network.NewServerIdentity(si.Public, si.Address).ID
+		err = schnorr.Verify(cothority.Suite, si.Public,
+			sv.initReq(id).Hash(), sv.Signature)
+		return cothority.ErrorOrNil(err, "invalid signature")
+	}
+	return xerrors.New("signer is not in the roster")
+}
+
+// initReq returns the view-change request signed by the node.
+func (sv SignedView) initReq(signerID network.ServerIdentityID) viewchange.InitReq {
+	return viewchange.InitReq{
+		View: viewchange.View{
+			ID:          sv.Block.Hash,
+			Gen:         sv.Block.SkipChainID(),
+			LeaderIndex: sv.LeaderIndex,
+		},
+		SignerID:  signerID,
+		Signature: sv.Signature,
+	}
+}
+
+// Verify makes sure that the signer signed forward links from the same block
+// to two different blocks. It doesn't check that the signer is in the roster
+// of the block.
+func (cs ConflictingSignature) Verify() error {
+	if len(cs.Links) != 2 {
+		return xerrors.New("need two links")
+	}
+	if cs.Links[0].To.Equal(cs.Links[1].To) {
+		return xerrors.New("links have the same target")
+	}
+	public := pairingSuite.G2().Point()
+	if err := public.UnmarshalBinary(cs.Signer); err != nil {
+		return xerrors.Errorf("invalid signer: %v", err)
+	}
+	for _, l := range cs.Links {
+		err := bls.Verify(pairingSuite, public, l.hash(cs.From), l.Signature)
+		if err != nil {
+			return xerrors.Errorf("link to %x: %v", l.To, err)
+		}
+	}
+	return nil
+}
+
+// verifyOnChain checks the signatures, and that the signer is in the roster
+// of the block of the forward links, which must be in the chain.
+func (cs ConflictingSignature) verifyOnChain(rosc ReadOnlySkipChain) error {
+	if err := cs.Verify(); err != nil {
+		return err
+	}
+	from, err := rosc.GetBlock(cs.From)
+	if err != nil {
+		return xerrors.Errorf("getting block: %v", err)
+	}
+	if from == nil || from.Roster == nil {
+		return xerrors.New("unknown block")
+	}
+	genesis, err := rosc.GetGenesisBlock()
+	if err != nil {
+		return xerrors.Errorf("getting genesis block: %v", err)
+	}
+	if !from.SkipChainID().Equal(genesis.SkipChainID()) {
+		return xerrors.New("block is from another chain")
+	}
+	for _, si := range from.Roster.List {
+		public, err := si.ServicePublic(skipchain.ServiceName).MarshalBinary()
+		if err == nil && bytes.Equal(public, cs.Signer) {
+			return nil
+		}
+	}
+	return xerrors.New("signer is not in the roster")
+}
+
+// InstanceID returns the ID of the instance registering the conflicting
+// signatures. It doesn't depend on the order of the links.
+func (cs ConflictingSignature) InstanceID() InstanceID {
+	h := sha256.New()
+	h.Write([]byte(ContractEvidenceID))
+	h.Write([]byte("signature"))
+	h.Write(cs.Signer)
+	h.Write(cs.From)
+	targets := make([][]byte, len(cs.Links))
+	for i, l := range cs.Links {
+		targets[i] = l.To
+	}
+	if len(targets) == 2 && bytes.Compare(targets[0], targets[1]) > 0 {
+		targets[0], targets[1] = targets[1], targets[0]
+	}
+	for _, to := range targets {
+		h.Write(to)
+	}
+	return NewInstanceID(h.Sum(nil))
+}
+
+// hash returns the hash of the forward link, which is the message signed by
+// the nodes.
+func (l SignedLink) hash(from skipchain.SkipBlockID) []byte {
+	h := sha256.New()
+	h.Write(from)
+	h.Write(l.To)
+	h.Write(l.NewRosterID)
+	return h.Sum(nil)
+}
+
+type signedViewKey struct {
+	scID        string
+	signer      string
+	leaderIndex int
+	blockIndex  int
+}
+
+type signedLinkKey struct {
+	scID   string
+	signer string
+	from   string
+}
+
+type signedLink struct {
+	index int
+	link  SignedLink
+}
+
+// forwardLink is the block and the forward link of a signed message.
+type forwardLink struct {
+	block *skipchain.SkipBlock
+	link  *skipchain.ForwardLink
+}
+
+// evidenceCollector keeps the recent view-change requests and forward-link
+// signatures of the nodes to detect the conflicting ones, and the resulting
+// evidence.
+type evidenceCollector struct {
+	sync.Mutex
+	views      map[signedViewKey]SignedView
+	evidence   map[string][]Evidence
+	links      map[signedLinkKey]signedLink
+	signatures map[string][]ConflictingSignature
+	messages   map[string]forwardLink
+}
+
+func newEvidenceCollector() *evidenceCollector {
+	return &evidenceCollector{
+		views:      make(map[signedViewKey]SignedView),
+		evidence:   make(map[string][]Evidence),
+		links:      make(map[signedLinkKey]signedLink),
+		signatures: make(map[string][]ConflictingSignature),
+		messages:   make(map[string]forwardLink),
+	}
+}
+
+// add records the view signed by the signer, which must have been verified,
+// and returns the evidence if it conflicts with a view seen before.
+func (ec *evidenceCollector) add(signer []byte, sv SignedView) *Evidence {
+	key := signedViewKey{
+		scID:        string(sv.Block.SkipChainID()),
+		signer:      string(signer),
+		leaderIndex: sv.LeaderIndex,
+		blockIndex:  sv.Block.Index,
+	}
+
+	ec.Lock()
+	defer ec.Unlock()
+	prev, ok := ec.views[key]
+	if !ok {
+		for k := range ec.views {
+			if k.scID == key.scID && k.blockIndex+evidenceWindow < key.blockIndex {
+				delete(ec.views, k)
+			}
+		}
+		ec.views[key] = sv
+		return nil
+	}
+	if prev.Block.Hash.Equal(sv.Block.Hash) {
+		return nil
+	}
+
+	ev := Evidence{Signer: signer, Views: []SignedView{prev, sv}}
+	list := ec.evidence[key.scID]
+	for _, e := range list {
+		if e.InstanceID().Equal(ev.InstanceID()) {
+			return nil
+		}
+	}
+	if len(list) == maxEvidence {
+		list = list[1:]
+	}
+	ec.evidence[key.scID] = append(list, ev)
+	return &ev
+}
+
+// addLink records the signature of the signer on a forward link from the
+// block, which must have been verified, and returns the conflict if the
+// signer signed a forward link from the same block to another one before.
+// index is the index of the target block.
+func (ec *evidenceCollector) addLink(signer []byte,
+	scID, from skipchain.SkipBlockID, index int,
+	link SignedLink) *ConflictingSignature {
+	key := signedLinkKey{
+		scID:   string(scID),
+		signer: string(signer),
+		from:   string(from),
+	}
+
+	ec.Lock()
+	defer ec.Unlock()
+	prev, ok := ec.links[key]
+	if !ok {
+		for k, l := range ec.links {
+			if k.scID == key.scID && l.index+evidenceWindow < index {
+				delete(ec.links, k)
+			}
+		}
+		ec.links[key] = signedLink{index: index, link: link}
+		return nil
+	}
+	if prev.link.To.Equal(link.To) {
+		return nil
+	}
+
+	cs := ConflictingSignature{Signer: signer, From: from,
+		Links: []SignedLink{prev.link, link}}
+	list := ec.signatures[key.scID]
+	for _, c := range list {
+		if bytes.Equal(c.Signer, signer) && c.From.Equal(from) &&
+			c.Links[1].To.Equal(link.To) {
+			return nil
+		}
+	}
+	if len(list) == maxEvidence {
+		list = list[1:]
+	}
+	ec.signatures[key.scID] = append(list, cs)
+	return &cs
+}
+
+// forwardLink returns the forward link of a message signed by the nodes, if
+// it has been seen before.
+func (ec *evidenceCollector) forwardLink(msg []byte) (forwardLink, bool) {
+	ec.Lock()
+	defer ec.Unlock()
+	fl, ok := ec.messages[string(msg)]
+	return fl, ok
+}
+
+// setForwardLink remembers the forward link of a message, so that it is not
+// decoded again for each signature.
+func (ec *evidenceCollector) setForwardLink(msg []byte, fl forwardLink) {
+	ec.Lock()
+	defer ec.Unlock()
+	for m, l := range ec.messages {
+		if l.block.Index+evidenceWindow < fl.block.Index {
+			delete(ec.messages, m)
+		}
+	}
+	ec.messages[string(msg)] = fl
+}
+
+// get returns the evidence collected for the chain.
+func (ec *evidenceCollector) get(scID skipchain.SkipBlockID) []Evidence {
+	ec.Lock()
+	defer ec.Unlock()
+	return append([]Evidence{}, ec.evidence[string(scID)]...)
+}
+
+// getSignatures returns the conflicting signatures collected for the chain.
+func (ec *evidenceCollector) getSignatures(
+	scID skipchain.SkipBlockID) []ConflictingSignature {
+	ec.Lock()
+	defer ec.Unlock()
+	return append([]ConflictingSignature{}, ec.signatures[string(scID)]...)
+}
+
+// evidenceBlock returns a copy of the block without its payload and forward
+// links.
+func evidenceBlock(sb *skipchain.SkipBlock) *skipchain.SkipBlock {
+	block := sb.Copy()
+	block.Payload = nil
+	block.ForwardLink = nil
+	return block
+}
+
+// collectEvidence compares a view-change request, whose signature has been
+// verified, with the previous ones of the same node. sb is the block of the
+// view.
+func (s *Service) collectEvidence(req viewchange.InitReq,
+	sb *skipchain.SkipBlock) {
+	_, si := sb.Roster.Search(req.SignerID)
+	if si == nil {
+		return
+	}
+	signer, err := si.Public.MarshalBinary()
+	if err != nil {
+		log.Error(s.ServerIdentity(), "couldn't marshal public key:", err)
+		return
+	}
+
+	ev := s.evidence.add(signer, SignedView{
+		Block:       *evidenceBlock(sb),
+		LeaderIndex: req.View.LeaderIndex,
+		Signature:   req.Signature,
+	})
+	if ev != nil {
+		log.Warnf("%s: %s signed view-changes for blocks %x and %x at "+
+			"index %d", s.ServerIdentity(), si, ev.Views[0].Block.Hash,
+			ev.Views[1].Block.Hash, sb.Index)
+	}
+}
+
+// collectSignature is called by the blscosi protocols of the node with the
+// signature of a child, once it has been verified. If the message is a
+// forward link of a chain, the signature is compared with the previous ones
+// of the same node from the same block.
+func (s *Service) collectSignature(signer *network.ServerIdentity, msg,
+	data, sig []byte) {
+	fl, ok := s.evidence.forwardLink(msg)
+	if !ok {
+		_, fsInt, err := network.Unmarshal(data, cothority.Suite)
+		if err != nil {
+			return
+		}
+		fs, ok := fsInt.(*skipchain.ForwardSignature)
+		if !ok || fs.TargetHeight != 0 || fs.Newest == nil ||
+			!s.hasStateTrie(fs.Newest.SkipChainID()) {
+			return
+		}
+		prev := s.db().GetByID(fs.Previous)
+		if prev == nil {
+			return
+		}
+		fl = forwardLink{block: fs.Newest,
+			link: skipchain.NewForwardLink(prev, fs.Newest)}
+		if !bytes.Equal(fl.link.Hash(), msg) {
+			return
+		}
+		s.evidence.setForwardLink(msg, fl)
+	}
+
+	public, err := signer.ServicePublic(skipchain.ServiceName).MarshalBinary()
+	if err != nil {
+		log.Error(s.ServerIdentity(), "couldn't marshal public key:", err)
+		return
+	}
+	link := SignedLink{To: fl.link.To, Signature: sig}
+	if fl.link.NewRoster != nil {
+		link.NewRosterID = fl.link.NewRoster.ID[:]
+	}
+	cs := s.evidence.addLink(public, fl.block.SkipChainID(), fl.link.From,
+		fl.block.Index, link)
+	if cs != nil {
+		log.Warnf("%s: %s signed forward links from %x to %x and %x",
+			s.ServerIdentity(), signer, cs.From, cs.Links[0].To,
+			cs.Links[1].To)
+	}
+}
+
+// GetEvidence returns the evidence of misbehaviour collected by the node
+// for the chain. It can be registered on the chain with the evidence
+// contract.
+func (s *Service) GetEvidence(req *GetEvidence) (*GetEvidenceResponse, error) {
+	if !s.hasStateTrie(req.SkipChainID) {
+		return nil, xerrors.New("unknown chain")
+	}
+	return &GetEvidenceResponse{
+		Evidence:   s.evidence.get(req.SkipChainID),
+		Signatures: s.evidence.getSignatures(req.SkipChainID),
+	}, nil
+}
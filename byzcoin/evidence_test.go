@@ -0,0 +1,280 @@
+package byzcoin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin/viewchange"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/sign/bls"
+	"go.dedis.ch/kyber/v3/util/key"
+	"go.dedis.ch/kyber/v3/util/random"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+)
+
+// signView returns the view of the block signed by the node.
+func signView(t *testing.T, sb *skipchain.SkipBlock, leaderIndex int,
+	si *network.ServerIdentity, priv kyber.Scalar) SignedView {
+	req := viewchange.InitReq{
+		View: viewchange.View{
+			ID:          sb.Hash,
+			Gen:         sb.SkipChainID(),
+			LeaderIndex: leaderIndex,
+		},
+		SignerID: si.ID,
+	}
+	require.NoError(t, req.Sign(priv))
+	block := sb.Copy()
+	block.Payload = nil
+	block.ForwardLink = nil
+	return SignedView{Block: *block, LeaderIndex: leaderIndex,
+		Signature: req.Signature}
+}
+
+// conflictingBlock returns a block with the same index as sb but another
+// hash.
+func conflictingBlock(sb *skipchain.SkipBlock) *skipchain.SkipBlock {
+	other := sb.Copy()
+	other.Data = append(other.Data, 0)
+	other.Hash = other.CalculateHash()
+	return other
+}
+
+func TestEvidence_Verify(t *testing.T) {
+	kp := key.NewKeyPair(cothority.Suite)
+	si := network.NewServerIdentity(kp.Public, "tls://0.0.0.1:2000")
+	signer, err := kp.Public.MarshalBinary()
+	require.NoError(t, err)
+
+	gen := getSBID("genesis")
+	sb := skipchain.NewSkipBlock()
+	sb.Index = 3
+	sb.GenesisID = gen
+	sb.Roster = onet.NewRoster([]*network.ServerIdentity{si})
+	sb.Hash = sb.CalculateHash()
+	other := conflictingBlock(sb)
+
+	ev := Evidence{Signer: signer, Views: []SignedView{
+		signView(t, sb, 1, si, kp.Private),
+		signView(t, other, 1, si, kp.Private),
+	}}
+	require.NoError(t, ev.Verify(gen))
+	require.Error(t, ev.Verify(getSBID("other")))
+
+	// The registered instance doesn't depend on the order of the views.
+	reversed := Evidence{Signer: signer, Views: []SignedView{ev.Views[1],
+		ev.Views[0]}}
+	require.Equal(t, ev.InstanceID(), reversed.InstanceID())
+
+	// A node can sign views for different leader indexes.
+	ev.Views[1] = signView(t, other, 2, si, kp.Private)
+	require.Error(t, ev.Verify(gen))
+	ev.Views[1] = signView(t, sb, 1, si, kp.Private)
+	require.Error(t, ev.Verify(gen))
+
+	ev.Views[1] = signView(t, other, 1, si, kp.Private)
+	ev.Views[1].Signature = ev.Views[0].Signature
+	require.Error(t, ev.Verify(gen))
+	ev.Views[1] = signView(t, other, 1, si, key.NewKeyPair(cothority.Suite).Private)
+	require.Error(t, ev.Verify(gen))
+}
+
+func TestEvidenceCollector(t *testing.T) {
+	kp := key.NewKeyPair(cothority.Suite)
+	si := network.NewServerIdentity(kp.Public, "tls://0.0.0.1:2000")
+	sb := skipchain.NewSkipBlock()
+	sb.Index = 3
+	sb.GenesisID = getSBID("genesis")
+	sb.Roster = onet.NewRoster([]*network.ServerIdentity{si})
+	sb.Hash = sb.CalculateHash()
+	other := conflictingBlock(sb)
+
+	ec := newEvidenceCollector()
+	signer := []byte("signer")
+	require.Nil(t, ec.add(signer, signView(t, sb, 1, si, kp.Private)))
+	require.Nil(t, ec.add(signer, signView(t, sb, 1, si, kp.Private)))
+	require.Nil(t, ec.add(signer, signView(t, other, 2, si, kp.Private)))
+	require.Nil(t, ec.add([]byte("another"), signView(t, other, 1, si,
+		kp.Private)))
+	require.NotNil(t, ec.add(signer, signView(t, other, 1, si, kp.Private)))
+	require.Nil(t, ec.add(signer, signView(t, other, 1, si, kp.Private)))
+	require.Len(t, ec.get(sb.SkipChainID()), 1)
+	require.Empty(t, ec.get(getSBID("other")))
+}
+
+func TestService_Evidence(t *testing.T) {
+	b := newBCT(t, nil)
+	b.AddGenesisRules("spawn:" + ContractEvidenceID)
+	b.CreateByzCoin()
+	defer b.CloseAll()
+
+	b.SpawnDummy(nil)
+	scID := b.Genesis.SkipChainID()
+	sb, err := b.Services[0].db().GetLatestByID(scID)
+	require.NoError(t, err)
+	require.True(t, sb.Index > 0)
+
+	// The second node signed a view-change for a block that is not on the
+	// chain.
+	s := b.Services[1]
+	signer, err := s.ServerIdentity().Public.MarshalBinary()
+	require.NoError(t, err)
+	view := signView(t, sb, 1, s.ServerIdentity(), s.getPrivateKey())
+	other := signView(t, conflictingBlock(sb), 1, s.ServerIdentity(),
+		s.getPrivateKey())
+
+	spawn := func(ev Evidence) string {
+		buf, err := protobuf.Encode(&ev)
+		require.NoError(t, err)
+		_, resp := b.SendInst(&TxArgs{Wait: 10}, Instruction{
+			InstanceID: NewInstanceID(b.GenesisDarc.GetBaseID()),
+			Spawn: &Spawn{
+				ContractID: ContractEvidenceID,
+				Args:       Arguments{{Name: "evidence", Value: buf}},
+			},
+		})
+		if resp.Error != "" {
+			b.SignerCounter--
+		}
+		return resp.Error
+	}
+
+	require.Contains(t, spawn(Evidence{Signer: signer,
+		Views: []SignedView{view, view}}), "invalid evidence")
+	ev := Evidence{Signer: signer, Views: []SignedView{view, other}}
+	require.Empty(t, spawn(ev))
+	st, err := b.Services[0].getStateTrie(scID)
+	require.NoError(t, err)
+	_, _, contractID, _, err := st.GetValues(ev.InstanceID().Slice())
+	require.NoError(t, err)
+	require.Equal(t, ContractEvidenceID, contractID)
+
+	// The same evidence is only registered once.
+	require.NotEmpty(t, spawn(Evidence{Signer: signer,
+		Views: []SignedView{other, view}}))
+
+	// The node collects the conflicting requests it verifies.
+	resp, err := b.Services[0].GetEvidence(&GetEvidence{SkipChainID: scID})
+	require.NoError(t, err)
+	require.Empty(t, resp.Evidence)
+	b.Services[0].collectEvidence(view.initReq(s.ServerIdentity().ID), sb)
+	b.Services[0].collectEvidence(other.initReq(s.ServerIdentity().ID),
+		&other.Block)
+	resp, err = b.Services[0].GetEvidence(&GetEvidence{SkipChainID: scID})
+	require.NoError(t, err)
+	require.Len(t, resp.Evidence, 1)
+	require.NoError(t, resp.Evidence[0].Verify(scID))
+}
+
+func TestConflictingSignature_Verify(t *testing.T) {
+	kp := bls.NewKeyPair(pairingSuite, random.New())
+	signer, err := kp.Public.MarshalBinary()
+	require.NoError(t, err)
+	from := getSBID("from")
+	signLink := func(to string) SignedLink {
+		link := SignedLink{To: getSBID(to)}
+		link.Signature, err = bls.Sign(pairingSuite, kp.Private,
+			link.hash(from))
+		require.NoError(t, err)
+		return link
+	}
+
+	cs := ConflictingSignature{Signer: signer, From: from,
+		Links: []SignedLink{signLink("first"), signLink("second")}}
+	require.NoError(t, cs.Verify())
+
+	cs.Links[1].Signature = cs.Links[0].Signature
+	require.Error(t, cs.Verify())
+	cs.Links[1] = cs.Links[0]
+	require.Error(t, cs.Verify())
+	cs.Links = cs.Links[:1]
+	require.Error(t, cs.Verify())
+}
+
+func TestService_EvidenceForwardLinks(t *testing.T) {
+	b := newBCT(t, nil)
+	b.AddGenesisRules("spawn:" + ContractEvidenceID)
+	b.CreateByzCoin()
+	defer b.CloseAll()
+
+	b.SpawnDummy(nil)
+	scID := b.Genesis.SkipChainID()
+	s := b.Services[0]
+	sb, err := s.db().GetLatestByID(scID)
+	require.NoError(t, err)
+	require.True(t, sb.Index > 0)
+	prev := s.db().GetByID(sb.BackLinkIDs[0])
+	require.NotNil(t, prev)
+
+	// A block after the previous one, with another timestamp.
+	header, err := decodeBlockHeader(sb)
+	require.NoError(t, err)
+	header.Timestamp++
+	other := sb.Copy()
+	other.Data, err = protobuf.Encode(header)
+	require.NoError(t, err)
+	other.Hash = other.CalculateHash()
+
+	// The second node signs forward links to both blocks.
+	signer := b.Services[1].ServerIdentity()
+	sign := func(newest *skipchain.SkipBlock) {
+		data, err := network.Marshal(&skipchain.ForwardSignature{
+			Previous: prev.Hash,
+			Newest:   newest,
+		})
+		require.NoError(t, err)
+		msg := skipchain.NewForwardLink(prev, newest).Hash()
+		sig, err := bls.Sign(pairingSuite,
+			signer.ServicePrivate(skipchain.ServiceName), msg)
+		require.NoError(t, err)
+		s.collectSignature(signer, msg, data, sig)
+	}
+	sign(sb)
+	sign(sb)
+	resp, err := s.GetEvidence(&GetEvidence{SkipChainID: scID})
+	require.NoError(t, err)
+	require.Empty(t, resp.Signatures)
+	sign(other)
+	resp, err = s.GetEvidence(&GetEvidence{SkipChainID: scID})
+	require.NoError(t, err)
+	require.Len(t, resp.Signatures, 1)
+	require.NoError(t, resp.Signatures[0].Verify())
+	public, err := signer.ServicePublic(skipchain.ServiceName).MarshalBinary()
+	require.NoError(t, err)
+	require.Equal(t, public, resp.Signatures[0].Signer)
+
+	// The signatures are registered on the chain.
+	spawn := func(cs ConflictingSignature) string {
+		buf, err := protobuf.Encode(&cs)
+		require.NoError(t, err)
+		_, resp := b.SendInst(&TxArgs{Wait: 10}, Instruction{
+			InstanceID: NewInstanceID(b.GenesisDarc.GetBaseID()),
+			Spawn: &Spawn{
+				ContractID: ContractEvidenceID,
+				Args:       Arguments{{Name: "signature", Value: buf}},
+			},
+		})
+		if resp.Error != "" {
+			b.SignerCounter--
+		}
+		return resp.Error
+	}
+	cs := resp.Signatures[0]
+	unknown := cs
+	unknown.From = getSBID("unknown")
+	require.Contains(t, spawn(unknown), "invalid signatures")
+	require.Empty(t, spawn(cs))
+	st, err := s.getStateTrie(scID)
+	require.NoError(t, err)
+	_, _, contractID, _, err := st.GetValues(cs.InstanceID().Slice())
+	require.NoError(t, err)
+	require.Equal(t, ContractEvidenceID, contractID)
+
+	// The same signatures are only registered once.
+	require.NotEmpty(t, spawn(ConflictingSignature{Signer: cs.Signer,
+		From: cs.From, Links: []SignedLink{cs.Links[1], cs.Links[0]}}))
+}
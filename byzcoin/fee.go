@@ -0,0 +1,162 @@
+package byzcoin
+
+import (
+	"sort"
+
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// feeCoinContractID is the ID of the coin contract that can pay fees. It is
+// the same as contracts.ContractCoinID, which cannot be imported here.
+const feeCoinContractID = "coin"
+
+// payFee checks the signatures of the fee, then debits it from its coin
+// instance and credits it to the beneficiary of the ChainConfig. The
+// returned state changes have already been applied to sst.
+// The caller only keeps them if the whole transaction is accepted: as the
+// fee signature ignores the signer counters, which are not incremented by a
+// refused transaction, charging refused transactions would let anybody
+// replay them to empty the paying instance.
+func payFee(sst *stagingStateTrie, tx ClientTransaction, ctxHash []byte) (StateChanges, error) {
+	if tx.Fee == nil {
+		return nil, nil
+	}
+	if err := verifyFee(sst, tx, ctxHash); err != nil {
+		return nil, xerrors.Errorf("verifying fee: %v", err)
+	}
+	return transferFee(sst, tx)
+}
+
+// transferFee moves the fee to the beneficiary without checking the
+// signatures.
+func transferFee(sst *stagingStateTrie, tx ClientTransaction) (StateChanges, error) {
+	if tx.Fee == nil {
+		return nil, nil
+	}
+	if tx.Fee.Amount == 0 {
+		return nil, xerrors.New("fee amount is zero")
+	}
+	config, err := sst.LoadConfig()
+	if err != nil {
+		return nil, xerrors.Errorf("reading config: %v", err)
+	}
+	if config.FeeBeneficiary == nil {
+		return nil, xerrors.New("this chain doesn't accept fees")
+	}
+	if tx.Fee.Coin.Equal(*config.FeeBeneficiary) {
+		return nil, xerrors.New("cannot pay the fee to the beneficiary itself")
+	}
+
+	payer, payerVer, payerDarc, err := loadFeeCoin(sst, tx.Fee.Coin)
+	if err != nil {
+		return nil, xerrors.Errorf("fee coin: %v", err)
+	}
+	beneficiary, benefVer, benefDarc, err := loadFeeCoin(sst,
+		*config.FeeBeneficiary)
+	if err != nil {
+		return nil, xerrors.Errorf("beneficiary coin: %v", err)
+	}
+	if !payer.Name.Equal(beneficiary.Name) {
+		return nil, xerrors.New("fee coin is not of the same type as the beneficiary")
+	}
+	if err := payer.SafeTransfer(&beneficiary, tx.Fee.Amount); err != nil {
+		return nil, xerrors.Errorf("transferring fee: %v", err)
+	}
+
+	payerBuf, err := protobuf.Encode(&payer)
+	if err != nil {
+		return nil, xerrors.Errorf("encoding coin: %v", err)
+	}
+	benefBuf, err := protobuf.Encode(&beneficiary)
+	if err != nil {
+		return nil, xerrors.Errorf("encoding coin: %v", err)
+	}
+	scs := StateChanges{
+		NewStateChange(Update, tx.Fee.Coin, feeCoinContractID, payerBuf,
+			payerDarc),
+		NewStateChange(Update, *config.FeeBeneficiary, feeCoinContractID,
+			benefBuf, benefDarc),
+	}
+	scs[0].Version = payerVer + 1
+	scs[1].Version = benefVer + 1
+	if err := sst.StoreAll(scs); err != nil {
+		return nil, xerrors.Errorf("storing fee: %v", err)
+	}
+	return scs, nil
+}
+
+// verifyFee checks that the signers of the first instruction, who also
+// signed the fee, are allowed to transfer coins from the fee instance.
+func verifyFee(rst ReadOnlyStateTrie, tx ClientTransaction, ctxHash []byte) error {
+	if len(tx.Instructions) == 0 {
+		return xerrors.New("cannot pay fee without instructions")
+	}
+	auth := Instruction{
+		InstanceID: tx.Fee.Coin,
+		Invoke: &Invoke{
+			ContractID: feeCoinContractID,
+			Command:    "transfer",
+		},
+		SignerIdentities: tx.Instructions[0].SignerIdentities,
+		Signatures:       tx.Instructions[0].Signatures,
+	}
+	return auth.VerifyWithOption(rst, ctxHash,
+		&VerificationOptions{IgnoreCounters: true})
+}
+
+// loadFeeCoin returns the coin stored in the given instance, together with
+// its version and darc.
+func loadFeeCoin(rst ReadOnlyStateTrie, id InstanceID) (coin Coin,
+	version uint64, darcID darc.ID, err error) {
+	var value []byte
+	var contractID string
+	value, version, contractID, darcID, err = rst.GetValues(id.Slice())
+	if err != nil {
+		err = xerrors.Errorf("reading trie: %v", err)
+		return
+	}
+	if contractID != feeCoinContractID {
+		err = xerrors.Errorf("instance is a %s, not a %s", contractID,
+			feeCoinContractID)
+		return
+	}
+	err = protobuf.Decode(value, &coin)
+	if err != nil {
+		err = xerrors.Errorf("decoding coin: %v", err)
+	}
+	return
+}
+
+// sortByFee orders the transactions by decreasing fee per byte if they
+// don't fit in one block. Transactions with the same fee per byte keep the
+// order in which they arrived.
+// As this changes the order of the transactions, a client sending multiple
+// transactions with increasing signer counters should use the same fee for
+// all of them.
+func sortByFee(txs []ClientTransaction, blockSize int) {
+	type txFee struct {
+		tx         ClientTransaction
+		feePerByte float64
+	}
+	fees := make([]txFee, len(txs))
+	total := 0
+	for i, tx := range txs {
+		size := txSize(TxResult{ClientTransaction: tx})
+		total += size
+		fees[i].tx = tx
+		if tx.Fee != nil && size > 0 {
+			fees[i].feePerByte = float64(tx.Fee.Amount) / float64(size)
+		}
+	}
+	if total <= blockSize {
+		return
+	}
+	sort.SliceStable(fees, func(i, j int) bool {
+		return fees[i].feePerByte > fees[j].feePerByte
+	})
+	for i := range fees {
+		txs[i] = fees[i].tx
+	}
+}
@@ -0,0 +1,129 @@
+package byzcoin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/protobuf"
+)
+
+func TestClientTransaction_HashFee(t *testing.T) {
+	ctx := NewClientTransaction(CurrentVersion, Instruction{})
+	require.Equal(t, ctx.Instructions.Hash(), ctx.Hash())
+
+	ctx.Fee = &TxFee{Coin: NewInstanceID([]byte("coin")), Amount: 10}
+	hash := ctx.Hash()
+	require.NotEqual(t, ctx.Instructions.Hash(), hash)
+	ctx.Fee.Amount++
+	require.NotEqual(t, hash, ctx.Hash())
+
+	clone := ctx.Clone()
+	require.Equal(t, ctx.Hash(), clone.Hash())
+	clone.Fee.Amount++
+	require.NotEqual(t, ctx.Hash(), clone.Hash())
+}
+
+func TestPayFee(t *testing.T) {
+	signer := darc.NewSignerEd25519(nil, nil)
+	ids := []darc.Identity{signer.Identity()}
+	d := darc.NewDarc(darc.InitRules(ids, ids), []byte("fee darc"))
+	d.Rules.AddRule("invoke:"+feeCoinContractID+".transfer",
+		d.Rules.GetSignExpr())
+	darcBuf, err := d.ToProto()
+	require.NoError(t, err)
+
+	payer := NewInstanceID([]byte("payer"))
+	beneficiary := NewInstanceID([]byte("beneficiary"))
+	coinName := NewInstanceID([]byte("byzCoin"))
+	coinBuf := func(value uint64) []byte {
+		buf, err := protobuf.Encode(&Coin{Name: coinName, Value: value})
+		require.NoError(t, err)
+		return buf
+	}
+	config := ChainConfig{DarcContractIDs: []string{ContractDarcID}}
+	storeConfig := func(sst *stagingStateTrie) {
+		buf, err := protobuf.Encode(&config)
+		require.NoError(t, err)
+		require.NoError(t, sst.StoreAll(StateChanges{
+			NewStateChange(Update, ConfigInstanceID, ContractConfigID, buf,
+				nil),
+		}))
+	}
+
+	sst, err := newMemStagingStateTrie([]byte("nonce"))
+	require.NoError(t, err)
+	require.NoError(t, sst.StoreAll(StateChanges{
+		NewStateChange(Create, NewInstanceID(d.GetBaseID()), ContractDarcID,
+			darcBuf, d.GetBaseID()),
+		NewStateChange(Create, payer, feeCoinContractID, coinBuf(100),
+			d.GetBaseID()),
+		NewStateChange(Create, beneficiary, feeCoinContractID, coinBuf(0),
+			d.GetBaseID()),
+	}))
+	storeConfig(sst)
+
+	ctx := NewClientTransaction(CurrentVersion, Instruction{
+		InstanceID:    payer,
+		Invoke:        &Invoke{ContractID: "any"},
+		SignerCounter: []uint64{1},
+	})
+	ctx.Fee = &TxFee{Coin: payer, Amount: 10}
+	require.NoError(t, ctx.FillSignersAndSignWith(signer))
+
+	// No beneficiary yet.
+	_, err = payFee(sst.Clone(), ctx, ctx.Hash())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "doesn't accept fees")
+
+	config.FeeBeneficiary = &beneficiary
+	storeConfig(sst)
+
+	// The fee must be signed.
+	_, err = payFee(sst.Clone(), ctx, ctx.Instructions.Hash())
+	require.Error(t, err)
+
+	sstFee := sst.Clone()
+	scs, err := payFee(sstFee, ctx, ctx.Hash())
+	require.NoError(t, err)
+	require.Equal(t, 2, len(scs))
+	coin, _, _, err := loadFeeCoin(sstFee, payer)
+	require.NoError(t, err)
+	require.Equal(t, uint64(90), coin.Value)
+	coin, _, _, err = loadFeeCoin(sstFee, beneficiary)
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), coin.Value)
+
+	// Not enough coins.
+	ctx.Fee.Amount = 1000
+	require.NoError(t, ctx.SignWith(signer))
+	_, err = payFee(sst.Clone(), ctx, ctx.Hash())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "transferring fee")
+}
+
+func TestSortByFee(t *testing.T) {
+	newTx := func(fee uint64) ClientTransaction {
+		ctx := ClientTransaction{Instructions: Instructions{{
+			Invoke: &Invoke{ContractID: "test"},
+		}}}
+		if fee > 0 {
+			ctx.Fee = &TxFee{Amount: fee}
+		}
+		return ctx
+	}
+	txs := []ClientTransaction{newTx(0), newTx(10), newTx(0), newTx(20)}
+	txs[0].Instructions[0].Invoke.Command = "first"
+
+	// Everything fits, so the order is kept.
+	sortByFee(txs, 1e6)
+	require.Nil(t, txs[0].Fee)
+	require.Equal(t, "first", txs[0].Instructions[0].Invoke.Command)
+
+	sortByFee(txs, 10)
+	require.Equal(t, uint64(20), txs[0].Fee.Amount)
+	require.Equal(t, uint64(10), txs[1].Fee.Amount)
+	require.Nil(t, txs[2].Fee)
+	require.Equal(t, "first", txs[2].Instructions[0].Invoke.Command)
+	require.Nil(t, txs[3].Fee)
+}
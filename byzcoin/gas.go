@@ -0,0 +1,236 @@
+package byzcoin
+
+import (
+	"math"
+
+	"go.dedis.ch/cothority/v3/byzcoin/trie"
+	"go.dedis.ch/cothority/v3/darc"
+	"golang.org/x/xerrors"
+)
+
+// The gas costs are only based on the data handled by an instruction, and
+// not on the time it takes to execute it. This makes sure that all the nodes
+// come to the same conclusion when a transaction runs out of gas.
+const (
+	// gasInstruction is the base cost of every instruction.
+	gasInstruction uint64 = 1000
+	// gasArgumentByte is charged for every byte of the arguments.
+	gasArgumentByte uint64 = 1
+	// gasTrieRead is charged for every read access to the state trie.
+	gasTrieRead uint64 = 100
+	// gasTrieReadByte is charged for every byte read from the state trie.
+	gasTrieReadByte uint64 = 1
+	// gasStateChange is charged for every state change returned by a
+	// contract.
+	gasStateChange uint64 = 500
+	// gasStateChangeByte is charged for every byte of a state change.
+	gasStateChangeByte uint64 = 10
+)
+
+// ErrOutOfGas is returned when a transaction uses more gas than allowed by
+// the ChainConfig.
+var ErrOutOfGas = xerrors.New("out of gas")
+
+// gasMeter counts the gas used by a ClientTransaction. A nil gasMeter
+// doesn't count anything, which is used when the gas is not metered.
+type gasMeter struct {
+	limit    uint64
+	used     uint64
+	outOfGas bool
+}
+
+func newGasMeter(limit uint64) *gasMeter {
+	return &gasMeter{limit: limit}
+}
+
+// consume adds the amount to the used gas and returns ErrOutOfGas if the
+// limit is exceeded. The used gas never goes over the limit, so that a
+// refused transaction can always be stored in a block.
+func (g *gasMeter) consume(amount uint64) error {
+	if g == nil {
+		return nil
+	}
+	if g.used > math.MaxUint64-amount {
+		g.used = math.MaxUint64
+	} else {
+		g.used += amount
+	}
+	if g.used > g.limit {
+		g.used = g.limit
+		g.outOfGas = true
+	}
+	if g.outOfGas {
+		return ErrOutOfGas
+	}
+	return nil
+}
+
+// gasUsed returns the gas used so far.
+func (g *gasMeter) gasUsed() uint64 {
+	if g == nil {
+		return 0
+	}
+	return g.used
+}
+
+// exhausted returns true if the limit has been reached. This catches
+// contracts that ignore the errors returned by the state trie.
+func (g *gasMeter) exhausted() bool {
+	return g != nil && g.outOfGas
+}
+
+// consumeInstruction charges the base cost of the instruction and the size
+// of its arguments.
+func (g *gasMeter) consumeInstruction(instr Instruction) error {
+	amount := gasInstruction
+	for _, arg := range instr.Arguments() {
+		amount += uint64(len(arg.Name)+len(arg.Value)) * gasArgumentByte
+	}
+	return g.consume(amount)
+}
+
+// consumeStateChanges charges the writes to the state trie.
+func (g *gasMeter) consumeStateChanges(scs StateChanges) error {
+	for _, sc := range scs {
+		size := len(sc.InstanceID) + len(sc.ContractID) + len(sc.Value) +
+			len(sc.DarcID)
+		err := g.consume(gasStateChange + uint64(size)*gasStateChangeByte)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// consumeRead charges one read access of the state trie returning size
+// bytes.
+func (g *gasMeter) consumeRead(size int) error {
+	return g.consume(gasTrieRead + uint64(size)*gasTrieReadByte)
+}
+
+// meteredStateTrie charges all accesses to the state trie to the gasMeter.
+// The metadata of the trie, like the index or the version, is free.
+type meteredStateTrie struct {
+	ReadOnlyStateTrie
+	meter *gasMeter
+}
+
+var _ ReadOnlyStateTrie = (*meteredStateTrie)(nil)
+
+func newMeteredStateTrie(rst ReadOnlyStateTrie, meter *gasMeter) *meteredStateTrie {
+	return &meteredStateTrie{ReadOnlyStateTrie: rst, meter: meter}
+}
+
+// GetValues implements ReadOnlyStateTrie.
+func (m *meteredStateTrie) GetValues(key []byte) (value []byte, version uint64,
+	contractID string, darcID darc.ID, err error) {
+	value, version, contractID, darcID, err = m.ReadOnlyStateTrie.GetValues(key)
+	if errGas := m.meter.consumeRead(len(value)); errGas != nil {
+		return nil, 0, "", nil, errGas
+	}
+	return
+}
+
+// GetProof implements ReadOnlyStateTrie.
+func (m *meteredStateTrie) GetProof(key []byte) (*trie.Proof, error) {
+	p, err := m.ReadOnlyStateTrie.GetProof(key)
+	size := 0
+	if p != nil {
+		size = len(p.Leaf.Value)
+	}
+	if errGas := m.meter.consumeRead(size); errGas != nil {
+		return nil, errGas
+	}
+	return p, err
+}
+
+// ForEach implements ReadOnlyStateTrie. Every visited key/value pair is
+// charged as a read.
+func (m *meteredStateTrie) ForEach(cb func(k, v []byte) error) error {
+	return m.ReadOnlyStateTrie.ForEach(func(k, v []byte) error {
+		if err := m.meter.consumeRead(len(k) + len(v)); err != nil {
+			return err
+		}
+		return cb(k, v)
+	})
+}
+
+// StoreAllToReplica implements ReadOnlyStateTrie. The state changes are
+// charged as writes, and the returned trie is metered, too.
+func (m *meteredStateTrie) StoreAllToReplica(scs StateChanges) (ReadOnlyStateTrie, error) {
+	if err := m.meter.consumeStateChanges(scs); err != nil {
+		return nil, err
+	}
+	rst, err := m.ReadOnlyStateTrie.StoreAllToReplica(scs)
+	if err != nil {
+		return nil, err
+	}
+	return newMeteredStateTrie(rst, m.meter), nil
+}
+
+// GetSignerCounter implements ReadOnlyStateTrie.
+func (m *meteredStateTrie) GetSignerCounter(id darc.Identity) (uint64, error) {
+	if err := m.meter.consumeRead(0); err != nil {
+		return 0, err
+	}
+	return m.ReadOnlyStateTrie.GetSignerCounter(id)
+}
+
+// LoadConfig implements ReadOnlyStateTrie.
+func (m *meteredStateTrie) LoadConfig() (*ChainConfig, error) {
+	if err := m.meter.consumeRead(0); err != nil {
+		return nil, err
+	}
+	return m.ReadOnlyStateTrie.LoadConfig()
+}
+
+// LoadDarc implements ReadOnlyStateTrie.
+func (m *meteredStateTrie) LoadDarc(id darc.ID) (*darc.Darc, error) {
+	if err := m.meter.consumeRead(0); err != nil {
+		return nil, err
+	}
+	return m.ReadOnlyStateTrie.LoadDarc(id)
+}
+
+// ConsumeGas charges the amount to the gas of the transaction executed on
+// the state trie given to a contract. It lets contracts charge the work that
+// doesn't come from the accesses to the state trie, like the execution of a
+// script. It returns ErrOutOfGas if the transaction uses more than its limit,
+// and nothing if the gas is not metered.
+func ConsumeGas(rst ReadOnlyStateTrie, amount uint64) error {
+	if gs, ok := rst.(globalState); ok {
+		rst = gs.ReadOnlyStateTrie
+	}
+	if m, ok := rst.(*meteredStateTrie); ok {
+		return m.meter.consume(amount)
+	}
+	return nil
+}
+
+// GasLeft returns the gas the transaction executed on the state trie can
+// still use, or math.MaxUint64 if the gas is not metered.
+func GasLeft(rst ReadOnlyStateTrie) uint64 {
+	if gs, ok := rst.(globalState); ok {
+		rst = gs.ReadOnlyStateTrie
+	}
+	if m, ok := rst.(*meteredStateTrie); ok && m.meter != nil {
+		return m.meter.limit - m.meter.used
+	}
+	return math.MaxUint64
+}
+
+// gasLimits returns the gas that a single transaction and a whole block may
+// use. If the config has no GasLimits, the gas is not metered and both
+// values are 0.
+func gasLimits(rst ReadOnlyStateTrie) (txGas uint64, blockGas uint64) {
+	config, err := rst.LoadConfig()
+	if err != nil || config.GasLimits == nil {
+		return 0, 0
+	}
+	blockGas = config.GasLimits.MaxBlockGas
+	txGas = config.GasLimits.MaxTxGas
+	if txGas == 0 {
+		txGas = blockGas
+	}
+	return
+}
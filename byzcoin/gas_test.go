@@ -0,0 +1,199 @@
+package byzcoin
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+func TestGasMeter(t *testing.T) {
+	var nilMeter *gasMeter
+	require.NoError(t, nilMeter.consume(1e9))
+	require.Equal(t, uint64(0), nilMeter.gasUsed())
+	require.False(t, nilMeter.exhausted())
+
+	meter := newGasMeter(gasInstruction + 10)
+	require.NoError(t, meter.consumeInstruction(Instruction{
+		Invoke: &Invoke{Args: Arguments{{Name: "a", Value: []byte("1234")}}},
+	}))
+	require.Equal(t, gasInstruction+5*gasArgumentByte, meter.gasUsed())
+	require.False(t, meter.exhausted())
+
+	err := meter.consume(10)
+	require.True(t, xerrors.Is(err, ErrOutOfGas))
+	require.Equal(t, gasInstruction+10, meter.gasUsed())
+	require.True(t, meter.exhausted())
+	require.True(t, xerrors.Is(meter.consume(0), ErrOutOfGas))
+}
+
+func TestMeteredStateTrie(t *testing.T) {
+	sst, err := newMemStagingStateTrie([]byte("nonce"))
+	require.NoError(t, err)
+	iid := NewInstanceID([]byte("gas"))
+	value := make([]byte, 100)
+	require.NoError(t, sst.StoreAll(StateChanges{
+		NewStateChange(Create, iid, "gas", value, nil),
+	}))
+
+	meter := newGasMeter(1e6)
+	mst := newMeteredStateTrie(sst, meter)
+	_, _, _, _, err = mst.GetValues(iid.Slice())
+	require.NoError(t, err)
+	require.Equal(t, gasTrieRead+uint64(len(value))*gasTrieReadByte,
+		meter.gasUsed())
+
+	// Reads of the metadata are free.
+	used := meter.gasUsed()
+	mst.GetIndex()
+	mst.GetVersion()
+	require.Equal(t, used, meter.gasUsed())
+
+	_, err = mst.StoreAllToReplica(StateChanges{
+		NewStateChange(Update, iid, "gas", value, nil),
+	})
+	require.NoError(t, err)
+	require.True(t, meter.gasUsed() > used+gasStateChange)
+
+	// Running out of gas must be reported, even if the value exists.
+	meter = newGasMeter(gasTrieRead)
+	mst = newMeteredStateTrie(sst, meter)
+	_, _, _, _, err = mst.GetValues(iid.Slice())
+	require.True(t, xerrors.Is(err, ErrOutOfGas))
+	require.True(t, meter.exhausted())
+}
+
+func TestConsumeGas(t *testing.T) {
+	sst, err := newMemStagingStateTrie([]byte("nonce"))
+	require.NoError(t, err)
+	require.NoError(t, ConsumeGas(sst, 1e9))
+	require.Equal(t, uint64(math.MaxUint64), GasLeft(sst))
+
+	// The contracts get the metered trie in a GlobalState.
+	meter := newGasMeter(100)
+	gs := globalState{ReadOnlyStateTrie: newMeteredStateTrie(sst, meter)}
+	require.NoError(t, ConsumeGas(gs, 60))
+	require.Equal(t, uint64(40), GasLeft(gs))
+	require.True(t, xerrors.Is(ConsumeGas(gs, 60), ErrOutOfGas))
+	require.Equal(t, uint64(0), GasLeft(gs))
+	require.True(t, meter.exhausted())
+}
+
+func TestTxResults_HashGas(t *testing.T) {
+	txs := NewTxResults(ClientTransaction{Instructions: Instructions{{}}})
+	hash := txs.Hash()
+	txs[0].GasUsed = 0
+	require.Equal(t, hash, txs.Hash())
+	txs[0].GasUsed = 1
+	require.NotEqual(t, hash, txs.Hash())
+}
+
+func TestService_Gas(t *testing.T) {
+	b := newBCTRun(t, nil)
+	defer b.CloseAll()
+
+	cid := "gasSC"
+	iid := NewInstanceID([]byte("gas instance"))
+	value := make([]byte, 1000)
+	f := func(cdb ReadOnlyStateTrie, inst Instruction, c []Coin) ([]StateChange, []Coin, error) {
+		// Reading the same value a lot of times is cheap in time,
+		// but not in gas.
+		for i := 0; i < len(inst.Invoke.Args); i++ {
+			_, _, _, _, err := cdb.GetValues(iid.Slice())
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		return StateChanges{
+			NewStateChange(Update, iid, cid, value, nil),
+		}, c, nil
+	}
+	b.Services[0].testRegisterContract(cid, adaptorNoVerify(f))
+	cdb, err := b.Services[0].getStateTrie(b.Genesis.SkipChainID())
+	require.NoError(t, err)
+
+	sst := cdb.MakeStagingStateTrie()
+	config, err := sst.LoadConfig()
+	require.NoError(t, err)
+	config.GasLimits = &GasLimits{MaxBlockGas: 25000, MaxTxGas: 20000}
+	configBuf, err := protobuf.Encode(config)
+	require.NoError(t, err)
+	require.NoError(t, sst.StoreAll(StateChanges{
+		NewStateChange(Update, ConfigInstanceID, ContractConfigID, configBuf,
+			nil),
+		NewStateChange(Create, iid, cid, value, nil),
+	}))
+
+	newTx := func(reads int) ClientTransaction {
+		return ClientTransaction{Instructions: Instructions{{
+			InstanceID: iid,
+			Invoke: &Invoke{
+				ContractID: cid,
+				Args:       make(Arguments, reads),
+			},
+		}}}
+	}
+
+	timestamp := time.Now().UnixNano()
+	// The first transaction fits, the second runs out of gas,
+	// and the third one would exceed the gas of the block.
+	_, txOut, _, _ := b.Services[0].createStateChanges(sst, b.Genesis.SkipChainID(),
+		NewTxResults(newTx(1), newTx(100), newTx(2)), noTimeout,
+		CurrentVersion, timestamp)
+	require.Equal(t, 3, len(txOut))
+	require.True(t, txOut[0].Accepted)
+	require.True(t, txOut[0].GasUsed > 0)
+	require.True(t, txOut[0].GasUsed < config.GasLimits.MaxTxGas)
+	require.False(t, txOut[1].Accepted)
+	require.Equal(t, config.GasLimits.MaxTxGas, txOut[1].GasUsed)
+	require.False(t, txOut[2].Accepted)
+	require.True(t, txOut[0].GasUsed+txOut[2].GasUsed > config.GasLimits.MaxBlockGas)
+
+	// When planning a block, the transaction exceeding the gas of the block
+	// is left for the next one.
+	_, txOut, _, _ = b.Services[0].createStateChanges(sst, b.Genesis.SkipChainID(),
+		NewTxResults(newTx(1), newTx(2)), time.Minute, CurrentVersion,
+		timestamp)
+	require.Equal(t, 1, len(txOut))
+	require.True(t, txOut[0].Accepted)
+}
+
+func TestService_GasReplay(t *testing.T) {
+	b := newBCTRun(t, nil)
+	defer b.CloseAll()
+
+	config, err := b.Services[0].LoadConfig(b.Genesis.SkipChainID())
+	require.NoError(t, err)
+	config.GasLimits = &GasLimits{MaxBlockGas: 1e6, MaxTxGas: 5e4}
+	buf, err := protobuf.Encode(config)
+	require.NoError(t, err)
+	b.SendInst(nil, Instruction{
+		InstanceID: ConfigInstanceID,
+		Invoke: &Invoke{
+			ContractID: ContractConfigID,
+			Command:    "update_config",
+			Args:       Arguments{{Name: "config", Value: buf}},
+		},
+	})
+	b.SpawnDummy(nil)
+
+	// The arguments alone use more gas than allowed.
+	_, resp := b.SendInst(&TxArgs{Wait: 10}, Instruction{
+		InstanceID: NewInstanceID(b.GenesisDarc.GetBaseID()),
+		Spawn: &Spawn{
+			ContractID: DummyContractName,
+			Args:       Arguments{{Name: "data", Value: make([]byte, 1e5)}},
+		},
+	})
+	require.Contains(t, resp.Error, "out of gas")
+	b.SignerCounter--
+	b.SpawnDummy(nil)
+
+	// The replay uses the same gas and refuses the same transactions.
+	_, err = b.Services[0].ReplayState(b.Genesis.Hash, stdFetcher{},
+		ReplayStateOptions{})
+	require.NoError(t, err)
+}
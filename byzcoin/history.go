@@ -0,0 +1,348 @@
+package byzcoin
+
+import (
+	"bytes"
+	"sort"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin/trie"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// ErrorStateNotAvailable is returned if the state of a past block cannot be
+// rebuilt, because the state changes of the following blocks are not all
+// stored anymore.
+var ErrorStateNotAvailable = xerrors.New("state of the block is not available")
+
+// GetProofAt returns a proof of the presence or the absence of the key in
+// the state of the chain after the given block. The state is rebuilt by
+// reverting the state changes of the following blocks, and its root is
+// checked against the header of the block, so the proof can be verified
+// like any other proof.
+func (s *Service) GetProofAt(req *GetProofAt) (*GetProofResponse, error) {
+	if !s.tasks.add(1) {
+		return nil, xerrors.New("node is closed")
+	}
+	defer s.tasks.done()
+
+	sb := s.db().GetByID(req.ID)
+	if sb == nil {
+		return nil, xerrors.New("cannot find skipblock while getting proof")
+	}
+	if req.Index < sb.Index {
+		return nil, xerrors.New("the block is before the first block of the proof")
+	}
+
+	st, _, err := s.readOnlyStateTrie(sb.SkipChainID())
+	if err != nil {
+		return nil, xerrors.Errorf("getting state trie: %w", err)
+	}
+	defer s.closeReadOnlyStateTrie(st)
+
+	sst, err := s.stateTrieAt(st, sb.SkipChainID(), req.Index)
+	if err != nil {
+		return nil, xerrors.Errorf("getting state: %w", err)
+	}
+	pr, err := sst.GetProof(req.Key)
+	if err != nil {
+		return nil, xerrors.Errorf("couldn't get proof: %v", err)
+	}
+	proof := Proof{InclusionProof: *pr}
+	links, latest, err := forwardLinks(s.db(), req.ID, req.Index)
+	if err != nil {
+		return nil, xerrors.Errorf("getting forward links: %w", err)
+	}
+	if latest.Index != req.Index {
+		return nil, xerrors.New("didn't find a path to the block")
+	}
+	proof.Links = links
+	proof.Latest = *latest
+
+	log.Lvlf2("%s: Returning proof for %x from chain %x at index %v",
+		s.ServerIdentity(), req.Key, sb.SkipChainID(), req.Index)
+	return &GetProofResponse{
+		Version: CurrentVersion,
+		Proof:   proof,
+	}, nil
+}
+
+// stateTrieAt returns a staging trie holding the state after the block with
+// the given index. Every instance changed after the block is set back to
+// its last state change up to the block, or removed if it has been created
+// after the block. As st is a read-only replica, the state changes of the
+// blocks following it are ignored.
+func (s *Service) stateTrieAt(st *stateTrie, scID skipchain.SkipBlockID,
+	index int) (*stagingStateTrie, error) {
+	if index > st.GetIndex() {
+		return nil, xerrors.New("the block is not yet known")
+	}
+	sb, err := s.skService().GetSingleBlockByIndex(
+		&skipchain.GetSingleBlockByIndex{
+			Genesis: scID,
+			Index:   index,
+		})
+	if err != nil {
+		return nil, xerrors.Errorf("getting block: %v", err)
+	}
+	header, err := decodeBlockHeader(sb.SkipBlock)
+	if err != nil {
+		return nil, xerrors.Errorf("decoding header: %v", err)
+	}
+
+	sst := st.MakeStagingStateTrie()
+	changed, err := s.stateChangeStorage.getChangedAfter(scID, index,
+		st.GetIndex())
+	if err != nil {
+		return nil, xerrors.Errorf("reading state changes: %v", err)
+	}
+	for _, entries := range changed {
+		var prev, next *StateChange
+		for i := range entries {
+			sc := &entries[i].StateChange
+			if sc.Op() != trie.OpSet && sc.Op() != trie.OpDel {
+				continue
+			}
+			if entries[i].BlockIndex <= index {
+				prev = sc
+			} else if next == nil {
+				next = sc
+			}
+		}
+
+		iid := entries[0].StateChange.InstanceID
+		var revert StateChange
+		switch {
+		case prev != nil && prev.StateAction != Remove:
+			revert = *prev
+		case prev != nil || (next != nil && next.StateAction == Create):
+			// The instance didn't exist after the block.
+			v, err := sst.Get(iid)
+			if err != nil {
+				return nil, xerrors.Errorf("reading trie: %v", err)
+			}
+			if v == nil {
+				continue
+			}
+			revert = StateChange{StateAction: Remove, InstanceID: iid}
+		case next == nil:
+			continue
+		default:
+			return nil, xerrors.Errorf("missing the state of instance %x: %w",
+				iid, ErrorStateNotAvailable)
+		}
+		if err := sst.StoreAll(StateChanges{revert}); err != nil {
+			return nil, xerrors.Errorf("reverting instance %x: %v",
+				revert.InstanceID, err)
+		}
+	}
+
+	if !bytes.Equal(sst.GetRoot(), header.TrieRoot) {
+		return nil, xerrors.Errorf("root doesn't match the block: %w",
+			ErrorStateNotAvailable)
+	}
+	return sst, nil
+}
+
+// GetInstanceHistory returns all the stored versions of an instance, each
+// with the block that applied it and the data needed to verify it, and a
+// proof of the current state of the instance. The oldest versions are
+// missing if the state change storage has been pruned.
+func (s *Service) GetInstanceHistory(req *GetInstanceHistory) (*GetInstanceHistoryResponse, error) {
+	if !s.tasks.add(1) {
+		return nil, xerrors.New("node is closed")
+	}
+	defer s.tasks.done()
+
+	// The proof must be for the same state as the stored versions.
+	s.updateTrieMutex.Lock()
+	defer s.updateTrieMutex.Unlock()
+
+	entries, err := s.stateChangeStorage.getAll(req.InstanceID.Slice(), req.SkipChainID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting state changes: %v", err)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].BlockIndex != entries[j].BlockIndex {
+			return entries[i].BlockIndex < entries[j].BlockIndex
+		}
+		return entries[i].TxIndex < entries[j].TxIndex
+	})
+
+	resp := &GetInstanceHistoryResponse{}
+	prev := req.SkipChainID
+	for _, e := range entries {
+		if op := e.StateChange.Op(); op != trie.OpSet && op != trie.OpDel {
+			continue
+		}
+		links, sb, err := forwardLinks(s.db(), prev, e.BlockIndex)
+		if err != nil {
+			return nil, xerrors.Errorf("getting forward links: %w", err)
+		}
+		if sb.Index != e.BlockIndex {
+			return nil, xerrors.Errorf("didn't find a path to block %d",
+				e.BlockIndex)
+		}
+		body, err := s.decodeBlockBody(sb)
+		if err != nil {
+			return nil, xerrors.Errorf("block %d: %w", sb.Index, err)
+		}
+		scs, err := s.blockStateChanges(sb, body)
+		if err != nil {
+			return nil, xerrors.Errorf("block %d: %w", sb.Index, err)
+		}
+
+		// The payload is not part of the hash of the block.
+		block := sb.Copy()
+		block.Payload = nil
+		resp.Entries = append(resp.Entries, InstanceHistoryEntry{
+			Block:        *block,
+			Links:        links,
+			StateChanges: scs,
+			Position:     e.TxIndex,
+			Summaries:    body.TxResults.summaries(),
+		})
+		prev = sb.Hash
+	}
+
+	st, err := s.GetReadOnlyStateTrie(req.SkipChainID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting state trie: %w", err)
+	}
+	proof, err := NewProof(st, s.db(), req.SkipChainID, req.InstanceID.Slice())
+	if err != nil {
+		return nil, xerrors.Errorf("making proof: %w", err)
+	}
+	resp.Proof = *proof
+	return resp, nil
+}
+
+// blockStateChanges returns all the state changes of the block, in the
+// order of the StateChangesHash of its header. As the events are not kept in
+// the state change storage, they are taken from the body and put back in the
+// gaps between the stored state changes.
+func (s *Service) blockStateChanges(sb *skipchain.SkipBlock,
+	body *DataBody) (StateChanges, error) {
+	entries, err := s.stateChangeStorage.getByBlock(sb.SkipChainID(), sb.Index)
+	if err != nil {
+		return nil, xerrors.Errorf("getting state changes: %v", err)
+	}
+
+	scs := make(StateChanges, 0, len(entries)+len(body.Events))
+	events := body.Events
+	addEvent := func() error {
+		sc, err := eventStateChange(events[0])
+		if err != nil {
+			return err
+		}
+		scs = append(scs, sc)
+		events = events[1:]
+		return nil
+	}
+	for _, e := range entries {
+		for len(scs) < e.TxIndex && len(events) > 0 {
+			if err := addEvent(); err != nil {
+				return nil, err
+			}
+		}
+		if len(scs) != e.TxIndex {
+			return nil, xerrors.New("state changes of the block are missing")
+		}
+		scs = append(scs, e.StateChange)
+	}
+	for len(events) > 0 {
+		if err := addEvent(); err != nil {
+			return nil, err
+		}
+	}
+	return scs, nil
+}
+
+// Verify checks the history of the instance against the genesis block. It
+// makes sure that the blocks come from the genesis block, that the state
+// changes and the transactions are the ones of their block, that every
+// version follows the previous one, and that the last version is the
+// current state of the instance in the proof. The history may start after
+// the first version of the instance, if the node pruned the oldest ones.
+func (r GetInstanceHistoryResponse) Verify(genesis *skipchain.SkipBlock,
+	iid InstanceID) error {
+	prev := genesis
+	var last *StateChange
+	for i := range r.Entries {
+		e := &r.Entries[i]
+		if len(e.Links) == 0 {
+			return cothority.WrapError(ErrorMissingForwardLinks)
+		}
+		// The roster of the first link is the one of the previous block,
+		// which has already been verified.
+		links := append([]skipchain.ForwardLink{}, e.Links...)
+		links[0].NewRoster = prev.Roster
+		if err := verifyForwardLinks(links, prev.Hash, &e.Block); err != nil {
+			return xerrors.Errorf("entry %d: %v", i, err)
+		}
+		if e.Block.Index < prev.Index {
+			return xerrors.Errorf("entry %d: block is older than the previous one", i)
+		}
+
+		header, err := decodeBlockHeader(&e.Block)
+		if err != nil {
+			return xerrors.Errorf("entry %d: %v", i, err)
+		}
+		if !bytes.Equal(StateChanges(e.StateChanges).Hash(),
+			header.StateChangesHash) {
+			return xerrors.Errorf("entry %d: wrong state changes", i)
+		}
+		if !bytes.Equal(txSummaries(e.Summaries).hash(),
+			header.ClientTransactionHash) {
+			return xerrors.Errorf("entry %d: wrong transactions", i)
+		}
+		if e.Position < 0 || e.Position >= len(e.StateChanges) {
+			return xerrors.Errorf("entry %d: position out of range", i)
+		}
+
+		sc := &e.StateChanges[e.Position]
+		if !bytes.Equal(sc.InstanceID, iid.Slice()) {
+			return xerrors.Errorf("entry %d: wrong instance", i)
+		}
+		switch {
+		case sc.Op() != trie.OpSet && sc.Op() != trie.OpDel:
+			return xerrors.Errorf("entry %d: not a new version", i)
+		case last == nil:
+		case last.StateAction == Remove:
+			if sc.StateAction != Create {
+				return xerrors.Errorf("entry %d: removed instance is changed", i)
+			}
+		case sc.StateAction == Create || sc.Version != last.Version+1:
+			return xerrors.Errorf("entry %d: missing version %d", i,
+				last.Version+1)
+		}
+		last = sc
+		prev = &e.Block
+	}
+
+	if err := r.Proof.VerifyFromBlock(genesis); err != nil {
+		return xerrors.Errorf("proof: %v", err)
+	}
+	if r.Proof.Latest.Index < prev.Index {
+		return xerrors.New("proof is older than the history")
+	}
+	if last == nil || last.StateAction == Remove {
+		if r.Proof.InclusionProof.Match(iid.Slice()) {
+			return xerrors.New("history is missing the current version")
+		}
+		return nil
+	}
+	if !r.Proof.InclusionProof.Match(iid.Slice()) {
+		return xerrors.New("history is missing the removal of the instance")
+	}
+	body, err := decodeStateChangeBody(r.Proof.InclusionProof.Get(iid.Slice()))
+	if err != nil {
+		return xerrors.Errorf("decoding proof: %v", err)
+	}
+	if body.Version != last.Version || !bytes.Equal(body.Value, last.Value) {
+		return xerrors.New("history is missing the current version")
+	}
+	return nil
+}
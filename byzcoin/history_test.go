@@ -0,0 +1,112 @@
+package byzcoin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_GetProofAt(t *testing.T) {
+	b := newBCT(t, nil)
+	b.CreateByzCoin()
+	defer b.CloseAll()
+
+	ctx, _ := b.SpawnDummy(nil)
+	iid := NewInstanceID(ctx.Instructions[0].Hash())
+	b.SendInst(nil, Instruction{
+		InstanceID: iid,
+		Delete:     &Delete{ContractID: DummyContractName},
+	})
+
+	// The instance doesn't exist in the genesis block, exists in the
+	// first block and is removed in the second block.
+	for index, exists := range []bool{false, true, false} {
+		resp, err := b.Services[0].GetProofAt(&GetProofAt{
+			Version: CurrentVersion,
+			Key:     iid.Slice(),
+			ID:      b.Genesis.Hash,
+			Index:   index,
+		})
+		require.NoError(t, err)
+		require.Equal(t, index, resp.Proof.Latest.Index)
+		require.NoError(t, resp.Proof.VerifyFromBlock(b.Genesis))
+		require.Equal(t, exists, resp.Proof.InclusionProof.Match(iid.Slice()))
+	}
+
+	resp, err := b.Client.GetProofAt(iid.Slice(), 1)
+	require.NoError(t, err)
+	_, v, _, _, err := resp.Proof.KeyValue()
+	require.NoError(t, err)
+	require.Equal(t, []byte("anyvalue"), v)
+
+	_, err = b.Client.GetProofAt(iid.Slice(), 3)
+	require.Error(t, err)
+}
+
+func TestService_GetInstanceHistory(t *testing.T) {
+	b := newBCT(t, nil)
+	for _, s := range b.Services {
+		s.testRegisterContract(eventContract, adaptor(eventContractFunc))
+	}
+	b.AddGenesisRules("spawn:"+eventContract,
+		"invoke:"+DummyContractName+".update")
+	b.CreateByzCoin()
+	defer b.CloseAll()
+
+	ctx, _ := b.SpawnDummy(nil)
+	iid := NewInstanceID(ctx.Instructions[0].Hash())
+	b.SendInst(nil, Instruction{
+		InstanceID: iid,
+		Invoke: &Invoke{
+			ContractID: DummyContractName,
+			Command:    "update",
+			Args:       Arguments{{Name: "data", Value: []byte("newvalue")}},
+		},
+	})
+
+	resp, err := b.Services[0].GetInstanceHistory(&GetInstanceHistory{
+		SkipChainID: b.Genesis.SkipChainID(),
+		InstanceID:  iid,
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(resp.Entries))
+	require.NoError(t, resp.Verify(b.Genesis, iid))
+	require.Nil(t, resp.Entries[0].Block.Payload)
+
+	// A missing or changed version is detected.
+	require.Error(t, GetInstanceHistoryResponse{
+		Entries: resp.Entries[:1],
+		Proof:   resp.Proof,
+	}.Verify(b.Genesis, iid))
+	entry := resp.Entries[1]
+	entry.StateChanges = append([]StateChange{}, entry.StateChanges...)
+	entry.StateChanges[entry.Position].Value = []byte("othervalue")
+	require.Error(t, GetInstanceHistoryResponse{
+		Entries: []InstanceHistoryEntry{resp.Entries[0], entry},
+		Proof:   resp.Proof,
+	}.Verify(b.Genesis, iid))
+
+	// The events of the block are part of its state changes.
+	ctx, _ = b.SendInst(nil, Instruction{
+		InstanceID: NewInstanceID(b.GenesisDarc.GetBaseID()),
+		Spawn: &Spawn{
+			ContractID: eventContract,
+			Args:       Arguments{{Name: "data", Value: []byte("event")}},
+		},
+	})
+	eventIID := NewInstanceID(ctx.Instructions[0].Hash())
+	b.SendInst(nil, Instruction{
+		InstanceID: iid,
+		Delete:     &Delete{ContractID: DummyContractName},
+	})
+
+	history, err := b.Client.GetInstanceHistory(eventIID)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(history.Entries))
+	require.Equal(t, EmitEvent, history.Entries[0].StateChanges[0].StateAction)
+
+	history, err = b.Client.GetInstanceHistory(iid)
+	require.NoError(t, err)
+	require.Equal(t, 3, len(history.Entries))
+	require.False(t, history.Proof.InclusionProof.Match(iid.Slice()))
+}
@@ -0,0 +1,508 @@
+package byzcoin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/protobuf"
+	bbolt "go.etcd.io/bbolt"
+	"golang.org/x/xerrors"
+)
+
+var bucketInstanceIndex = []byte("instanceindex")
+
+// maxListInstances is the maximum number of instances returned by
+// ListInstances.
+var maxListInstances = 1000
+
+// maxIndexBatch is the number of instances of the state trie that are
+// indexed at once when the index is rebuilt.
+var maxIndexBatch = 1000
+
+// errIndexStale is returned by update if the index doesn't hold the state of
+// the previous block, so it needs to be rebuilt.
+var errIndexStale = xerrors.New("the index doesn't follow the state trie")
+
+// The bucket of a chain holds the index of the block of the indexed state,
+// and the following entries, each prefixed by one byte:
+//   - instance ID -> ListedInstance
+//   - contract ID, 0, instance ID -> empty
+//   - darc ID, instance ID -> empty
+//
+// While the index is rebuilt, the bucket also holds the rebuilding key.
+var (
+	indexKeyHeight     = []byte("height")
+	indexKeyRebuilding = []byte("rebuilding")
+	indexPfxInstance   = byte('i')
+	indexPfxContract   = byte('c')
+	indexPfxDarc       = byte('d')
+)
+
+// instanceIndexStorage indexes the instances of every skipchain by contract
+// ID and by darc ID. As it can always be rebuilt from the state trie, it is
+// thrown away and rebuilt in the background whenever it doesn't follow the
+// trie.
+type instanceIndexStorage struct {
+	db     *bbolt.DB
+	bucket []byte
+	// rebuilding holds the chains whose index is being rebuilt.
+	rebuilding map[string]bool
+	sync.Mutex
+}
+
+func newInstanceIndexStorage(c *onet.Context) *instanceIndexStorage {
+	db, name := c.GetAdditionalBucket(bucketInstanceIndex)
+	return &instanceIndexStorage{
+		db:         db,
+		bucket:     name,
+		rebuilding: make(map[string]bool),
+	}
+}
+
+func indexContractKey(contractID string, iid []byte) []byte {
+	key := append([]byte{indexPfxContract}, contractID...)
+	key = append(key, 0)
+	return append(key, iid...)
+}
+
+func indexDarcKey(darcID []byte, iid []byte) []byte {
+	key := append([]byte{indexPfxDarc}, darcID...)
+	return append(key, iid...)
+}
+
+func indexInstanceKey(iid []byte) []byte {
+	return append([]byte{indexPfxInstance}, iid...)
+}
+
+// getIndexHeight returns the index of the block whose state is indexed, or
+// -1 if there is no index for the chain.
+func getIndexHeight(b *bbolt.Bucket) int {
+	if b == nil {
+		return -1
+	}
+	buf := b.Get(indexKeyHeight)
+	if len(buf) != 8 {
+		return -1
+	}
+	return int(binary.LittleEndian.Uint64(buf))
+}
+
+func putIndexHeight(b *bbolt.Bucket, index int) error {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, uint64(index))
+	return b.Put(indexKeyHeight, buf)
+}
+
+// setIndexInstance replaces the entries of the instance in the index. If
+// inst is nil, the instance is removed.
+func setIndexInstance(b *bbolt.Bucket, iid []byte, inst *ListedInstance) error {
+	if buf := b.Get(indexInstanceKey(iid)); buf != nil {
+		var old ListedInstance
+		if err := protobuf.Decode(buf, &old); err != nil {
+			return xerrors.Errorf("decoding: %v", err)
+		}
+		if err := b.Delete(indexContractKey(old.ContractID, iid)); err != nil {
+			return xerrors.Errorf("deleting item: %v", err)
+		}
+		if err := b.Delete(indexDarcKey(old.DarcID, iid)); err != nil {
+			return xerrors.Errorf("deleting item: %v", err)
+		}
+	}
+	if inst == nil {
+		return cothority.ErrorOrNil(b.Delete(indexInstanceKey(iid)),
+			"deleting item")
+	}
+	buf, err := protobuf.Encode(inst)
+	if err != nil {
+		return xerrors.Errorf("encoding: %v", err)
+	}
+	if err := b.Put(indexInstanceKey(iid), buf); err != nil {
+		return xerrors.Errorf("writing item: %v", err)
+	}
+	if err := b.Put(indexContractKey(inst.ContractID, iid), []byte{}); err != nil {
+		return xerrors.Errorf("writing item: %v", err)
+	}
+	return cothority.ErrorOrNil(b.Put(indexDarcKey(inst.DarcID, iid), []byte{}),
+		"writing item")
+}
+
+// update applies the state changes of the block with the given index. If
+// the index doesn't hold the state of the previous block, nothing is
+// written and errIndexStale is returned. The changes are also applied while
+// the index is rebuilt, so that it follows the trie.
+func (s *instanceIndexStorage) update(sid skipchain.SkipBlockID, index int,
+	scs StateChanges) error {
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		if getIndexHeight(tx.Bucket(s.bucket).Bucket(sid)) != index-1 {
+			return errIndexStale
+		}
+		b, err := tx.Bucket(s.bucket).CreateBucketIfNotExists(sid)
+		if err != nil {
+			return xerrors.Errorf("creating bucket: %v", err)
+		}
+		for _, sc := range scs {
+			// Events and generated instructions don't touch the trie.
+			if sc.StateAction == GenerateInstruction ||
+				sc.StateAction == EmitEvent {
+				continue
+			}
+			var inst *ListedInstance
+			if sc.StateAction != Remove {
+				inst = &ListedInstance{
+					InstanceID: NewInstanceID(sc.InstanceID),
+					ContractID: sc.ContractID,
+					DarcID:     sc.DarcID,
+				}
+			}
+			if err := setIndexInstance(b, sc.InstanceID, inst); err != nil {
+				return xerrors.Errorf("indexing instance: %v", err)
+			}
+		}
+		return cothority.ErrorOrNil(putIndexHeight(b, index), "writing height")
+	})
+	if err == errIndexStale {
+		return err
+	}
+	return cothority.ErrorOrNil(err, "tx error")
+}
+
+// isReady returns whether the index of the chain holds the state of the
+// block with the given index.
+func (s *instanceIndexStorage) isReady(sid skipchain.SkipBlockID,
+	index int) bool {
+	ready := false
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket).Bucket(sid)
+		ready = getIndexHeight(b) == index && b.Get(indexKeyRebuilding) == nil
+		return nil
+	})
+	return err == nil && ready
+}
+
+// startRebuild returns false if the index of the chain is already being
+// rebuilt. Otherwise finishRebuild must be called once it is done.
+func (s *instanceIndexStorage) startRebuild(sid skipchain.SkipBlockID) bool {
+	s.Lock()
+	defer s.Unlock()
+	if s.rebuilding[string(sid)] {
+		return false
+	}
+	s.rebuilding[string(sid)] = true
+	return true
+}
+
+func (s *instanceIndexStorage) finishRebuild(sid skipchain.SkipBlockID) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.rebuilding, string(sid))
+}
+
+// resetRebuild replaces the index of the chain with an empty one marked as
+// rebuilding, which follows the trie from its current index on.
+func (s *instanceIndexStorage) resetRebuild(sid skipchain.SkipBlockID,
+	st *stateTrie) error {
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		if tx.Bucket(s.bucket).Bucket(sid) != nil {
+			if err := tx.Bucket(s.bucket).DeleteBucket(sid); err != nil {
+				return xerrors.Errorf("deleting bucket: %v", err)
+			}
+		}
+		b, err := tx.Bucket(s.bucket).CreateBucket(sid)
+		if err != nil {
+			return xerrors.Errorf("creating bucket: %v", err)
+		}
+		if err := b.Put(indexKeyRebuilding, []byte{}); err != nil {
+			return xerrors.Errorf("writing item: %v", err)
+		}
+		return cothority.ErrorOrNil(putIndexHeight(b, st.GetIndex()),
+			"writing height")
+	})
+	return cothority.ErrorOrNil(err, "tx error")
+}
+
+// rebuildBatch indexes at most maxIndexBatch instances of the trie, after
+// the given key. It returns the key of the last one, or nil once the whole
+// trie is indexed and the index is ready. If the index doesn't follow the
+// trie anymore, errIndexStale is returned.
+func (s *instanceIndexStorage) rebuildBatch(sid skipchain.SkipBlockID,
+	st *stateTrie, after []byte) ([]byte, error) {
+	type item struct {
+		key  []byte
+		body StateChangeBody
+	}
+	var items []item
+	last, err := st.ForEachAfter(after, maxIndexBatch, func(k, v []byte) error {
+		body, err := decodeStateChangeBody(v)
+		if err != nil {
+			return xerrors.Errorf("decoding body: %v", err)
+		}
+		items = append(items, item{k, body})
+		return nil
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("reading trie: %v", err)
+	}
+
+	err = s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket).Bucket(sid)
+		if getIndexHeight(b) != st.GetIndex() ||
+			b.Get(indexKeyRebuilding) == nil {
+			return errIndexStale
+		}
+		for _, it := range items {
+			err := setIndexInstance(b, it.key, &ListedInstance{
+				InstanceID: NewInstanceID(it.key),
+				ContractID: string(it.body.ContractID),
+				DarcID:     it.body.DarcID,
+			})
+			if err != nil {
+				return xerrors.Errorf("indexing instance: %v", err)
+			}
+		}
+		if last == nil {
+			return cothority.ErrorOrNil(b.Delete(indexKeyRebuilding),
+				"deleting item")
+		}
+		return nil
+	})
+	if err == errIndexStale {
+		return nil, err
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("tx error: %v", err)
+	}
+	return last, nil
+}
+
+// list returns at most limit instances matching the request, and the ID of
+// the next matching instance if there are more.
+func (s *instanceIndexStorage) list(req *ListInstances,
+	limit int) (instances []ListedInstance, next []byte, err error) {
+	// The index with the fewest entries is used, and the other filters are
+	// checked on the entries. All the indexes are ordered by instance ID.
+	var pfx []byte
+	switch {
+	case req.ContractID != "":
+		pfx = indexContractKey(req.ContractID, nil)
+	case len(req.DarcID) > 0:
+		pfx = indexDarcKey(req.DarcID, nil)
+	default:
+		pfx = indexInstanceKey(nil)
+	}
+	scan := append(append([]byte{}, pfx...), req.Prefix...)
+	start := append(append([]byte{}, pfx...), req.Start...)
+	if bytes.Compare(start, scan) < 0 {
+		start = scan
+	}
+
+	err = s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket).Bucket(req.ByzCoinID)
+		if b == nil {
+			return xerrors.New("no index for this chain")
+		}
+		if b.Get(indexKeyRebuilding) != nil {
+			return xerrors.New("the index of this chain is being rebuilt")
+		}
+		c := b.Cursor()
+		for k, _ := c.Seek(start); k != nil && bytes.HasPrefix(k, scan); k, _ = c.Next() {
+			iid := k[len(pfx):]
+			var inst ListedInstance
+			buf := b.Get(indexInstanceKey(iid))
+			if err := protobuf.Decode(buf, &inst); err != nil {
+				return xerrors.Errorf("decoding: %v", err)
+			}
+			if req.ContractID != "" && inst.ContractID != req.ContractID {
+				continue
+			}
+			if len(req.DarcID) > 0 && !inst.DarcID.Equal(req.DarcID) {
+				continue
+			}
+			if len(instances) == limit {
+				next = append([]byte{}, iid...)
+				return nil
+			}
+			instances = append(instances, inst)
+		}
+		return nil
+	})
+	err = cothority.ErrorOrNil(err, "tx error")
+	return
+}
+
+// ListInstances returns one page of the instances matching the filters of
+// the request, optionally with their proofs.
+func (s *Service) ListInstances(req *ListInstances) (*ListInstancesResponse, error) {
+	limit := maxListInstances
+	if req.WithProofs && maxProofKeys < limit {
+		limit = maxProofKeys
+	}
+	if req.Limit < 0 {
+		return nil, xerrors.New("negative limit")
+	}
+	if req.Limit > 0 && req.Limit < limit {
+		limit = req.Limit
+	}
+
+	s.updateTrieMutex.Lock()
+	defer s.updateTrieMutex.Unlock()
+
+	if !s.tasks.areTasksAllowed() {
+		return nil, xerrors.New("cannot list instances while in closed state")
+	}
+
+	st, err := s.getStateTrie(req.ByzCoinID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting state trie: %w", err)
+	}
+	// The index of a chain bootstrapped from a snapshot, or of a chain
+	// known before the indexes, is built in the background.
+	if !s.instanceIndex.isReady(req.ByzCoinID, st.GetIndex()) {
+		s.rebuildInstanceIndex(req.ByzCoinID)
+		return nil, xerrors.New("the instance index is being built, " +
+			"try again later")
+	}
+	instances, next, err := s.instanceIndex.list(req, limit)
+	if err != nil {
+		return nil, xerrors.Errorf("listing instances: %v", err)
+	}
+
+	reply := &ListInstancesResponse{
+		Instances: instances,
+		Next:      next,
+		Index:     st.GetIndex(),
+	}
+	if req.WithProofs && len(instances) > 0 {
+		keys := make([][]byte, len(instances))
+		for i := range instances {
+			keys[i] = instances[i].InstanceID.Slice()
+		}
+		reply.Proofs, err = newMultiProof(st, s.db(), req.ByzCoinID, keys)
+		if err != nil {
+			return nil, xerrors.Errorf("making proof: %v", err)
+		}
+	}
+	return reply, nil
+}
+
+// stateIndex is an index of the state trie of every chain. It is rebuilt from
+// the trie in the background when it doesn't follow the trie.
+type stateIndex interface {
+	// startRebuild returns false if the index of the chain is already
+	// being rebuilt. Otherwise finishRebuild must be called once it is
+	// done.
+	startRebuild(sid skipchain.SkipBlockID) bool
+	finishRebuild(sid skipchain.SkipBlockID)
+	// resetRebuild replaces the index of the chain with an empty one,
+	// which follows the trie from its current index on.
+	resetRebuild(sid skipchain.SkipBlockID, st *stateTrie) error
+	// rebuildBatch indexes one batch of instances of the trie after the
+	// given key, and returns the key of the last one, or nil once the
+	// index is ready. It returns errIndexStale if the index doesn't follow
+	// the trie anymore.
+	rebuildBatch(sid skipchain.SkipBlockID, st *stateTrie,
+		after []byte) ([]byte, error)
+}
+
+// rebuildInstanceIndex rebuilds the instance index of the chain in the
+// background.
+func (s *Service) rebuildInstanceIndex(sid skipchain.SkipBlockID) {
+	s.rebuildIndex("instance index", s.instanceIndex, sid)
+}
+
+// rebuildIndex rebuilds the index of the chain in the background. The trie
+// is indexed in batches, and the blocks stored in between update the index
+// like the finished one. It does nothing if the index is already being
+// rebuilt.
+func (s *Service) rebuildIndex(name string, idx stateIndex,
+	sid skipchain.SkipBlockID) {
+	if !idx.startRebuild(sid) {
+		return
+	}
+	if !s.tasks.add(1) {
+		idx.finishRebuild(sid)
+		return
+	}
+	go func() {
+		defer s.tasks.done()
+		defer idx.finishRebuild(sid)
+		var after []byte
+		var err error
+		reset := true
+		for s.tasks.areTasksAllowed() {
+			after, err = s.rebuildIndexBatch(name, idx, sid, after, reset)
+			if err == errIndexStale {
+				// A block has been stored without updating the index.
+				after, reset = nil, true
+				continue
+			}
+			if err != nil {
+				log.Errorf("%s couldn't rebuild the %s of %x: %v",
+					s.ServerIdentity(), name, sid, err)
+				return
+			}
+			if after == nil {
+				log.Lvlf2("%s rebuilt the %s of %x",
+					s.ServerIdentity(), name, sid)
+				return
+			}
+			reset = false
+		}
+	}()
+}
+
+// rebuildIndexBatch indexes one batch of the trie. No block can be stored
+// meanwhile. If reset is true, the index is first emptied.
+func (s *Service) rebuildIndexBatch(name string, idx stateIndex,
+	sid skipchain.SkipBlockID, after []byte, reset bool) ([]byte, error) {
+	s.updateTrieMutex.Lock()
+	defer s.updateTrieMutex.Unlock()
+
+	st, err := s.getStateTrie(sid)
+	if err != nil {
+		return nil, xerrors.Errorf("getting state trie: %v", err)
+	}
+	if reset {
+		log.Lvlf2("%s rebuilding the %s of %x at index %d",
+			s.ServerIdentity(), name, sid, st.GetIndex())
+		if err := idx.resetRebuild(sid, st); err != nil {
+			return nil, xerrors.Errorf("resetting index: %v", err)
+		}
+	}
+	return idx.rebuildBatch(sid, st, after)
+}
+
+// verifyInstances checks that every listed instance is in the proofs, with
+// the same contract and darc, and that it matches the filters of the
+// request. It cannot check that no instance has been left out.
+func (r *ListInstancesResponse) verifyInstances(req *ListInstances) error {
+	for _, inst := range r.Instances {
+		key := inst.InstanceID.Slice()
+		if !bytes.HasPrefix(key, req.Prefix) || bytes.Compare(key, req.Start) < 0 {
+			return xerrors.Errorf("instance %x doesn't match the request", key)
+		}
+		if (req.ContractID != "" && inst.ContractID != req.ContractID) ||
+			(len(req.DarcID) > 0 && !inst.DarcID.Equal(req.DarcID)) {
+			return xerrors.Errorf("instance %x doesn't match the request", key)
+		}
+		pr, err := r.Proofs.Proof(key)
+		if err != nil {
+			return xerrors.Errorf("getting proof: %v", err)
+		}
+		if !pr.InclusionProof.Match(key) {
+			return xerrors.Errorf("instance %x is not in the proof", key)
+		}
+		_, _, contractID, darcID, err := pr.KeyValue()
+		if err != nil {
+			return xerrors.Errorf("reading proof: %v", err)
+		}
+		if contractID != inst.ContractID || !darcID.Equal(inst.DarcID) {
+			return xerrors.Errorf("instance %x differs from the proof", key)
+		}
+	}
+	return nil
+}
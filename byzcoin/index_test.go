@@ -0,0 +1,144 @@
+package byzcoin
+
+import (
+	"bytes"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	bbolt "go.etcd.io/bbolt"
+)
+
+func TestService_ListInstances(t *testing.T) {
+	b := newBCTRun(t, nil)
+	defer b.CloseAll()
+
+	var ids []InstanceID
+	for i := 0; i < 3; i++ {
+		ctx, _ := b.SpawnDummy(nil)
+		ids = append(ids, NewInstanceID(ctx.Instructions[0].Hash()))
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return bytes.Compare(ids[i][:], ids[j][:]) < 0
+	})
+
+	rep, err := b.Client.ListInstances(ListInstances{
+		ContractID: DummyContractName,
+		WithProofs: true,
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(rep.Instances))
+	require.Nil(t, rep.Next)
+	require.NotNil(t, rep.Proofs)
+	for i, inst := range rep.Instances {
+		require.Equal(t, ids[i], inst.InstanceID)
+		require.True(t, inst.DarcID.Equal(b.GenesisDarc.GetBaseID()))
+	}
+
+	// The darc index holds the dummies, the config and the genesis darc.
+	rep, err = b.Client.ListInstances(ListInstances{
+		DarcID: b.GenesisDarc.GetBaseID(),
+	})
+	require.NoError(t, err)
+	var listed []InstanceID
+	for _, inst := range rep.Instances {
+		listed = append(listed, inst.InstanceID)
+	}
+	require.Subset(t, listed, append([]InstanceID{ConfigInstanceID},
+		NewInstanceID(b.GenesisDarc.GetBaseID()), ids[0], ids[1], ids[2]))
+
+	// Pages of one instance.
+	var start []byte
+	for i := range ids {
+		rep, err = b.Client.ListInstances(ListInstances{
+			ContractID: DummyContractName,
+			Start:      start,
+			Limit:      1,
+		})
+		require.NoError(t, err)
+		require.Equal(t, 1, len(rep.Instances))
+		require.Equal(t, ids[i], rep.Instances[0].InstanceID)
+		start = rep.Next
+	}
+	require.Nil(t, start)
+
+	rep, err = b.Client.ListInstances(ListInstances{
+		Prefix: ids[0][:4],
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(rep.Instances))
+	require.Equal(t, ids[0], rep.Instances[0].InstanceID)
+
+	// The index is rebuilt in the background if it is lost, one instance at
+	// a time, while new blocks are added.
+	defer func(batch int) { maxIndexBatch = batch }(maxIndexBatch)
+	maxIndexBatch = 1
+	s := b.Services[0]
+	err = s.instanceIndex.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(s.instanceIndex.bucket).DeleteBucket(b.Genesis.SkipChainID())
+	})
+	require.NoError(t, err)
+	req := &ListInstances{
+		ByzCoinID:  b.Genesis.SkipChainID(),
+		ContractID: DummyContractName,
+	}
+	_, err = s.ListInstances(req)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "being built")
+	b.SpawnDummy(nil)
+
+	var resp *ListInstancesResponse
+	for i := 0; i < 50; i++ {
+		resp, err = s.ListInstances(req)
+		if err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	require.NoError(t, err)
+	require.Equal(t, 4, len(resp.Instances))
+	require.Equal(t, 4, resp.Index)
+}
+
+func TestService_ListInstancesEvents(t *testing.T) {
+	// The event is emitted after the instance is created, so it would
+	// replace it in the index.
+	f := func(rst ReadOnlyStateTrie, inst Instruction, c []Coin) ([]StateChange, []Coin, error) {
+		scs, c, err := eventContractFunc(rst, inst, c)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []StateChange{scs[1], scs[0]}, c, nil
+	}
+	b := newBCT(t, nil)
+	for _, s := range b.Services {
+		s.testRegisterContract(eventContract, adaptor(f))
+	}
+	b.AddGenesisRules("spawn:" + eventContract)
+	b.CreateByzCoin()
+	defer b.CloseAll()
+
+	ctx, _ := b.SendInst(nil, Instruction{
+		InstanceID: NewInstanceID(b.GenesisDarc.GetBaseID()),
+		Spawn: &Spawn{
+			ContractID: eventContract,
+			Args:       Arguments{{Name: "data", Value: []byte("event")}},
+		},
+	})
+	iid := NewInstanceID(ctx.Instructions[0].Hash())
+
+	rep, err := b.Client.ListInstances(ListInstances{
+		DarcID:     b.GenesisDarc.GetBaseID(),
+		WithProofs: true,
+	})
+	require.NoError(t, err)
+	var found bool
+	for _, inst := range rep.Instances {
+		if inst.InstanceID.Equal(iid) {
+			found = true
+			require.Equal(t, eventContract, inst.ContractID)
+		}
+	}
+	require.True(t, found)
+}
@@ -0,0 +1,166 @@
+package byzcoin
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+	"golang.org/x/xerrors"
+)
+
+// sanityCheck makes sure that the rotation can always hand over to a node of
+// the roster, starting with its leader.
+func (lr LeaderRotation) sanityCheck(c ChainConfig) error {
+	if lr.Interval <= 0 {
+		return xerrors.New("leader rotation interval is less or equal to zero")
+	}
+	if lr.Start < 0 {
+		return xerrors.New("leader rotation start is negative")
+	}
+	for i, w := range lr.Weights {
+		if w.Weight < 0 {
+			return xerrors.New("negative leader weight")
+		}
+		for _, other := range lr.Weights[:i] {
+			if bytes.Equal(w.Public, other.Public) {
+				return xerrors.New("two weights for the same node")
+			}
+		}
+	}
+	if c.leaderWeight(c.Roster.List[0]) == 0 {
+		return xerrors.New("the leader has a weight of zero")
+	}
+	return nil
+}
+
+// leaderWeight returns the weight of the node in the leader rotation. Without
+// a rotation, every node has a weight of 1.
+func (c ChainConfig) leaderWeight(si *network.ServerIdentity) int {
+	if c.LeaderRotation == nil || len(c.LeaderRotation.Weights) == 0 {
+		return 1
+	}
+	public, err := si.Public.MarshalBinary()
+	if err != nil {
+		return 0
+	}
+	for _, w := range c.LeaderRotation.Weights {
+		if bytes.Equal(w.Public, public) {
+			return w.Weight
+		}
+	}
+	return 0
+}
+
+// nextLeader returns the index in the roster of the leader of the block with
+// the given index. It is 0 as long as the term of the current leader is not
+// over, or if no other node can become leader.
+func (c ChainConfig) nextLeader(index int) int {
+	lr := c.LeaderRotation
+	if lr == nil {
+		return 0
+	}
+	if index < lr.Start+lr.Interval*c.leaderWeight(c.Roster.List[0]) {
+		return 0
+	}
+	for i, si := range c.Roster.List[1:] {
+		if c.leaderWeight(si) > 0 {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// withLeaderStart returns the config with the start of the leader rotation
+// set for a config that is used from the block with the given index. The
+// term of the leader goes on if the old config had the same leader and a
+// rotation.
+func (c ChainConfig) withLeaderStart(old *ChainConfig, index int) ChainConfig {
+	if c.LeaderRotation == nil {
+		return c
+	}
+	lr := *c.LeaderRotation
+	lr.Start = index
+	if old != nil && old.LeaderRotation != nil &&
+		old.Roster.List[0].Equal(c.Roster.List[0]) {
+		lr.Start = old.LeaderRotation.Start
+	}
+	c.LeaderRotation = &lr
+	return c
+}
+
+// checkLeaderRotation makes sure that the leader of the new config, used
+// from the block with the given index, follows the rotation of the old
+// config.
+func (c ChainConfig) checkLeaderRotation(old *ChainConfig, index int) error {
+	if old.nextLeader(index) > 0 &&
+		old.Roster.List[0].Equal(c.Roster.List[0]) {
+		return xerrors.New("the leader must hand over")
+	}
+	if c.leaderWeight(c.Roster.List[0]) == 0 {
+		return xerrors.New("the leader has a weight of zero")
+	}
+	return nil
+}
+
+// rotateLeader returns the state changes of the config contract handing over
+// to the next leader in the block following the state.
+func rotateLeader(rst ReadOnlyStateTrie, inst Instruction) (StateChanges,
+	error) {
+	_, _, _, darcID, err := rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return nil, xerrors.Errorf("reading trie: %v", err)
+	}
+	config, err := rst.LoadConfig()
+	if err != nil {
+		return nil, xerrors.Errorf("reading trie: %v", err)
+	}
+
+	index := rst.GetIndex() + 1
+	buf := inst.Invoke.Args.Search("index")
+	if len(buf) != 8 || binary.LittleEndian.Uint64(buf) != uint64(index) {
+		return nil, xerrors.New("wrong block index")
+	}
+	next := config.nextLeader(index)
+	if next == 0 {
+		return nil, xerrors.New("leader rotation is not due")
+	}
+	return updateRosterScs(rst, darcID, *rotateRoster(&config.Roster, next))
+}
+
+// createRotationBlock is called by the next leader once the term of the
+// current leader is over. It creates the block handing over to itself, so
+// that the current leader stops creating blocks. If the block cannot be
+// created, a view-change will happen.
+func (s *Service) createRotationBlock(scID skipchain.SkipBlockID) error {
+	defer log.Lvl2(s.ServerIdentity(), "created leader rotation block")
+	st, err := s.GetReadOnlyStateTrie(scID)
+	if err != nil {
+		return xerrors.Errorf("getting trie: %v", err)
+	}
+	config, err := st.LoadConfig()
+	if err != nil {
+		return xerrors.Errorf("reading trie: %v", err)
+	}
+	index := st.GetIndex() + 1
+	next := config.nextLeader(index)
+	if next == 0 || !config.Roster.List[next].Equal(s.ServerIdentity()) {
+		return xerrors.New("not the next leader")
+	}
+
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, uint64(index))
+	ctx := NewClientTransaction(st.GetVersion(), Instruction{
+		InstanceID: ConfigInstanceID,
+		Invoke: &Invoke{
+			ContractID: ContractConfigID,
+			Command:    "rotate_leader",
+			Args:       Arguments{{Name: "index", Value: buf}},
+		},
+	})
+	_, err = s.createNewBlock(scID, rotateRoster(&config.Roster, next),
+		[]TxResult{{ClientTransaction: ctx}})
+	return cothority.ErrorOrNil(err, "creating block")
+}
@@ -0,0 +1,114 @@
+package byzcoin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+)
+
+func leaderWeight(t *testing.T, si *network.ServerIdentity,
+	weight int) LeaderWeight {
+	public, err := si.Public.MarshalBinary()
+	require.NoError(t, err)
+	return LeaderWeight{Public: public, Weight: weight}
+}
+
+func TestChainConfig_LeaderRotation(t *testing.T) {
+	roster, _ := genRoster(4)
+	c := ChainConfig{Roster: *roster}
+	require.Equal(t, 0, c.nextLeader(100))
+
+	c.LeaderRotation = &LeaderRotation{Interval: 5, Start: 10}
+	require.NoError(t, c.LeaderRotation.sanityCheck(c))
+	require.Equal(t, 0, c.nextLeader(14))
+	require.Equal(t, 1, c.nextLeader(15))
+
+	// The weights change the length of the terms, and nodes without weight
+	// are skipped.
+	c.LeaderRotation.Weights = []LeaderWeight{
+		leaderWeight(t, roster.List[0], 2),
+		leaderWeight(t, roster.List[2], 1),
+	}
+	require.NoError(t, c.LeaderRotation.sanityCheck(c))
+	require.Equal(t, 0, c.nextLeader(19))
+	require.Equal(t, 2, c.nextLeader(20))
+
+	newC := c
+	newC.Roster = *rotateRoster(&c.Roster, 1)
+	require.Error(t, newC.checkLeaderRotation(&c, 20))
+	newC.Roster = *rotateRoster(&c.Roster, 2)
+	require.NoError(t, newC.checkLeaderRotation(&c, 20))
+	require.Error(t, c.checkLeaderRotation(&c, 20))
+	require.NoError(t, c.checkLeaderRotation(&c, 19))
+
+	// The term of the leader only goes on if it doesn't change.
+	require.Equal(t, 10, c.withLeaderStart(&c, 30).LeaderRotation.Start)
+	require.Equal(t, 30, newC.withLeaderStart(&c, 30).LeaderRotation.Start)
+	require.Equal(t, 30, c.withLeaderStart(&ChainConfig{Roster: *roster},
+		30).LeaderRotation.Start)
+	require.Equal(t, 10, c.LeaderRotation.Start)
+
+	c.LeaderRotation.Weights[0].Weight = 0
+	require.Error(t, c.LeaderRotation.sanityCheck(c))
+	c.LeaderRotation.Weights[0].Weight = -1
+	require.Error(t, c.LeaderRotation.sanityCheck(c))
+	c.LeaderRotation.Weights = nil
+	c.LeaderRotation.Interval = 0
+	require.Error(t, c.LeaderRotation.sanityCheck(c))
+}
+
+func TestService_LeaderRotation(t *testing.T) {
+	b := newBCTRun(t, nil)
+	defer b.CloseAll()
+
+	scID := b.Genesis.SkipChainID()
+	requireLeader := func(i int) {
+		var leader *network.ServerIdentity
+		for j := 0; j < 20; j++ {
+			var err error
+			leader, err = b.Services[0].getLeader(scID)
+			require.NoError(t, err)
+			if leader.Equal(b.Services[i].ServerIdentity()) {
+				return
+			}
+			time.Sleep(b.PropagationInterval)
+		}
+		require.Fail(t, "wrong leader", leader.String())
+	}
+
+	// The last node never becomes leader.
+	config, err := b.Services[0].LoadConfig(scID)
+	require.NoError(t, err)
+	config.LeaderRotation = &LeaderRotation{
+		Interval: 2,
+		Weights: []LeaderWeight{
+			leaderWeight(t, b.Services[0].ServerIdentity(), 1),
+			leaderWeight(t, b.Services[1].ServerIdentity(), 2),
+		},
+	}
+	buf, err := protobuf.Encode(config)
+	require.NoError(t, err)
+	b.SendInst(nil, Instruction{
+		InstanceID: ConfigInstanceID,
+		Invoke: &Invoke{
+			ContractID: ContractConfigID,
+			Command:    "update_config",
+			Args:       Arguments{{Name: "config", Value: buf}},
+		},
+	})
+	requireLeader(0)
+
+	// After one more block, the second node takes over for four blocks,
+	// including the one handing over.
+	b.SpawnDummy(nil)
+	requireLeader(1)
+	for i := 0; i < 3; i++ {
+		ctx, _ := b.SpawnDummy(nil)
+		requireDummyValue(t, b, NewInstanceID(ctx.Instructions[0].Hash()),
+			"anyvalue")
+	}
+	requireLeader(0)
+}
@@ -0,0 +1,324 @@
+package byzcoin
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// ErrorUnverifiable is returned by a light client for the requests whose
+// reply cannot be verified against its trust anchor.
+var ErrorUnverifiable = xerrors.New("reply cannot be verified by a light client")
+
+// TrustAnchor holds the blocks trusted by a light client: the genesis block
+// of the chain and the latest block it verified from it.
+type TrustAnchor struct {
+	Genesis skipchain.SkipBlock
+	Latest  skipchain.SkipBlock
+}
+
+// NewLightClient returns a client that doesn't trust the nodes: every reply
+// is verified with the forward links from its trust anchor, and the replies
+// that cannot be verified are refused with ErrorUnverifiable. The trust anchor
+// is stored in the file at path every time the client verifies a newer
+// block, so it survives restarts of the client. If the file doesn't exist,
+// the genesis block is fetched from the roster and checked against the ID.
+func NewLightClient(ID skipchain.SkipBlockID, roster onet.Roster,
+	path string) (*Client, error) {
+	c := NewClient(ID, roster)
+	c.anchorPath = path
+
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		if err := c.fetchGenesis(); err != nil {
+			return nil, xerrors.Errorf("fetching genesis block: %v", err)
+		}
+		if c.Genesis.Index != 0 || !c.Genesis.CalculateHash().Equal(ID) {
+			return nil, xerrors.New("got an invalid genesis block")
+		}
+		if err := c.storeTrustAnchor(); err != nil {
+			return nil, xerrors.Errorf("storing trust anchor: %v", err)
+		}
+		return c, nil
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("reading trust anchor: %v", err)
+	}
+
+	var anchor TrustAnchor
+	err = protobuf.DecodeWithConstructors(buf, &anchor,
+		network.DefaultConstructors(cothority.Suite))
+	if err != nil {
+		return nil, xerrors.Errorf("decoding trust anchor: %v", err)
+	}
+	if !anchor.Genesis.CalculateHash().Equal(ID) ||
+		!anchor.Latest.SkipChainID().Equal(ID) {
+		return nil, xerrors.New("trust anchor is for another chain")
+	}
+	c.Genesis = &anchor.Genesis
+	c.Latest = &anchor.Latest
+	return c, nil
+}
+
+// isLight returns true if the client verifies all the replies against its
+// trust anchor.
+func (c *Client) isLight() bool {
+	return c.anchorPath != ""
+}
+
+// storeTrustAnchor writes the trust anchor to a temporary file which then
+// replaces the previous one, so that a crash doesn't leave a broken anchor.
+func (c *Client) storeTrustAnchor() error {
+	buf, err := protobuf.Encode(&TrustAnchor{
+		Genesis: *c.Genesis,
+		Latest:  *c.Latest,
+	})
+	if err != nil {
+		return xerrors.Errorf("encoding: %v", err)
+	}
+	tmp := c.anchorPath + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf, 0600); err != nil {
+		return xerrors.Errorf("writing: %v", err)
+	}
+	return cothority.ErrorOrNil(os.Rename(tmp, c.anchorPath), "renaming")
+}
+
+// setLatest updates the latest block known to the client, which must have
+// been verified by the caller. A light client stores it as its new trust
+// anchor.
+func (c *Client) setLatest(sb *skipchain.SkipBlock) error {
+	if c.Latest != nil && c.Latest.Index >= sb.Index {
+		return nil
+	}
+	c.Latest = sb
+	if c.isLight() {
+		return c.storeTrustAnchor()
+	}
+	return nil
+}
+
+// UpdateTrustAnchor fetches the blocks following the latest block known to
+// the client, and makes the last one the latest block once their forward
+// links are verified. A light client stores it as its new trust anchor.
+func (c *Client) UpdateTrustAnchor() (*skipchain.SkipBlock, error) {
+	if c.Genesis == nil {
+		if err := c.fetchGenesis(); err != nil {
+			return nil, xerrors.Errorf("fetching genesis block: %v", err)
+		}
+	}
+	// The update chain is verified by the skipchain client.
+	reply, err := skipchain.NewClient().GetUpdateChain(&c.Roster,
+		c.getLatestKnownBlock().Hash)
+	if err != nil {
+		return nil, xerrors.Errorf("getting update chain: %v", err)
+	}
+	if len(reply.Update) == 0 {
+		return nil, xerrors.New("no block found in chain update")
+	}
+	if err := c.setLatest(reply.Update[len(reply.Update)-1]); err != nil {
+		return nil, xerrors.Errorf("storing latest block: %v", err)
+	}
+	return c.Latest, nil
+}
+
+// getSignerCountersLight reads the counters from proofs instead of trusting
+// the reply of the nodes. The index of the reply is the one of the oldest
+// block used.
+func (c *Client) getSignerCountersLight(ids ...string) (*GetSignerCountersResponse, error) {
+	reply := &GetSignerCountersResponse{
+		Counters: make([]uint64, len(ids)),
+	}
+	for i, id := range ids {
+		key := publicVersionKey(id)
+		pr, err := c.GetProofFromLatest(key)
+		if err != nil {
+			return nil, xerrors.Errorf("getting proof: %v", err)
+		}
+		if i == 0 || uint64(pr.Proof.Latest.Index) < reply.Index {
+			reply.Index = uint64(pr.Proof.Latest.Index)
+		}
+		ok, err := pr.Proof.InclusionProof.Exists(key)
+		if err != nil {
+			return nil, xerrors.Errorf("invalid proof: %v", err)
+		}
+		if !ok {
+			continue
+		}
+		buf, _, _, err := pr.Proof.Get(key)
+		if err != nil {
+			return nil, xerrors.Errorf("reading proof: %v", err)
+		}
+		if len(buf) != 8 {
+			return nil, xerrors.New("invalid signer counter")
+		}
+		reply.Counters[i] = binary.LittleEndian.Uint64(buf)
+	}
+	return reply, nil
+}
+
+// getUpdatesLight asks a proof for every instance, as the nodes could omit
+// the proofs of updated instances, verifies them against the forward links
+// from the genesis block, and then keeps the proofs the caller asked for.
+func (c *Client) getUpdatesLight(keyVer []IDVersion,
+	flags GetUpdatesFlags) (*GetUpdatesReply, error) {
+	req := &GetUpdatesRequest{
+		Flags:       GUFSendVersion0 | GUFSendMissingProofs,
+		SkipchainID: c.ID,
+	}
+	for _, idv := range keyVer {
+		req.Instances = append(req.Instances, IDVersion{ID: idv.ID})
+	}
+
+	decoder := func(buf []byte, msg interface{}) error {
+		err := protobuf.Decode(buf, msg)
+		if err != nil {
+			return xerrors.Errorf("decoding: %+v", err)
+		}
+
+		rep, ok := msg.(*GetUpdatesReply)
+		if !ok {
+			return xerrors.New("couldn't cast msg")
+		}
+		if rep.Latest == nil {
+			return xerrors.New("missing latest block")
+		}
+		if rep.Latest.Index < c.getLatestKnownBlock().Index {
+			return xerrors.New("latest block in reply is too old")
+		}
+		if len(rep.Proofs) != len(keyVer) {
+			return xerrors.New("missing proofs in reply")
+		}
+		for i := range rep.Proofs {
+			p := Proof{
+				InclusionProof: rep.Proofs[i],
+				Latest:         *rep.Latest,
+				Links:          rep.Links,
+			}
+			if err := p.VerifyFromBlock(c.Genesis); err != nil {
+				return xerrors.Errorf("proof verification: %+v", err)
+			}
+			if _, err := rep.Proofs[i].Exists(keyVer[i].ID[:]); err != nil {
+				return xerrors.Errorf("proof of wrong instance: %+v", err)
+			}
+		}
+		return nil
+	}
+
+	rep := &GetUpdatesReply{}
+	_, err := c.SendProtobufParallelWithDecoder(c.Roster.List, req, rep,
+		c.options, decoder)
+	if err != nil {
+		return nil, xerrors.Errorf("couldn't get updates: %v", err)
+	}
+	if err := c.setLatest(rep.Latest); err != nil {
+		return nil, xerrors.Errorf("storing latest block: %v", err)
+	}
+
+	sendVersion0 := flags&GUFSendVersion0 > 0
+	proofs := rep.Proofs
+	rep.Proofs = nil
+	for i, p := range proofs {
+		id := keyVer[i].ID[:]
+		if !p.Match(id) {
+			if flags&GUFSendMissingProofs > 0 {
+				rep.Proofs = append(rep.Proofs, p)
+			}
+			continue
+		}
+		var inst StateChangeBody
+		if err := protobuf.Decode(p.Get(id), &inst); err != nil {
+			return nil, xerrors.Errorf("invalid instance in proof: %v", err)
+		}
+		if inst.Version > keyVer[i].Version ||
+			(sendVersion0 && inst.Version == 0) {
+			rep.Proofs = append(rep.Proofs, p)
+		}
+	}
+	return rep, nil
+}
+
+// checkAuthorizationLight evaluates the rules of the darc with the darcs
+// proven by the nodes, instead of trusting the evaluation of the nodes.
+func (c *Client) checkAuthorizationLight(dID darc.ID,
+	ids ...darc.Identity) ([]darc.Action, error) {
+	config, err := c.GetChainConfig()
+	if err != nil {
+		return nil, xerrors.Errorf("getting config: %v", err)
+	}
+	d, err := c.getVerifiedDarc(config, dID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting darc: %v", err)
+	}
+	if d == nil {
+		return nil, xerrors.New("couldn't find darc")
+	}
+
+	// An error while getting a darc would give an incomplete list of
+	// actions, so it is returned instead.
+	var getErr error
+	getDarcs := func(s string, latest bool) *darc.Darc {
+		if !latest {
+			return nil
+		}
+		id, err := hex.DecodeString(strings.Replace(s, "darc:", "", 1))
+		if err != nil || len(id) != 32 {
+			return nil
+		}
+		d, err := c.getVerifiedDarc(config, id)
+		if err != nil && getErr == nil {
+			getErr = err
+		}
+		return d
+	}
+	var idStrs []string
+	for _, i := range ids {
+		idStrs = append(idStrs, i.String())
+	}
+	var actions []darc.Action
+	for _, r := range d.Rules.List {
+		if darc.EvalExprDarc(r.Expr, getDarcs, true, idStrs...) == nil {
+			actions = append(actions, r.Action)
+		}
+	}
+	if getErr != nil {
+		return nil, xerrors.Errorf("getting darc: %v", getErr)
+	}
+	return actions, nil
+}
+
+// getVerifiedDarc returns the latest version of the darc from a proof, or
+// nil if the proof shows that there is no such darc.
+func (c *Client) getVerifiedDarc(config *ChainConfig,
+	id darc.ID) (*darc.Darc, error) {
+	pr, err := c.GetProofFromLatest(id)
+	if err != nil {
+		return nil, xerrors.Errorf("getting proof: %v", err)
+	}
+	ok, err := pr.Proof.InclusionProof.Exists(id)
+	if err != nil {
+		return nil, xerrors.Errorf("invalid proof: %v", err)
+	}
+	if !ok {
+		return nil, nil
+	}
+	_, buf, contract, _, err := pr.Proof.KeyValue()
+	if err != nil {
+		return nil, xerrors.Errorf("invalid proof: %v", err)
+	}
+	for _, cid := range config.DarcContractIDs {
+		if contract == cid {
+			return darc.NewFromProtobuf(buf)
+		}
+	}
+	return nil, nil
+}
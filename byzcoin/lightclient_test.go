@@ -0,0 +1,76 @@
+package byzcoin
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"golang.org/x/xerrors"
+)
+
+func TestClient_Light(t *testing.T) {
+	b := newBCTRun(t, nil)
+	defer b.CloseAll()
+
+	dir, err := ioutil.TempDir("", "lightclient")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "anchor")
+
+	c, err := NewLightClient(b.Genesis.SkipChainID(), *b.Roster, path)
+	require.NoError(t, err)
+	require.Equal(t, 0, c.Latest.Index)
+
+	ctx, _ := b.SpawnDummy(nil)
+	key := NewInstanceID(ctx.Instructions[0].Hash())
+	pr, err := c.GetProof(key.Slice())
+	require.NoError(t, err)
+	require.True(t, pr.Proof.InclusionProof.Match(key.Slice()))
+	require.Equal(t, 1, c.Latest.Index)
+
+	// The trust anchor survives a restart of the client.
+	c, err = NewLightClient(b.Genesis.SkipChainID(), *b.Roster, path)
+	require.NoError(t, err)
+	require.Equal(t, 1, c.Latest.Index)
+	_, err = NewLightClient(skipchain.SkipBlockID(make([]byte, 32)),
+		*b.Roster, path)
+	require.Error(t, err)
+
+	counters, err := c.GetSignerCounters(b.Signer.Identity().String())
+	require.NoError(t, err)
+	require.Equal(t, []uint64{b.SignerCounter - 1}, counters.Counters)
+
+	missing := NewInstanceID([]byte("missing"))
+	ids := []IDVersion{{ID: key}, {ID: missing}}
+	upd, err := c.GetUpdates(ids, 0, nil)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(upd.Proofs))
+	upd, err = c.GetUpdates(ids, GUFSendVersion0, nil)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(upd.Proofs))
+	upd, err = c.GetUpdates(ids, GUFSendVersion0|GUFSendMissingProofs, nil)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(upd.Proofs))
+
+	actions, err := c.CheckAuthorization(b.GenesisDarc.GetBaseID(),
+		b.Signer.Identity())
+	require.NoError(t, err)
+	expected, err := b.Client.CheckAuthorization(b.GenesisDarc.GetBaseID(),
+		b.Signer.Identity())
+	require.NoError(t, err)
+	require.ElementsMatch(t, expected, actions)
+
+	_, err = c.SimulateTransaction(ctx)
+	require.True(t, xerrors.Is(err, ErrorUnverifiable))
+
+	b.SpawnDummy(nil)
+	latest, err := c.UpdateTrustAnchor()
+	require.NoError(t, err)
+	require.Equal(t, 2, latest.Index)
+	c, err = NewLightClient(b.Genesis.SkipChainID(), *b.Roster, path)
+	require.NoError(t, err)
+	require.Equal(t, 2, c.Latest.Index)
+}
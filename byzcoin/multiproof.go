@@ -0,0 +1,125 @@
+package byzcoin
+
+import (
+	"bytes"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// maxProofKeys is the maximum number of keys that can be asked in a single
+// GetProofs request.
+var maxProofKeys = 1000
+
+// newMultiProof creates a proof of the keys in the state trie, starting at
+// the block with the given id.
+func newMultiProof(st *stateTrie, s *skipchain.SkipBlockDB,
+	id skipchain.SkipBlockID, keys [][]byte) (*MultiProof, error) {
+	p := &MultiProof{}
+	pr, err := st.GetMultiProof(keys)
+	if err != nil {
+		return nil, xerrors.Errorf("couldn't get proof: %+v", err)
+	}
+	p.InclusionProof = *pr
+	var sb *skipchain.SkipBlock
+	p.Links, sb, err = forwardLinks(s, id, st.GetIndex())
+	if err != nil {
+		return nil, xerrors.Errorf("getting forward links: %w", err)
+	}
+	if st.GetIndex() != sb.Index {
+		return nil, xerrors.New("didn't find skipblock with same index as state-trie")
+	}
+	p.Latest = *sb
+	return p, nil
+}
+
+// VerifyFromBlock takes a skipchain block and verifies that the proof is
+// valid for this block, like Proof.VerifyFromBlock.
+func (p MultiProof) VerifyFromBlock(verifiedBlock *skipchain.SkipBlock) error {
+	if len(p.Links) > 0 {
+		p.Links[0].NewRoster = verifiedBlock.Roster
+	}
+	err := p.Verify(verifiedBlock.Hash)
+	return cothority.ErrorOrNil(err, "verification failed")
+}
+
+// Verify takes a skipchain id and verifies that the proof is valid for this
+// skipchain, like Proof.Verify. It does not verify whether the keys exist in
+// the proof.
+func (p MultiProof) Verify(sbID skipchain.SkipBlockID) error {
+	var header DataHeader
+	err := protobuf.Decode(p.Latest.Data, &header)
+	if err != nil {
+		return xerrors.Errorf("decoding header: %v", err)
+	}
+	if !bytes.Equal(p.InclusionProof.GetRoot(), header.TrieRoot) {
+		return cothority.WrapError(ErrorVerifyTrieRoot)
+	}
+
+	return verifyForwardLinks(p.Links, sbID, &p.Latest)
+}
+
+// Proof extracts the proof of a single key. The returned proof can be
+// verified and read like the one returned by GetProof.
+func (p MultiProof) Proof(key []byte) (*Proof, error) {
+	pr, err := p.InclusionProof.Proof(key)
+	if err != nil {
+		return nil, xerrors.Errorf("extracting proof: %v", err)
+	}
+	return &Proof{
+		InclusionProof: *pr,
+		Latest:         p.Latest,
+		Links:          p.Links,
+	}, nil
+}
+
+// GetProofs returns the proofs of the given keys in a single MultiProof,
+// which can be used together with the genesis block to prove the inclusion
+// or the absence of each key.
+func (s *Service) GetProofs(req *GetProofs) (*GetProofsResponse, error) {
+	if len(req.Keys) == 0 {
+		return nil, xerrors.New("no keys given")
+	}
+	if len(req.Keys) > maxProofKeys {
+		return nil, xerrors.Errorf("too many keys: %d > %d", len(req.Keys),
+			maxProofKeys)
+	}
+
+	s.updateTrieMutex.Lock()
+	defer s.updateTrieMutex.Unlock()
+
+	if !s.tasks.areTasksAllowed() {
+		return nil, xerrors.New("cannot get proofs while in closed state")
+	}
+
+	sb := s.db().GetByID(req.ID)
+	if sb == nil {
+		return nil, xerrors.New("cannot find skipblock while getting proofs")
+	}
+	st, err := s.getStateTrie(sb.SkipChainID())
+	if err != nil {
+		return nil, xerrors.Errorf("getting state trie: %w", err)
+	}
+	proof, err := newMultiProof(st, s.db(), req.ID, req.Keys)
+	if err != nil {
+		return nil, xerrors.Errorf("making proof: %w", err)
+	}
+
+	if len(req.MustContainBlock) > 0 {
+		mcb := s.db().GetByID(req.MustContainBlock)
+		// As in GetProof, the proof is returned with the latest known block.
+		if mcb == nil || proof.Latest.Index < mcb.Index {
+			return nil, xerrors.New("must contain clause cannot be enforced")
+		}
+	}
+
+	log.Lvlf2("%s: Returning proof for %d keys from chain %x at index %v",
+		s.ServerIdentity(), len(req.Keys), sb.SkipChainID(), proof.Latest.Index)
+	return &GetProofsResponse{
+		Version: CurrentVersion,
+		Proofs:  *proof,
+	}, nil
+}
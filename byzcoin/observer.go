@@ -0,0 +1,235 @@
+package byzcoin
+
+import (
+	"time"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/kyber/v3/sign/schnorr"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
+	"golang.org/x/xerrors"
+)
+
+// An observer is a conode that follows a chain without being part of its
+// roster. It regularly asks the roster for new blocks and replays them, so
+// its state trie is verified against the trie root of every block, and it can
+// answer the read-only requests of the clients. The transactions it gets are
+// forwarded to the roster.
+
+func observeMessage(id skipchain.SkipBlockID) []byte {
+	return append([]byte("observe:"), id...)
+}
+
+func stopObservingMessage(id skipchain.SkipBlockID) []byte {
+	return append([]byte("stopobserving:"), id...)
+}
+
+// Observe makes the conode follow the chain as an observer. If the chain is
+// unknown, its genesis block is fetched from the roster of the request. The
+// reply is sent once the conode caught up with the chain.
+func (s *Service) Observe(req *ObserveRequest) (*ObserveResponse, error) {
+	err := schnorr.Verify(cothority.Suite, s.ServerIdentity().Public,
+		observeMessage(req.ByzCoinID), req.Signature)
+	if err != nil {
+		return nil, xerrors.Errorf("verifying signature: %v", err)
+	}
+	if len(req.Roster.List) == 0 {
+		return nil, xerrors.New("empty roster")
+	}
+	if !s.tasks.add(1) {
+		return nil, xerrors.New("node is closed")
+	}
+	defer s.tasks.done()
+
+	s.skService().FollowIDInternal(req.ByzCoinID)
+	if s.db().GetByID(req.ByzCoinID) == nil {
+		cl := skipchain.NewClient()
+		cl.DontContact(s.ServerIdentity())
+		genesis, err := cl.GetSingleBlock(&req.Roster, req.ByzCoinID)
+		if err != nil {
+			return nil, xerrors.Errorf("getting genesis block: %v", err)
+		}
+		if genesis.Index != 0 || !genesis.CalculateHash().Equal(req.ByzCoinID) {
+			return nil, xerrors.New("got an invalid genesis block")
+		}
+		isByzCoin := false
+		for _, x := range genesis.VerifierIDs {
+			isByzCoin = isByzCoin || x.Equal(Verify)
+		}
+		if !isByzCoin {
+			return nil, xerrors.New("not a byzcoin instance")
+		}
+		// This creates the state trie through updateTrieCallback.
+		_, err = s.db().StoreBlocks([]*skipchain.SkipBlock{genesis})
+		if err != nil {
+			return nil, xerrors.Errorf("storing genesis block: %v", err)
+		}
+	} else if !s.hasByzCoinVerification(req.ByzCoinID) {
+		return nil, xerrors.New("not a byzcoin instance")
+	}
+
+	s.storage.Lock()
+	if !s.storage.isObserved(req.ByzCoinID) {
+		s.storage.Observed = append(s.storage.Observed, req.ByzCoinID)
+	}
+	s.storage.Unlock()
+	s.save()
+
+	if err := s.observeUpdate(req.ByzCoinID, &req.Roster); err != nil {
+		return nil, xerrors.Errorf("catching up: %v", err)
+	}
+	s.startObserving(req.ByzCoinID)
+
+	latest, err := s.db().GetLatestByID(req.ByzCoinID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting latest block: %v", err)
+	}
+	return &ObserveResponse{Index: latest.Index}, nil
+}
+
+// StopObserving stops following the chain. The blocks and the state trie of
+// the chain are kept.
+func (s *Service) StopObserving(req *StopObservingRequest) (*StopObservingResponse, error) {
+	err := schnorr.Verify(cothority.Suite, s.ServerIdentity().Public,
+		stopObservingMessage(req.ByzCoinID), req.Signature)
+	if err != nil {
+		return nil, xerrors.Errorf("verifying signature: %v", err)
+	}
+
+	s.storage.Lock()
+	found := false
+	for i, id := range s.storage.Observed {
+		if id.Equal(req.ByzCoinID) {
+			s.storage.Observed = append(s.storage.Observed[:i],
+				s.storage.Observed[i+1:]...)
+			found = true
+			break
+		}
+	}
+	s.storage.Unlock()
+	if !found {
+		return nil, xerrors.New("chain is not observed")
+	}
+	s.save()
+
+	s.observersMut.Lock()
+	if stop, ok := s.observers[string(req.ByzCoinID)]; ok {
+		close(stop)
+		delete(s.observers, string(req.ByzCoinID))
+	}
+	s.observersMut.Unlock()
+	return &StopObservingResponse{}, nil
+}
+
+func (s *bcStorage) isObserved(scID skipchain.SkipBlockID) bool {
+	for _, id := range s.Observed {
+		if id.Equal(scID) {
+			return true
+		}
+	}
+	return false
+}
+
+// isObserving returns true if the conode follows the chain as an observer.
+func (s *Service) isObserving(scID skipchain.SkipBlockID) bool {
+	s.storage.Lock()
+	defer s.storage.Unlock()
+	return s.storage.isObserved(scID)
+}
+
+// startObserving starts the go-routine fetching the new blocks of the chain,
+// if it is not running yet.
+func (s *Service) startObserving(scID skipchain.SkipBlockID) {
+	s.observersMut.Lock()
+	defer s.observersMut.Unlock()
+	if _, ok := s.observers[string(scID)]; ok {
+		return
+	}
+	if !s.tasks.add(1) {
+		return
+	}
+	stop := make(chan bool)
+	s.observers[string(scID)] = stop
+	go s.observe(scID, stop)
+}
+
+// stopAllObservers stops the go-routines of all the observed chains.
+func (s *Service) stopAllObservers() {
+	s.observersMut.Lock()
+	defer s.observersMut.Unlock()
+	for k, stop := range s.observers {
+		close(stop)
+		delete(s.observers, k)
+	}
+}
+
+// observe asks for new blocks once every block interval, until stop is
+// closed.
+func (s *Service) observe(scID skipchain.SkipBlockID, stop chan bool) {
+	defer s.tasks.done()
+	for {
+		interval, _, err := s.LoadBlockInfo(scID)
+		if err != nil {
+			log.Warnf("%s: couldn't get block interval: %v",
+				s.ServerIdentity(), err)
+		}
+		// Don't poll without pause if the interval cannot be read.
+		if err != nil || interval <= 0 {
+			interval = defaultInterval
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(interval):
+		}
+		latest, err := s.db().GetLatestByID(scID)
+		if err != nil {
+			log.Errorf("%s: couldn't get latest block: %v",
+				s.ServerIdentity(), err)
+			continue
+		}
+		if err := s.observeUpdate(scID, latest.Roster); err != nil {
+			log.Warnf("%s: couldn't update observed chain %x: %v",
+				s.ServerIdentity(), scID, err)
+		}
+	}
+}
+
+// observeUpdate gets the latest block of the chain from the roster and
+// catches up with it. The blocks are verified with the forward links from
+// the latest block known by the conode.
+func (s *Service) observeUpdate(scID skipchain.SkipBlockID,
+	roster *onet.Roster) error {
+	latest, err := s.db().GetLatestByID(scID)
+	if err != nil {
+		return xerrors.Errorf("getting latest block: %v", err)
+	}
+	cl := skipchain.NewClient()
+	cl.DontContact(s.ServerIdentity())
+	reply, err := cl.GetUpdateChain(roster, latest.Hash)
+	if err != nil {
+		return xerrors.Errorf("getting update chain: %v", err)
+	}
+	if len(reply.Update) == 0 {
+		return xerrors.New("no block found in chain update")
+	}
+	sb := reply.Update[len(reply.Update)-1]
+	if sb.Index > latest.Index {
+		s.catchUp(sb)
+	}
+	return nil
+}
+
+// forwardTransaction sends the request to the roster of an observed chain,
+// which returns the response and the proof to the client.
+func (s *Service) forwardTransaction(req *AddTxRequest,
+	roster *onet.Roster) (*AddTxResponse, error) {
+	reply := &AddTxResponse{}
+	_, err := NewClient(req.SkipchainID, *roster).
+		SendProtobufParallel(roster.List, req, reply, nil)
+	if err != nil {
+		return nil, xerrors.Errorf("forwarding transaction: %v", err)
+	}
+	return reply, nil
+}
@@ -0,0 +1,70 @@
+package byzcoin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3/darc"
+)
+
+func TestService_Observe(t *testing.T) {
+	b := newBCTRun(t, nil)
+	defer b.CloseAll()
+	b.SpawnDummy(nil)
+
+	server := b.Local.GenServers(1)[0]
+	obs := server.Service(ServiceName).(*Service)
+	registerContracts(obs)
+
+	// Only the conode can ask to observe a chain.
+	_, err := obs.Observe(&ObserveRequest{
+		ByzCoinID: b.Genesis.SkipChainID(),
+		Roster:    *b.Roster,
+	})
+	require.Error(t, err)
+
+	resp, err := Observe(server.ServerIdentity, b.Roster, b.Genesis.SkipChainID())
+	require.NoError(t, err)
+	require.Equal(t, 1, resp.Index)
+	require.True(t, obs.isObserving(b.Genesis.SkipChainID()))
+
+	// The transactions sent to the observer are forwarded to the roster.
+	b.Services = append(b.Services, obs)
+	ctx, _ := b.SpawnDummy(&TxArgs{
+		Node:            len(b.Services) - 1,
+		Wait:            10,
+		WaitPropagation: true,
+		RequireSuccess:  true,
+	})
+
+	// The observer gets the new block.
+	st, err := obs.getStateTrie(b.Genesis.SkipChainID())
+	require.NoError(t, err)
+	for i := 0; i < 10 && st.GetIndex() < 2; i++ {
+		time.Sleep(b.PropagationInterval)
+	}
+	require.Equal(t, 2, st.GetIndex())
+
+	key := NewInstanceID(ctx.Instructions[0].Hash()).Slice()
+	proof, err := obs.GetProof(&GetProof{
+		Version: CurrentVersion,
+		Key:     key,
+		ID:      b.Genesis.SkipChainID(),
+	})
+	require.NoError(t, err)
+	require.NoError(t, proof.Proof.Verify(b.Genesis.SkipChainID()))
+	require.True(t, proof.Proof.InclusionProof.Match(key))
+
+	auth, err := obs.CheckAuthorization(&CheckAuthorization{
+		ByzCoinID:  b.Genesis.SkipChainID(),
+		DarcID:     b.GenesisDarc.GetBaseID(),
+		Identities: []darc.Identity{b.Signer.Identity()},
+	})
+	require.NoError(t, err)
+	require.Contains(t, auth.Actions, darc.Action("spawn:"+DummyContractName))
+
+	require.NoError(t, StopObserving(server.ServerIdentity, b.Genesis.SkipChainID()))
+	require.False(t, obs.isObserving(b.Genesis.SkipChainID()))
+	require.Error(t, StopObserving(server.ServerIdentity, b.Genesis.SkipChainID()))
+}
@@ -0,0 +1,151 @@
+package byzcoin
+
+import (
+	"sync"
+
+	"go.dedis.ch/cothority/v3/skipchain"
+)
+
+// readTracker records the keys read from a stagingStateTrie. A nil
+// readTracker doesn't record anything.
+type readTracker struct {
+	sync.Mutex
+	keys map[string]struct{}
+	all  bool
+}
+
+func newReadTracker() *readTracker {
+	return &readTracker{keys: make(map[string]struct{})}
+}
+
+// read records that the key has been read.
+func (rt *readTracker) read(key []byte) {
+	if rt == nil {
+		return
+	}
+	rt.Lock()
+	rt.keys[string(key)] = struct{}{}
+	rt.Unlock()
+}
+
+// readAll records that the result depends on all the keys of the trie.
+func (rt *readTracker) readAll() {
+	if rt == nil {
+		return
+	}
+	rt.Lock()
+	rt.all = true
+	rt.Unlock()
+}
+
+// conflicts returns true if any of the recorded keys has been written.
+func (rt *readTracker) conflicts(written map[string]struct{}) bool {
+	rt.Lock()
+	defer rt.Unlock()
+	if rt.all {
+		return len(written) > 0
+	}
+	if len(written) < len(rt.keys) {
+		for k := range written {
+			if _, ok := rt.keys[k]; ok {
+				return true
+			}
+		}
+		return false
+	}
+	for k := range rt.keys {
+		if _, ok := written[k]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// speculativeTx is the result of a transaction executed on the state at the
+// beginning of the block, regardless of the other transactions of the block.
+type speculativeTx struct {
+	states StateChanges
+	gas    uint64
+	err    error
+	reads  *readTracker
+}
+
+// apply returns the result of the speculative execution as if the
+// transaction had been executed on sst. This is only correct if none of the
+// keys read by the transaction have been written since the beginning of the
+// block.
+func (st speculativeTx) apply(sst *stagingStateTrie) (StateChanges,
+	*stagingStateTrie, uint64, error) {
+	if st.err != nil {
+		return nil, nil, st.gas, st.err
+	}
+	sst = sst.Clone()
+	if err := sst.StoreAll(st.states); err != nil {
+		return nil, nil, st.gas, err
+	}
+	return st.states, sst, st.gas, nil
+}
+
+// addWrites adds the keys written by the state changes to written.
+func addWrites(written map[string]struct{}, scs StateChanges) {
+	for _, sc := range scs {
+		if sc.StateAction != GenerateInstruction && sc.StateAction != EmitEvent {
+			written[string(sc.InstanceID)] = struct{}{}
+		}
+	}
+}
+
+// executeSpeculatively runs all the transactions in parallel on clones of
+// sst. The results can be used by createStateChanges for all the
+// transactions that don't read keys written by the previous transactions
+// of the block, the others have to be executed again.
+func (s *Service) executeSpeculatively(sst *stagingStateTrie,
+	scID skipchain.SkipBlockID, txIn TxResults, timestamp int64,
+	workers int) []speculativeTx {
+	out := make([]speculativeTx, len(txIn))
+	indexes := make(chan int, len(txIn))
+	for i := range txIn {
+		indexes <- i
+	}
+	close(indexes)
+	if workers > len(txIn) {
+		workers = len(txIn)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				sstSpec := sst.Clone()
+				sstSpec.reads = newReadTracker()
+				// processOneTx may modify the instructions of the
+				// transaction, which are shared with the caller.
+				tx := txIn[i].ClientTransaction.Clone()
+				out[i].states, _, out[i].gas, out[i].err = s.processOneTx(
+					sstSpec, tx, scID, timestamp, nil)
+				out[i].reads = sstSpec.reads
+			}
+		}()
+	}
+	wg.Wait()
+	return out
+}
+
+// SetParallelExecution sets the number of transactions that are executed in
+// parallel when creating or verifying a block. With 0 or 1, the
+// transactions are executed one after the other. The resulting blocks are
+// the same in both modes, so the nodes of a roster can use different
+// values.
+func (s *Service) SetParallelExecution(workers int) {
+	s.parallelWorkersMutex.Lock()
+	s.parallelWorkers = workers
+	s.parallelWorkersMutex.Unlock()
+}
+
+func (s *Service) getParallelWorkers() int {
+	s.parallelWorkersMutex.Lock()
+	defer s.parallelWorkersMutex.Unlock()
+	return s.parallelWorkers
+}
@@ -0,0 +1,127 @@
+package byzcoin
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadTracker(t *testing.T) {
+	var nilTracker *readTracker
+	nilTracker.read([]byte("key"))
+	nilTracker.readAll()
+
+	rt := newReadTracker()
+	require.False(t, rt.conflicts(map[string]struct{}{}))
+	rt.read([]byte("a"))
+	rt.read([]byte("b"))
+	require.False(t, rt.conflicts(map[string]struct{}{"c": {}}))
+	require.True(t, rt.conflicts(map[string]struct{}{"b": {}}))
+	require.True(t, rt.conflicts(map[string]struct{}{
+		"b": {}, "c": {}, "d": {},
+	}))
+
+	rt.readAll()
+	require.False(t, rt.conflicts(map[string]struct{}{}))
+	require.True(t, rt.conflicts(map[string]struct{}{"c": {}}))
+}
+
+func TestStagingStateTrie_Reads(t *testing.T) {
+	sst, err := newMemStagingStateTrie([]byte("nonce"))
+	require.NoError(t, err)
+	iid := NewInstanceID([]byte("read"))
+	require.NoError(t, sst.StoreAll(StateChanges{
+		NewStateChange(Create, iid, "read", nil, nil),
+	}))
+
+	sst.reads = newReadTracker()
+	clone := sst.Clone()
+	_, _, _, _, err = clone.GetValues(iid.Slice())
+	require.NoError(t, err)
+	require.True(t, sst.reads.conflicts(map[string]struct{}{
+		string(iid.Slice()): {},
+	}))
+	require.False(t, sst.reads.all)
+
+	_, err = clone.GetProof(iid.Slice())
+	require.NoError(t, err)
+	require.True(t, sst.reads.all)
+}
+
+func TestService_ParallelExecution(t *testing.T) {
+	b := newBCTRun(t, nil)
+	defer b.CloseAll()
+
+	// The contract adds the value of the instance given in the argument to
+	// its own value.
+	cid := "parallelSC"
+	f := func(cdb ReadOnlyStateTrie, inst Instruction, c []Coin) ([]StateChange, []Coin, error) {
+		src, _, _, _, err := cdb.GetValues(inst.Invoke.Args.Search("src"))
+		if err != nil {
+			return nil, nil, err
+		}
+		dst, ver, _, _, err := cdb.GetValues(inst.InstanceID.Slice())
+		if err != nil {
+			return nil, nil, err
+		}
+		value := make([]byte, 8)
+		binary.LittleEndian.PutUint64(value,
+			binary.LittleEndian.Uint64(src)+binary.LittleEndian.Uint64(dst))
+		sc := NewStateChange(Update, inst.InstanceID, cid, value, nil)
+		sc.Version = ver + 1
+		return StateChanges{sc}, c, nil
+	}
+	b.Services[0].testRegisterContract(cid, adaptorNoVerify(f))
+	cdb, err := b.Services[0].getStateTrie(b.Genesis.SkipChainID())
+	require.NoError(t, err)
+
+	var iids []InstanceID
+	var scs StateChanges
+	for i := 0; i < 6; i++ {
+		iid := NewInstanceID([]byte{byte(i)})
+		value := make([]byte, 8)
+		binary.LittleEndian.PutUint64(value, uint64(i+1))
+		iids = append(iids, iid)
+		scs = append(scs, NewStateChange(Create, iid, cid, value, nil))
+	}
+	require.NoError(t, cdb.StoreAll(scs, cdb.GetIndex(), CurrentVersion))
+
+	newTx := func(dst, src InstanceID) ClientTransaction {
+		return ClientTransaction{Instructions: Instructions{{
+			InstanceID: dst,
+			Invoke: &Invoke{
+				ContractID: cid,
+				Args:       Arguments{{Name: "src", Value: src.Slice()}},
+			},
+		}}}
+	}
+	txs := NewTxResults(
+		newTx(iids[0], iids[1]),
+		newTx(iids[2], iids[3]),
+		// Reads the value written by the first transaction.
+		newTx(iids[1], iids[0]),
+		// Refused, as the source instance doesn't exist.
+		newTx(iids[4], NewInstanceID([]byte("none"))),
+		newTx(iids[5], iids[5]),
+		newTx(iids[5], iids[2]),
+	)
+
+	timestamp := time.Now().UnixNano()
+	root, txOut, states, _ := b.Services[0].createStateChanges(
+		cdb.MakeStagingStateTrie(), b.Genesis.SkipChainID(), txs, noTimeout,
+		CurrentVersion, timestamp)
+	require.Equal(t, len(txs), len(txOut))
+	require.False(t, txOut[3].Accepted)
+
+	// Make sure the result doesn't come from the cache.
+	b.Services[0].stateChangeCache = newStateChangeCache()
+	b.Services[0].SetParallelExecution(4)
+	rootPar, txOutPar, statesPar, _ := b.Services[0].createStateChanges(
+		cdb.MakeStagingStateTrie(), b.Genesis.SkipChainID(), txs, noTimeout,
+		CurrentVersion, timestamp)
+	require.Equal(t, root, rootPar)
+	require.Equal(t, txOut.Hash(), txOutPar.Hash())
+	require.Equal(t, states.Hash(), statesPar.Hash())
+}
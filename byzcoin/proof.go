@@ -26,16 +26,33 @@ func NewProof(c ReadOnlyStateTrie, s *skipchain.SkipBlockDB, id skipchain.SkipBl
 		return nil, xerrors.Errorf("couldn't get proof: %+v", err)
 	}
 	p.InclusionProof = *pr
+	var sb *skipchain.SkipBlock
+	p.Links, sb, err = forwardLinks(s, id, c.GetIndex())
+	if err != nil {
+		return nil, xerrors.Errorf("getting forward links: %w", err)
+	}
+	if c.GetIndex() != sb.Index {
+		return nil, xerrors.New("didn't find skipblock with same index as state-trie")
+	}
+	p.Latest = *sb
+	return
+}
+
+// forwardLinks returns the forward links going from the block with the given
+// id towards the block with the given index, and the last block reached. The
+// first link is a synthetic link holding the roster of the first block.
+func forwardLinks(s *skipchain.SkipBlockDB, id skipchain.SkipBlockID,
+	index int) ([]skipchain.ForwardLink, *skipchain.SkipBlock, error) {
 	sb := s.GetByID(id)
 	if sb == nil {
-		return nil, xerrors.New("didn't find skipchain")
+		return nil, nil, xerrors.New("didn't find skipchain")
 	}
-	p.Links = []skipchain.ForwardLink{{
+	links := []skipchain.ForwardLink{{
 		From:      []byte{},
 		To:        id,
 		NewRoster: sb.Roster,
 	}}
-	for len(sb.ForwardLink) > 0 && sb.Index < c.GetIndex() {
+	for len(sb.ForwardLink) > 0 && sb.Index < index {
 		var link *skipchain.ForwardLink
 		// Corner-case when the database is downloading blocks and a proof is
 		// requested before all blocks are stored - then we need to make sure that
@@ -48,25 +65,21 @@ func NewProof(c ReadOnlyStateTrie, s *skipchain.SkipBlockDB, id skipchain.SkipBl
 				log.Warnf("Found block %d with invalid forward-link at level"+
 					" %d", sb.Index, height)
 				if height == 0 {
-					return nil, xerrors.New("missing block in chain")
+					return nil, nil, xerrors.New("missing block in chain")
 				}
 				continue
 			}
 			if sbTemp.Index <= sb.Index {
-				return nil, cothority.ErrorOrNil(skipchain.ErrorInconsistentForwardLink, "")
+				return nil, nil, cothority.ErrorOrNil(skipchain.ErrorInconsistentForwardLink, "")
 			}
-			if sbTemp.Index <= c.GetIndex() {
+			if sbTemp.Index <= index {
 				sb = sbTemp
 				break
 			}
 		}
-		p.Links = append(p.Links, *link)
-	}
-	if c.GetIndex() != sb.Index {
-		return nil, xerrors.New("didn't find skipblock with same index as state-trie")
+		links = append(links, *link)
 	}
-	p.Latest = *sb
-	return
+	return links, sb, nil
 }
 
 // ErrorVerifyTrie is returned if the proof itself is not properly set up.
@@ -125,19 +138,27 @@ func (p Proof) Verify(sbID skipchain.SkipBlockID) error {
 		return cothority.WrapError(err)
 	}
 
-	if len(p.Links) == 0 {
+	return verifyForwardLinks(p.Links, sbID, &p.Latest)
+}
+
+// verifyForwardLinks checks that the links go from the block with ID sbID
+// to the latest block. The first link must be a synthetic link holding the
+// roster of the block sbID.
+func verifyForwardLinks(links []skipchain.ForwardLink,
+	sbID skipchain.SkipBlockID, latest *skipchain.SkipBlock) error {
+	if len(links) == 0 {
 		return cothority.WrapError(ErrorMissingForwardLinks)
 	}
-	if p.Links[0].NewRoster == nil {
+	if links[0].NewRoster == nil {
 		return cothority.WrapError(ErrorMalformedForwardLink)
 	}
 
 	// Get the first from the synthetic link which is assumed to be verified
 	// before against the block with ID stored in the To field by the caller.
-	publics := p.Links[0].NewRoster.ServicePublics(skipchain.ServiceName)
+	publics := links[0].NewRoster.ServicePublics(skipchain.ServiceName)
 
-	for _, l := range p.Links[1:] {
-		if err = l.VerifyWithScheme(pairing.NewSuiteBn256(), publics, p.Latest.SignatureScheme); err != nil {
+	for _, l := range links[1:] {
+		if err := l.VerifyWithScheme(pairing.NewSuiteBn256(), publics, latest.SignatureScheme); err != nil {
 			return cothority.WrapError(ErrorVerifySkipchain)
 		}
 		if !l.From.Equal(sbID) {
@@ -150,7 +171,7 @@ func (p Proof) Verify(sbID skipchain.SkipBlockID) error {
 	}
 
 	// Check that the given latest block matches the last forward link target
-	if !p.Latest.CalculateHash().Equal(sbID) {
+	if !latest.CalculateHash().Equal(sbID) {
 		return cothority.WrapError(ErrorVerifyHash)
 	}
 
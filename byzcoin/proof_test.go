@@ -35,6 +35,39 @@ func TestNewProof(t *testing.T) {
 	require.True(t, p.InclusionProof.Match(s.key))
 }
 
+func TestService_GetProofs(t *testing.T) {
+	b := newBCTRun(t, nil)
+	defer b.CloseAll()
+
+	ctx, _ := b.SpawnDummy(nil)
+	key := NewInstanceID(ctx.Instructions[0].Hash()).Slice()
+	missing := NewInstanceID([]byte("missing")).Slice()
+	darcID := b.GenesisDarc.GetBaseID()
+
+	rep, err := b.Client.GetProofs(key, missing, darcID)
+	require.NoError(t, err)
+	require.NoError(t, rep.Proofs.Verify(b.Genesis.SkipChainID()))
+	require.True(t, rep.Proofs.InclusionProof.Match(key))
+	require.False(t, rep.Proofs.InclusionProof.Match(missing))
+
+	// The extracted proof is the same as the one of GetProof.
+	pr, err := rep.Proofs.Proof(darcID)
+	require.NoError(t, err)
+	require.NoError(t, pr.VerifyFromBlock(b.Genesis))
+	expected, err := b.Client.GetProof(darcID)
+	require.NoError(t, err)
+	require.Equal(t, expected.Proof.InclusionProof.GetRoot(),
+		pr.InclusionProof.GetRoot())
+	require.Equal(t, expected.Proof.InclusionProof.Get(darcID),
+		pr.InclusionProof.Get(darcID))
+
+	_, err = b.Services[0].GetProofs(&GetProofs{
+		Version: CurrentVersion,
+		ID:      b.Genesis.SkipChainID(),
+	})
+	require.Error(t, err)
+}
+
 func TestVerify(t *testing.T) {
 	s := createSC(t)
 	p, err := NewProof(s.c, s.s, s.genesis.Hash, s.key)
@@ -7,6 +7,7 @@ import (
 	"go.dedis.ch/cothority/v3/darc"
 	"go.dedis.ch/cothority/v3/skipchain"
 	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/network"
 )
 
 // PROTOSTART
@@ -20,8 +21,10 @@ import (
 // type :InstanceID:bytes
 // type :Version:sint32
 // type :GetUpdatesFlags:uint64
+// type :NodeRole:sint32
 // import "skipchain.proto";
 // import "onet.proto";
+// import "network.proto";
 // import "darc.proto";
 // import "trie.proto";
 //
@@ -51,12 +54,21 @@ type DataHeader struct {
 	Timestamp int64
 	// Version is the version of ByzCoin at the creation of the block.
 	Version Version `protobuf:"opt"`
+	// EventsHash is the sha256 of all the Events emitted by the accepted
+	// transactions. It is only set if there are events in the block.
+	EventsHash []byte `protobuf:"opt"`
+	// IndexRoot is the root of the range index after applying the valid
+	// transactions. It is only set if the range index of the node creating
+	// the block was ready.
+	IndexRoot []byte `protobuf:"opt"`
 }
 
 // DataBody is stored in the body of the skipblock, and it's hash is stored
 // in the DataHeader.
 type DataBody struct {
 	TxResults TxResults
+	// Events emitted by the contracts, in the order of the transactions.
+	Events []Event `protobuf:"opt"`
 }
 
 // ***
@@ -150,6 +162,87 @@ type GetProofResponse struct {
 	Proof Proof
 }
 
+// GetProofAt asks for a proof of the key in the state of the chain after
+// the block with the given index.
+type GetProofAt struct {
+	// Version of the protocol
+	Version Version
+	// Key is the key we want to look up
+	Key []byte
+	// ID is any block that is known to us in the skipchain, up to the block
+	// of the given index. The proof returned will be starting at this block.
+	ID skipchain.SkipBlockID
+	// Index of the block holding the state to prove
+	Index int
+}
+
+// GetProofs asks for the proofs of several keys, which are returned in a
+// single MultiProof.
+type GetProofs struct {
+	// Version of the protocol
+	Version Version
+	// Keys are the keys we want to look up
+	Keys [][]byte
+	// ID is any block that is known to us in the skipchain, can be the genesis
+	// block or any later block. The proof returned will be starting at this block.
+	ID skipchain.SkipBlockID
+	// MustContainBlock when provided informs the server that the proof
+	// should include this block.
+	MustContainBlock skipchain.SkipBlockID `protobuf:"opt"`
+}
+
+// GetProofsResponse can be used together with the Genesis block to proof that
+// the returned key/value pairs are in the trie.
+type GetProofsResponse struct {
+	// Version of the protocol
+	Version Version
+	// Proofs contains everything necessary to prove the inclusion or the
+	// absence of all the keys given a genesis skipblock.
+	Proofs MultiProof
+}
+
+// GetRangeProof asks for a proof of all the instances whose ID is in the
+// range [Start, End).
+type GetRangeProof struct {
+	// Version of the protocol
+	Version Version
+	// ID is any block that is known to us in the skipchain, can be the genesis
+	// block or any later block. The proof returned will be starting at this block.
+	ID skipchain.SkipBlockID
+	// Start is the smallest instance ID of the range
+	Start []byte
+	// End is the first instance ID after the range. If it is empty, the
+	// range has no upper bound.
+	End []byte `protobuf:"opt"`
+	// MustContainBlock when provided informs the server that the proof
+	// should include this block.
+	MustContainBlock skipchain.SkipBlockID `protobuf:"opt"`
+}
+
+// GetRangeProofResponse holds a proof that can be used together with the
+// Genesis block to get all the instances of the range.
+type GetRangeProofResponse struct {
+	// Version of the protocol
+	Version Version
+	// Proof holds the nodes of the range index that might hold an instance
+	// of the range.
+	Proof RangeProof
+}
+
+// RangeProof is like MultiProof, but for the range index, whose root is the
+// IndexRoot of the block. As the keys of the range index are not hashed, the
+// proof of a range of instance IDs only holds the subtrees of the range.
+type RangeProof struct {
+	// IndexProof holds the nodes of the range index that might hold an
+	// instance of the range
+	IndexProof trie.MultiProof
+	// Providing the latest skipblock to retrieve the root of the range
+	// index.
+	Latest skipchain.SkipBlock
+	// Proving the path to the latest skipblock, like in Proof.
+	Links []skipchain.ForwardLink
+}
+
 // CheckAuthorization returns the list of actions that could be executed if the
 // signatures of the given identities are present and valid
 type CheckAuthorization struct {
@@ -177,6 +270,57 @@ type ChainConfig struct {
 	Roster          onet.Roster
 	MaxBlockSize    int
 	DarcContractIDs []string
+	// GasLimits enables the metering of the transactions. If it is nil,
+	// the gas is not metered.
+	GasLimits *GasLimits `protobuf:"opt"`
+	// FeeBeneficiary is the coin instance receiving the fees of the
+	// transactions. If it is nil, transactions with a fee are refused.
+	FeeBeneficiary *InstanceID `protobuf:"opt"`
+	// ContractVersions records from which block the versions of the
+	// contracts are used. A contract without a record uses its version 0.
+	ContractVersions []ContractVersion `protobuf:"opt"`
+	// LeaderRotation makes the leader hand over to the next node of the
+	// roster after a number of blocks. If it is nil, the leader only
+	// changes with a view-change.
+	LeaderRotation *LeaderRotation `protobuf:"opt"`
+}
+
+// LeaderRotation defines how long every node of the roster stays leader.
+type LeaderRotation struct {
+	// Interval is the number of blocks created by a leader of weight 1.
+	Interval int
+	// Weights multiply the interval of the nodes. If it is empty, all the
+	// nodes have a weight of 1, else the missing nodes have a weight of 0
+	// and never become leader.
+	Weights []LeaderWeight `protobuf:"opt"`
+	// Start is the index of the first block of the current leader. It is
+	// set by the config contract.
+	Start int
+}
+
+// LeaderWeight is the weight of a node, given by its public key, in the
+// leader rotation.
+type LeaderWeight struct {
+	Public []byte
+	Weight int
+}
+
+// ContractVersion activates a version of a contract from the block with the
+// given index.
+type ContractVersion struct {
+	ContractID string
+	Version    int
+	BlockIndex int
+}
+
+// GasLimits define how much gas the transactions can use.
+type GasLimits struct {
+	// MaxBlockGas is the maximum gas that the accepted transactions of a
+	// block can use.
+	MaxBlockGas uint64
+	// MaxTxGas is the maximum gas that one transaction can use. If it is 0,
+	// a transaction can use up to MaxBlockGas.
+	MaxTxGas uint64
 }
 
 // Proof represents everything necessary to verify a given
@@ -199,6 +343,18 @@ type Proof struct {
 	Links []skipchain.ForwardLink
 }
 
+// MultiProof is like Proof, but for several keys. The skipblock and the links
+// are shared by all the keys, and the nodes of the trie that are common to
+// the paths of the keys are stored only once.
+type MultiProof struct {
+	// InclusionProof holds the nodes of the paths of all the keys
+	InclusionProof trie.MultiProof
+	// Providing the latest skipblock to retrieve the Merkle tree root.
+	Latest skipchain.SkipBlock
+	// Proving the path to the latest skipblock, like in Proof.
+	Links []skipchain.ForwardLink
+}
+
 // Instruction holds only one of Spawn, Invoke, or Delete
 type Instruction struct {
 	// InstanceID is either the instance that can spawn a new instance, or the instance
@@ -274,12 +430,49 @@ type Argument struct {
 // every instruction must sign for the transaction to be valid.
 type ClientTransaction struct {
 	Instructions Instructions
+	// Fee is an optional fee paid for the transaction. It is included in
+	// the hash signed by the instructions.
+	Fee *TxFee `protobuf:"opt"`
+	// Validity optionally restricts the blocks that can include the
+	// transaction. It is included in the hash signed by the instructions.
+	Validity *TxValidity `protobuf:"opt"`
+}
+
+// TxFee is paid from a coin instance to the FeeBeneficiary of the
+// ChainConfig. The signers of the first instruction must be allowed to
+// invoke coin.transfer on the coin instance. The fee is only paid if the
+// transaction is accepted.
+type TxFee struct {
+	// Coin is the coin instance paying the fee.
+	Coin InstanceID
+	// Amount is the number of coins paid.
+	Amount uint64
+}
+
+// TxValidity defines the last block that can include a ClientTransaction.
+// A transaction that arrives later is refused, so that a client can safely
+// retry a transaction once it expired.
+type TxValidity struct {
+	// MaxBlockIndex is the index of the last block that can include the
+	// transaction. If it is 0, the index is not checked.
+	MaxBlockIndex int
+	// MaxTimestamp is the latest timestamp, in nanoseconds, of a block
+	// including the transaction. If it is 0, the timestamp is not checked.
+	MaxTimestamp int64
 }
 
 // TxResult holds a transaction and the result of running it.
 type TxResult struct {
 	ClientTransaction ClientTransaction
 	Accepted          bool
+	// GasUsed is the gas used by the transaction. It is only set if the
+	// ChainConfig defines GasLimits.
+	GasUsed uint64 `protobuf:"opt"`
+	// stateChanges is a private field holding the state changes created by
+	// an accepted transaction, which are stored in its TxReceipt.
+	// This field must be the last field of the struct, so that the
+	// protobuf-library enumerates the fields correctly.
+	stateChanges StateChanges
 }
 
 // StateChange is one new state that will be applied to the collection.
@@ -298,6 +491,22 @@ type StateChange struct {
 	Version uint64
 }
 
+// Event is emitted by a contract while executing an instruction. It doesn't
+// change the trie, but is stored in the block.
+type Event struct {
+	// ContractID of the contract emitting the event
+	ContractID string
+	// InstanceID of the instance the event relates to
+	InstanceID InstanceID
+	// Name of the event
+	Name string
+	// Data is the payload of the event, defined by the contract
+	Data []byte
+	// TxIndex is the position in the block of the transaction emitting the
+	// event.
+	TxIndex int
+}
+
 // Coin is a generic structure holding any type of coin. Coins are defined
 // by a genesis coin instance that is unique for each type of coin.
 type Coin struct {
@@ -308,14 +517,68 @@ type Coin struct {
 }
 
 // StreamingRequest is a request asking the service to start streaming blocks
-// on the chain specified by ID.
+// on the chain specified by ID. If any of the filters is set, only the
+// blocks holding at least one transaction matching all the non-empty filters
+// are sent.
 type StreamingRequest struct {
 	ID skipchain.SkipBlockID
+	// ContractIDs of the instructions of the transactions to send
+	ContractIDs []string `protobuf:"opt"`
+	// InstanceIDs of the instructions of the transactions to send
+	InstanceIDs []InstanceID `protobuf:"opt"`
+	// Signers of the instructions of the transactions to send
+	Signers []darc.Identity `protobuf:"opt"`
+	// AcceptedOnly filters out the refused transactions.
+	AcceptedOnly bool `protobuf:"opt"`
+	// TxResultsOnly asks to send the block without its body, together with
+	// the matching transactions and the summaries of all the transactions.
+	TxResultsOnly bool `protobuf:"opt"`
+	// StartID is the first stored block to send, before the new blocks.
+	StartID skipchain.SkipBlockID `protobuf:"opt"`
+	// StartIndex is the index of the first block to send, if StartID is
+	// not set.
+	StartIndex *int `protobuf:"opt"`
 }
 
 // StreamingResponse is the reply (block) that is streamed back to the client
 type StreamingResponse struct {
 	Block *skipchain.SkipBlock
+	// TxResults are the matching transactions if TxResultsOnly is set in
+	// the request. The Payload of the block is empty in this case.
+	TxResults []TxResult `protobuf:"opt"`
+	// Positions of the TxResults in the block.
+	Positions []int `protobuf:"opt"`
+	// Summaries of all the transactions of the block, to verify the
+	// TxResults against the header of the block.
+	Summaries []TxSummary `protobuf:"opt"`
+}
+
+// TxSummary holds the parts of a TxResult needed to compute the
+// ClientTransactionHash of the DataHeader.
+type TxSummary struct {
+	ClientTransactionHash []byte
+	Accepted              bool
+	GasUsed               uint64 `protobuf:"opt"`
+}
+
+// StreamEventsRequest is a request asking the service to start streaming
+// the events of the chain specified by ID. Only the events matching all the
+// non-empty filters are sent.
+type StreamEventsRequest struct {
+	ID skipchain.SkipBlockID
+	// ContractIDs of the events to send
+	ContractIDs []string `protobuf:"opt"`
+	// InstanceIDs of the events to send
+	InstanceIDs []InstanceID `protobuf:"opt"`
+	// Names of the events to send
+	Names []string `protobuf:"opt"`
+}
+
+// StreamEventsResponse holds the matching events of a new block.
+type StreamEventsResponse struct {
+	BlockID    skipchain.SkipBlockID
+	BlockIndex int
+	Events     []Event
 }
 
 // PaginateRequest is a request to get NumPages times the consecutive list of
@@ -388,6 +651,86 @@ type DBKeyValue struct {
 	Value []byte
 }
 
+// Snapshot describes a copy of the nodes of the state trie kept by a node
+// after a block, which can be downloaded in chunks by a node joining the
+// chain. As the content of the trie is the same on all the honest nodes, so is
+// the snapshot, and the roster of the block signs its description.
+type Snapshot struct {
+	// ByzCoinID of the chain of the snapshot
+	ByzCoinID skipchain.SkipBlockID
+	// Index of the block of the snapshot
+	Index int
+	// BlockID of the block of the snapshot
+	BlockID skipchain.SkipBlockID
+	// TrieRoot is the root of the trie after the block
+	TrieRoot []byte
+	// Total nodes of the trie
+	Total int
+	// ChunkHashes holds the hash of every chunk of the snapshot
+	ChunkHashes [][]byte
+	// Nonce of the trie
+	Nonce []byte
+	// Signature of the roster of the block on the hash of the snapshot. Only
+	// the leader of the block stores it.
+	Signature []byte `protobuf:"opt"`
+}
+
+// GetSnapshot asks a node for the description of its latest snapshot.
+type GetSnapshot struct {
+	// ByzCoinID of the chain of the snapshot
+	ByzCoinID skipchain.SkipBlockID
+}
+
+// GetSnapshotResponse is the description of the latest snapshot of the node.
+type GetSnapshotResponse struct {
+	Snapshot Snapshot
+}
+
+// GetSnapshotChunk asks a node for one chunk of its snapshot.
+type GetSnapshotChunk struct {
+	// ByzCoinID of the chain of the snapshot
+	ByzCoinID skipchain.SkipBlockID
+	// Index of the block of the snapshot
+	Index int
+	// Chunk is the position of the chunk in the snapshot
+	Chunk int
+}
+
+// GetSnapshotChunkResponse holds the trie nodes of the chunk as they are stored
+// in the DB, which can be verified with the hash of the chunk in the Snapshot.
+type GetSnapshotChunkResponse struct {
+	KeyValues []DBKeyValue
+}
+
+// GetNodeRole asks a node for its role.
+type GetNodeRole struct {
+}
+
+// GetNodeRoleResponse tells which blocks the node keeps.
+type GetNodeRoleResponse struct {
+	// Role of the node
+	Role NodeRole
+	// PruningWindow is the number of recent blocks whose body is kept by a
+	// full node.
+	PruningWindow int
+	// Counter of the last ChangeNodeRole request
+	Counter uint64
+}
+
+// ChangeNodeRole asks the conode to change its role. It needs to be signed by
+// the private key of the conode, on the message returned by
+// NodeRoleMessage.
+type ChangeNodeRole struct {
+	// Role of the node
+	Role NodeRole
+	// PruningWindow is the number of recent blocks whose body is kept by a
+	// full node.
+	PruningWindow int
+	// Counter must be the one of the node plus one.
+	Counter   uint64
+	Signature []byte
+}
+
 // StateChangeBody represents the body part of a state change, which is the
 // part that needs to be serialised and stored in a merkle tree.
 type StateChangeBody struct {
@@ -415,6 +758,87 @@ type GetSignerCountersResponse struct {
 	Index uint64 `protobuf:"opt"`
 }
 
+// SimulateTransaction is a request to execute a ClientTransaction on the
+// latest state of the chain without storing the result. The instructions
+// don't need to be signed.
+type SimulateTransaction struct {
+	// Version of the protocol
+	Version Version
+	// SkipchainID is the hash of the first skipblock
+	SkipchainID skipchain.SkipBlockID
+	// Transaction is the ClientTransaction to simulate
+	Transaction ClientTransaction
+}
+
+// SimulateTransactionResponse holds the result of a simulated
+// ClientTransaction.
+type SimulateTransactionResponse struct {
+	// Version of the protocol
+	Version Version
+	// StateChanges that the transaction would create, including the ones
+	// of the fee and of the signer counters.
+	StateChanges []StateChange
+	// Coins that are left over after the last instruction.
+	Coins []Coin
+	// GasUsed by the transaction if the ChainConfig defines GasLimits.
+	GasUsed uint64 `protobuf:"opt"`
+	// Authorized is true if the signatures of the instructions and of the
+	// fee are accepted by their darcs.
+	Authorized bool
+	// AuthorizationError describes why the signatures are not accepted.
+	AuthorizationError string `protobuf:"opt"`
+	// Error describes why the transaction would be refused, regardless of
+	// its signatures.
+	Error string `protobuf:"opt"`
+	// Index of the block whose state has been used for the simulation.
+	Index int
+}
+
+// GetTxReceipt is a request for the receipt of a ClientTransaction.
+type GetTxReceipt struct {
+	// Version of the protocol
+	Version Version
+	// SkipchainID is the hash of the first skipblock
+	SkipchainID skipchain.SkipBlockID
+	// TxHash is the hash of the instructions of the ClientTransaction, as
+	// returned by Instructions.Hash().
+	TxHash []byte
+}
+
+// GetTxReceiptResponse holds the receipt of a ClientTransaction and the
+// proof that the transaction is included in a block of the chain.
+type GetTxReceiptResponse struct {
+	// Version of the protocol
+	Version Version
+	// Receipt of the transaction
+	Receipt TxReceipt
+	// Block holds the transaction in its body.
+	Block skipchain.SkipBlock
+	// Links are the forward links from the genesis block to Block. The
+	// first link is a synthetic link holding the roster of the genesis
+	// block.
+	Links []skipchain.ForwardLink
+}
+
+// TxReceipt describes what happened to a ClientTransaction. If the same
+// transaction has been included in more than one block, the receipt points
+// to the block where it has been accepted, or else to the latest block.
+type TxReceipt struct {
+	// BlockID is the ID of the block holding the transaction.
+	BlockID skipchain.SkipBlockID
+	// BlockIndex is the index of the block holding the transaction.
+	BlockIndex int
+	// Position of the transaction in the block.
+	Position int
+	// Accepted is true if the transaction changed the global state.
+	Accepted bool
+	// Error describes why the transaction has been refused, if the node
+	// knows it.
+	Error string `protobuf:"opt"`
+	// StateChanges created by the transaction.
+	StateChanges []StateChange
+}
+
 // GetInstanceVersion is a request asking the service to fetch
 // the version of the given instance
 type GetInstanceVersion struct {
@@ -469,6 +893,39 @@ type CheckStateChangeValidityResponse struct {
 	BlockID      skipchain.SkipBlockID
 }
 
+// GetInstanceHistory is a request for all the stored versions of an
+// instance, together with the data needed to verify them.
+type GetInstanceHistory struct {
+	SkipChainID skipchain.SkipBlockID
+	InstanceID  InstanceID
+}
+
+// GetInstanceHistoryResponse holds the versions of an instance in the order
+// they have been applied, and a proof of the current state of the instance.
+// It can be verified against the genesis block with Verify.
+type GetInstanceHistoryResponse struct {
+	Entries []InstanceHistoryEntry
+	Proof   Proof
+}
+
+// InstanceHistoryEntry is one version of an instance together with the block
+// that applied it.
+type InstanceHistoryEntry struct {
+	// Block is the block of the state change, without its payload.
+	Block skipchain.SkipBlock
+	// Links go from the block of the previous entry, or the genesis block
+	// for the first entry, to Block.
+	Links []skipchain.ForwardLink
+	// StateChanges are all the state changes of the block, so that their
+	// hash can be compared to the StateChangesHash of the header.
+	StateChanges []StateChange
+	// Position is the index of the version in StateChanges.
+	Position int
+	// Summaries are the transactions of the block, so that their hash can
+	// be compared to the ClientTransactionHash of the header.
+	Summaries []TxSummary
+}
+
 // ResolveInstanceID is the request for resolving the instance ID based on the
 // Darc ID and the name.
 type ResolveInstanceID struct {
@@ -482,6 +939,48 @@ type ResolvedInstanceID struct {
 	InstanceID InstanceID
 }
 
+// ListInstances asks for the instances of the chain matching all the given
+// filters, in the order of their IDs. The instances are read from indexes
+// kept by the node.
+type ListInstances struct {
+	ByzCoinID skipchain.SkipBlockID
+	// ContractID keeps only the instances of this contract
+	ContractID string `protobuf:"opt"`
+	// DarcID keeps only the instances controlled by this darc
+	DarcID darc.ID `protobuf:"opt"`
+	// Prefix keeps only the instances whose ID starts with it
+	Prefix []byte `protobuf:"opt"`
+	// Start is the ID of the first instance to return, as given in
+	// ListInstancesResponse.Next to get the next page
+	Start []byte `protobuf:"opt"`
+	// Limit is the maximum number of instances returned. If it is 0, the
+	// maximum allowed by the node is used.
+	Limit int
+	// WithProofs asks for a proof of the listed instances
+	WithProofs bool
+}
+
+// ListInstancesResponse holds one page of the instances matching the
+// filters, as found in the state of the block with the given index.
+type ListInstancesResponse struct {
+	Instances []ListedInstance
+	// Next is the ID of the first instance of the next page, or empty if
+	// this is the last page.
+	Next []byte `protobuf:"opt"`
+	// Index of the block of the state
+	Index int
+	// Proofs of the instances, from the genesis block, if they have been
+	// asked for.
+	Proofs *MultiProof `protobuf:"opt"`
+}
+
+// ListedInstance is an instance as stored in the indexes.
+type ListedInstance struct {
+	InstanceID InstanceID
+	ContractID string
+	DarcID     darc.ID
+}
+
 // DebugRequest returns the list of all byzcoins if byzcoinid is empty, else it returns
 // a dump of all instances if byzcoinid is given and exists.
 type DebugRequest struct {
@@ -517,6 +1016,35 @@ type DebugRemoveRequest struct {
 	Signature []byte
 }
 
+// ObserveRequest asks the conode to follow the given byzcoin-instance as a
+// read-only observer, without being part of its roster. It needs to be signed
+// by the private key of the conode, on "observe:" followed by the ByzCoinID.
+type ObserveRequest struct {
+	ByzCoinID skipchain.SkipBlockID
+	// Roster of nodes to get the blocks from
+	Roster    onet.Roster
+	Signature []byte
+}
+
+// ObserveResponse is returned once the conode caught up with the chain.
+type ObserveResponse struct {
+	// Index of the latest block known by the conode
+	Index int
+}
+
+// StopObservingRequest asks the conode to stop following the given
+// byzcoin-instance. It needs to be signed by the private key of the conode,
+// on "stopobserving:" followed by the ByzCoinID.
+type StopObservingRequest struct {
+	ByzCoinID skipchain.SkipBlockID
+	Signature []byte
+}
+
+// StopObservingResponse is returned once the conode stopped following the
+// chain.
+type StopObservingResponse struct {
+}
+
 // IDVersion holds the InstanceID and the latest known version of an instance.
 type IDVersion struct {
 	ID      InstanceID
@@ -540,6 +1068,132 @@ type GetUpdatesRequest struct {
 // but will not send any proof for an instance that didn't change.
 type GetUpdatesReply struct {
 	Proofs []trie.Proof
-	Links  []skipchain.ForwardLink
+	// Links go from the block given in the request, or the genesis block,
+	// to the latest block.
+	Links []skipchain.ForwardLink
+	// Latest is the block holding the root of the trie of the proofs
 	Latest *skipchain.SkipBlock
 }
+
+// GetEvidence asks a node for the evidence of misbehaviour it collected for
+// a chain.
+type GetEvidence struct {
+	SkipChainID skipchain.SkipBlockID
+}
+
+// GetEvidenceResponse holds the evidence collected by the node since it
+// started, from the oldest to the newest.
+type GetEvidenceResponse struct {
+	Evidence []Evidence
+	// Signatures are the conflicting forward-link signatures of the nodes.
+	Signatures []ConflictingSignature
+}
+
+// Evidence proves that a node signed two conflicting messages. It can be
+// registered on the chain with the evidence contract.
+type Evidence struct {
+	// Signer is the public key of the node.
+	Signer []byte
+	// Views are two view-change requests of the node for the same leader
+	// index, from two different blocks with the same index.
+	Views []SignedView
+}
+
+// SignedView is a view-change request signed by a node.
+type SignedView struct {
+	// Block is the latest block of the view, without its payload and
+	// forward links.
+	Block       skipchain.SkipBlock
+	LeaderIndex int
+	Signature   []byte
+}
+
+// ConflictingSignature holds the signatures of a node on forward links from
+// the same block to two different blocks. It can be registered on the chain
+// with the evidence contract.
+type ConflictingSignature struct {
+	// Signer is the public key of the node for the skipchain service.
+	Signer []byte
+	// From is the ID of the block of the forward links.
+	From  skipchain.SkipBlockID
+	Links []SignedLink
+}
+
+// SignedLink is the individual signature of a forward link by a node.
+type SignedLink struct {
+	To skipchain.SkipBlockID
+	// NewRosterID is the ID of the roster of the target block, if it is not
+	// the one of the block of the forward link.
+	NewRosterID []byte
+	Signature   []byte
+}
+
+// GetViewChanges asks a node for the view-changes of a chain it recorded,
+// and for the state of its view-change controller.
+type GetViewChanges struct {
+	SkipChainID skipchain.SkipBlockID
+	// Count is the maximum number of records returned, the most recent
+	// ones. If it is 0, all the records are returned.
+	Count int
+}
+
+// GetViewChangesResponse holds the view-changes, from the oldest to the
+// newest, and the state of the controller of the node.
+type GetViewChangesResponse struct {
+	Records []ViewChangeRecord
+	Status  ViewChangeStatus
+}
+
+// ViewChangeRecord describes a view-change stored in the chain.
+type ViewChangeRecord struct {
+	// BlockIndex is the index of the view-change block.
+	BlockIndex int
+	BlockID    skipchain.SkipBlockID
+	// ViewID is the ID of the block before the view-change.
+	ViewID skipchain.SkipBlockID
+	// LeaderIndex is the index of the new leader in the roster of the
+	// block before the view-change.
+	LeaderIndex int
+	OldLeader   *network.ServerIdentity
+	NewLeader   *network.ServerIdentity
+	// Proof holds the view-change requests that led to the view-change.
+	Proof []ViewChangeProof
+	// PreviousTimestamp is the timestamp of the block before the
+	// view-change, in nanoseconds.
+	PreviousTimestamp int64
+	// Timestamp is the timestamp of the view-change block, in
+	// nanoseconds.
+	Timestamp int64
+	// Started is the time, in nanoseconds, at which the node detected the
+	// need for the view-change, or 0 if it didn't take part in it.
+	Started int64
+}
+
+// ViewChangeProof is a view-change request of a node for the view of a
+// ViewChangeRecord.
+type ViewChangeProof struct {
+	SignerID  []byte
+	Signature []byte
+}
+
+// ViewChangeStatus is the state of the view-change controller of a node for
+// a chain.
+type ViewChangeStatus struct {
+	// Running is true if the controller is started.
+	Running bool
+	// LeaderIndex is the leader index of the current view-change, or 0 if
+	// there is none.
+	LeaderIndex int
+	// ViewID is the ID of the block of the current view-change.
+	ViewID skipchain.SkipBlockID `protobuf:"opt"`
+	// State is 0 if no request has been sent, 1 if the request of the node
+	// has been sent, and 2 if the node is waiting for the new view.
+	State int
+	// Requests is the number of requests received for the view.
+	Requests int
+	// Threshold is the number of requests needed to wait for the new view.
+	Threshold int
+	// Since is the time, in nanoseconds, at which the controller detected
+	// the need for the view-change, or 0.
+	Since int64
+}
@@ -0,0 +1,293 @@
+package byzcoin
+
+import (
+	"encoding/binary"
+	"os"
+	"strconv"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/kyber/v3/sign/schnorr"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/protobuf"
+	bbolt "go.etcd.io/bbolt"
+	"golang.org/x/xerrors"
+)
+
+// NodeRole tells which blocks a node keeps in its database.
+type NodeRole int
+
+const (
+	// NodeRoleArchive nodes keep all the blocks with their body, and can
+	// serve the old bodies to the other nodes. It is the default role.
+	NodeRoleArchive NodeRole = iota
+	// NodeRoleFull nodes keep the state, and the headers and forward links
+	// of all the blocks, but only the bodies of the recent blocks.
+	NodeRoleFull
+)
+
+// String returns the name of the role.
+func (r NodeRole) String() string {
+	switch r {
+	case NodeRoleArchive:
+		return "archive"
+	case NodeRoleFull:
+		return "full"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseNodeRole returns the role with the given name.
+func ParseNodeRole(name string) (NodeRole, error) {
+	switch name {
+	case "archive":
+		return NodeRoleArchive, nil
+	case "full":
+		return NodeRoleFull, nil
+	default:
+		return 0, xerrors.Errorf("unknown role '%s'", name)
+	}
+}
+
+// How many bodies are removed at most after a new block, so that switching
+// a node with a long chain to the full role doesn't stop it for long.
+var pruneMaxBlocks = 100
+
+var bucketPruning = []byte("pruning")
+
+// ErrorBodyPruned is returned if the body of a block has been removed by the
+// node. It can be asked to an archive node.
+var ErrorBodyPruned = xerrors.New("body of the block has been pruned")
+
+// pruningStorage keeps the index of the first block of every skipchain whose
+// body has not been pruned, apart from the genesis block.
+type pruningStorage struct {
+	db     *bbolt.DB
+	bucket []byte
+}
+
+func newPruningStorage(c *onet.Context) *pruningStorage {
+	db, name := c.GetAdditionalBucket(bucketPruning)
+	return &pruningStorage{
+		db:     db,
+		bucket: name,
+	}
+}
+
+// getPrunedBelow returns the index of the first block that has not been
+// pruned.
+func (s *pruningStorage) getPrunedBelow(sid skipchain.SkipBlockID) (index int, err error) {
+	index = 1
+	err = s.db.View(func(tx *bbolt.Tx) error {
+		buf := tx.Bucket(s.bucket).Get(sid)
+		if len(buf) == 8 {
+			index = int(binary.BigEndian.Uint64(buf))
+		}
+		return nil
+	})
+	return index, cothority.ErrorOrNil(err, "tx error")
+}
+
+func (s *pruningStorage) setPrunedBelow(sid skipchain.SkipBlockID, index int) error {
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		buf := make([]byte, 8)
+		binary.BigEndian.PutUint64(buf, uint64(index))
+		return tx.Bucket(s.bucket).Put(sid, buf)
+	})
+	return cothority.ErrorOrNil(err, "tx error")
+}
+
+// SetNodeRole sets which blocks the node keeps. A full node keeps the bodies
+// of the last window blocks and of the genesis block. The window must cover
+// the blocks that a catching up node replays from its state, as these blocks
+// can only be fetched from the nodes of the roster. The blocks following the
+// latest snapshot of the node are also kept, for the nodes bootstrapping
+// from it.
+func (s *Service) SetNodeRole(role NodeRole, window int) error {
+	switch role {
+	case NodeRoleArchive:
+	case NodeRoleFull:
+		if window < catchupDownloadAll {
+			return xerrors.Errorf("window must be at least %d blocks",
+				catchupDownloadAll)
+		}
+	default:
+		return xerrors.Errorf("unknown role %d", role)
+	}
+	s.storage.Lock()
+	s.storage.Role = role
+	s.storage.PruningWindow = window
+	s.storage.Unlock()
+	s.save()
+	return nil
+}
+
+// NodeRoleMessage returns the message signed by the conode to change its
+// role. The counter must be the one of the node plus one, so that a request
+// cannot be replayed.
+func NodeRoleMessage(role NodeRole, window int, counter uint64) []byte {
+	buf := make([]byte, 24)
+	binary.LittleEndian.PutUint64(buf, uint64(role))
+	binary.LittleEndian.PutUint64(buf[8:], uint64(window))
+	binary.LittleEndian.PutUint64(buf[16:], counter)
+	return append([]byte("noderole:"), buf...)
+}
+
+// ChangeNodeRole sets the role of the node, as asked by the operator of the
+// conode.
+func (s *Service) ChangeNodeRole(req *ChangeNodeRole) (*GetNodeRoleResponse, error) {
+	err := schnorr.Verify(cothority.Suite, s.ServerIdentity().Public,
+		NodeRoleMessage(req.Role, req.PruningWindow, req.Counter),
+		req.Signature)
+	if err != nil {
+		return nil, xerrors.Errorf("verifying signature: %v", err)
+	}
+
+	s.nodeRoleMutex.Lock()
+	defer s.nodeRoleMutex.Unlock()
+	s.storage.Lock()
+	counter := s.storage.RoleCounter
+	s.storage.Unlock()
+	if req.Counter != counter+1 {
+		return nil, xerrors.Errorf("got counter %d instead of %d",
+			req.Counter, counter+1)
+	}
+	if err := s.SetNodeRole(req.Role, req.PruningWindow); err != nil {
+		return nil, xerrors.Errorf("setting role: %v", err)
+	}
+	s.storage.Lock()
+	s.storage.RoleCounter = req.Counter
+	s.storage.Unlock()
+	s.save()
+	return s.GetNodeRole(&GetNodeRole{})
+}
+
+// setNodeRoleFromEnv sets the role of the node given by the
+// BYZCOIN_NODE_ROLE and BYZCOIN_PRUNING_WINDOW environment variables, if
+// they are set.
+func (s *Service) setNodeRoleFromEnv() error {
+	name := os.Getenv("BYZCOIN_NODE_ROLE")
+	if name == "" {
+		return nil
+	}
+	role, err := ParseNodeRole(name)
+	if err != nil {
+		return xerrors.Errorf("BYZCOIN_NODE_ROLE: %v", err)
+	}
+	var window int
+	if w := os.Getenv("BYZCOIN_PRUNING_WINDOW"); w != "" {
+		window, err = strconv.Atoi(w)
+		if err != nil {
+			return xerrors.Errorf("BYZCOIN_PRUNING_WINDOW: %v", err)
+		}
+	}
+	log.Lvlf1("%s: node role is %s with a window of %d blocks",
+		s.ServerIdentity(), role, window)
+	return cothority.ErrorOrNil(s.SetNodeRole(role, window), "setting role")
+}
+
+// getNodeRole returns the role of the node and its pruning window.
+func (s *Service) getNodeRole() (NodeRole, int) {
+	s.storage.Lock()
+	defer s.storage.Unlock()
+	return s.storage.Role, s.storage.PruningWindow
+}
+
+// pruneBodies removes the bodies of the blocks older than the window of a
+// full node, apart from the genesis block. The headers and forward links are
+// kept, so the chain can still be verified. The bodies of the blocks
+// following the latest snapshot are kept, as they are replayed by the nodes
+// bootstrapping from it, so nothing is pruned without a snapshot.
+func (s *Service) pruneBodies(sb *skipchain.SkipBlock) {
+	role, window := s.getNodeRole()
+	if role != NodeRoleFull {
+		return
+	}
+	scID := sb.SkipChainID()
+	from, err := s.pruning.getPrunedBelow(scID)
+	if err != nil {
+		log.Errorf("%s: couldn't read pruning index: %v", s.ServerIdentity(), err)
+		return
+	}
+	snap, err := s.snapshotStorage.getManifest(scID)
+	if err != nil {
+		log.Errorf("%s: couldn't read snapshot: %v", s.ServerIdentity(), err)
+		return
+	}
+	if snap == nil {
+		return
+	}
+	below := sb.Index - window
+	if below > snap.Index+1 {
+		below = snap.Index + 1
+	}
+	if below > from+pruneMaxBlocks {
+		below = from + pruneMaxBlocks
+	}
+	for index := from; index < below; index++ {
+		reply, err := s.skService().GetSingleBlockByIndex(
+			&skipchain.GetSingleBlockByIndex{
+				Genesis: scID,
+				Index:   index,
+			})
+		if err != nil {
+			log.Errorf("%s: couldn't get block %d to prune: %v",
+				s.ServerIdentity(), index, err)
+			return
+		}
+		// The pruned index is stored first, so that a missing body is
+		// never mistaken for an empty body.
+		if err := s.pruning.setPrunedBelow(scID, index+1); err != nil {
+			log.Errorf("%s: couldn't store pruning index: %v",
+				s.ServerIdentity(), err)
+			return
+		}
+		if err := s.db().RemovePayload(reply.SkipBlock.Hash); err != nil {
+			log.Errorf("%s: couldn't prune block %d: %v",
+				s.ServerIdentity(), index, err)
+			return
+		}
+	}
+}
+
+// isBodyPruned returns true if the node removed the body of the block.
+func (s *Service) isBodyPruned(sb *skipchain.SkipBlock) bool {
+	if sb.Index == 0 || len(sb.Payload) > 0 {
+		return false
+	}
+	below, err := s.pruning.getPrunedBelow(sb.SkipChainID())
+	if err != nil {
+		log.Errorf("%s: couldn't read pruning index: %v", s.ServerIdentity(), err)
+		return true
+	}
+	return sb.Index < below
+}
+
+// decodeBlockBody returns the body of the block, or ErrorBodyPruned if the
+// node removed it.
+func (s *Service) decodeBlockBody(sb *skipchain.SkipBlock) (*DataBody, error) {
+	if s.isBodyPruned(sb) {
+		return nil, ErrorBodyPruned
+	}
+	var body DataBody
+	if err := protobuf.Decode(sb.Payload, &body); err != nil {
+		return nil, xerrors.Errorf("decoding body: %v", err)
+	}
+	return &body, nil
+}
+
+// GetNodeRole returns the role of the node, so that a client can find a
+// node keeping the bodies of old blocks.
+func (s *Service) GetNodeRole(req *GetNodeRole) (*GetNodeRoleResponse, error) {
+	role, window := s.getNodeRole()
+	s.storage.Lock()
+	counter := s.storage.RoleCounter
+	s.storage.Unlock()
+	return &GetNodeRoleResponse{
+		Role:          role,
+		PruningWindow: window,
+		Counter:       counter,
+	}, nil
+}
@@ -0,0 +1,105 @@
+package byzcoin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/kyber/v3/sign/schnorr"
+	"golang.org/x/xerrors"
+)
+
+func TestService_PruneBodies(t *testing.T) {
+	interval, downloadAll := snapshotInterval, catchupDownloadAll
+	defer func() {
+		snapshotInterval, catchupDownloadAll = interval, downloadAll
+	}()
+	snapshotInterval = 2
+	catchupDownloadAll = 2
+
+	b := newBCT(t, nil)
+	b.CreateByzCoin()
+	defer b.CloseAll()
+
+	s := b.Services[0]
+	require.Error(t, s.SetNodeRole(NodeRoleFull, 1))
+	require.Error(t, s.SetNodeRole(NodeRole(42), 2))
+	require.NoError(t, s.SetNodeRole(NodeRoleFull, 2))
+	role, err := s.GetNodeRole(&GetNodeRole{})
+	require.NoError(t, err)
+	require.Equal(t, NodeRoleFull, role.Role)
+	require.Equal(t, 2, role.PruningWindow)
+
+	for i := 0; i < 5; i++ {
+		b.SpawnDummy(nil)
+	}
+
+	getBlock := func(s *Service, index int) *skipchain.SkipBlock {
+		reply, err := s.skService().GetSingleBlockByIndex(
+			&skipchain.GetSingleBlockByIndex{
+				Genesis: b.Genesis.SkipChainID(),
+				Index:   index,
+			})
+		require.NoError(t, err)
+		return reply.SkipBlock
+	}
+
+	// The genesis block and the blocks of the window keep their body.
+	require.NotNil(t, getBlock(s, 0).Payload)
+	require.NotNil(t, getBlock(s, 4).Payload)
+	require.NotNil(t, getBlock(s, 5).Payload)
+
+	sb := getBlock(s, 1)
+	require.Nil(t, sb.Payload)
+	require.True(t, s.isBodyPruned(sb))
+	_, _, err = s.getBlockTx(sb.Hash)
+	require.True(t, xerrors.Is(err, ErrorBodyPruned))
+
+	// The headers are kept, so the chain can still be verified.
+	links, latest, err := forwardLinks(s.db(), b.Genesis.SkipChainID(), 5)
+	require.NoError(t, err)
+	require.NoError(t, verifyForwardLinks(links, b.Genesis.SkipChainID(), latest))
+
+	// An archive node still has all the bodies.
+	txs, _, err := b.Services[1].getBlockTx(sb.Hash)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(txs))
+
+	// Only the blocks up to the latest snapshot are pruned.
+	snap, err := s.snapshotStorage.getManifest(b.Genesis.SkipChainID())
+	require.NoError(t, err)
+	require.NotNil(t, snap)
+	below, err := s.pruning.getPrunedBelow(b.Genesis.SkipChainID())
+	require.NoError(t, err)
+	require.True(t, below <= snap.Index+1)
+
+	// The operator of the conode changes the role with a signed request.
+	sig, err := schnorr.Sign(cothority.Suite, s.getPrivateKey(),
+		NodeRoleMessage(NodeRoleArchive, 0, 1))
+	require.NoError(t, err)
+	_, err = s.ChangeNodeRole(&ChangeNodeRole{Role: NodeRoleArchive,
+		PruningWindow: 3, Counter: 1, Signature: sig})
+	require.Error(t, err)
+	role, err = s.ChangeNodeRole(&ChangeNodeRole{Role: NodeRoleArchive,
+		Counter: 1, Signature: sig})
+	require.NoError(t, err)
+	require.Equal(t, NodeRoleArchive, role.Role)
+	require.Equal(t, uint64(1), role.Counter)
+
+	// The same request cannot be replayed.
+	_, err = s.ChangeNodeRole(&ChangeNodeRole{Role: NodeRoleArchive,
+		Counter: 1, Signature: sig})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "counter")
+}
+
+func TestParseNodeRole(t *testing.T) {
+	for _, r := range []NodeRole{NodeRoleArchive, NodeRoleFull} {
+		role, err := ParseNodeRole(r.String())
+		require.NoError(t, err)
+		require.Equal(t, r, role)
+	}
+	_, err := ParseNodeRole("unknown")
+	require.Error(t, err)
+}
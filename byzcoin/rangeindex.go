@@ -0,0 +1,473 @@
+package byzcoin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin/trie"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/protobuf"
+	bbolt "go.etcd.io/bbolt"
+	"golang.org/x/xerrors"
+)
+
+// maxRangeProofKeys is the maximum number of instances in the proof returned
+// by GetRangeProof.
+var maxRangeProofKeys = 1000
+
+// The metadata of the range index of a chain: the index of the block of the
+// indexed state and, while the index is rebuilt, the rebuilding key.
+var (
+	rangeIndexKeyHeight     = []byte("height")
+	rangeIndexKeyRebuilding = []byte("rebuilding")
+)
+
+// rangeIndexStorage keeps the range index of every skipchain: a trie holding
+// the same instances as the state trie, but whose keys are the instance IDs
+// themselves instead of their hash. So the instances of a range of IDs are in
+// the same subtrees, and the proof of a range is small. As the trie only
+// depends on the instances, its root is the same on all the nodes and is
+// stored in the header of the blocks. Like the instance index, it is rebuilt
+// from the state trie in the background when it doesn't follow the trie.
+type rangeIndexStorage struct {
+	getBucket func(name []byte) (*bbolt.DB, []byte)
+	// tries holds the index of every chain that has been loaded.
+	tries map[string]*trie.Trie
+	// rebuilding holds the chains whose index is being rebuilt.
+	rebuilding map[string]bool
+	sync.Mutex
+}
+
+func newRangeIndexStorage(c *onet.Context) *rangeIndexStorage {
+	return &rangeIndexStorage{
+		getBucket:  c.GetAdditionalBucket,
+		tries:      make(map[string]*trie.Trie),
+		rebuilding: make(map[string]bool),
+	}
+}
+
+func (s *rangeIndexStorage) bucketName(sid skipchain.SkipBlockID) []byte {
+	return []byte(fmt.Sprintf("%x-range", sid))
+}
+
+// getTrie returns the index of the chain, or nil if there is none.
+func (s *rangeIndexStorage) getTrie(sid skipchain.SkipBlockID) *trie.Trie {
+	s.Lock()
+	defer s.Unlock()
+	if t := s.tries[string(sid)]; t != nil {
+		return t
+	}
+	db, name := s.getBucket(s.bucketName(sid))
+	// A missing or broken index is created again by resetRebuild.
+	t, err := trie.LoadTrieWithoutHashedKeys(trie.NewDiskDB(db, name))
+	if err != nil {
+		return nil
+	}
+	s.tries[string(sid)] = t
+	return t
+}
+
+// newTrie replaces the index of the chain with an empty one, whose nonce is
+// the one of the state trie.
+func (s *rangeIndexStorage) newTrie(sid skipchain.SkipBlockID,
+	nonce []byte) (*trie.Trie, error) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.tries, string(sid))
+	db, name := s.getBucket(s.bucketName(sid))
+	err := db.Update(func(tx *bbolt.Tx) error {
+		if err := tx.DeleteBucket(name); err != nil {
+			return xerrors.Errorf("deleting bucket: %v", err)
+		}
+		_, err := tx.CreateBucket(name)
+		return cothority.ErrorOrNil(err, "creating bucket")
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("tx error: %v", err)
+	}
+	t, err := trie.NewTrieWithoutHashedKeys(trie.NewDiskDB(db, name), nonce)
+	if err != nil {
+		return nil, xerrors.Errorf("creating trie: %v", err)
+	}
+	s.tries[string(sid)] = t
+	return t, nil
+}
+
+// getRangeIndexHeight returns the index of the block whose state is indexed,
+// or -1 if it is unknown.
+func getRangeIndexHeight(t *trie.Trie, b trie.Bucket) int {
+	buf := t.GetMetadataWithBucket(rangeIndexKeyHeight, b)
+	if len(buf) != 8 {
+		return -1
+	}
+	return int(binary.LittleEndian.Uint64(buf))
+}
+
+func putRangeIndexHeight(t *trie.Trie, b trie.Bucket, index int) error {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, uint64(index))
+	return t.SetMetadataWithBucket(rangeIndexKeyHeight, buf, b)
+}
+
+// rangeIndexPairs returns the state changes that touch the trie. The keys of
+// the index must all have the length of an instance ID.
+func rangeIndexPairs(scs StateChanges) []trie.KVPair {
+	var pairs []trie.KVPair
+	for i := range scs {
+		if scs[i].Op() == trie.Nop || len(scs[i].InstanceID) != len(InstanceID{}) {
+			continue
+		}
+		pairs = append(pairs, &scs[i])
+	}
+	return pairs
+}
+
+// update applies the state changes of the block with the given index. The
+// index of a new chain is created with the genesis block. If the index doesn't
+// hold the state of the previous block, nothing is written and errIndexStale
+// is returned. The changes are also applied while the index is rebuilt, so
+// that it follows the trie.
+func (s *rangeIndexStorage) update(sid skipchain.SkipBlockID, nonce []byte,
+	index int, scs StateChanges) error {
+	t := s.getTrie(sid)
+	if t == nil {
+		if index != 0 {
+			return errIndexStale
+		}
+		var err error
+		t, err = s.newTrie(sid, nonce)
+		if err != nil {
+			return xerrors.Errorf("creating index: %v", err)
+		}
+	}
+	err := t.DB().Update(func(b trie.Bucket) error {
+		if getRangeIndexHeight(t, b) != index-1 {
+			return errIndexStale
+		}
+		if err := t.BatchWithBucket(rangeIndexPairs(scs), b); err != nil {
+			return xerrors.Errorf("indexing instances: %v", err)
+		}
+		return cothority.ErrorOrNil(putRangeIndexHeight(t, b, index),
+			"writing height")
+	})
+	if err == errIndexStale {
+		return err
+	}
+	return cothority.ErrorOrNil(err, "tx error")
+}
+
+// nextRoot returns the root of the index after the state changes of the block
+// with the given index, or nil if the index isn't ready at the previous
+// block.
+func (s *rangeIndexStorage) nextRoot(sid skipchain.SkipBlockID, index int,
+	scs StateChanges) []byte {
+	t := s.getTrie(sid)
+	if t == nil {
+		return nil
+	}
+	var root []byte
+	err := t.DB().UpdateDryRun(func(b trie.Bucket) error {
+		if getRangeIndexHeight(t, b) != index-1 ||
+			t.GetMetadataWithBucket(rangeIndexKeyRebuilding, b) != nil {
+			return nil
+		}
+		if err := t.BatchWithBucket(rangeIndexPairs(scs), b); err != nil {
+			return xerrors.Errorf("indexing instances: %v", err)
+		}
+		root = append([]byte{}, t.GetRootWithBucket(b)...)
+		return nil
+	})
+	if err != nil {
+		log.Errorf("couldn't compute the root of the range index: %v", err)
+		return nil
+	}
+	return root
+}
+
+// isReady returns whether the index of the chain holds the state of the
+// block with the given index.
+func (s *rangeIndexStorage) isReady(sid skipchain.SkipBlockID, index int) bool {
+	t := s.getTrie(sid)
+	if t == nil {
+		return false
+	}
+	ready := false
+	err := t.DB().View(func(b trie.Bucket) error {
+		ready = getRangeIndexHeight(t, b) == index &&
+			t.GetMetadataWithBucket(rangeIndexKeyRebuilding, b) == nil
+		return nil
+	})
+	return err == nil && ready
+}
+
+func (s *rangeIndexStorage) startRebuild(sid skipchain.SkipBlockID) bool {
+	s.Lock()
+	defer s.Unlock()
+	if s.rebuilding[string(sid)] {
+		return false
+	}
+	s.rebuilding[string(sid)] = true
+	return true
+}
+
+func (s *rangeIndexStorage) finishRebuild(sid skipchain.SkipBlockID) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.rebuilding, string(sid))
+}
+
+func (s *rangeIndexStorage) resetRebuild(sid skipchain.SkipBlockID,
+	st *stateTrie) error {
+	nonce, err := st.GetNonce()
+	if err != nil {
+		return xerrors.Errorf("getting nonce: %v", err)
+	}
+	t, err := s.newTrie(sid, nonce)
+	if err != nil {
+		return xerrors.Errorf("creating index: %v", err)
+	}
+	err = t.DB().Update(func(b trie.Bucket) error {
+		err := t.SetMetadataWithBucket(rangeIndexKeyRebuilding, []byte{}, b)
+		if err != nil {
+			return xerrors.Errorf("writing item: %v", err)
+		}
+		return cothority.ErrorOrNil(putRangeIndexHeight(t, b, st.GetIndex()),
+			"writing height")
+	})
+	return cothority.ErrorOrNil(err, "tx error")
+}
+
+// rebuildBatch copies at most maxIndexBatch instances of the trie after the
+// given key into the index, as the instance index does.
+func (s *rangeIndexStorage) rebuildBatch(sid skipchain.SkipBlockID,
+	st *stateTrie, after []byte) ([]byte, error) {
+	var pairs []trie.KVPair
+	last, err := st.ForEachAfter(after, maxIndexBatch, func(k, v []byte) error {
+		if len(k) == len(InstanceID{}) {
+			pairs = append(pairs, rangeIndexPair{
+				key:   append([]byte{}, k...),
+				value: append([]byte{}, v...),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("reading trie: %v", err)
+	}
+
+	t := s.getTrie(sid)
+	if t == nil {
+		return nil, errIndexStale
+	}
+	err = t.DB().Update(func(b trie.Bucket) error {
+		if getRangeIndexHeight(t, b) != st.GetIndex() ||
+			t.GetMetadataWithBucket(rangeIndexKeyRebuilding, b) == nil {
+			return errIndexStale
+		}
+		if err := t.BatchWithBucket(pairs, b); err != nil {
+			return xerrors.Errorf("indexing instances: %v", err)
+		}
+		if last == nil {
+			return cothority.ErrorOrNil(
+				t.DeleteMetadataWithBucket(rangeIndexKeyRebuilding, b),
+				"deleting item")
+		}
+		return nil
+	})
+	if err == errIndexStale {
+		return nil, err
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("tx error: %v", err)
+	}
+	return last, nil
+}
+
+// rangeIndexPair is an instance of the state trie copied into the index.
+type rangeIndexPair struct {
+	key   []byte
+	value []byte
+}
+
+func (p rangeIndexPair) Op() trie.OpType {
+	return trie.OpSet
+}
+
+func (p rangeIndexPair) Key() []byte {
+	return p.key
+}
+
+func (p rangeIndexPair) Val() []byte {
+	return p.value
+}
+
+// updateRangeIndex applies the state changes of the block to the range
+// index. If the index is ready, its root should be the one of the block, as
+// the roster checks it.
+func (s *Service) updateRangeIndex(sb *skipchain.SkipBlock, header *DataHeader,
+	st *stateTrie, scs StateChanges) error {
+	nonce, err := st.GetNonce()
+	if err != nil {
+		return xerrors.Errorf("getting nonce: %v", err)
+	}
+	err = s.rangeIndex.update(sb.SkipChainID(), nonce, sb.Index, scs)
+	if err == errIndexStale {
+		return err
+	}
+	if err != nil {
+		return xerrors.Errorf("updating index: %v", err)
+	}
+	if len(header.IndexRoot) > 0 && s.rangeIndex.isReady(sb.SkipChainID(), sb.Index) &&
+		!bytes.Equal(header.IndexRoot, s.rangeIndex.getTrie(sb.SkipChainID()).GetRoot()) {
+		log.Warnf("%s the range index of %x differs from block %d",
+			s.ServerIdentity(), sb.SkipChainID(), sb.Index)
+	}
+	return nil
+}
+
+// rebuildRangeIndex rebuilds the range index of the chain in the background.
+func (s *Service) rebuildRangeIndex(sid skipchain.SkipBlockID) {
+	s.rebuildIndex("range index", s.rangeIndex, sid)
+}
+
+// VerifyFromBlock takes a skipchain block and verifies that the proof is
+// valid for this block, like Proof.VerifyFromBlock.
+func (p RangeProof) VerifyFromBlock(verifiedBlock *skipchain.SkipBlock) error {
+	if len(p.Links) > 0 {
+		p.Links[0].NewRoster = verifiedBlock.Roster
+	}
+	err := p.Verify(verifiedBlock.Hash)
+	return cothority.ErrorOrNil(err, "verification failed")
+}
+
+// Verify takes a skipchain id and verifies that the proof is valid for this
+// skipchain: the proof must be of a trie whose keys are not hashed, with the
+// root of the range index of the latest block. It does not verify that the
+// proof holds a range, which is done by Range.
+func (p RangeProof) Verify(sbID skipchain.SkipBlockID) error {
+	if !p.IndexProof.NoHashKey {
+		return xerrors.New("the proof is not of the range index")
+	}
+	var header DataHeader
+	err := protobuf.Decode(p.Latest.Data, &header)
+	if err != nil {
+		return xerrors.Errorf("decoding header: %v", err)
+	}
+	if len(header.IndexRoot) == 0 ||
+		!bytes.Equal(p.IndexProof.GetRoot(), header.IndexRoot) {
+		return xerrors.New("root of the range index is not in skipblock")
+	}
+
+	return verifyForwardLinks(p.Links, sbID, &p.Latest)
+}
+
+// Range verifies that the proof holds all the instances whose ID is in the
+// range [start, end) and returns them in the order of their IDs. If end is
+// empty, the range has no upper bound. The proof itself must be verified
+// with Verify or VerifyFromBlock first.
+func (p RangeProof) Range(start, end []byte) ([]InstanceID,
+	[]StateChangeBody, error) {
+	if len(end) == 0 {
+		end = nil
+	}
+	keys, values, err := p.IndexProof.Range(start, end)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("incomplete proof: %v", err)
+	}
+	ids := make([]InstanceID, len(keys))
+	bodies := make([]StateChangeBody, len(keys))
+	for i := range keys {
+		if len(keys[i]) != len(InstanceID{}) {
+			return nil, nil, xerrors.Errorf("invalid instance ID %x", keys[i])
+		}
+		ids[i] = NewInstanceID(keys[i])
+		bodies[i], err = decodeStateChangeBody(values[i])
+		if err != nil {
+			return nil, nil, xerrors.Errorf("decoding body: %v", err)
+		}
+	}
+	return ids, bodies, nil
+}
+
+// GetRangeProof returns a proof of all the instances whose ID is in the
+// range of the request, which can be used together with the genesis block to
+// show that no instance of the range has been left out.
+func (s *Service) GetRangeProof(req *GetRangeProof) (*GetRangeProofResponse, error) {
+	s.updateTrieMutex.Lock()
+	defer s.updateTrieMutex.Unlock()
+
+	if !s.tasks.areTasksAllowed() {
+		return nil, xerrors.New("cannot get range proof while in closed state")
+	}
+
+	sb := s.db().GetByID(req.ID)
+	if sb == nil {
+		return nil, xerrors.New("cannot find skipblock while getting range proof")
+	}
+	st, err := s.getStateTrie(sb.SkipChainID())
+	if err != nil {
+		return nil, xerrors.Errorf("getting state trie: %w", err)
+	}
+	// The index of a chain bootstrapped from a snapshot, or of a chain
+	// known before the range index, is built in the background.
+	if !s.rangeIndex.isReady(sb.SkipChainID(), st.GetIndex()) {
+		s.rebuildRangeIndex(sb.SkipChainID())
+		return nil, xerrors.New("the range index is being built, " +
+			"try again later")
+	}
+	end := req.End
+	if len(end) == 0 {
+		end = nil
+	}
+	pr, err := s.rangeIndex.getTrie(sb.SkipChainID()).GetRangeProofWithLimit(
+		req.Start, end, maxRangeProofKeys)
+	if err == trie.ErrTooManyLeaves {
+		return nil, xerrors.Errorf("proof is too big: more than %d instances",
+			maxRangeProofKeys)
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("couldn't get proof: %+v", err)
+	}
+
+	proof := &RangeProof{IndexProof: *pr}
+	var latest *skipchain.SkipBlock
+	proof.Links, latest, err = forwardLinks(s.db(), req.ID, st.GetIndex())
+	if err != nil {
+		return nil, xerrors.Errorf("getting forward links: %w", err)
+	}
+	if st.GetIndex() != latest.Index {
+		return nil, xerrors.New("didn't find skipblock with same index as state-trie")
+	}
+	proof.Latest = *latest
+	header, err := decodeBlockHeader(latest)
+	if err != nil {
+		return nil, xerrors.Errorf("decoding header: %v", err)
+	}
+	// The block has no root if its leader had no range index, or a root
+	// that differs from ours if the roster accepted a wrong one.
+	if !bytes.Equal(header.IndexRoot, pr.GetRoot()) {
+		return nil, xerrors.New("the latest block doesn't hold the root " +
+			"of our range index, try again later")
+	}
+
+	if len(req.MustContainBlock) > 0 {
+		mcb := s.db().GetByID(req.MustContainBlock)
+		// As in GetProof, the proof is returned with the latest known block.
+		if mcb == nil || proof.Latest.Index < mcb.Index {
+			return nil, xerrors.New("must contain clause cannot be enforced")
+		}
+	}
+
+	log.Lvlf2("%s: Returning range proof for [%x, %x) from chain %x at index %v",
+		s.ServerIdentity(), req.Start, req.End, sb.SkipChainID(),
+		proof.Latest.Index)
+	return &GetRangeProofResponse{
+		Version: CurrentVersion,
+		Proof:   *proof,
+	}, nil
+}
@@ -0,0 +1,84 @@
+package byzcoin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3/byzcoin/trie"
+)
+
+func TestService_GetRangeProof(t *testing.T) {
+	b := newBCTRun(t, nil)
+	defer b.CloseAll()
+
+	ctx, _ := b.SpawnDummy(nil)
+	id := NewInstanceID(ctx.Instructions[0].Hash())
+	ctx, _ = b.SpawnDummy(nil)
+	removed := NewInstanceID(ctx.Instructions[0].Hash())
+	b.SendInst(nil, Instruction{
+		InstanceID: removed,
+		Delete:     &Delete{ContractID: DummyContractName},
+	})
+
+	rep, err := b.Client.GetPrefixProof(id[:2])
+	require.NoError(t, err)
+	require.True(t, rep.Proof.IndexProof.NoHashKey)
+	ids, bodies, err := rep.Proof.Range(id[:2], trie.PrefixEnd(id[:2]))
+	require.NoError(t, err)
+	require.Contains(t, ids, id)
+	for i := range ids {
+		require.Equal(t, id[:2], ids[i][:2])
+		if ids[i] == id {
+			require.Equal(t, DummyContractName, string(bodies[i].ContractID))
+		}
+	}
+
+	rep, err = b.Client.GetRangeProof(nil, nil)
+	require.NoError(t, err)
+	ids, _, err = rep.Proof.Range(nil, nil)
+	require.NoError(t, err)
+	require.Contains(t, ids, ConfigInstanceID)
+	require.Contains(t, ids, id)
+	require.NotContains(t, ids, removed)
+
+	// An incomplete proof is refused, and so is a proof of a trie with
+	// hashed keys.
+	pr := rep.Proof
+	pr.IndexProof.Leaves = pr.IndexProof.Leaves[1:]
+	_, _, err = pr.Range(nil, nil)
+	require.Error(t, err)
+	pr = rep.Proof
+	pr.IndexProof.NoHashKey = false
+	require.Error(t, pr.VerifyFromBlock(b.Genesis))
+
+	// The index is rebuilt in the background if it is lost, and has the
+	// same root as the index of the other nodes.
+	s := b.Services[0]
+	st, err := s.getStateTrie(b.Genesis.SkipChainID())
+	require.NoError(t, err)
+	nonce, err := st.GetNonce()
+	require.NoError(t, err)
+	_, err = s.rangeIndex.newTrie(b.Genesis.SkipChainID(), nonce)
+	require.NoError(t, err)
+	req := &GetRangeProof{
+		Version: CurrentVersion,
+		ID:      b.Genesis.SkipChainID(),
+	}
+	_, err = s.GetRangeProof(req)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "being built")
+	for i := 0; i < 50 && !s.rangeIndex.isReady(b.Genesis.SkipChainID(),
+		st.GetIndex()); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	b.SpawnDummy(nil)
+	resp, err := s.GetRangeProof(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Proof.VerifyFromBlock(b.Genesis))
+
+	defer func(old int) { maxRangeProofKeys = old }(maxRangeProofKeys)
+	maxRangeProofKeys = 1
+	_, err = b.Client.GetRangeProof(nil, nil)
+	require.Error(t, err)
+}
@@ -0,0 +1,189 @@
+package byzcoin
+
+import (
+	"bytes"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/protobuf"
+	bbolt "go.etcd.io/bbolt"
+	"golang.org/x/xerrors"
+)
+
+var bucketTxReceipts = []byte("txreceipts")
+
+// ErrorTxNotFound is returned when no receipt is known for a transaction.
+var ErrorTxNotFound = xerrors.New("transaction not found")
+
+// txReceiptStorage stores the TxReceipts of every skipchain in its own
+// bucket, using the hash of the instructions as the key.
+type txReceiptStorage struct {
+	db     *bbolt.DB
+	bucket []byte
+}
+
+func newTxReceiptStorage(c *onet.Context) *txReceiptStorage {
+	db, name := c.GetAdditionalBucket(bucketTxReceipts)
+	return &txReceiptStorage{
+		db:     db,
+		bucket: name,
+	}
+}
+
+// store adds the receipts of the transactions of the block. The receipt of
+// an accepted transaction is never replaced, as sending the same transaction
+// again can only be refused.
+func (s *txReceiptStorage) store(sb *skipchain.SkipBlock,
+	receipts map[string]TxReceipt) error {
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.Bucket(s.bucket).CreateBucketIfNotExists(sb.SkipChainID())
+		if err != nil {
+			return xerrors.Errorf("creating bucket: %v", err)
+		}
+		for key, receipt := range receipts {
+			if buf := b.Get([]byte(key)); buf != nil {
+				var old TxReceipt
+				if err := protobuf.Decode(buf, &old); err != nil {
+					return xerrors.Errorf("decoding: %v", err)
+				}
+				if old.Accepted {
+					continue
+				}
+			}
+			buf, err := protobuf.Encode(&receipt)
+			if err != nil {
+				return xerrors.Errorf("encoding: %v", err)
+			}
+			if err := b.Put([]byte(key), buf); err != nil {
+				return xerrors.Errorf("writing item: %v", err)
+			}
+		}
+		return nil
+	})
+	return cothority.ErrorOrNil(err, "tx error")
+}
+
+// get returns the receipt of the transaction, or nil if it is not known.
+func (s *txReceiptStorage) get(sid skipchain.SkipBlockID,
+	txHash []byte) (receipt *TxReceipt, err error) {
+	err = s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket).Bucket(sid)
+		if b == nil {
+			return nil
+		}
+		buf := b.Get(txHash)
+		if buf == nil {
+			return nil
+		}
+		receipt = &TxReceipt{}
+		return cothority.ErrorOrNil(protobuf.Decode(buf, receipt), "decoding")
+	})
+	err = cothority.ErrorOrNil(err, "tx error")
+	return
+}
+
+// newTxReceipts creates the receipts of the transactions of the block, using
+// the hash of the instructions as the key. The error of a refused
+// transaction is only available if this node recorded it.
+func (s *Service) newTxReceipts(sb *skipchain.SkipBlock,
+	txs TxResults) map[string]TxReceipt {
+	receipts := make(map[string]TxReceipt)
+	for i, tx := range txs {
+		receipt := TxReceipt{
+			BlockID:      sb.Hash,
+			BlockIndex:   sb.Index,
+			Position:     i,
+			Accepted:     tx.Accepted,
+			StateChanges: tx.stateChanges,
+		}
+		if !tx.Accepted {
+			receipt.Error, _ = s.txErrorBuf.get(
+				tx.ClientTransaction.Instructions.HashWithSignatures())
+		}
+		key := string(tx.ClientTransaction.Instructions.Hash())
+		// A transaction might be twice in the same block if it has been
+		// refused the first time.
+		if old, ok := receipts[key]; ok && old.Accepted {
+			continue
+		}
+		receipts[key] = receipt
+	}
+	return receipts
+}
+
+// GetTxReceipt returns the receipt of a transaction, together with the
+// block holding it and the forward links from the genesis block.
+func (s *Service) GetTxReceipt(req *GetTxReceipt) (*GetTxReceiptResponse, error) {
+	receipt, err := s.txReceipts.get(req.SkipchainID, req.TxHash)
+	if err != nil {
+		return nil, xerrors.Errorf("reading receipt: %v", err)
+	}
+	if receipt == nil {
+		return nil, ErrorTxNotFound
+	}
+	sb := s.db().GetByID(receipt.BlockID)
+	if sb == nil {
+		return nil, xerrors.New("couldn't find the block of the receipt")
+	}
+	if s.isBodyPruned(sb) {
+		return nil, ErrorBodyPruned
+	}
+	links, latest, err := forwardLinks(s.db(), req.SkipchainID, sb.Index)
+	if err != nil {
+		return nil, xerrors.Errorf("getting forward links: %v", err)
+	}
+	if !latest.Hash.Equal(sb.Hash) {
+		return nil, xerrors.New("didn't find a path to the block of the receipt")
+	}
+	return &GetTxReceiptResponse{
+		Version: CurrentVersion,
+		Receipt: *receipt,
+		Block:   *sb,
+		Links:   links,
+	}, nil
+}
+
+// Verify checks that the transaction with the given hash is at the position
+// given by the receipt, in a block of the chain starting with the genesis
+// block, and that its status is the same as in the receipt. The error and
+// the state changes of the receipt are not covered by the block, so they
+// need to be trusted.
+func (r GetTxReceiptResponse) Verify(genesis *skipchain.SkipBlock,
+	txHash []byte) error {
+	if len(r.Links) > 0 {
+		r.Links[0].NewRoster = genesis.Roster
+	}
+	if err := verifyForwardLinks(r.Links, genesis.Hash, &r.Block); err != nil {
+		return xerrors.Errorf("verifying links: %w", err)
+	}
+	if !r.Block.Hash.Equal(r.Receipt.BlockID) ||
+		r.Block.Index != r.Receipt.BlockIndex {
+		return xerrors.New("receipt points to another block")
+	}
+
+	var header DataHeader
+	if err := protobuf.Decode(r.Block.Data, &header); err != nil {
+		return xerrors.Errorf("decoding header: %v", err)
+	}
+	var body DataBody
+	if err := protobuf.Decode(r.Block.Payload, &body); err != nil {
+		return xerrors.Errorf("decoding body: %v", err)
+	}
+	body.TxResults.SetVersion(header.Version)
+	if !bytes.Equal(header.ClientTransactionHash, body.TxResults.Hash()) {
+		return xerrors.New("client transaction hash does not match")
+	}
+
+	if r.Receipt.Position < 0 || r.Receipt.Position >= len(body.TxResults) {
+		return xerrors.New("position of the transaction is out of the block")
+	}
+	tx := body.TxResults[r.Receipt.Position]
+	if !bytes.Equal(tx.ClientTransaction.Instructions.Hash(), txHash) {
+		return xerrors.New("wrong transaction at the position of the receipt")
+	}
+	if tx.Accepted != r.Receipt.Accepted {
+		return xerrors.New("status of the receipt doesn't match the block")
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package byzcoin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func TestService_GetTxReceipt(t *testing.T) {
+	b := newBCTRun(t, nil)
+	defer b.CloseAll()
+
+	ctx, _ := b.SpawnDummy(nil)
+	txHash := ctx.Instructions.Hash()
+	resp, err := b.Client.GetTxReceipt(txHash)
+	require.NoError(t, err)
+	require.True(t, resp.Receipt.Accepted)
+	require.Empty(t, resp.Receipt.Error)
+	require.Equal(t, 1, resp.Receipt.BlockIndex)
+	require.Equal(t, 0, resp.Receipt.Position)
+	require.NotEmpty(t, resp.Receipt.StateChanges)
+
+	// A refused transaction also has a receipt.
+	txArgs := TxArgsDefault
+	txArgs.RequireSuccess = false
+	ctxRefused, addResp := b.SendInst(&txArgs, Instruction{
+		InstanceID: NewInstanceID([]byte("unknown")),
+		Invoke: &Invoke{
+			ContractID: DummyContractName,
+			Command:    "update",
+		},
+	})
+	require.NotEmpty(t, addResp.Error)
+	b.SignerCounter--
+	refusedHash := ctxRefused.Instructions.Hash()
+	resp, err = b.Client.GetTxReceipt(refusedHash)
+	require.NoError(t, err)
+	require.False(t, resp.Receipt.Accepted)
+	require.NotEmpty(t, resp.Receipt.Error)
+	require.Empty(t, resp.Receipt.StateChanges)
+
+	// The receipt must match the block.
+	require.NoError(t, resp.Verify(b.Genesis, refusedHash))
+	require.Error(t, resp.Verify(b.Genesis, txHash))
+	resp.Receipt.Accepted = true
+	require.Error(t, resp.Verify(b.Genesis, refusedHash))
+	resp.Receipt.Accepted = false
+	resp.Receipt.Position++
+	require.Error(t, resp.Verify(b.Genesis, refusedHash))
+
+	_, err = b.Services[0].GetTxReceipt(&GetTxReceipt{
+		SkipchainID: b.Genesis.SkipChainID(),
+		TxHash:      []byte("unknown"),
+	})
+	require.True(t, xerrors.Is(err, ErrorTxNotFound))
+}
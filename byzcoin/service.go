@@ -61,6 +61,8 @@ const noTimeout time.Duration = 0
 
 const viewChangeSubFtCosi = "viewchange_sub_ftcosi"
 const viewChangeFtCosi = "viewchange_ftcosi"
+const snapshotSubFtCosi = "snapshot_sub_ftcosi"
+const snapshotFtCosi = "snapshot_ftcosi"
 
 var viewChangeMsgID network.MessageTypeID
 
@@ -97,6 +99,14 @@ func init() {
 	if err != nil {
 		panic(err)
 	}
+	err = RegisterGlobalContract(ContractSchedulerID, contractSchedulerFromBytes)
+	if err != nil {
+		panic(err)
+	}
+	err = RegisterGlobalContract(ContractEvidenceID, contractEvidenceFromBytes)
+	if err != nil {
+		panic(err)
+	}
 }
 
 // GenNonce returns a random nonce.
@@ -117,6 +127,16 @@ type Service struct {
 	// We need to store the state changes for keeping track
 	// of the history of an instance
 	stateChangeStorage *stateChangeStorage
+	// txReceipts indexes the transactions by the hash of their instructions
+	txReceipts *txReceiptStorage
+	// instanceIndex indexes the instances by contract ID and by darc ID
+	instanceIndex *instanceIndexStorage
+	// rangeIndex holds the instances in a trie whose keys are not hashed
+	rangeIndex *rangeIndexStorage
+	// snapshotStorage keeps the latest snapshot of the state tries
+	snapshotStorage *snapshotStorage
+	// pruning keeps track of the blocks whose body has been removed
+	pruning *pruningStorage
 	// notifications is used for client transaction and block notification
 	notifications bcNotifications
 
@@ -126,6 +146,11 @@ type Service struct {
 	stopTxPipelineMut sync.Mutex
 	stopTxPipelineWG  sync.WaitGroup
 
+	// observers maintains a map of channels that can be used to stop
+	// following an observed chain.
+	observers    map[string]chan bool
+	observersMut sync.Mutex
+
 	txPipelinesMutex sync.Mutex
 	txPipeline       map[string]*txPipeline
 
@@ -133,6 +158,9 @@ type Service struct {
 	contracts *contractRegistry
 
 	storage *bcStorage
+	// nodeRoleMutex makes sure that the counter of each ChangeNodeRole
+	// request is only used once.
+	nodeRoleMutex sync.Mutex
 
 	createSkipChainMut sync.Mutex
 
@@ -143,6 +171,10 @@ type Service struct {
 
 	tasks         tasksWG
 	viewChangeMan viewChangeManager
+	// evidence holds the conflicting view-change requests of the nodes
+	evidence *evidenceCollector
+	// viewChanges keeps the records of the view-changes of the chains
+	viewChanges *viewChangeStorage
 
 	streamingMan streamingManager
 
@@ -161,6 +193,11 @@ type Service struct {
 	// ByzCoin chains.
 	defaultVersion      Version
 	defaultVersionMutex sync.Mutex
+
+	// parallelWorkers is the number of transactions executed in parallel
+	// by createStateChanges.
+	parallelWorkers      int
+	parallelWorkersMutex sync.Mutex
 }
 
 type downloadState struct {
@@ -187,6 +224,16 @@ type bcStorage struct {
 	// PropTimeout is used when sending the request to integrate a new block
 	// to all nodes.
 	PropTimeout time.Duration
+	// Role of the node, which tells which blocks it keeps.
+	Role NodeRole
+	// PruningWindow is the number of recent blocks whose body is kept by a
+	// full node.
+	PruningWindow int
+	// RoleCounter is the counter of the last ChangeNodeRole request.
+	RoleCounter uint64
+	// Observed holds the IDs of the chains followed by the node as an
+	// observer.
+	Observed []skipchain.SkipBlockID
 
 	sync.Mutex
 }
@@ -397,6 +444,9 @@ func (s *Service) AddTransaction(req *AddTxRequest) (*AddTxResponse, error) {
 		log.Warn("Got block, but with an error:", err)
 	}
 	if i, _ := latest.Roster.Search(s.ServerIdentity().ID); i < 0 {
+		if s.isObserving(req.SkipchainID) {
+			return s.forwardTransaction(req, latest.Roster)
+		}
 		return nil, xerrors.New("refusing to accept transaction for a chain we're not part of")
 	}
 
@@ -411,6 +461,12 @@ func (s *Service) AddTransaction(req *AddTxRequest) (*AddTxResponse, error) {
 		return nil, xerrors.New("invalid client version below 2")
 	}
 
+	// Refuse transactions that cannot be included in the next block anymore.
+	err = req.Transaction.checkValidity(latest.Index+1, time.Now().UnixNano())
+	if err != nil {
+		return nil, xerrors.Errorf("refusing transaction: %w", err)
+	}
+
 	// Upgrade the instructions with the byzcoin protocol version
 	// to use the correct hash function.
 	req.Transaction.Instructions.SetVersion(header.Version)
@@ -438,7 +494,7 @@ func (s *Service) AddTransaction(req *AddTxRequest) (*AddTxResponse, error) {
 	// Need to create the hash before sending it to ctxChan,
 	// in case it's the leader.
 	// Else it will race when creating the Hash...
-	ctxHash := req.Transaction.Instructions.Hash()
+	ctxHash := req.Transaction.Hash()
 
 	interval, _, err := s.LoadBlockInfo(req.SkipchainID)
 	if err != nil {
@@ -695,7 +751,13 @@ func (s *Service) GetUpdates(pr *GetUpdatesRequest) (*GetUpdatesReply, error) {
 
 	sendVersion0 := pr.Flags&GUFSendVersion0 > 0
 	reply := &GetUpdatesReply{}
-	reply.Latest, err = s.db().GetLatestByID(scID)
+	// The links go to the block of the trie, so that the proofs can be
+	// verified from the given block or the genesis block.
+	from := pr.LatestBlockID
+	if from.IsNull() {
+		from = scID
+	}
+	reply.Links, reply.Latest, err = forwardLinks(s.db(), from, st.GetIndex())
 	if err != nil {
 		return nil, xerrors.Errorf("couldn't get latest block: %v", err)
 	}
@@ -865,14 +927,13 @@ func (s *Service) CheckStateChangeValidity(req *CheckStateChangeValidity) (*Chec
 		return nil, xerrors.Errorf("getting block: %v", err)
 	}
 
-	sces, err := s.stateChangeStorage.getByBlock(req.SkipChainID, sce.BlockIndex)
+	body, err := s.decodeBlockBody(sb.SkipBlock)
 	if err != nil {
-		return nil, xerrors.Errorf("getting state changes: %v", err)
+		return nil, xerrors.Errorf("getting body: %w", err)
 	}
-
-	scs := make(StateChanges, len(sces))
-	for i, e := range sces {
-		scs[i] = e.StateChange.Copy()
+	scs, err := s.blockStateChanges(sb.SkipBlock, body)
+	if err != nil {
+		return nil, xerrors.Errorf("getting state changes: %w", err)
 	}
 
 	return &CheckStateChangeValidityResponse{
@@ -1143,8 +1204,20 @@ func (s *Service) createNewBlock(scID skipchain.SkipBlockID, r *onet.Roster, tx
 		return nil, xerrors.New("no transactions")
 	}
 
+	events, err := txRes.events()
+	if err != nil {
+		return nil, xerrors.Errorf("getting events: %v", err)
+	}
+
+	// The block doesn't hold the root of the range index if our index is
+	// not ready. The genesis block creates the index.
+	var indexRoot []byte
+	if !scID.IsNull() {
+		indexRoot = s.rangeIndex.nextRoot(scID, sb.Index+1, scs)
+	}
+
 	// Store transactions in the body
-	body := &DataBody{TxResults: txRes}
+	body := &DataBody{TxResults: txRes, Events: events}
 	sb.Payload, err = protobuf.Encode(body)
 	if err != nil {
 		return nil, xerrors.Errorf("Couldn't marshal data: %v", err)
@@ -1156,6 +1229,8 @@ func (s *Service) createNewBlock(scID skipchain.SkipBlockID, r *onet.Roster, tx
 		StateChangesHash:      scs.Hash(),
 		Timestamp:             timestamp,
 		Version:               version,
+		EventsHash:            eventsHash(events),
+		IndexRoot:             indexRoot,
 	}
 	sb.Data, err = protobuf.Encode(header)
 	if err != nil {
@@ -1237,6 +1312,7 @@ func (s *Service) createUpgradeVersionBlock(scID skipchain.SkipBlockID, version
 		StateChangesHash:      scs.Hash(),
 		Timestamp:             timestamp,
 		Version:               version,
+		IndexRoot:             s.rangeIndex.nextRoot(scID, sb.Index+1, scs),
 	})
 	if err != nil {
 		return nil, xerrors.Errorf("Couldn't marshal data: %v", err)
@@ -1281,51 +1357,31 @@ func (s *Service) downloadDB(sb *skipchain.SkipBlock) error {
 			s.stateTriesMutex.Unlock()
 		}
 
-		// Then start downloading the stateTrie over the network.
-		cl := NewClient(sb.SkipChainID(), *sb.Roster)
-		cl.DontContact(s.ServerIdentity())
-		var db *bbolt.DB
-		var bucketName []byte
-		var nonce uint64
-		var cursor int
-		for {
-			// Note: we trust the chain therefore even if the reply is corrupted,
-			// it will be detected by difference in the root hash
-			resp, err := cl.DownloadState(sb.SkipChainID(), nonce, catchupFetchDBEntries)
-			if err != nil {
-				return xerrors.Errorf("cannot download trie: %v", err)
-			}
-			log.Lvlf1("Downloaded key/values %d..%d of %d from %s", cursor, cursor+len(resp.KeyValues), resp.Total,
-				cl.noncesSI[resp.Nonce])
-			cursor += len(resp.KeyValues)
-			if db == nil {
-				db, bucketName = s.GetAdditionalBucket([]byte(idStr))
-				nonce = resp.Nonce
-			}
-			// And store all entries in our local database.
+		// Then start downloading the stateTrie over the network. A snapshot
+		// is signed by the roster and can be verified chunk by chunk, so
+		// it is preferred over the current state of a single node.
+		db, bucketName := s.GetAdditionalBucket([]byte(idStr))
+		st, err := s.downloadSnapshot(sb, db, bucketName)
+		if err != nil {
+			log.Lvlf2("%s: couldn't download a snapshot: %v", s.ServerIdentity(), err)
 			err = db.Update(func(tx *bbolt.Tx) error {
-				bucket := tx.Bucket(bucketName)
-				for _, kv := range resp.KeyValues {
-					err := bucket.Put(kv.Key, kv.Value)
-					if err != nil {
-						return err
-					}
+				if err := tx.DeleteBucket(bucketName); err != nil {
+					return err
 				}
-				return nil
+				_, err := tx.CreateBucket(bucketName)
+				return err
 			})
 			if err != nil {
-				return xerrors.Errorf("couldn't store entries: %v", err)
+				return xerrors.Errorf("couldn't clear the trie: %v", err)
 			}
-			if len(resp.KeyValues) < catchupFetchDBEntries {
-				break
+			st, err = s.downloadCurrentState(sb, db, bucketName)
+			if err != nil {
+				return xerrors.Errorf("cannot download trie: %v", err)
 			}
 		}
 
 		// Check the new trie is correct
-		st, err := loadStateTrie(db, bucketName)
-		if err != nil {
-			return xerrors.Errorf("couldn't load state trie: %v", err)
-		}
+		target := sb.Index
 		skCl := skipchain.NewClient()
 		skCl.DontContact(s.ServerIdentity())
 		if sb.Index != st.GetIndex() {
@@ -1354,9 +1410,27 @@ func (s *Service) downloadDB(sb *skipchain.SkipBlock) error {
 		if err != nil {
 			return xerrors.Errorf("getting chain: %v", err)
 		}
+		// The blocks up to the trie are stored first, so that the blocks
+		// following the trie can be replayed, e.g., after a snapshot.
+		var known *skipchain.SkipBlock
 		for _, sb := range chain.Update {
+			if sb.Index > st.GetIndex() {
+				break
+			}
 			log.Lvlf2("Storing block %d: %x", sb.Index, sb.CalculateHash())
 			s.db().Store(sb)
+			known = sb
+		}
+		if known != nil && st.GetIndex() < target {
+			if _, err := s.fetchBlocks(skCl, sb.Roster, known, target); err != nil {
+				return xerrors.Errorf("replaying blocks: %v", err)
+			}
+		}
+		for _, sb := range chain.Update {
+			if sb.Index > st.GetIndex() {
+				log.Lvlf2("Storing block %d: %x", sb.Index, sb.CalculateHash())
+				s.db().Store(sb)
+			}
 		}
 		log.Lvlf1("%s: successfully downloaded database for chain %s up to block %d/%d", s.ServerIdentity(),
 			idStr, sb.Index, st.GetIndex())
@@ -1369,6 +1443,51 @@ func (s *Service) downloadDB(sb *skipchain.SkipBlock) error {
 	return xerrors.New("none of the non-leader and non-subleader nodes were able to give us a copy of the state")
 }
 
+// downloadCurrentState copies the current state trie of one node, entry by entry,
+// into the bucket.
+func (s *Service) downloadCurrentState(sb *skipchain.SkipBlock, db *bbolt.DB,
+	bucketName []byte) (*stateTrie, error) {
+	cl := NewClient(sb.SkipChainID(), *sb.Roster)
+	cl.DontContact(s.ServerIdentity())
+	var nonce uint64
+	var cursor int
+	for {
+		// Note: we trust the chain therefore even if the reply is corrupted,
+		// it will be detected by difference in the root hash
+		resp, err := cl.DownloadState(sb.SkipChainID(), nonce, catchupFetchDBEntries)
+		if err != nil {
+			return nil, xerrors.Errorf("cannot download trie: %v", err)
+		}
+		log.Lvlf1("Downloaded key/values %d..%d of %d from %s", cursor, cursor+len(resp.KeyValues), resp.Total,
+			cl.noncesSI[resp.Nonce])
+		cursor += len(resp.KeyValues)
+		nonce = resp.Nonce
+		// And store all entries in our local database.
+		err = db.Update(func(tx *bbolt.Tx) error {
+			bucket := tx.Bucket(bucketName)
+			for _, kv := range resp.KeyValues {
+				err := bucket.Put(kv.Key, kv.Value)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, xerrors.Errorf("couldn't store entries: %v", err)
+		}
+		if len(resp.KeyValues) < catchupFetchDBEntries {
+			break
+		}
+	}
+
+	st, err := loadStateTrie(db, bucketName)
+	if err != nil {
+		return nil, xerrors.Errorf("couldn't load state trie: %v", err)
+	}
+	return st, nil
+}
+
 // catchupAll calls catchup for every byzcoin instance stored in this system.
 func (s *Service) catchupAll() error {
 	if !s.tasks.add(1) {
@@ -1576,15 +1695,33 @@ func (s *Service) catchUp(sb *skipchain.SkipBlock) {
 		return
 	}
 
-	latest := reply.SkipBlock
-
 	// Fetch all missing blocks to fill the hole
-	for trieIndex < sb.Index {
-		log.Lvlf2("%s: our index: %d - latest known index: %d", s.ServerIdentity(), trieIndex, sb.Index)
-		updates, err := cl.GetUpdateChainLevel(sb.Roster, latest.Hash, 1, catchupFetchBlocks)
+	latest, err := s.fetchBlocks(cl, sb.Roster, reply.SkipBlock, sb.Index)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	trieIndex = latest.Index
+
+	err = s.skService().SyncChain(latest.Roster, latest.SkipChainID())
+	if err != nil {
+		log.Errorf("Couldn't update chain: %+v", err)
+	}
+
+	log.LLvlf2("%v Done catch up %x / %d", s.ServerIdentity(),
+		sb.SkipChainID(), trieIndex)
+}
+
+// fetchBlocks stores the blocks following latest, which must be known, up to
+// the block with the given index, and returns the last block stored. Storing
+// the blocks calls updateTrieCallback for each of them.
+func (s *Service) fetchBlocks(cl *skipchain.Client, roster *onet.Roster,
+	latest *skipchain.SkipBlock, index int) (*skipchain.SkipBlock, error) {
+	for latest.Index < index {
+		log.Lvlf2("%s: our index: %d - latest known index: %d", s.ServerIdentity(), latest.Index, index)
+		updates, err := cl.GetUpdateChainLevel(roster, latest.Hash, 1, catchupFetchBlocks)
 		if err != nil {
-			log.Error("Couldn't update blocks:", err)
-			return
+			return nil, xerrors.Errorf("couldn't update blocks: %v", err)
 		}
 
 		// This will call updateTrieCallback with the next block to add
@@ -1593,20 +1730,11 @@ func (s *Service) catchUp(sb *skipchain.SkipBlock) {
 		}
 		_, err = s.db().StoreBlocks(updates)
 		if err != nil {
-			log.Error("Got an invalid, unlinkable block: ", err)
-			return
+			return nil, xerrors.Errorf("got an invalid, unlinkable block: %v", err)
 		}
 		latest = updates[len(updates)-1]
-		trieIndex = latest.Index
-	}
-
-	err = s.skService().SyncChain(latest.Roster, latest.SkipChainID())
-	if err != nil {
-		log.Errorf("Couldn't update chain: %+v", err)
 	}
-
-	log.LLvlf2("%v Done catch up %x / %d", s.ServerIdentity(),
-		sb.SkipChainID(), trieIndex)
+	return latest, nil
 }
 
 // updateTrieCallback is registered in skipchain and is called after a
@@ -1703,10 +1831,12 @@ func (s *Service) updateTrieCallback(sbID skipchain.SkipBlockID) error {
 	}
 
 	log.Lvlf2("%s Updating %d transactions for %x on index %v", s.ServerIdentity(), len(body.TxResults), sb.SkipChainID(), sb.Index)
-	_, _, scs, _ := s.createStateChanges(st.MakeStagingStateTrie(), sb.SkipChainID(), body.TxResults, noTimeout, header.Version, header.Timestamp)
+	_, txOut, scs, _ := s.createStateChanges(st.MakeStagingStateTrie(), sb.SkipChainID(), body.TxResults, noTimeout, header.Version, header.Timestamp)
 
 	log.Lvlf3("%s Storing index %d with %d state changes %v",
 		s.ServerIdentity(), sb.Index, len(scs), scs.ShortStrings())
+	// The snapshot being taken keeps the values before the block.
+	s.snapshotStorage.recordUndo(sb.SkipChainID(), st, scs)
 	// Update our global state using all state changes.
 	if err = st.VerifiedStoreAll(scs, sb.Index, header.Version, header.TrieRoot); err != nil {
 		return xerrors.Errorf("storing state changes: %v", err)
@@ -1719,6 +1849,28 @@ func (s *Service) updateTrieCallback(sbID skipchain.SkipBlockID) error {
 			"mean that the db is broken.")
 	}
 
+	// The receipts are only an index, so the block is still valid if they
+	// cannot be stored.
+	if err := s.txReceipts.store(sb, s.newTxReceipts(sb, txOut)); err != nil {
+		log.Errorf("%s couldn't store the receipts: %v", s.ServerIdentity(), err)
+	}
+	if err := s.instanceIndex.update(sb.SkipChainID(), sb.Index, scs); err == errIndexStale {
+		s.rebuildInstanceIndex(sb.SkipChainID())
+	} else if err != nil {
+		log.Errorf("%s couldn't update the instance index: %v", s.ServerIdentity(), err)
+	}
+	if err := s.updateRangeIndex(sb, header, st, scs); err == errIndexStale {
+		s.rebuildRangeIndex(sb.SkipChainID())
+	} else if err != nil {
+		log.Errorf("%s couldn't update the range index: %v", s.ServerIdentity(), err)
+	}
+	if req := newViewReqOf(txOut); req != nil && txOut[0].Accepted {
+		s.recordViewChange(sb, header, req)
+	}
+
+	s.takeSnapshot(sb, header)
+	s.pruneBodies(sb)
+
 	// If we are adding a genesis block, then look into it for the darc ID
 	// and add it to the darcToSc hash map.
 	if sb.Index == 0 {
@@ -1745,7 +1897,11 @@ func (s *Service) updateTrieCallback(sbID skipchain.SkipBlockID) error {
 	// is this node.
 	i, _ := bcConfig.Roster.Search(s.ServerIdentity().ID)
 	nodeInNew := i >= 0
-	nodeIsLeader := bcConfig.Roster.List[0].Equal(s.ServerIdentity())
+	// Once the term of the leader is over, the next leader hands over to
+	// itself.
+	next := bcConfig.nextLeader(sb.Index + 1)
+	nodeIsLeader := next == 0 && bcConfig.Roster.List[0].Equal(s.ServerIdentity())
+	nodeIsNextLeader := next > 0 && bcConfig.Roster.List[next].Equal(s.ServerIdentity())
 	initialDur, err := s.computeInitialDuration(sb.SkipChainID())
 	if err != nil {
 		return xerrors.Errorf("getting initial duration: %v", err)
@@ -1768,6 +1924,13 @@ func (s *Service) updateTrieCallback(sbID skipchain.SkipBlockID) error {
 		}
 	}
 	s.stopTxPipelineMut.Unlock()
+	if nodeIsNextLeader && !catchingUp {
+		go func() {
+			if err := s.createRotationBlock(sb.SkipChainID()); err != nil {
+				log.Error(s.ServerIdentity(), "couldn't rotate leader:", err)
+			}
+		}()
+	}
 
 	// Check if viewchange needs to be started/stopped
 	if nodeInNew && !catchingUp {
@@ -1804,6 +1967,16 @@ func (s *Service) updateTrieCallback(sbID skipchain.SkipBlockID) error {
 }
 
 func isViewChangeTx(txs TxResults) *viewchange.View {
+	req := newViewReqOf(txs)
+	if req == nil {
+		return nil
+	}
+	return req.GetView()
+}
+
+// newViewReqOf returns the request of a view-change block, or nil if the
+// transactions are not from a view-change block.
+func newViewReqOf(txs TxResults) *viewchange.NewViewReq {
 	if len(txs) != 1 {
 		// view-change block must only have one transaction
 		return nil
@@ -1821,11 +1994,13 @@ func isViewChangeTx(txs TxResults) *viewchange.View {
 		return nil
 	}
 	var req viewchange.NewViewReq
-	if err := protobuf.Decode(invoke.Args.Search("newview"), &req); err != nil {
+	err := protobuf.DecodeWithConstructors(invoke.Args.Search("newview"), &req,
+		network.DefaultConstructors(cothority.Suite))
+	if err != nil {
 		log.Error("failed to decode new-view req")
 		return nil
 	}
-	return req.GetView()
+	return &req
 }
 
 // GetReadOnlyStateTrie returns a read-only accessor to the trie for the given
@@ -1899,7 +2074,17 @@ func (s *Service) isLeader(view viewchange.View) bool {
 
 	idx := view.LeaderIndex % len(sb.Roster.List)
 	sid := sb.Roster.List[idx]
-	return sid.ID.Equal(s.ServerIdentity().ID)
+	if !sid.ID.Equal(s.ServerIdentity().ID) {
+		return false
+	}
+	// A node that cannot be leader in the rotation lets the view-change
+	// go on with the following node.
+	config, err := s.LoadConfig(sb.SkipChainID())
+	if err != nil {
+		log.Error(s.ServerIdentity(), "couldn't load config:", err)
+		return false
+	}
+	return config.leaderWeight(sid) > 0
 }
 
 // gives us access to the skipchain's database, so we can get blocks by ID
@@ -2049,10 +2234,25 @@ func (s *Service) verifySkipBlock(newID []byte, newSB *skipchain.SkipBlock) bool
 		return false
 	}
 
-	if s.viewChangeMan.waiting(string(newSB.SkipChainID())) && isViewChangeTx(body.TxResults) == nil {
+	var view *viewchange.View
+	newView := newViewReqOf(body.TxResults)
+	if newView != nil {
+		view = newView.GetView()
+	}
+	if s.viewChangeMan.waiting(string(newSB.SkipChainID())) && view == nil {
 		log.Error(s.ServerIdentity(), "we are not accepting blocks when a view-change is in progress")
 		return false
 	}
+	// The requests of a view-change block are compared with the ones we
+	// received, even if the block is refused later.
+	if view != nil {
+		if sb := s.db().GetByID(view.ID); sb != nil &&
+			newView.Verify(sb) == nil {
+			for _, req := range newView.Proof {
+				s.collectEvidence(req, sb)
+			}
+		}
+	}
 
 	// Load/create a staging trie to add the state changes to it and
 	// compute the Merkle root.
@@ -2098,6 +2298,10 @@ func (s *Service) verifySkipBlock(newID []byte, newSB *skipchain.SkipBlock) bool
 			log.Lvl2(s.ServerIdentity(), "Client Transaction accept mistmatch on tx", i)
 			return false
 		}
+		if txOut[i].GasUsed != body.TxResults[i].GasUsed {
+			log.Lvl2(s.ServerIdentity(), "Client Transaction gas mismatch on tx", i)
+			return false
+		}
 	}
 
 	// Check that the hashes in DataHeader are right.
@@ -2114,6 +2318,35 @@ func (s *Service) verifySkipBlock(newID []byte, newSB *skipchain.SkipBlock) bool
 		log.Lvl2(s.ServerIdentity(), "State Changes hash doesn't verify")
 		return false
 	}
+	// The root of the range index can only be checked if our index is
+	// ready.
+	if len(header.IndexRoot) > 0 {
+		root := s.rangeIndex.nextRoot(newSB.SkipChainID(), newSB.Index, scs)
+		if root != nil && !bytes.Equal(header.IndexRoot, root) {
+			log.Lvl2(s.ServerIdentity(), "Range index root doesn't verify")
+			return false
+		}
+	}
+
+	events, err := txOut.events()
+	if err != nil {
+		log.Lvl2(s.ServerIdentity(), "Couldn't get events:", err)
+		return false
+	}
+	if !bytes.Equal(header.EventsHash, eventsHash(events)) ||
+		!bytes.Equal(header.EventsHash, eventsHash(body.Events)) {
+		log.Lvl2(s.ServerIdentity(), "Events hash doesn't verify")
+		return false
+	}
+
+	var oldConfig *ChainConfig
+	if newSB.Index > 0 {
+		oldConfig, err = sst.LoadConfig()
+		if err != nil {
+			log.Error(s.ServerIdentity(), err)
+			return false
+		}
+	}
 
 	// Compute the new state and check whether the roster in newSB matches
 	// the config.
@@ -2132,6 +2365,10 @@ func (s *Service) verifySkipBlock(newID []byte, newSB *skipchain.SkipBlock) bool
 			log.Error("Didn't accept the new roster:", err)
 			return false
 		}
+		if err := config.checkLeaderRotation(oldConfig, newSB.Index); err != nil {
+			log.Error("Didn't accept the new leader:", err)
+			return false
+		}
 		previous := s.db().GetByID(newSB.BackLinkIDs[0])
 		if previous != nil {
 			var prevHeader DataHeader
@@ -2218,17 +2455,49 @@ func (s *Service) createStateChanges(sst *stagingStateTrie, scID skipchain.SkipB
 	// no error or expected "no trie" err, so keep going with the
 	// maxsz we got.
 	_, maxsz, _ = loadBlockInfo(sst)
+	var blockGas uint64
+	_, maxGas := gasLimits(sst)
 
 	deadline := time.Now().Add(timeout)
 
 	sstTemp = sst.Clone()
 
-	for _, tx := range txIn {
+	// In parallel mode, all transactions are first executed on the state of
+	// the beginning of the block. Only the transactions reading a key
+	// written by a previous transaction of the block are executed again,
+	// so the result is the same as executing them one after the other.
+	var speculative []speculativeTx
+	written := make(map[string]struct{})
+	if workers := s.getParallelWorkers(); workers > 1 && len(txIn) > 1 {
+		speculative = s.executeSpeculatively(sstTemp, scID, txIn, timestamp,
+			workers)
+	}
+
+	for i, tx := range txIn {
 		txsz := txSize(tx)
 
 		var sstTempC *stagingStateTrie
 		var statesTemp StateChanges
-		statesTemp, sstTempC, err = s.processOneTx(sstTemp, tx.ClientTransaction, scID, timestamp)
+		if speculative != nil && !speculative[i].reads.conflicts(written) {
+			statesTemp, sstTempC, tx.GasUsed, err = speculative[i].apply(sstTemp)
+			if err != nil {
+				s.addError(tx.ClientTransaction, err)
+			}
+		} else {
+			statesTemp, sstTempC, tx.GasUsed, err = s.processOneTx(sstTemp, tx.ClientTransaction, scID, timestamp, nil)
+		}
+		// Only the gas of the accepted transactions counts towards the
+		// limit of the block. When planning a block, the transaction is
+		// left for the next block, just like when the block is full.
+		if err == nil && maxGas > 0 && blockGas+tx.GasUsed > maxGas {
+			if timeout != noTimeout {
+				log.Lvlf3("stopping block creation when gas %v > %v, with len(txOut) of %v",
+					blockGas+tx.GasUsed, maxGas, len(txOut))
+				return
+			}
+			err = xerrors.Errorf("block gas limit of %d reached", maxGas)
+			s.addError(tx.ClientTransaction, err)
+		}
 		if err != nil {
 			tx.Accepted = false
 			txOut = append(txOut, tx)
@@ -2266,6 +2535,11 @@ func (s *Service) createStateChanges(sst *stagingStateTrie, scID skipchain.SkipB
 			tx.Accepted = true
 			sstTemp = sstTempC
 			blocksz += txsz
+			blockGas += tx.GasUsed
+			tx.stateChanges = statesTemp
+			if speculative != nil {
+				addWrites(written, statesTemp)
+			}
 			states = append(states, statesTemp...)
 			txOut = append(txOut, tx)
 		}
@@ -2297,29 +2571,126 @@ func ComputeSeed(instr Instruction, index uint8) []byte {
 	return seed
 }
 
+// generatedInstruction decodes the instruction of a GenerateInstruction
+// state change returned by instr. Generated Spawn instructions get a seed
+// computed from instr and the seedCounter, which is incremented.
+func generatedInstruction(sc StateChange, instr Instruction,
+	seedCounter *uint8) (Instruction, error) {
+	var newInstr Instruction
+	err := protobuf.Decode(sc.Value, &newInstr)
+	if err != nil {
+		return newInstr, xerrors.Errorf("failed to decode "+
+			"new instruction: %v", err)
+	}
+
+	newInstr.synthetic = true
+
+	if newInstr.Spawn != nil {
+		// For Spawn instructions, provide a seed to the contract
+		// allowing to define a new InstanceID that can also be
+		// determined by the client.
+		seedArg := Argument{
+			Name:  "seed",
+			Value: ComputeSeed(instr, *seedCounter),
+		}
+		newInstr.Spawn.Args = append(newInstr.Spawn.Args, seedArg)
+
+		*seedCounter++
+	}
+	return newInstr, nil
+}
+
+// dryRun makes processOneTx simulate a transaction: the errors are not
+// recorded, and the signatures of the fee and of the instructions that are
+// not accepted don't stop the execution, but are kept in authErr.
+type dryRun struct {
+	// authErr is the first verification that failed.
+	authErr error
+	// coins are left over after the last instruction.
+	coins []Coin
+}
+
+func (d *dryRun) unauthorized(err error) {
+	if d.authErr == nil {
+		d.authErr = err
+	}
+}
+
 // processOneTx takes one transaction and creates a set of StateChanges. It
 // also returns the temporary StateTrie with the StateChanges applied. Any data
 // from the trie should be read from sst and not the service.
+// If the ChainConfig defines GasLimits, the gas used by the transaction is
+// returned, even if the transaction is refused. If dry is not nil, the
+// transaction is only simulated.
 func (s *Service) processOneTx(sst *stagingStateTrie, tx ClientTransaction,
-	scID skipchain.SkipBlockID, timestamp int64) (StateChanges, *stagingStateTrie, error) {
+	scID skipchain.SkipBlockID, timestamp int64,
+	dry *dryRun) (StateChanges, *stagingStateTrie, uint64, error) {
 
 	// Make a new trie for each instruction. If the instruction is
 	// sucessfully implemented and changes applied, then keep it
 	// otherwise dump it.
 	sst = sst.Clone()
 
+	// The errors of a speculative execution are not recorded, as the
+	// transaction might be executed again.
+	addError := s.addError
+	if sst.reads != nil || dry != nil {
+		addError = func(ClientTransaction, error) {}
+	}
+
 	// convert ReadOnlyStateTrie to a GlobalState so that contracts may cast it if they wish
 	roSC := newROSkipChain(s.skService(), scID)
-	gs := globalState{sst, roSC, &currentBlockInfo{timestamp}}
+	var meter *gasMeter
+	var rst ReadOnlyStateTrie = sst
+	if txGas, _ := gasLimits(sst); txGas > 0 {
+		meter = newGasMeter(txGas)
+		rst = newMeteredStateTrie(sst, meter)
+	}
+	gs := globalState{rst, roSC, &currentBlockInfo{timestamp}, nil}
 
-	h := tx.Instructions.Hash()
+	// The transaction will be part of the block following the state of sst.
+	if err := tx.checkValidity(sst.GetIndex()+1, timestamp); err != nil {
+		err = xerrors.Errorf("%s refused transaction: %w", s.ServerIdentity(), err)
+		addError(tx, err)
+		return nil, nil, meter.gasUsed(), err
+	}
+
+	h := tx.Hash()
+	gs.calls = &callEnv{service: s, ctxHash: h}
 	var statesTemp StateChanges
+	var err error
+	if dry != nil && tx.Fee != nil {
+		if err := verifyFee(sst, tx, h); err != nil {
+			dry.unauthorized(xerrors.Errorf("fee: %v", err))
+		}
+		statesTemp, err = transferFee(sst, tx)
+	} else {
+		statesTemp, err = payFee(sst, tx, h)
+	}
+	if err != nil {
+		err = xerrors.Errorf("%s couldn't pay fee: %v", s.ServerIdentity(), err)
+		addError(tx, err)
+		return nil, nil, meter.gasUsed(), err
+	}
 	var cin []Coin
 	for i := 0; i < len(tx.Instructions); i++ {
 		instr := tx.Instructions[i]
 		log.Lvlf2("Processing instruction: %v", instr.Action())
 
-		scs, cout, err := s.executeInstruction(gs, cin, instr, h)
+		if err := meter.consumeInstruction(instr); err != nil {
+			err = xerrors.Errorf("%s instruction %x: %w", s.ServerIdentity(),
+				instr.Hash(), err)
+			addError(tx, err)
+			return nil, nil, meter.gasUsed(), err
+		}
+
+		scs, cout, err := s.executeInstruction(gs, cin, instr, h, dry)
+		if err == nil {
+			err = meter.consumeStateChanges(scs)
+		}
+		if err == nil && meter.exhausted() {
+			err = ErrOutOfGas
+		}
 		if err != nil {
 			_, _, cid, _, err2 := sst.GetValues(instr.InstanceID.Slice())
 			if err2 != nil {
@@ -2327,16 +2698,16 @@ func (s *Service) processOneTx(sst *stagingStateTrie, tx ClientTransaction,
 			}
 			err = xerrors.Errorf("%s Contract %s got %x and returned error: %v",
 				s.ServerIdentity(), cid, instr.Hash(), err)
-			s.addError(tx, err)
-			return nil, nil, err
+			addError(tx, err)
+			return nil, nil, meter.gasUsed(), err
 		}
 
 		counterScs, err := incrementSignerCounters(sst, instr.SignerIdentities)
 		if err != nil {
 			err = xerrors.Errorf("%s failed to update signature counters: %v",
 				s.ServerIdentity(), err)
-			s.addError(tx, err)
-			return nil, nil, err
+			addError(tx, err)
+			return nil, nil, meter.gasUsed(), err
 		}
 
 		// Counter used in the seed provided to generated Spawn instructions.
@@ -2374,50 +2745,42 @@ func (s *Service) processOneTx(sst *stagingStateTrie, tx ClientTransaction,
 					err = xerrors.Errorf("%s couldn't get contractID from the "+
 						"following instruction: %x (with instanceID %x)",
 						s.ServerIdentity(), instr.Hash(), instr.InstanceID.Slice())
-					s.addError(tx, err)
-					return nil, nil, err
+					addError(tx, err)
+					return nil, nil, meter.gasUsed(), err
 				}
 				err = xerrors.Errorf("%s: contract %s %s %x", s.ServerIdentity(),
 					contractID, reason, sc.InstanceID)
-				s.addError(tx, err)
-				return nil, nil, err
+				addError(tx, err)
+				return nil, nil, meter.gasUsed(), err
 			}
 			log.Lvlf2("StateChange %s for id %x - contract: %s", sc.StateAction,
 				sc.InstanceID, sc.ContractID)
 
 			if sc.StateAction == GenerateInstruction {
-				var newInstr Instruction
-				err = protobuf.Decode(sc.Value, &newInstr)
+				newInstr, err := generatedInstruction(sc, instr, &seedCounter)
 				if err != nil {
-					return nil, nil, xerrors.Errorf("failed to decode "+
-						"new instruction: %v", err)
+					return nil, nil, meter.gasUsed(), err
 				}
+				newInstructions = append(newInstructions, newInstr)
 
-				newInstr.synthetic = true
-
-				if newInstr.Spawn != nil {
-					// For Spawn instructions, provide a seed to the contract
-					// allowing to define a new InstanceID that can also be
-					// determined by the client.
-					seedArg := Argument{
-						Name:  "seed",
-						Value: ComputeSeed(instr, seedCounter),
-					}
-					newInstr.Spawn.Args = append(newInstr.Spawn.Args, seedArg)
+				continue
+			}
 
-					seedCounter++
+			if sc.StateAction == EmitEvent {
+				if _, err := decodeEvent(sc); err != nil {
+					err = xerrors.Errorf("%s: contract %s emitted an invalid "+
+						"event: %v", s.ServerIdentity(), sc.ContractID, err)
+					addError(tx, err)
+					return nil, nil, meter.gasUsed(), err
 				}
-
-				newInstructions = append(newInstructions, newInstr)
-
 				continue
 			}
 
 			err = sst.StoreAll(StateChanges{sc})
 			if err != nil {
 				err = xerrors.Errorf("%s StoreAll failed: %v", s.ServerIdentity(), err)
-				s.addError(tx, err)
-				return nil, nil, err
+				addError(tx, err)
+				return nil, nil, meter.gasUsed(), err
 			}
 		}
 
@@ -2431,19 +2794,21 @@ func (s *Service) processOneTx(sst *stagingStateTrie, tx ClientTransaction,
 		if err = sst.StoreAll(counterScs); err != nil {
 			err = xerrors.Errorf("%s StoreAll failed to add counter changes: %v",
 				s.ServerIdentity(), err)
-			s.addError(tx, err)
-			return nil, nil, err
+			addError(tx, err)
+			return nil, nil, meter.gasUsed(), err
 		}
 
 		statesTemp = append(statesTemp, scs...)
 		statesTemp = append(statesTemp, counterScs...)
 		cin = cout
 	}
-	if len(cin) != 0 {
+	if dry != nil {
+		dry.coins = cin
+	} else if len(cin) != 0 {
 		log.Lvl2(s.ServerIdentity(), "Leftover coins detected, discarding.")
 	}
 
-	return statesTemp, sst, nil
+	return statesTemp, sst, meter.gasUsed(), nil
 }
 
 // GetContractConstructor gets the contract constructor of the contract
@@ -2476,9 +2841,12 @@ func (s *Service) GetContractInstance(contractName string, in []byte) (Contract,
 	return c, nil
 }
 
+// executeInstruction verifies the instruction and runs its contract. If dry
+// is not nil, a failed verification is recorded in it and the contract is
+// run anyway.
 func (s *Service) executeInstruction(gs GlobalState, cin []Coin,
-	instr Instruction, ctxHash []byte) (scs StateChanges, cout []Coin,
-	err error) {
+	instr Instruction, ctxHash []byte, dry *dryRun) (scs StateChanges,
+	cout []Coin, err error) {
 	defer func() {
 		if re := recover(); re != nil {
 			log.Lvl2("Recovered from panic:\n", log.Stack())
@@ -2486,55 +2854,73 @@ func (s *Service) executeInstruction(gs GlobalState, cin []Coin,
 		}
 	}()
 
+	c, err := s.loadContract(gs, instr)
+	if err != nil {
+		return
+	}
+
+	err = c.VerifyInstruction(gs, instr, ctxHash)
+	if err != nil {
+		err = xerrors.Errorf("instruction verification failed: %v", err)
+		if dry == nil {
+			return
+		}
+		dry.unauthorized(xerrors.Errorf("instruction %x: %v", instr.Hash(),
+			err))
+	}
+
+	return s.runContract(gs, c, cin, instr)
+}
+
+// loadContract returns the contract of the instance the instruction is sent
+// to.
+func (s *Service) loadContract(gs GlobalState, instr Instruction) (Contract, error) {
 	contents, _, contractID, _, err := gs.GetValues(instr.InstanceID.Slice())
 	if !xerrors.Is(err, errKeyNotSet) && err != nil {
-		err = xerrors.Errorf("couldn't get contract type of instruction: %v", err)
-		return
+		return nil, xerrors.Errorf("couldn't get contract type of instruction: %v", err)
 	}
 
-	contractFactory, exists := s.GetContractConstructor(contractID)
+	// The contracts are used in the version active in the block.
+	registry := s.registryAt(gs)
+	contractFactory, exists := registry.Search(contractID)
 	if !exists {
 		if ConfigInstanceID.Equal(instr.InstanceID) {
 			// Special case 1: first time call to
 			// genesis-configuration must return correct contract
 			// type.
-			contractFactory, _ = s.GetContractConstructor(ContractConfigID)
+			contractFactory, _ = registry.Search(ContractConfigID)
 		} else if NamingInstanceID.Equal(instr.InstanceID) {
 			// Special case 2: first time call to the naming
 			// contract must return the correct type too.
-			contractFactory, _ = s.GetContractConstructor(ContractNamingID)
+			contractFactory, _ = registry.Search(ContractNamingID)
 		} else {
 			// If the leader does not have a verifier for this
 			// contract, it drops the transaction.
-			err = xerrors.Errorf("leader is dropping instruction of unknown contract \"%s\" on instance \"%x\"",
+			return nil, xerrors.Errorf("leader is dropping instruction of unknown contract \"%s\" on instance \"%x\"",
 				contractID, instr.InstanceID.Slice())
-			return
 		}
 	}
 
 	// Now we call the contract function with the data of the key.
 	log.Lvlf3("%s Calling contract '%s'", s.ServerIdentity(), contractID)
 
-	var c Contract
-	c, err = contractFactory(contents)
+	c, err := contractFactory(contents)
 	if err != nil {
-		err = xerrors.Errorf("making contract: %v", err)
-		return
+		return nil, xerrors.Errorf("making contract: %v", err)
 	}
 	if c == nil {
-		err = xerrors.New("contract factory returned nil contract instance")
-		return
+		return nil, xerrors.New("contract factory returned nil contract instance")
 	}
 	if sc, ok := c.(ContractWithRegistry); ok {
-		sc.SetRegistry(s.contracts)
-	}
-
-	err = c.VerifyInstruction(gs, instr, ctxHash)
-	if err != nil {
-		err = xerrors.Errorf("instruction verification failed: %v", err)
-		return
+		sc.SetRegistry(registry)
 	}
+	return c, nil
+}
 
+// runContract executes the instruction with the contract, without verifying
+// it, and sets the versions of the returned state changes.
+func (s *Service) runContract(gs GlobalState, c Contract, cin []Coin,
+	instr Instruction) (scs StateChanges, cout []Coin, err error) {
 	switch instr.GetType() {
 	case SpawnType:
 		scs, cout, err = c.Spawn(gs, instr, cin)
@@ -2560,6 +2946,11 @@ func (s *Service) executeInstruction(gs GlobalState, cin []Coin,
 			return nil, nil, xerrors.New("unknown contract ID")
 		}
 
+		// Events don't change the instance.
+		if sc.StateAction == EmitEvent {
+			continue
+		}
+
 		ver, ok := vv[hex.EncodeToString(sc.InstanceID)]
 		if !ok {
 			_, ver, _, _, err = gs.GetValues(sc.InstanceID)
@@ -2644,6 +3035,7 @@ func (s *Service) cleanupGoroutines() {
 	log.Lvl1(s.ServerIdentity(), "closing go-routines")
 	s.viewChangeMan.closeAll()
 	s.streamingMan.stopAll()
+	s.stopAllObservers()
 
 	s.stopTxPipelineMut.Lock()
 	for k, c := range s.stopTxPipeline {
@@ -2824,6 +3216,12 @@ func (s *Service) startChain(genesisID skipchain.SkipBlockID) error {
 	if err := s.fixInconsistencyIfAny(genesisID, st); err != nil {
 		return xerrors.Errorf("fixing inconsistency: %v", err)
 	}
+	if !s.instanceIndex.isReady(genesisID, st.GetIndex()) {
+		s.rebuildInstanceIndex(genesisID)
+	}
+	if !s.rangeIndex.isReady(genesisID, st.GetIndex()) {
+		s.rebuildRangeIndex(genesisID)
+	}
 
 	// load the metadata to prepare for starting the managers (viewchange)
 	if s.db().GetByID(genesisID) == nil {
@@ -2858,15 +3256,22 @@ func (s *Service) startChain(genesisID skipchain.SkipBlockID) error {
 	s.darcToSc[string(d.GetBaseID())] = genesisID
 	s.darcToScMut.Unlock()
 
-	// initiate the view-change manager
-	initialDur, err := s.computeInitialDuration(genesisID)
-	if err != nil {
-		return xerrors.Errorf("getting initial duration: %v", err)
+	if i, _ := latest.Roster.Search(s.ServerIdentity().ID); i < 0 &&
+		s.isObserving(genesisID) {
+		// An observer only follows the chain and doesn't take part in the
+		// view-changes.
+		s.startObserving(genesisID)
+	} else {
+		// initiate the view-change manager
+		initialDur, err := s.computeInitialDuration(genesisID)
+		if err != nil {
+			return xerrors.Errorf("getting initial duration: %v", err)
+		}
+		s.viewChangeMan.add(s.sendViewChangeReq, s.sendNewView, s.isLeader,
+			string(genesisID))
+		s.viewChangeMan.start(s.ServerIdentity().ID, genesisID, initialDur,
+			s.getSignatureThreshold(latest.Hash))
 	}
-	s.viewChangeMan.add(s.sendViewChangeReq, s.sendNewView, s.isLeader,
-		string(genesisID))
-	s.viewChangeMan.start(s.ServerIdentity().ID, genesisID, initialDur,
-		s.getSignatureThreshold(latest.Hash))
 
 	// Set the server's set of valid peers from the roster in the latest block.
 	ctx := s.ServiceProcessor.Context
@@ -2902,15 +3307,15 @@ func (s *Service) save() {
 }
 
 // getBlockTx fetches the block with the given id and then decodes the payload
-// to return the list of transactions
+// to return the list of transactions. ErrorBodyPruned is returned if the
+// node doesn't keep the body of the block anymore.
 func (s *Service) getBlockTx(sid skipchain.SkipBlockID) (TxResults, *skipchain.SkipBlock, error) {
 	sb, err := s.skService().GetSingleBlock(&skipchain.GetSingleBlock{ID: sid})
 	if err != nil {
 		return nil, nil, err
 	}
 
-	var body DataBody
-	err = protobuf.Decode(sb.Payload, &body)
+	body, err := s.decodeBlockBody(sb)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -3036,12 +3441,20 @@ func newService(c *onet.Context) (onet.Service, error) {
 		darcToSc:           make(map[string]skipchain.SkipBlockID),
 		stateChangeCache:   newStateChangeCache(),
 		stateChangeStorage: newStateChangeStorage(c),
+		txReceipts:         newTxReceiptStorage(c),
+		instanceIndex:      newInstanceIndexStorage(c),
+		rangeIndex:         newRangeIndexStorage(c),
+		snapshotStorage:    newSnapshotStorage(c),
+		pruning:            newPruningStorage(c),
 		viewChangeMan:      newViewChangeManager(),
+		evidence:           newEvidenceCollector(),
+		viewChanges:        newViewChangeStorage(c),
 		streamingMan:       streamingManager{},
 		catchingUpHistory:  make(map[string]time.Time),
 		rotationWindow:     defaultRotationWindow,
 		defaultVersion:     CurrentVersion,
 		txPipeline:         make(map[string]*txPipeline),
+		observers:          make(map[string]chan bool),
 		// We need a large enough buffer for all errors in 2 blocks
 		// where each block might be 1 MB in size and each tx is 1 KB.
 		txErrorBuf: newRingBuf(2048),
@@ -3052,22 +3465,38 @@ func newService(c *onet.Context) (onet.Service, error) {
 		s.CreateGenesisBlock,
 		s.AddTransaction,
 		s.GetProof,
+		s.GetProofs,
+		s.GetRangeProof,
+		s.GetProofAt,
 		s.GetUpdates,
 		s.CheckAuthorization,
 		s.GetSignerCounters,
 		s.DownloadState,
+		s.GetSnapshot,
+		s.GetSnapshotChunk,
+		s.GetNodeRole,
+		s.ChangeNodeRole,
 		s.GetInstanceVersion,
 		s.GetLastInstanceVersion,
 		s.GetAllInstanceVersion,
 		s.CheckStateChangeValidity,
+		s.GetInstanceHistory,
 		s.ResolveInstanceID,
+		s.ListInstances,
 		s.Debug,
-		s.DebugRemove)
+		s.DebugRemove,
+		s.Observe,
+		s.StopObserving,
+		s.SimulateTransaction,
+		s.GetTxReceipt,
+		s.GetEvidence,
+		s.GetViewChanges)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := s.RegisterStreamingHandlers(s.StreamTransactions, s.PaginateBlocks); err != nil {
+	if err := s.RegisterStreamingHandlers(s.StreamTransactions, s.PaginateBlocks,
+		s.StreamEvents); err != nil {
 		return nil, xerrors.Errorf("registering handlers: %v", err)
 	}
 	s.RegisterProcessorFunc(viewChangeMsgID, s.handleViewChangeReq)
@@ -3076,6 +3505,11 @@ func newService(c *onet.Context) (onet.Service, error) {
 		log.ErrFatal(err)
 	}
 
+	// The signatures of the forward links verified by the node are compared
+	// to detect the nodes signing two blocks after the same one.
+	protocol.RegisterResponseObserver(s.ServerIdentity().ID,
+		s.collectSignature)
+
 	// Register the view-change cosi protocols.
 	_, err = s.ProtocolRegister(viewChangeSubFtCosi, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
 		return protocol.NewSubBlsCosi(n, s.verifyViewChange, pairingSuite)
@@ -3089,6 +3523,20 @@ func newService(c *onet.Context) (onet.Service, error) {
 	if err != nil {
 		return nil, xerrors.Errorf("registering protocol: %v", err)
 	}
+
+	// Register the cosi protocols signing the snapshots.
+	_, err = s.ProtocolRegister(snapshotSubFtCosi, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+		return protocol.NewSubBlsCosi(n, s.verifySnapshot, pairingSuite)
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("registering protocol: %v", err)
+	}
+	_, err = s.ProtocolRegister(snapshotFtCosi, func(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+		return protocol.NewBlsCosi(n, s.verifySnapshot, snapshotSubFtCosi, pairingSuite)
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("registering protocol: %v", err)
+	}
 	ver, err := s.LoadVersion()
 	if err != nil {
 		return nil, xerrors.Errorf("loading version: %v", err)
@@ -3126,6 +3574,10 @@ func newService(c *onet.Context) (onet.Service, error) {
 		return nil, xerrors.Errorf("unknown db version number %v", ver)
 	}
 
+	if err := s.stateChangeStorage.indexBlocks(); err != nil {
+		return nil, xerrors.Errorf("indexing state changes: %v", err)
+	}
+
 	go func() {
 		// initialize the stats of the storage
 		if err := s.stateChangeStorage.calculateSize(); err != nil {
@@ -3136,6 +3588,9 @@ func newService(c *onet.Context) (onet.Service, error) {
 	if _, err := s.startAllChains(); err != nil {
 		return nil, xerrors.Errorf("starting chains: %v", err)
 	}
+	if err := s.setNodeRoleFromEnv(); err != nil {
+		return nil, xerrors.Errorf("node role: %v", err)
+	}
 
 	return s, nil
 }
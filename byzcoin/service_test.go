@@ -1323,6 +1323,50 @@ func TestService_StateChangeVerification(t *testing.T) {
 	require.Equal(t, true, txOut[0].Accepted)
 }
 
+func TestService_TxValidity(t *testing.T) {
+	b := newBCTRun(t, nil)
+	defer b.CloseAll()
+
+	cid := "validitySC"
+	f := func(cdb ReadOnlyStateTrie, inst Instruction, c []Coin) ([]StateChange, []Coin, error) {
+		return nil, c, nil
+	}
+	b.Services[0].testRegisterContract(cid, adaptorNoVerify(f))
+	cdb, err := b.Services[0].getStateTrie(b.Genesis.SkipChainID())
+	require.NoError(t, err)
+
+	iid := NewInstanceID([]byte("validity"))
+	require.NoError(t, cdb.StoreAll(StateChanges{
+		NewStateChange(Create, iid, cid, nil, nil),
+	}, 4, CurrentVersion))
+
+	timestamp := time.Now().UnixNano()
+	newTx := func(validity TxValidity) ClientTransaction {
+		return ClientTransaction{
+			Instructions: Instructions{{
+				InstanceID: iid,
+				Invoke:     &Invoke{ContractID: cid},
+			}},
+			Validity: &validity,
+		}
+	}
+
+	index := cdb.GetIndex() + 1
+	require.Equal(t, 5, index)
+	_, txOut, _, _ := b.Services[0].createStateChanges(cdb.MakeStagingStateTrie(),
+		b.Genesis.SkipChainID(), NewTxResults(
+			newTx(TxValidity{MaxBlockIndex: index}),
+			newTx(TxValidity{MaxBlockIndex: index - 1}),
+			newTx(TxValidity{MaxTimestamp: timestamp}),
+			newTx(TxValidity{MaxTimestamp: timestamp - 1}),
+		), noTimeout, CurrentVersion, timestamp)
+	require.Equal(t, 4, len(txOut))
+	require.True(t, txOut[0].Accepted)
+	require.False(t, txOut[1].Accepted)
+	require.True(t, txOut[2].Accepted)
+	require.False(t, txOut[3].Accepted)
+}
+
 func TestService_DarcEvolutionFail(t *testing.T) {
 	b := newBCTRun(t, nil)
 	defer b.CloseAll()
@@ -0,0 +1,103 @@
+package byzcoin
+
+import (
+	"fmt"
+	"time"
+
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3/log"
+	"golang.org/x/xerrors"
+)
+
+// SimulateTransaction executes the transaction on the latest state of the
+// chain and returns the resulting state changes. Nothing is stored and the
+// transaction is not sent to the leader. The signatures are verified, but
+// a failed verification doesn't stop the simulation, so that a client can
+// check a transaction before signing it.
+func (s *Service) SimulateTransaction(req *SimulateTransaction) (*SimulateTransactionResponse, error) {
+	if !s.tasks.add(1) {
+		return nil, xerrors.New("node is closed")
+	}
+	defer s.tasks.done()
+
+	if len(req.Transaction.Instructions) == 0 {
+		return nil, xerrors.New("no instructions to simulate")
+	}
+
+	st, header, err := s.readOnlyStateTrie(req.SkipchainID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting state trie: %w", err)
+	}
+	defer s.closeReadOnlyStateTrie(st)
+
+	tx := req.Transaction.Clone()
+	tx.Instructions.SetVersion(header.Version)
+	resp := s.simulateTx(st.MakeStagingStateTrie(), req.SkipchainID, tx,
+		time.Now().UnixNano())
+	resp.Version = CurrentVersion
+	return resp, nil
+}
+
+// readOnlyStateTrie returns a replica of the state trie and the header of
+// the latest block. The replica stays consistent while the state trie is
+// updated, so that it can be used without holding updateTrieMutex. It must
+// be closed by the caller.
+func (s *Service) readOnlyStateTrie(scID skipchain.SkipBlockID) (*stateTrie,
+	*DataHeader, error) {
+	s.updateTrieMutex.Lock()
+	defer s.updateTrieMutex.Unlock()
+
+	latest, err := s.db().GetLatestByID(scID)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("reading latest block: %w", err)
+	}
+	header, err := decodeBlockHeader(latest)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("decoding header: %w", err)
+	}
+
+	db, bucket := s.GetAdditionalBucket([]byte(fmt.Sprintf("%x", scID)))
+	tx, err := db.Begin(false)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("opening transaction: %v", err)
+	}
+	st, err := loadReadOnlyStateTrie(tx, bucket)
+	if err != nil {
+		tx.Rollback()
+		return nil, nil, xerrors.Errorf("loading trie: %w", err)
+	}
+	return st, header, nil
+}
+
+// closeReadOnlyStateTrie releases the replica returned by readOnlyStateTrie.
+func (s *Service) closeReadOnlyStateTrie(st *stateTrie) {
+	if err := st.DB().Close(); err != nil {
+		log.Errorf("%s: closing the read-only trie: %v", s.ServerIdentity(),
+			err)
+	}
+}
+
+// simulateTx executes the transaction with processOneTx on a replica of sst
+// and returns the result.
+func (s *Service) simulateTx(sst *stagingStateTrie, scID skipchain.SkipBlockID,
+	tx ClientTransaction, timestamp int64) *SimulateTransactionResponse {
+	resp := &SimulateTransactionResponse{
+		Index:      sst.GetIndex(),
+		Authorized: true,
+	}
+
+	var dry dryRun
+	scs, _, gas, err := s.processOneTx(sst, tx, scID, timestamp, &dry)
+	resp.GasUsed = gas
+	if err != nil {
+		resp.Error = err.Error()
+	} else {
+		resp.StateChanges = scs
+		resp.Coins = dry.coins
+	}
+	if dry.authErr != nil {
+		resp.Authorized = false
+		resp.AuthorizationError = dry.authErr.Error()
+	}
+	return resp
+}
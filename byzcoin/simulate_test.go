@@ -0,0 +1,59 @@
+package byzcoin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3/darc"
+	"golang.org/x/xerrors"
+)
+
+func TestService_SimulateTransaction(t *testing.T) {
+	b := newBCTRun(t, nil)
+	defer b.CloseAll()
+
+	ctx := NewClientTransaction(CurrentVersion, Instruction{
+		InstanceID: NewInstanceID(b.GenesisDarc.GetBaseID()),
+		Spawn: &Spawn{
+			ContractID: DummyContractName,
+			Args:       Arguments{{Name: "data", Value: []byte("simulated")}},
+		},
+		SignerIdentities: []darc.Identity{b.Signer.Identity()},
+		SignerCounter:    []uint64{b.SignerCounter},
+	})
+
+	// Without signatures, the state changes are returned, but the
+	// transaction is not authorized.
+	resp, err := b.Client.SimulateTransaction(ctx)
+	require.NoError(t, err)
+	require.Empty(t, resp.Error)
+	require.False(t, resp.Authorized)
+	require.NotEmpty(t, resp.AuthorizationError)
+	require.Equal(t, 2, len(resp.StateChanges))
+	require.Equal(t, Create, resp.StateChanges[0].StateAction)
+	require.Equal(t, []byte("simulated"), resp.StateChanges[0].Value)
+	require.Equal(t, b.Genesis.Index, resp.Index)
+
+	require.NoError(t, ctx.FillSignersAndSignWith(b.Signer))
+	resp, err = b.Client.SimulateTransaction(ctx)
+	require.NoError(t, err)
+	require.Empty(t, resp.Error)
+	require.True(t, resp.Authorized)
+	require.Empty(t, resp.AuthorizationError)
+
+	// Nothing has been stored.
+	st, err := b.Services[0].GetReadOnlyStateTrie(b.Genesis.SkipChainID())
+	require.NoError(t, err)
+	_, _, _, _, err = st.GetValues(resp.StateChanges[0].InstanceID)
+	require.True(t, xerrors.Is(err, errKeyNotSet))
+	counters, err := b.Client.GetSignerCounters(b.Signer.Identity().String())
+	require.NoError(t, err)
+	require.Equal(t, b.SignerCounter-1, counters.Counters[0])
+
+	// The transaction is refused if the instance doesn't exist.
+	ctx.Instructions[0].InstanceID = NewInstanceID([]byte("unknown"))
+	resp, err = b.Client.SimulateTransaction(ctx)
+	require.NoError(t, err)
+	require.NotEmpty(t, resp.Error)
+	require.Empty(t, resp.StateChanges)
+}
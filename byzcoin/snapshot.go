@@ -0,0 +1,725 @@
+package byzcoin
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/blscosi/protocol"
+	"go.dedis.ch/cothority/v3/byzcoin/trie"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+	bbolt "go.etcd.io/bbolt"
+	"golang.org/x/xerrors"
+)
+
+// How many blocks between two snapshots of the state trie. No snapshots are
+// taken if it is 0.
+var snapshotInterval = 1000
+
+// How many nodes of the trie in one chunk of a snapshot. It is also the number
+// of nodes copied from the trie at once.
+var snapshotChunkEntries = 10000
+
+// How long the leader waits for the roster to sign a snapshot.
+var snapshotSignTimeout = 5 * time.Minute
+
+var bucketSnapshots = []byte("snapshots")
+var keySnapshotManifest = []byte("manifest")
+var bucketSnapshotChunks = []byte("chunks")
+var bucketSnapshotUndo = []byte("undo")
+
+// ErrorNoSnapshot is returned if the node has no snapshot of the chain.
+var ErrorNoSnapshot = xerrors.New("no snapshot available")
+
+// snapshotStorage keeps the latest snapshot of every skipchain in its own
+// bucket, with the chunks ready to be sent. A snapshot is built from the nodes
+// of the trie, copied in short transactions.
+type snapshotStorage struct {
+	db     *bbolt.DB
+	bucket []byte
+	// pending counts the snapshots being taken in the background.
+	pending sync.WaitGroup
+	sync.Mutex
+	// target is the snapshot being taken, if any.
+	target *Snapshot
+	// recording is true while the trie is copied into the target.
+	recording bool
+	// failed is the error of recordUndo, which spoils the target.
+	failed error
+}
+
+func newSnapshotStorage(c *onet.Context) *snapshotStorage {
+	db, name := c.GetAdditionalBucket(bucketSnapshots)
+	return &snapshotStorage{
+		db:     db,
+		bucket: name,
+	}
+}
+
+// start clears what is left of a previous snapshot of the chain and marks the
+// storage as busy with the target until finish is called. It returns false if
+// a snapshot is already being taken.
+func (s *snapshotStorage) start(target *Snapshot) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.target != nil {
+		return false, nil
+	}
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.Bucket(s.bucket).CreateBucketIfNotExists(target.ByzCoinID)
+		if err != nil {
+			return xerrors.Errorf("creating bucket: %v", err)
+		}
+		err = b.DeleteBucket(bucketSnapshotUndo)
+		if err != nil && err != bbolt.ErrBucketNotFound {
+			return xerrors.Errorf("deleting bucket: %v", err)
+		}
+		if _, err := b.CreateBucket(bucketSnapshotUndo); err != nil {
+			return xerrors.Errorf("creating bucket: %v", err)
+		}
+		chunks, err := b.CreateBucketIfNotExists(bucketSnapshotChunks)
+		if err != nil {
+			return xerrors.Errorf("creating bucket: %v", err)
+		}
+		err = chunks.DeleteBucket(chunkKey(target.Index))
+		if err != nil && err != bbolt.ErrBucketNotFound {
+			return xerrors.Errorf("deleting bucket: %v", err)
+		}
+		_, err = chunks.CreateBucket(chunkKey(target.Index))
+		return cothority.ErrorOrNil(err, "creating bucket")
+	})
+	if err != nil {
+		return false, xerrors.Errorf("tx error: %v", err)
+	}
+	s.target = target
+	s.recording = true
+	s.failed = nil
+	s.pending.Add(1)
+	return true, nil
+}
+
+func (s *snapshotStorage) finish() {
+	s.Lock()
+	s.target = nil
+	s.recording = false
+	s.Unlock()
+	s.pending.Done()
+}
+
+// stopRecording is called once the whole trie is copied into the target, and
+// returns the error of recordUndo, if any.
+func (s *snapshotStorage) stopRecording() error {
+	s.Lock()
+	defer s.Unlock()
+	s.recording = false
+	return s.failed
+}
+
+// recordUndo keeps the nodes of the trie that are replaced when storing the
+// state changes, which are the nodes on the paths of the changed keys. So the
+// nodes of the trie at the block of the target can still be copied after the
+// following blocks. It must be called with the trie locked.
+func (s *snapshotStorage) recordUndo(sid skipchain.SkipBlockID, st *stateTrie,
+	scs StateChanges) {
+	s.Lock()
+	recording := s.recording && s.target.ByzCoinID.Equal(sid)
+	s.Unlock()
+	if !recording || len(scs) == 0 {
+		return
+	}
+
+	err := func() error {
+		keys := make([][]byte, len(scs))
+		for i, sc := range scs {
+			keys[i] = sc.InstanceID
+		}
+		// The trie cannot be read in the transaction of the undo
+		// bucket, as both are in the same DB.
+		var nodes []DBKeyValue
+		err := st.ForEachPathNode(keys, func(k, v []byte) error {
+			nodes = append(nodes, DBKeyValue{Key: k, Value: v})
+			return nil
+		})
+		if err != nil {
+			return xerrors.Errorf("reading trie: %v", err)
+		}
+		return s.db.Update(func(tx *bbolt.Tx) error {
+			undo := tx.Bucket(s.bucket).Bucket(sid).Bucket(bucketSnapshotUndo)
+			for _, node := range nodes {
+				// Only the first value is the one of the block, even
+				// though a node never changes under the same key.
+				if undo.Get(node.Key) != nil {
+					continue
+				}
+				if err := undo.Put(node.Key, node.Value); err != nil {
+					return xerrors.Errorf("writing undo: %v", err)
+				}
+			}
+			return nil
+		})
+	}()
+	if err != nil {
+		s.Lock()
+		s.failed = err
+		s.Unlock()
+	}
+}
+
+// copyBatch reads the next nodes of the trie of the target, at most
+// snapshotChunkEntries of them. The nodes are visited from the root in
+// depth-first order, and todo holds the keys of the nodes left to visit. A
+// node replaced since the block of the target is read from the ones kept by
+// recordUndo. It returns the nodes and the keys left to visit, and must be
+// called with the trie locked.
+func (s *snapshotStorage) copyBatch(snap *Snapshot, trieBucket []byte,
+	todo [][]byte) ([]DBKeyValue, [][]byte, error) {
+	var kvs []DBKeyValue
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		// The buckets of the service are all in the same DB.
+		nodes := tx.Bucket(trieBucket)
+		if nodes == nil {
+			return xerrors.New("missing trie bucket")
+		}
+		undo := tx.Bucket(s.bucket).Bucket(snap.ByzCoinID).Bucket(bucketSnapshotUndo)
+		for len(todo) > 0 && len(kvs) < snapshotChunkEntries {
+			key := todo[len(todo)-1]
+			todo = todo[:len(todo)-1]
+			value := nodes.Get(key)
+			if value == nil {
+				value = undo.Get(key)
+			}
+			if value == nil {
+				return xerrors.Errorf("missing node %x", key)
+			}
+			children, err := trie.NodeChildren(value)
+			if err != nil {
+				return xerrors.Errorf("decoding node: %v", err)
+			}
+			// The left child is visited first.
+			for i := len(children) - 1; i >= 0; i-- {
+				todo = append(todo, children[i])
+			}
+			kvs = append(kvs, DBKeyValue{
+				Key:   append([]byte{}, key...),
+				Value: append([]byte{}, value...),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, xerrors.Errorf("tx error: %v", err)
+	}
+	return kvs, todo, nil
+}
+
+// writeChunk writes the nodes as the next chunk of the target.
+func (s *snapshotStorage) writeChunk(snap *Snapshot, kvs []DBKeyValue) error {
+	buf, err := protobuf.Encode(&GetSnapshotChunkResponse{KeyValues: kvs})
+	if err != nil {
+		return xerrors.Errorf("encoding: %v", err)
+	}
+	err = s.db.Update(func(tx *bbolt.Tx) error {
+		chunks := tx.Bucket(s.bucket).Bucket(snap.ByzCoinID).
+			Bucket(bucketSnapshotChunks).Bucket(chunkKey(snap.Index))
+		return cothority.ErrorOrNil(chunks.Put(chunkKey(len(snap.ChunkHashes)), buf),
+			"writing chunk")
+	})
+	if err != nil {
+		return xerrors.Errorf("tx error: %v", err)
+	}
+	snap.ChunkHashes = append(snap.ChunkHashes, snapshotChunkHash(kvs))
+	snap.Total += len(kvs)
+	return nil
+}
+
+// publish replaces the snapshot of the chain with the target, whose chunks
+// are written, and removes the previous one.
+func (s *snapshotStorage) publish(snap *Snapshot) error {
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket).Bucket(snap.ByzCoinID)
+		if err := b.DeleteBucket(bucketSnapshotUndo); err != nil {
+			return xerrors.Errorf("deleting bucket: %v", err)
+		}
+		chunks := b.Bucket(bucketSnapshotChunks)
+		var old [][]byte
+		err := chunks.ForEach(func(k, v []byte) error {
+			if !bytes.Equal(k, chunkKey(snap.Index)) {
+				old = append(old, append([]byte{}, k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return xerrors.Errorf("reading chunks: %v", err)
+		}
+		for _, k := range old {
+			if err := chunks.DeleteBucket(k); err != nil {
+				return xerrors.Errorf("deleting old chunks: %v", err)
+			}
+		}
+
+		buf, err := protobuf.Encode(snap)
+		if err != nil {
+			return xerrors.Errorf("encoding: %v", err)
+		}
+		return cothority.ErrorOrNil(b.Put(keySnapshotManifest, buf),
+			"writing manifest")
+	})
+	return cothority.ErrorOrNil(err, "tx error")
+}
+
+// setSignature stores the signature of the roster in the description of the
+// snapshot, if it is still the latest one.
+func (s *snapshotStorage) setSignature(snap *Snapshot) error {
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket).Bucket(snap.ByzCoinID)
+		var latest Snapshot
+		if err := protobuf.Decode(b.Get(keySnapshotManifest), &latest); err != nil {
+			return xerrors.Errorf("decoding: %v", err)
+		}
+		if !bytes.Equal(latest.Hash(), snap.Hash()) {
+			return xerrors.New("the snapshot has been replaced")
+		}
+		buf, err := protobuf.Encode(snap)
+		if err != nil {
+			return xerrors.Errorf("encoding: %v", err)
+		}
+		return cothority.ErrorOrNil(b.Put(keySnapshotManifest, buf),
+			"writing manifest")
+	})
+	return cothority.ErrorOrNil(err, "tx error")
+}
+
+// getManifest returns the description of the snapshot of the chain, or nil if
+// there is none.
+func (s *snapshotStorage) getManifest(sid skipchain.SkipBlockID) (snap *Snapshot, err error) {
+	err = s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket).Bucket(sid)
+		if b == nil || b.Get(keySnapshotManifest) == nil {
+			return nil
+		}
+		snap = &Snapshot{}
+		return cothority.ErrorOrNil(protobuf.Decode(b.Get(keySnapshotManifest), snap),
+			"decoding")
+	})
+	return
+}
+
+// getChunk returns the encoded chunk of the snapshot of the chain at the
+// given index.
+func (s *snapshotStorage) getChunk(sid skipchain.SkipBlockID, index int,
+	chunk int) (buf []byte, err error) {
+	err = s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket).Bucket(sid)
+		if b == nil || b.Get(keySnapshotManifest) == nil {
+			return ErrorNoSnapshot
+		}
+		var snap Snapshot
+		if err := protobuf.Decode(b.Get(keySnapshotManifest), &snap); err != nil {
+			return xerrors.Errorf("decoding: %v", err)
+		}
+		if snap.Index != index {
+			return xerrors.Errorf("snapshot of block %d is not available "+
+				"anymore", index)
+		}
+		chunks := b.Bucket(bucketSnapshotChunks).Bucket(chunkKey(index))
+		if chunks != nil {
+			buf = append([]byte{}, chunks.Get(chunkKey(chunk))...)
+		}
+		if len(buf) == 0 {
+			return xerrors.New("unknown chunk")
+		}
+		return nil
+	})
+	return
+}
+
+func chunkKey(chunk int) []byte {
+	key := make([]byte, 4)
+	binary.BigEndian.PutUint32(key, uint32(chunk))
+	return key
+}
+
+// snapshotChunkHash returns the sha256 of the key/value pairs of a chunk.
+func snapshotChunkHash(kvs []DBKeyValue) []byte {
+	h := sha256.New()
+	for _, kv := range kvs {
+		binary.Write(h, binary.LittleEndian, uint32(len(kv.Key)))
+		h.Write(kv.Key)
+		binary.Write(h, binary.LittleEndian, uint32(len(kv.Value)))
+		h.Write(kv.Value)
+	}
+	return h.Sum(nil)
+}
+
+// Hash returns the sha256 of the snapshot description, which is the message
+// signed by the roster, so the signature itself is left out.
+func (snap Snapshot) Hash() []byte {
+	snap.Signature = nil
+	buf, err := protobuf.Encode(&snap)
+	if err != nil {
+		log.Lvl2("Couldn't marshal snapshot")
+	}
+	h := sha256.Sum256(buf)
+	return h[:]
+}
+
+// takeSnapshot starts a snapshot of the state trie if the block is at the
+// interval. It must be called with the trie at the block: the nodes of the
+// trie are then copied in the background, and the nodes replaced by the
+// following blocks are kept until the copy is done. If the previous snapshot
+// is still being taken, this one is skipped.
+func (s *Service) takeSnapshot(sb *skipchain.SkipBlock, header *DataHeader) {
+	if snapshotInterval <= 0 || sb.Index == 0 || sb.Index%snapshotInterval != 0 {
+		return
+	}
+	st, err := s.getStateTrie(sb.SkipChainID())
+	if err != nil {
+		log.Errorf("%s: couldn't start snapshot of block %d: %v",
+			s.ServerIdentity(), sb.Index, err)
+		return
+	}
+	nonce, err := st.GetNonce()
+	if err != nil {
+		log.Errorf("%s: couldn't start snapshot of block %d: %v",
+			s.ServerIdentity(), sb.Index, err)
+		return
+	}
+	snap := &Snapshot{
+		ByzCoinID: sb.SkipChainID(),
+		Index:     sb.Index,
+		BlockID:   sb.Hash,
+		TrieRoot:  header.TrieRoot,
+		Nonce:     nonce,
+	}
+	if !s.tasks.add(1) {
+		return
+	}
+	ok, err := s.snapshotStorage.start(snap)
+	if err != nil || !ok {
+		if err != nil {
+			log.Errorf("%s: couldn't start snapshot of block %d: %v",
+				s.ServerIdentity(), sb.Index, err)
+		} else {
+			log.Warnf("%s: skipping snapshot of block %d, the previous "+
+				"one is still running", s.ServerIdentity(), sb.Index)
+		}
+		s.tasks.done()
+		return
+	}
+
+	go func() {
+		defer s.tasks.done()
+		defer s.snapshotStorage.finish()
+		if err := s.copySnapshot(snap); err != nil {
+			log.Errorf("%s: couldn't take snapshot of block %d: %v",
+				s.ServerIdentity(), sb.Index, err)
+			return
+		}
+		log.Lvlf2("%s: stored snapshot of block %d with %d nodes in %d chunks",
+			s.ServerIdentity(), sb.Index, snap.Total, len(snap.ChunkHashes))
+
+		// Only the leader of the block asks the roster to sign the
+		// snapshot.
+		if !sb.Roster.List[0].Equal(s.ServerIdentity()) {
+			return
+		}
+		if err := s.signSnapshot(sb.Roster, snap); err != nil {
+			log.Warnf("%s: couldn't sign snapshot of block %d: %v",
+				s.ServerIdentity(), sb.Index, err)
+		}
+	}()
+}
+
+// copySnapshot copies the nodes of the trie at the block of the target into
+// its chunks, holding the trie only while reading one chunk.
+func (s *Service) copySnapshot(snap *Snapshot) error {
+	todo := [][]byte{snap.TrieRoot}
+	for len(todo) > 0 {
+		if !s.tasks.areTasksAllowed() {
+			return xerrors.New("the service is closing")
+		}
+		var kvs []DBKeyValue
+		var err error
+		kvs, todo, err = s.copySnapshotBatch(snap, todo)
+		if err != nil {
+			return xerrors.Errorf("copying trie: %v", err)
+		}
+		if err := s.snapshotStorage.writeChunk(snap, kvs); err != nil {
+			return xerrors.Errorf("writing chunk: %v", err)
+		}
+	}
+	// The following blocks can change the trie freely once the whole trie
+	// is copied.
+	s.updateTrieMutex.Lock()
+	err := s.snapshotStorage.stopRecording()
+	s.updateTrieMutex.Unlock()
+	if err != nil {
+		return xerrors.Errorf("keeping the nodes at the block: %v", err)
+	}
+	return cothority.ErrorOrNil(s.snapshotStorage.publish(snap), "publishing")
+}
+
+func (s *Service) copySnapshotBatch(snap *Snapshot,
+	todo [][]byte) ([]DBKeyValue, [][]byte, error) {
+	s.updateTrieMutex.Lock()
+	defer s.updateTrieMutex.Unlock()
+	_, bucket := s.GetAdditionalBucket([]byte(fmt.Sprintf("%x", snap.ByzCoinID)))
+	return s.snapshotStorage.copyBatch(snap, bucket, todo)
+}
+
+// signSnapshot asks the roster of the block of the snapshot to sign its
+// description, every node checking it against its own snapshot, and stores
+// the signature.
+func (s *Service) signSnapshot(roster *onet.Roster, snap *Snapshot) error {
+	proto, err := s.CreateProtocol(snapshotFtCosi, roster.GenerateBinaryTree())
+	if err != nil {
+		return xerrors.Errorf("creating protocol: %v", err)
+	}
+	payload, err := protobuf.Encode(snap)
+	if err != nil {
+		return xerrors.Errorf("encoding snapshot: %v", err)
+	}
+
+	cosiProto := proto.(*protocol.BlsCosi)
+	cosiProto.Msg = snap.Hash()
+	cosiProto.Data = payload
+	cosiProto.CreateProtocol = s.CreateProtocol
+	cosiProto.Timeout = snapshotSignTimeout
+	if err := cosiProto.Start(); err != nil {
+		return xerrors.Errorf("starting protocol: %v", err)
+	}
+	// The protocol always sends the signature because it has a timeout.
+	sig := <-cosiProto.FinalSignature
+	if len(sig) == 0 {
+		return xerrors.New("the roster refused to sign")
+	}
+	snap.Signature = sig
+	return cothority.ErrorOrNil(s.snapshotStorage.setSignature(snap),
+		"storing signature")
+}
+
+// verifySnapshot is registered in the snapshot ftcosi. The node signs the
+// snapshot if it is the same as its own snapshot of the block, waiting for
+// the latter to be taken.
+func (s *Service) verifySnapshot(msg []byte, data []byte) bool {
+	var snap Snapshot
+	if err := protobuf.Decode(data, &snap); err != nil {
+		log.Error(s.ServerIdentity(), err)
+		return false
+	}
+	if !bytes.Equal(msg, snap.Hash()) {
+		log.Error(s.ServerIdentity(), "digest doesn't verify")
+		return false
+	}
+
+	deadline := time.Now().Add(snapshotSignTimeout / 2)
+	for time.Now().Before(deadline) {
+		own, err := s.snapshotStorage.getManifest(snap.ByzCoinID)
+		if err != nil {
+			log.Error(s.ServerIdentity(), err)
+			return false
+		}
+		if own == nil || own.Index < snap.Index {
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		if !bytes.Equal(own.Hash(), msg) {
+			log.Errorf("%s: refusing to sign snapshot of block %d, which "+
+				"is not the same as ours", s.ServerIdentity(), snap.Index)
+			return false
+		}
+		return true
+	}
+	log.Warnf("%s: no snapshot of block %d to compare with",
+		s.ServerIdentity(), snap.Index)
+	return false
+}
+
+// GetSnapshot returns the description of the latest snapshot of the chain.
+func (s *Service) GetSnapshot(req *GetSnapshot) (*GetSnapshotResponse, error) {
+	snap, err := s.snapshotStorage.getManifest(req.ByzCoinID)
+	if err != nil {
+		return nil, xerrors.Errorf("reading snapshot: %v", err)
+	}
+	if snap == nil {
+		return nil, ErrorNoSnapshot
+	}
+	return &GetSnapshotResponse{Snapshot: *snap}, nil
+}
+
+// GetSnapshotChunk returns one chunk of the latest snapshot of the chain.
+func (s *Service) GetSnapshotChunk(req *GetSnapshotChunk) (*GetSnapshotChunkResponse, error) {
+	buf, err := s.snapshotStorage.getChunk(req.ByzCoinID, req.Index, req.Chunk)
+	if err != nil {
+		return nil, xerrors.Errorf("reading chunk: %w", err)
+	}
+	resp := &GetSnapshotChunkResponse{}
+	if err := protobuf.Decode(buf, resp); err != nil {
+		return nil, xerrors.Errorf("decoding chunk: %v", err)
+	}
+	return resp, nil
+}
+
+// downloadSnapshot downloads the most recent snapshot signed by the roster of
+// its block into the bucket, which must be empty. The block is checked against
+// the genesis block, and every chunk against its hash before its nodes are
+// stored, asking another node holding the snapshot if the check fails.
+func (s *Service) downloadSnapshot(sb *skipchain.SkipBlock, db *bbolt.DB,
+	bucketName []byte) (*stateTrie, error) {
+	cl := NewClient(sb.SkipChainID(), *sb.Roster)
+	defer cl.Close()
+
+	holders := make(map[string][]*network.ServerIdentity)
+	var signed []Snapshot
+	for _, si := range sb.Roster.List {
+		if si.Equal(s.ServerIdentity()) {
+			continue
+		}
+		resp, err := cl.GetSnapshot(si)
+		if err != nil {
+			log.Lvlf2("%s: no snapshot from %s: %v", s.ServerIdentity(), si, err)
+			continue
+		}
+		if !resp.Snapshot.ByzCoinID.Equal(sb.SkipChainID()) {
+			continue
+		}
+		key := string(resp.Snapshot.Hash())
+		holders[key] = append(holders[key], si)
+		if len(resp.Snapshot.Signature) > 0 {
+			signed = append(signed, resp.Snapshot)
+		}
+	}
+	sort.SliceStable(signed, func(i, j int) bool {
+		return signed[i].Index > signed[j].Index
+	})
+
+	var snap *Snapshot
+	var header *DataHeader
+	for i := range signed {
+		var err error
+		header, err = s.verifySnapshotSignature(sb, &signed[i])
+		if err != nil {
+			log.Warnf("%s: wrong snapshot of block %d: %v",
+				s.ServerIdentity(), signed[i].Index, err)
+			continue
+		}
+		snap = &signed[i]
+		break
+	}
+	if snap == nil {
+		return nil, ErrorNoSnapshot
+	}
+
+	nodesDB := trie.NewDiskDB(db, bucketName)
+	nodes := holders[string(snap.Hash())]
+	for i, hash := range snap.ChunkHashes {
+		var kvs []DBKeyValue
+		for try := 0; try < len(nodes) && kvs == nil; try++ {
+			si := nodes[(i+try)%len(nodes)]
+			resp, err := cl.GetSnapshotChunk(si, snap.Index, i)
+			if err != nil {
+				log.Warnf("%s: couldn't get chunk %d from %s: %v",
+					s.ServerIdentity(), i, si, err)
+				continue
+			}
+			if !bytes.Equal(snapshotChunkHash(resp.KeyValues), hash) {
+				log.Warnf("%s: got a wrong chunk %d from %s",
+					s.ServerIdentity(), i, si)
+				continue
+			}
+			kvs = resp.KeyValues
+		}
+		if kvs == nil {
+			return nil, xerrors.Errorf("couldn't get chunk %d", i)
+		}
+		err := nodesDB.Update(func(b trie.Bucket) error {
+			for _, kv := range kvs {
+				if err := b.Put(kv.Key, kv.Value); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, xerrors.Errorf("couldn't store entries: %v", err)
+		}
+		log.Lvlf1("Downloaded chunk %d of %d of the snapshot of block %d",
+			i+1, len(snap.ChunkHashes), snap.Index)
+	}
+
+	t, err := trie.NewTrieFromNodes(nodesDB, snap.Nonce, snap.TrieRoot)
+	if err != nil {
+		return nil, xerrors.Errorf("creating trie: %v", err)
+	}
+	st := &stateTrie{Trie: *t}
+	err = st.VerifiedStoreAll(nil, snap.Index, header.Version, snap.TrieRoot)
+	if err != nil {
+		return nil, xerrors.Errorf("snapshot doesn't match its root: %v", err)
+	}
+	return st, nil
+}
+
+// verifySnapshotSignature checks that the snapshot is signed by the roster of
+// its block, and that it matches the block, and returns the header of the
+// block.
+func (s *Service) verifySnapshotSignature(sb *skipchain.SkipBlock,
+	snap *Snapshot) (*DataHeader, error) {
+	block, err := s.verifiedBlockByIndex(sb, snap.Index)
+	if err != nil {
+		return nil, xerrors.Errorf("getting block of snapshot: %v", err)
+	}
+	header, err := decodeBlockHeader(block)
+	if err != nil {
+		return nil, xerrors.Errorf("decoding header: %v", err)
+	}
+	if !block.Hash.Equal(snap.BlockID) ||
+		!bytes.Equal(header.TrieRoot, snap.TrieRoot) {
+		return nil, xerrors.New("snapshot doesn't match its block")
+	}
+	err = protocol.BlsSignature(snap.Signature).Verify(pairingSuite,
+		snap.Hash(), block.Roster.ServicePublics(ServiceName))
+	return header, cothority.ErrorOrNil(err, "verifying signature")
+}
+
+// verifiedBlockByIndex fetches the block of the chain with the given index
+// and checks the forward links going from the genesis block to it.
+func (s *Service) verifiedBlockByIndex(sb *skipchain.SkipBlock,
+	index int) (*skipchain.SkipBlock, error) {
+	skCl := skipchain.NewClient()
+	skCl.DontContact(s.ServerIdentity())
+	genesis := s.db().GetByID(sb.SkipChainID())
+	if genesis == nil {
+		var err error
+		genesis, err = skCl.GetSingleBlock(sb.Roster, sb.SkipChainID())
+		if err != nil {
+			return nil, xerrors.Errorf("getting genesis block: %v", err)
+		}
+		if !genesis.CalculateHash().Equal(sb.SkipChainID()) {
+			return nil, xerrors.New("got a wrong genesis block")
+		}
+	}
+
+	reply, err := skCl.GetSingleBlockByIndex(sb.Roster, sb.SkipChainID(), index)
+	if err != nil {
+		return nil, xerrors.Errorf("getting block: %v", err)
+	}
+	links := []skipchain.ForwardLink{{
+		From:      []byte{},
+		To:        genesis.Hash,
+		NewRoster: genesis.Roster,
+	}}
+	for _, l := range reply.Links {
+		links = append(links, *l)
+	}
+	err = verifyForwardLinks(links, genesis.Hash, reply.SkipBlock)
+	return reply.SkipBlock, cothority.ErrorOrNil(err, "verifying links")
+}
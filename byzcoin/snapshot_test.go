@@ -0,0 +1,147 @@
+package byzcoin
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin/trie"
+)
+
+func TestService_Snapshot(t *testing.T) {
+	interval, entries := snapshotInterval, snapshotChunkEntries
+	defer func() {
+		snapshotInterval, snapshotChunkEntries = interval, entries
+	}()
+	snapshotInterval = 2
+	snapshotChunkEntries = 10
+
+	b := newBCT(t, nil)
+	b.CreateByzCoin()
+	defer b.CloseAll()
+	for i := 0; i < 3; i++ {
+		b.SpawnDummy(nil)
+	}
+	// The snapshots are taken in the background.
+	for _, s := range b.Services {
+		s.snapshotStorage.pending.Wait()
+	}
+
+	resp, err := b.Services[1].GetSnapshot(&GetSnapshot{
+		ByzCoinID: b.Genesis.SkipChainID(),
+	})
+	require.NoError(t, err)
+	snap := resp.Snapshot
+	require.Equal(t, 2, snap.Index)
+	require.True(t, len(snap.ChunkHashes) > 1)
+
+	chunk, err := b.Services[1].GetSnapshotChunk(&GetSnapshotChunk{
+		ByzCoinID: b.Genesis.SkipChainID(),
+		Index:     snap.Index,
+		Chunk:     1,
+	})
+	require.NoError(t, err)
+	require.Equal(t, snap.ChunkHashes[1], snapshotChunkHash(chunk.KeyValues))
+	_, err = b.Services[1].GetSnapshotChunk(&GetSnapshotChunk{
+		ByzCoinID: b.Genesis.SkipChainID(),
+		Index:     snap.Index - 1,
+	})
+	require.Error(t, err)
+
+	// All the nodes have the same snapshot, which is signed by the roster
+	// and stored with the signature by the leader.
+	require.Empty(t, snap.Signature)
+	resp, err = b.Services[0].GetSnapshot(&GetSnapshot{
+		ByzCoinID: b.Genesis.SkipChainID(),
+	})
+	require.NoError(t, err)
+	require.Equal(t, snap.Hash(), resp.Snapshot.Hash())
+	signed := resp.Snapshot
+	require.NotEmpty(t, signed.Signature)
+
+	latest, err := b.Services[0].db().GetLatestByID(b.Genesis.Hash)
+	require.NoError(t, err)
+	_, err = b.Services[2].verifySnapshotSignature(latest, &signed)
+	require.NoError(t, err)
+	signed.ChunkHashes = signed.ChunkHashes[1:]
+	_, err = b.Services[2].verifySnapshotSignature(latest, &signed)
+	require.Error(t, err)
+	st0, err := b.Services[0].getStateTrie(b.Genesis.SkipChainID())
+	require.NoError(t, err)
+
+	// A new node gets the trie of the snapshot.
+	servers, _, _ := b.Local.MakeSRS(cothority.Suite, 2, ByzCoinID)
+	services := b.Local.GetServices(servers, ByzCoinID)
+	service := services[0].(*Service)
+	db, bucket := service.GetAdditionalBucket(
+		[]byte(fmt.Sprintf("%x", b.Genesis.SkipChainID())))
+	st, err := service.downloadSnapshot(latest, db, bucket)
+	require.NoError(t, err)
+	require.Equal(t, snap.Index, st.GetIndex())
+	require.Equal(t, snap.TrieRoot, st.GetRoot())
+
+	// The blocks following the snapshot are replayed.
+	service = services[1].(*Service)
+	require.NoError(t, service.downloadDB(latest))
+	st, err = service.getStateTrie(b.Genesis.SkipChainID())
+	require.NoError(t, err)
+	require.Equal(t, latest.Index, st.GetIndex())
+	require.Equal(t, st0.GetRoot(), st.GetRoot())
+}
+
+func TestService_SnapshotUndo(t *testing.T) {
+	interval, entries := snapshotInterval, snapshotChunkEntries
+	defer func() {
+		snapshotInterval, snapshotChunkEntries = interval, entries
+	}()
+	snapshotInterval = 0
+	snapshotChunkEntries = 2
+
+	b := newBCT(t, nil)
+	b.CreateByzCoin()
+	defer b.CloseAll()
+	b.SpawnDummy(nil)
+
+	s := b.Services[0]
+	st, err := s.getStateTrie(b.Genesis.SkipChainID())
+	require.NoError(t, err)
+	nonce, err := st.GetNonce()
+	require.NoError(t, err)
+	snap := &Snapshot{
+		ByzCoinID: b.Genesis.SkipChainID(),
+		Index:     st.GetIndex(),
+		TrieRoot:  st.GetRoot(),
+		Nonce:     nonce,
+	}
+	ok, err := s.snapshotStorage.start(snap)
+	require.NoError(t, err)
+	require.True(t, ok)
+	defer s.snapshotStorage.finish()
+
+	// The blocks following the start of the snapshot change the trie, and
+	// the snapshot keeps the nodes replaced by them.
+	b.SpawnDummy(nil)
+	b.SpawnDummy(nil)
+	require.NotEqual(t, snap.TrieRoot, st.GetRoot())
+	require.NoError(t, s.copySnapshot(snap))
+
+	db := trie.NewMemDB()
+	for i := range snap.ChunkHashes {
+		chunk, err := s.GetSnapshotChunk(&GetSnapshotChunk{
+			ByzCoinID: snap.ByzCoinID,
+			Index:     snap.Index,
+			Chunk:     i,
+		})
+		require.NoError(t, err)
+		require.NoError(t, db.Update(func(b trie.Bucket) error {
+			for _, kv := range chunk.KeyValues {
+				require.NoError(t, b.Put(kv.Key, kv.Value))
+			}
+			return nil
+		}))
+	}
+	copied, err := trie.NewTrieFromNodes(db, snap.Nonce, snap.TrieRoot)
+	require.NoError(t, err)
+	require.NoError(t, copied.IsValid())
+}
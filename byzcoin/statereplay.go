@@ -122,22 +122,31 @@ func (s *Service) ReplayState(id skipchain.SkipBlockID,
 
 			var scs StateChanges
 			txAccepted := 0
+			// The transactions are also refused once the gas limit of the
+			// block is reached, like in createStateChanges.
+			var blockGas uint64
+			_, maxGas := gasLimits(sst)
 			for _, tx := range dBody.TxResults {
+				scsTmp, sstTmp, gasUsed, err := s.processOneTx(sst,
+					tx.ClientTransaction, id, dHead.Timestamp, nil)
+				if gasUsed != tx.GasUsed {
+					return nil, replayError(sb, xerrors.Errorf(
+						"transaction used %d gas instead of %d", gasUsed,
+						tx.GasUsed))
+				}
+				if err == nil && maxGas > 0 && blockGas+gasUsed > maxGas {
+					err = xerrors.Errorf("block gas limit of %d reached", maxGas)
+				}
 				if tx.Accepted {
-					txAccepted++
-					var scsTmp StateChanges
-					scsTmp, sst, err = s.processOneTx(sst, tx.ClientTransaction,
-						id, dHead.Timestamp)
 					if err != nil {
 						return nil, replayError(sb, err)
 					}
-
+					txAccepted++
+					sst = sstTmp
+					blockGas += gasUsed
 					scs = append(scs, scsTmp...)
-				} else {
-					_, _, err = s.processOneTx(sst, tx.ClientTransaction, id, dHead.Timestamp)
-					if err == nil {
-						return nil, replayError(sb, xerrors.New("refused transaction passes"))
-					}
+				} else if err == nil {
+					return nil, replayError(sb, xerrors.New("refused transaction passes"))
 				}
 			}
 
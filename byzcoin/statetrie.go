@@ -73,6 +73,9 @@ type globalState struct {
 	ReadOnlyStateTrie
 	ReadOnlySkipChain
 	TimeReader
+	// calls is set if the contracts can call other instances, see
+	// NewCallContext.
+	calls *callEnv
 }
 
 var _ GlobalState = (*globalState)(nil)
@@ -83,16 +86,39 @@ type stagingStateTrie struct {
 	trie.StagingTrie
 	trieCache
 	sync.Mutex
+	// reads is set when the keys read from the trie need to be recorded,
+	// e.g., for a speculative execution of a transaction.
+	reads *readTracker
 }
 
 // Clone makes a copy of the staged data of the structure, the source Trie is
-// not copied.
+// not copied. The clone records its reads in the same readTracker.
 func (t *stagingStateTrie) Clone() *stagingStateTrie {
 	return &stagingStateTrie{
 		StagingTrie: *t.StagingTrie.Clone(),
+		reads:       t.reads,
 	}
 }
 
+// Get returns the value stored for the key, or nil if the key is not set.
+func (t *stagingStateTrie) Get(key []byte) ([]byte, error) {
+	t.reads.read(key)
+	return t.StagingTrie.Get(key)
+}
+
+// GetProof returns a proof for the key. As the proof depends on the other
+// keys of the trie, the whole trie is considered read.
+func (t *stagingStateTrie) GetProof(key []byte) (*trie.Proof, error) {
+	t.reads.readAll()
+	return t.StagingTrie.GetProof(key)
+}
+
+// ForEach calls the callback for every key/value pair of the trie.
+func (t *stagingStateTrie) ForEach(cb func(k, v []byte) error) error {
+	t.reads.readAll()
+	return t.StagingTrie.ForEach(cb)
+}
+
 // StoreAll puts all the state changes and the index in the staging area.
 func (t *stagingStateTrie) StoreAll(scs StateChanges) error {
 	t.Lock()
@@ -295,6 +321,16 @@ func loadStateTrie(db *bbolt.DB, bucket []byte) (*stateTrie, error) {
 	return &stateTrie{Trie: *t}, nil
 }
 
+// loadReadOnlyStateTrie loads the StateTrie as it is in the read-only
+// transaction tx. The trie cannot be updated, and closing it rolls back tx.
+func loadReadOnlyStateTrie(tx *bbolt.Tx, bucket []byte) (*stateTrie, error) {
+	t, err := trie.LoadTrie(trie.NewTxDB(tx, bucket))
+	if err != nil {
+		return nil, xerrors.Errorf("loading trie: %v", err)
+	}
+	return &stateTrie{Trie: *t}, nil
+}
+
 // newStateTrie creates a new, disk-based trie.Trie, an error is returned if
 // the db already contains a trie.
 func newStateTrie(db *bbolt.DB, bucket, nonce []byte) (*stateTrie, error) {
@@ -1,11 +1,15 @@
 package byzcoin
 
 import (
+	"bytes"
 	"fmt"
 	"sync"
 
 	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3/log"
 	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
 )
 
 const (
@@ -84,6 +88,17 @@ func (s *streamingManager) stopListener(scID string, outChan chan *StreamingResp
 	}
 }
 
+// removeListener works like stopListener, but doesn't block if a block is
+// sent to the listener in the meantime.
+func (s *streamingManager) removeListener(scID string,
+	outChan chan *StreamingResponse) {
+	go func() {
+		for range outChan {
+		}
+	}()
+	s.stopListener(scID, outChan)
+}
+
 func (s *streamingManager) stopAll() {
 	s.Lock()
 	defer s.Unlock()
@@ -99,12 +114,23 @@ func (s *streamingManager) stopAll() {
 }
 
 // StreamTransactions will stream all transactions IDs to the client until the
-// client closes the connection.
+// client closes the connection. If the request has filters, only the
+// matching blocks are sent, or only the matching transactions if
+// TxResultsOnly is set. If the request has a start block, the stored blocks
+// are sent first, starting with this block, followed by the new blocks.
 func (s *Service) StreamTransactions(msg *StreamingRequest) (chan *StreamingResponse, chan bool, error) {
 	stopChan := make(chan bool)
 	key := string(msg.ID)
-	outChan := s.streamingMan.newListener(key)
+	next, err := s.streamStartIndex(msg)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("getting start block: %v", err)
+	}
 
+	if next >= 0 || msg.hasFilters() || msg.TxResultsOnly {
+		return s.streamFilteredTransactions(msg, next, stopChan)
+	}
+
+	outChan := s.streamingMan.newListener(key)
 	go func() {
 		if !s.tasks.add(1) {
 			return
@@ -120,6 +146,280 @@ func (s *Service) StreamTransactions(msg *StreamingRequest) (chan *StreamingResp
 	return outChan, stopChan, nil
 }
 
+// streamStartIndex returns the index of the first stored block to send, or
+// -1 if only the new blocks are sent.
+func (s *Service) streamStartIndex(msg *StreamingRequest) (int, error) {
+	switch {
+	case msg.StartID != nil:
+		sb := s.db().GetByID(msg.StartID)
+		if sb == nil || !sb.SkipChainID().Equal(msg.ID) {
+			return 0, xerrors.New("start block is not in the chain")
+		}
+		return sb.Index, nil
+	case msg.StartIndex != nil:
+		if *msg.StartIndex < 0 {
+			return 0, xerrors.New("negative start index")
+		}
+		return *msg.StartIndex, nil
+	}
+	return -1, nil
+}
+
+// streamFilteredTransactions sends the responses of msg for the blocks,
+// starting with the stored block at index next if next >= 0. Every block is
+// sent once, in the order of the chain: the stored blocks are sent before
+// listening to the new blocks, and the blocks stored while the listener is
+// added are fetched from the db.
+func (s *Service) streamFilteredTransactions(msg *StreamingRequest,
+	next int, stopChan chan bool) (chan *StreamingResponse, chan bool, error) {
+	key := string(msg.ID)
+	outChan := make(chan *StreamingResponse)
+	send := func(sb *skipchain.SkipBlock) bool {
+		resp, err := msg.filter(sb)
+		if err != nil {
+			log.Errorf("%s: couldn't filter block %x: %v",
+				s.ServerIdentity(), sb.Hash, err)
+			return true
+		}
+		if resp == nil {
+			return true
+		}
+		select {
+		case outChan <- resp:
+			return true
+		case <-stopChan:
+			return false
+		}
+	}
+
+	// Without a start block, the new blocks are listened to right away.
+	var blocks chan *StreamingResponse
+	if next < 0 {
+		blocks = s.streamingMan.newListener(key)
+	}
+
+	go func() {
+		defer close(outChan)
+		if blocks != nil {
+			defer s.streamingMan.removeListener(key, blocks)
+		}
+		if !s.tasks.add(1) {
+			return
+		}
+		defer s.tasks.done()
+
+		if blocks == nil {
+			if !s.sendStoredBlocks(msg.ID, &next, -1, send) {
+				return
+			}
+			blocks = s.streamingMan.newListener(key)
+			defer s.streamingMan.removeListener(key, blocks)
+			if !s.sendStoredBlocks(msg.ID, &next, -1, send) {
+				return
+			}
+		}
+
+		forwardBlocks(blocks, stopChan, func(sb *skipchain.SkipBlock) bool {
+			if next >= 0 {
+				if sb.Index < next {
+					return true
+				}
+				if !s.sendStoredBlocks(msg.ID, &next, sb.Index, send) {
+					return false
+				}
+				next++
+			}
+			return send(sb)
+		})
+	}()
+	return outChan, stopChan, nil
+}
+
+// sendStoredBlocks calls send with the stored blocks of the chain, starting
+// at the index next, until the index end, excluded, or until the latest
+// block if end < 0. next is updated to the index of the following block.
+func (s *Service) sendStoredBlocks(id skipchain.SkipBlockID, next *int,
+	end int, send func(*skipchain.SkipBlock) bool) bool {
+	if end < 0 {
+		latest, err := s.db().GetLatestByID(id)
+		if err != nil {
+			log.Errorf("%s: couldn't get latest block: %v",
+				s.ServerIdentity(), err)
+			return false
+		}
+		end = latest.Index + 1
+	}
+	for ; *next < end; *next++ {
+		if !s.tasks.areTasksAllowed() {
+			return false
+		}
+		reply, err := s.skService().GetSingleBlockByIndex(
+			&skipchain.GetSingleBlockByIndex{
+				Genesis: id,
+				Index:   *next,
+			})
+		if err != nil {
+			log.Errorf("%s: couldn't get block %d: %v", s.ServerIdentity(),
+				*next, err)
+			return false
+		}
+		if s.isBodyPruned(reply.SkipBlock) {
+			log.Warnf("%s: body of block %d is pruned, stopping the stream",
+				s.ServerIdentity(), *next)
+			return false
+		}
+		if !send(reply.SkipBlock) {
+			return false
+		}
+	}
+	return true
+}
+
+// forwardBlocks calls handle with every block sent to the listener, until
+// handle returns false, stopChan is closed or the service stops.
+func forwardBlocks(blocks chan *StreamingResponse, stopChan chan bool,
+	handle func(*skipchain.SkipBlock) bool) {
+	for {
+		select {
+		case sr, ok := <-blocks:
+			// The listener is closed by the streaming manager if the
+			// service stops.
+			if !ok || !handle(sr.Block) {
+				return
+			}
+		case <-stopChan:
+			return
+		}
+	}
+}
+
+func (req *StreamingRequest) hasFilters() bool {
+	return len(req.ContractIDs) > 0 || len(req.InstanceIDs) > 0 ||
+		len(req.Signers) > 0 || req.AcceptedOnly
+}
+
+// matches returns true if the transaction passes all the filters of the
+// request. The instruction filters match if any of the instructions
+// matches.
+func (req *StreamingRequest) matches(tx TxResult) bool {
+	if req.AcceptedOnly && !tx.Accepted {
+		return false
+	}
+	instrs := tx.ClientTransaction.Instructions
+	if len(req.ContractIDs) > 0 {
+		found := false
+		for _, instr := range instrs {
+			for _, cid := range req.ContractIDs {
+				found = found || cid == instr.ContractID()
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(req.InstanceIDs) > 0 {
+		found := false
+		for _, instr := range instrs {
+			for _, iid := range req.InstanceIDs {
+				found = found || iid.Equal(instr.InstanceID)
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(req.Signers) > 0 {
+		found := false
+		for _, instr := range instrs {
+			for _, signer := range instr.SignerIdentities {
+				for i := range req.Signers {
+					found = found || signer.Equal(&req.Signers[i])
+				}
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// filter returns the response to send for the block, or nil if the block
+// doesn't hold any matching transaction.
+func (req *StreamingRequest) filter(sb *skipchain.SkipBlock) (*StreamingResponse, error) {
+	if !req.hasFilters() && !req.TxResultsOnly {
+		return &StreamingResponse{Block: sb}, nil
+	}
+	header, err := decodeBlockHeader(sb)
+	if err != nil {
+		return nil, xerrors.Errorf("decoding header: %v", err)
+	}
+	var body DataBody
+	if err := protobuf.Decode(sb.Payload, &body); err != nil {
+		return nil, xerrors.Errorf("decoding body: %v", err)
+	}
+	body.TxResults.SetVersion(header.Version)
+
+	var positions []int
+	for i, tx := range body.TxResults {
+		if req.matches(tx) {
+			positions = append(positions, i)
+		}
+	}
+	if len(positions) == 0 {
+		return nil, nil
+	}
+	if !req.TxResultsOnly {
+		return &StreamingResponse{Block: sb}, nil
+	}
+
+	// The payload is not part of the hash of the block.
+	block := sb.Copy()
+	block.Payload = nil
+	resp := &StreamingResponse{
+		Block:     block,
+		Positions: positions,
+		Summaries: body.TxResults.summaries(),
+	}
+	for _, i := range positions {
+		resp.TxResults = append(resp.TxResults, body.TxResults[i])
+	}
+	return resp, nil
+}
+
+// VerifyTxResults checks that the TxResults of a response to a request with
+// TxResultsOnly are part of the block. The integrity of the block itself is
+// not checked.
+func (sr StreamingResponse) VerifyTxResults() error {
+	if sr.Block == nil {
+		return xerrors.New("missing block")
+	}
+	header, err := decodeBlockHeader(sr.Block)
+	if err != nil {
+		return xerrors.Errorf("decoding header: %v", err)
+	}
+	if !bytes.Equal(txSummaries(sr.Summaries).hash(),
+		header.ClientTransactionHash) {
+		return xerrors.New("summaries don't match the header")
+	}
+	if len(sr.TxResults) != len(sr.Positions) {
+		return xerrors.New("wrong number of positions")
+	}
+	TxResults(sr.TxResults).SetVersion(header.Version)
+	for i, tx := range sr.TxResults {
+		p := sr.Positions[i]
+		if p < 0 || p >= len(sr.Summaries) {
+			return xerrors.New("position is out of the block")
+		}
+		sum := sr.Summaries[p]
+		if !bytes.Equal(tx.ClientTransaction.Hash(), sum.ClientTransactionHash) ||
+			tx.Accepted != sum.Accepted || tx.GasUsed != sum.GasUsed {
+			return xerrors.Errorf("transaction %d doesn't match its summary", i)
+		}
+	}
+	return nil
+}
+
 // PaginateBlocks returns blocks with pagination, ie. N asynchronous requests
 // that contain each K consecutive block. The caller is responsible for closing
 // the close chan when the caller wants to close the connection.
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3/darc"
 	"go.dedis.ch/cothority/v3/skipchain"
 )
 
@@ -392,3 +393,138 @@ func TestStreamingService_PaginateBlocks(t *testing.T) {
 
 	close(closeChan)
 }
+
+func TestStreamingRequest_Matches(t *testing.T) {
+	signer := darc.NewSignerEd25519(nil, nil)
+	tx := TxResult{
+		ClientTransaction: NewClientTransaction(CurrentVersion, Instruction{
+			InstanceID:       NewInstanceID([]byte{1}),
+			Invoke:           &Invoke{ContractID: DummyContractName},
+			SignerIdentities: []darc.Identity{signer.Identity()},
+		}),
+		Accepted: false,
+	}
+	other := darc.NewSignerEd25519(nil, nil).Identity()
+
+	require.True(t, (&StreamingRequest{}).matches(tx))
+	require.True(t, (&StreamingRequest{
+		ContractIDs: []string{ContractDarcID, DummyContractName},
+		InstanceIDs: []InstanceID{NewInstanceID([]byte{1})},
+		Signers:     []darc.Identity{other, signer.Identity()},
+	}).matches(tx))
+	require.False(t, (&StreamingRequest{AcceptedOnly: true}).matches(tx))
+	require.False(t, (&StreamingRequest{
+		ContractIDs: []string{ContractDarcID},
+	}).matches(tx))
+	require.False(t, (&StreamingRequest{
+		InstanceIDs: []InstanceID{NewInstanceID([]byte{2})},
+	}).matches(tx))
+	require.False(t, (&StreamingRequest{
+		Signers: []darc.Identity{other},
+	}).matches(tx))
+}
+
+func TestStreamingService_StreamTransactionsFiltered(t *testing.T) {
+	b := newBCTRun(t, nil)
+	defer b.CloseAll()
+
+	responses, closeChan, err := b.Services[0].StreamTransactions(
+		&StreamingRequest{
+			ID:            b.Genesis.SkipChainID(),
+			ContractIDs:   []string{DummyContractName},
+			AcceptedOnly:  true,
+			TxResultsOnly: true,
+		})
+	require.NoError(t, err)
+	defer close(closeChan)
+
+	// The block with the refused transaction is not sent.
+	txArgs := TxArgsDefault
+	txArgs.RequireSuccess = false
+	b.SendInst(&txArgs, Instruction{
+		InstanceID: NewInstanceID([]byte("unknown")),
+		Invoke: &Invoke{
+			ContractID: DummyContractName,
+			Command:    "update",
+		},
+	})
+	b.SignerCounter--
+	ctx, _ := b.SpawnDummy(nil)
+
+	var resp *StreamingResponse
+	select {
+	case resp = <-responses:
+	case <-time.After(10 * b.PropagationInterval):
+		require.Fail(t, "didn't get the transaction")
+	}
+	require.Equal(t, 2, resp.Block.Index)
+	require.Empty(t, resp.Block.Payload)
+	require.True(t, resp.Block.CalculateHash().Equal(resp.Block.Hash))
+	require.Equal(t, 1, len(resp.TxResults))
+	require.Equal(t, []int{0}, resp.Positions)
+	require.Equal(t, 1, len(resp.Summaries))
+	require.Equal(t, ctx.Instructions.Hash(),
+		resp.TxResults[0].ClientTransaction.Instructions.Hash())
+	require.NoError(t, resp.VerifyTxResults())
+
+	resp.TxResults[0].Accepted = false
+	require.Error(t, resp.VerifyTxResults())
+	resp.TxResults[0].Accepted = true
+	resp.Summaries[0].GasUsed++
+	require.Error(t, resp.VerifyTxResults())
+}
+
+func TestStreamingService_StreamTransactionsFrom(t *testing.T) {
+	b := newBCTRun(t, nil)
+	defer b.CloseAll()
+
+	b.SpawnDummy(nil)
+	b.SpawnDummy(nil)
+	sb2, err := b.Services[0].db().GetLatestByID(b.Genesis.SkipChainID())
+	require.NoError(t, err)
+	require.Equal(t, 2, sb2.Index)
+
+	start := 1
+	fromIndex, closeIndex, err := b.Services[0].StreamTransactions(
+		&StreamingRequest{
+			ID:         b.Genesis.SkipChainID(),
+			StartIndex: &start,
+		})
+	require.NoError(t, err)
+	defer close(closeIndex)
+	fromID, closeID, err := b.Services[0].StreamTransactions(
+		&StreamingRequest{
+			ID:      b.Genesis.SkipChainID(),
+			StartID: sb2.Hash,
+		})
+	require.NoError(t, err)
+	defer close(closeID)
+
+	expect := func(responses chan *StreamingResponse, index int) {
+		select {
+		case resp := <-responses:
+			require.Equal(t, index, resp.Block.Index)
+		case <-time.After(10 * b.PropagationInterval):
+			require.Fail(t, "didn't get the block")
+		}
+	}
+
+	// The stored blocks are sent first, followed by the new blocks.
+	expect(fromIndex, 1)
+	expect(fromIndex, 2)
+	expect(fromID, 2)
+	b.SpawnDummy(nil)
+	expect(fromIndex, 3)
+	expect(fromID, 3)
+	select {
+	case <-fromIndex:
+		require.Fail(t, "there shouldn't be another block")
+	case <-time.After(chanTimeout):
+	}
+
+	_, _, err = b.Services[0].StreamTransactions(&StreamingRequest{
+		ID:      b.Genesis.SkipChainID(),
+		StartID: []byte("unknown"),
+	})
+	require.Error(t, err)
+}
@@ -31,6 +31,7 @@ const cleanThreshold = 0.8
 const notificationQueueLenght = 8
 
 var bucketStateChangeStorage = []byte("statechangestorage")
+var bucketIndexSuffix = []byte("-byblock")
 var errLengthInstanceID = xerrors.New("InstanceID must have 32 bytes")
 
 // StateChangeEntry is the object stored to keep track of instance history. It
@@ -77,6 +78,8 @@ func (sc StateChange) Copy() StateChange {
 // first by instance ID and then by version so we can use the BoltDB key traversal.
 // The block index is appended only to access more efficiently to the information
 // without having to decode the value.
+// A second bucket indexes the keys by block index, then instance ID and version,
+// to find the instances changed after a block without reading all the entries.
 // The storage cleans up by itself with respect to the parameters when appending new
 // state changes. If the size goes above the limit, each skipchain is truncated by its
 // oldest block until the space threshold is reached.
@@ -118,6 +121,65 @@ func (s *stateChangeStorage) getBucket(tx *bbolt.Tx, sid skipchain.SkipBlockID)
 	return b.Bucket(sid)
 }
 
+// getIndexBucket gets the bucket indexing the keys of the given skipchain by
+// block index.
+func (s *stateChangeStorage) getIndexBucket(tx *bbolt.Tx, sid skipchain.SkipBlockID) *bbolt.Bucket {
+	name := append(append([]byte{}, s.bucket...), bucketIndexSuffix...)
+	if tx.Writable() {
+		b, err := tx.CreateBucketIfNotExists(name)
+		if err != nil {
+			panic(err)
+		}
+		sbb, err := b.CreateBucketIfNotExists(sid)
+		if err != nil {
+			panic(err)
+		}
+
+		return sbb
+	}
+
+	b := tx.Bucket(name)
+	if b == nil {
+		return nil
+	}
+	return b.Bucket(sid)
+}
+
+// indexKey takes the key of an entry and returns its key in the index, with
+// the block index first.
+func (s *stateChangeStorage) indexKey(key []byte) []byte {
+	idx := prefixLength + versionLength
+	ik := make([]byte, 0, len(key))
+	ik = append(ik, key[idx:]...)
+	return append(ik, key[:idx]...)
+}
+
+// indexBlocks creates the index of the skipchains stored before it existed.
+func (s *stateChangeStorage) indexBlocks() error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket)
+		if b == nil {
+			return xerrors.New("Missing bucket")
+		}
+		name := append(append([]byte{}, s.bucket...), bucketIndexSuffix...)
+		if tx.Bucket(name) != nil {
+			return nil
+		}
+
+		return b.ForEach(func(scid, v []byte) error {
+			scb := b.Bucket(scid)
+			if scb == nil {
+				return nil
+			}
+
+			ib := s.getIndexBucket(tx, scid)
+			return scb.ForEach(func(k, v []byte) error {
+				return ib.Put(s.indexKey(k), []byte{})
+			})
+		})
+	})
+}
+
 // setMaxSize enables the cleaning of old state changes when the storage
 // size is above a given threshold. Note that the value is not strict.
 func (s *stateChangeStorage) setMaxSize(size int) {
@@ -186,6 +248,7 @@ func (s *stateChangeStorage) cleanBySize() error {
 				if scb == nil {
 					return nil
 				}
+				ib := s.getIndexBucket(tx, scid)
 
 				// we first look for the oldest block for the skipchain
 				oldestIndex := int64(-1)
@@ -219,6 +282,9 @@ func (s *stateChangeStorage) cleanBySize() error {
 					}
 
 					if oldestIndex == idx {
+						if err := ib.Delete(s.indexKey(k)); err != nil {
+							return xerrors.Errorf("deleting index: %v", err)
+						}
 						if err := c.Delete(); err != nil {
 							return xerrors.Errorf("deleting pair: %v", err)
 						}
@@ -235,6 +301,11 @@ func (s *stateChangeStorage) cleanBySize() error {
 					if err := b.DeleteBucket(scid); err != nil {
 						return xerrors.Errorf("deleting bucket: %v", err)
 					}
+					name := append(append([]byte{}, s.bucket...),
+						bucketIndexSuffix...)
+					if err := tx.Bucket(name).DeleteBucket(scid); err != nil {
+						return xerrors.Errorf("deleting index: %v", err)
+					}
 				}
 
 				return nil
@@ -268,6 +339,7 @@ func (s *stateChangeStorage) cleanByBlock(scs StateChanges, sb *skipchain.SkipBl
 
 	err := s.db.Update(func(tx *bbolt.Tx) error {
 		b := s.getBucket(tx, sb.SkipChainID())
+		ib := s.getIndexBucket(tx, sb.SkipChainID())
 
 		// Prevent from cleaning the same instance twice
 		done := map[string]bool{}
@@ -289,6 +361,9 @@ func (s *stateChangeStorage) cleanByBlock(scs StateChanges, sb *skipchain.SkipBl
 				c := b.Cursor()
 				for k, v := c.Seek(sc.InstanceID); k != nil && bytes.HasPrefix(k, sc.InstanceID); k, v = c.Next() {
 					if bytes.Compare(k[len(k)-len(index):], index) <= 0 {
+						if err := ib.Delete(s.indexKey(k)); err != nil {
+							return xerrors.Errorf("deleting index: %v", err)
+						}
 						if err := c.Delete(); err != nil {
 							return xerrors.Errorf("deleting item: %v", err)
 						}
@@ -354,9 +429,14 @@ func (s *stateChangeStorage) append(scs StateChanges, sb *skipchain.SkipBlock) e
 
 	err = s.db.Update(func(tx *bbolt.Tx) error {
 		b := s.getBucket(tx, sb.SkipChainID())
+		ib := s.getIndexBucket(tx, sb.SkipChainID())
 
 		// append each list of state changes (or create the entry)
 		for i, sc := range scs {
+			// Events are stored in the block, not with the instances.
+			if sc.StateAction == EmitEvent {
+				continue
+			}
 			if len(sc.InstanceID) != prefixLength {
 				// as we use it as a prefix, all must have the same length
 				return cothority.WrapError(errLengthInstanceID)
@@ -386,6 +466,10 @@ func (s *stateChangeStorage) append(scs StateChanges, sb *skipchain.SkipBlock) e
 			if err != nil {
 				return xerrors.Errorf("writing item: %v", err)
 			}
+			err = ib.Put(s.indexKey(key), []byte{})
+			if err != nil {
+				return xerrors.Errorf("writing index: %v", err)
+			}
 
 			size += len(buf)
 		}
@@ -512,6 +596,69 @@ func (s *stateChangeStorage) getByBlock(sid skipchain.SkipBlockID, idx int) (ent
 	return
 }
 
+// getChangedAfter returns the entries up to the block with index last of the
+// instances that have been changed after the block with index idx, with one
+// list per instance sorted by block and transaction.
+func (s *stateChangeStorage) getChangedAfter(sid skipchain.SkipBlockID, idx,
+	last int) (changed []StateChangeEntries, err error) {
+	s.Lock()
+	defer s.Unlock()
+	err = s.db.View(func(tx *bbolt.Tx) error {
+		b := s.getBucket(tx, sid)
+		ib := s.getIndexBucket(tx, sid)
+		if b == nil || ib == nil {
+			return nil
+		}
+
+		// The index gives the instances changed after the block.
+		var iids [][]byte
+		seen := make(map[string]bool)
+		start := make([]byte, 8)
+		binary.BigEndian.PutUint64(start, uint64(idx+1))
+		c := ib.Cursor()
+		for k, _ := c.Seek(start); k != nil; k, _ = c.Next() {
+			if int(binary.BigEndian.Uint64(k[:8])) > last {
+				break
+			}
+			iid := k[8 : 8+prefixLength]
+			if !seen[string(iid)] {
+				seen[string(iid)] = true
+				iids = append(iids, append([]byte{}, iid...))
+			}
+		}
+
+		c = b.Cursor()
+		for _, iid := range iids {
+			var entries StateChangeEntries
+			for k, v := c.Seek(iid); bytes.HasPrefix(k, iid); k, v = c.Next() {
+				var sce StateChangeEntry
+				err = protobuf.Decode(v, &sce)
+				if err != nil {
+					return xerrors.Errorf("decoding: %v", err)
+				}
+				if sce.BlockIndex <= last {
+					entries = append(entries, sce)
+				}
+			}
+			if len(entries) == 0 {
+				continue
+			}
+			sort.SliceStable(entries, func(i, j int) bool {
+				if entries[i].BlockIndex != entries[j].BlockIndex {
+					return entries[i].BlockIndex < entries[j].BlockIndex
+				}
+				return entries[i].TxIndex < entries[j].TxIndex
+			})
+			changed = append(changed, entries)
+		}
+
+		return nil
+	})
+
+	err = cothority.ErrorOrNil(err, "tx error")
+	return
+}
+
 // getLast looks for the last version of a given instance and return the entry. Use
 // the bool value to know if there is a hit or not.
 func (s *stateChangeStorage) getLast(iid []byte, sid skipchain.SkipBlockID) (sce StateChangeEntry, ok bool, err error) {
@@ -613,7 +760,7 @@ func (bc *bcNotifications) informBlock(block *skipchain.SkipBlock, txs TxResults
 	hashes := make([][]byte, len(txs))
 	for i, tx := range txs {
 		// Pre-computed hash to save some computation load.
-		hashes[i] = tx.ClientTransaction.Instructions.Hash()
+		hashes[i] = tx.ClientTransaction.Hash()
 	}
 
 	notif := &notification{
@@ -671,6 +818,19 @@ func (c ChainConfig) sanityCheck(old *ChainConfig) error {
 	if len(c.Roster.List) < 3 {
 		return xerrors.New("need at least 3 nodes to have a majority")
 	}
+	if c.GasLimits != nil {
+		if c.GasLimits.MaxBlockGas == 0 {
+			return xerrors.New("max block gas is zero")
+		}
+		if c.GasLimits.MaxTxGas > c.GasLimits.MaxBlockGas {
+			return xerrors.New("max tx gas is greater than max block gas")
+		}
+	}
+	if c.LeaderRotation != nil {
+		if err := c.LeaderRotation.sanityCheck(c); err != nil {
+			return xerrors.Errorf("leader rotation: %v", err)
+		}
+	}
 	if old != nil {
 		return cothority.ErrorOrNil(old.checkNewRoster(c.Roster), "roster check")
 	}
@@ -703,7 +863,8 @@ func (c ChainConfig) checkNewRoster(newRoster onet.Roster) error {
 	return nil
 }
 
-// String implements a nicer text representation of a Chainconfig.
+// String implements a nicer text representation of a Chainconfig. The
+// optional fields are only written when they are set.
 //
 // Here is an example of what it outputs:
 //
@@ -712,10 +873,20 @@ func (c ChainConfig) checkNewRoster(newRoster onet.Roster) error {
 // -- BlockInterval: 7s
 // -- Roster: {1e89775c-636a-536a-bc39-1ec951c86dc9 [tls://localhost:2002 tls://localhost:2004 tls://localhost:2006] 467abd382f78222e898d323194c0fb30f7096bb6bb885ea31284979f794e558a}
 // -- MaxBlockSize: 5000000
+// -- GasLimits:
+// --- MaxBlockGas: 1000000
+// --- MaxTxGas: 100000
+// -- FeeBeneficiary: 7b5a...
 // -- DarcContractIDs:
 // --- darc contract ID 0: darc
 // --- darc contract ID 1: darc2
 // --- darc contract ID 2: darc3'
+// -- ContractVersions:
+// --- value: version 1 from block 120
+// -- LeaderRotation:
+// --- Interval: 10
+// --- Start: 100
+// --- Weight of 5d9e...: 2
 // ```
 func (c ChainConfig) String() string {
 	res := new(strings.Builder)
@@ -723,10 +894,33 @@ func (c ChainConfig) String() string {
 	fmt.Fprintf(res, "-- BlockInterval: %s\n", c.BlockInterval.String())
 	fmt.Fprintf(res, "-- Roster: %s\n", c.Roster)
 	fmt.Fprintf(res, "-- MaxBlockSize: %d\n", c.MaxBlockSize)
+	if c.GasLimits != nil {
+		res.WriteString("-- GasLimits:\n")
+		fmt.Fprintf(res, "--- MaxBlockGas: %d\n", c.GasLimits.MaxBlockGas)
+		fmt.Fprintf(res, "--- MaxTxGas: %d\n", c.GasLimits.MaxTxGas)
+	}
+	if c.FeeBeneficiary != nil {
+		fmt.Fprintf(res, "-- FeeBeneficiary: %s\n", c.FeeBeneficiary)
+	}
 	res.WriteString("-- DarcContractIDs:\n")
 	for i, darcID := range c.DarcContractIDs {
 		fmt.Fprintf(res, "--- darc contract ID %d: %s\n", i, darcID)
 	}
+	if len(c.ContractVersions) > 0 {
+		res.WriteString("-- ContractVersions:\n")
+		for _, cv := range c.ContractVersions {
+			fmt.Fprintf(res, "--- %s: version %d from block %d\n",
+				cv.ContractID, cv.Version, cv.BlockIndex)
+		}
+	}
+	if c.LeaderRotation != nil {
+		res.WriteString("-- LeaderRotation:\n")
+		fmt.Fprintf(res, "--- Interval: %d\n", c.LeaderRotation.Interval)
+		fmt.Fprintf(res, "--- Start: %d\n", c.LeaderRotation.Start)
+		for _, w := range c.LeaderRotation.Weights {
+			fmt.Fprintf(res, "--- Weight of %x: %d\n", w.Public, w.Weight)
+		}
+	}
 	return res.String()
 }
 
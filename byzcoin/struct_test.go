@@ -6,6 +6,7 @@ import (
 	"os"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"go.dedis.ch/cothority/v3/skipchain"
@@ -284,6 +285,64 @@ func TestStateChangeStorage_MaxNbrBlock(t *testing.T) {
 	require.Equal(t, n/l-store.maxNbrBlock, entries[0].BlockIndex)
 }
 
+// Checks that the instances changed after a block are found through the
+// index, which is kept up to date when cleaning and built for older storages.
+func TestStateChangeStorage_GetChangedAfter(t *testing.T) {
+	store, name := generateDB(t)
+	store.maxNbrBlock = 3
+	defer os.Remove(name)
+
+	iids := make([][]byte, 3)
+	for i := range iids {
+		iids[i] = genID().Slice()
+	}
+
+	// The instance i is changed in the blocks i, i+3, i+6, ...
+	sb := createBlock()
+	for i := 0; i < 9; i++ {
+		sb.Index = i
+		err := store.append(StateChanges{{
+			InstanceID: iids[i%3],
+			Version:    uint64(i / 3),
+			Value:      []byte{},
+		}}, sb)
+		require.NoError(t, err)
+	}
+
+	changed, err := store.getChangedAfter(sb.SkipChainID(), 7, 8)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(changed))
+	require.Equal(t, iids[2], changed[0][0].StateChange.InstanceID)
+
+	// The entries of the following blocks are ignored.
+	changed, err = store.getChangedAfter(sb.SkipChainID(), 5, 7)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(changed))
+	for _, entries := range changed {
+		require.True(t, entries[len(entries)-1].BlockIndex <= 7)
+	}
+
+	// The index of the cleaned entries is removed.
+	err = store.db.View(func(tx *bbolt.Tx) error {
+		require.Equal(t, 3, store.getIndexBucket(tx, sb.SkipChainID()).Stats().KeyN)
+		return nil
+	})
+	require.NoError(t, err)
+
+	// A storage without index gets one.
+	err = store.db.Update(func(tx *bbolt.Tx) error {
+		return tx.DeleteBucket(append(store.bucket, bucketIndexSuffix...))
+	})
+	require.NoError(t, err)
+	changed, err = store.getChangedAfter(sb.SkipChainID(), 5, 8)
+	require.NoError(t, err)
+	require.Empty(t, changed)
+	require.NoError(t, store.indexBlocks())
+	changed, err = store.getChangedAfter(sb.SkipChainID(), 5, 8)
+	require.NoError(t, err)
+	require.Equal(t, 3, len(changed))
+}
+
 func TestStateChangeStorage_Race(t *testing.T) {
 	store, name := generateDB(t)
 	defer os.Remove(name)
@@ -343,3 +402,20 @@ func generateDB(t *testing.T) (*stateChangeStorage, string) {
 
 	return &scs, tmpDB.Name()
 }
+
+// Checks that the optional fields of the config are only written when set.
+func TestChainConfig_String(t *testing.T) {
+	c := ChainConfig{
+		BlockInterval:   time.Second,
+		MaxBlockSize:    16000,
+		DarcContractIDs: []string{"darc"},
+	}
+	require.Regexp(t, "(?s)-- MaxBlockSize: 16000\n-- DarcContractIDs:\n"+
+		"--- darc contract ID 0: darc\n$", c.String())
+
+	c.GasLimits = &GasLimits{MaxBlockGas: 2, MaxTxGas: 1}
+	c.ContractVersions = []ContractVersion{{ContractID: "a", Version: 1}}
+	require.Contains(t, c.String(), "--- MaxTxGas: 1\n")
+	require.Contains(t, c.String(), "--- a: version 1 from block 0\n")
+	require.NotContains(t, c.String(), "LeaderRotation")
+}
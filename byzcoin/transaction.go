@@ -108,7 +108,7 @@ func (ctx *ClientTransaction) FillSignersAndSignWith(signers ...darc.Signer) err
 // SignWith signs all the instructions with the same signers. If some instructions need to be signed by different sets
 // of signers, then use the SignWith method of Instruction.
 func (ctx *ClientTransaction) SignWith(signers ...darc.Signer) error {
-	digest := ctx.Instructions.Hash()
+	digest := ctx.Hash()
 	for i := range ctx.Instructions {
 		if err := ctx.Instructions[i].SignWith(digest, signers...); err != nil {
 			return err
@@ -122,9 +122,64 @@ func (ctx *ClientTransaction) SignWith(signers ...darc.Signer) error {
 func (ctx *ClientTransaction) Clone() ClientTransaction {
 	newCtx := ClientTransaction{}
 	newCtx.Instructions = append(newCtx.Instructions, ctx.Instructions...)
+	if ctx.Fee != nil {
+		fee := *ctx.Fee
+		newCtx.Fee = &fee
+	}
+	if ctx.Validity != nil {
+		validity := *ctx.Validity
+		newCtx.Validity = &validity
+	}
 	return newCtx
 }
 
+// Hash returns the digest that has to be signed by the instructions. For a
+// transaction without a fee and without a validity, this is the same as the
+// hash of the instructions.
+func (ctx ClientTransaction) Hash() []byte {
+	if ctx.Fee == nil && ctx.Validity == nil {
+		return ctx.Instructions.Hash()
+	}
+	h := sha256.New()
+	h.Write(ctx.Instructions.Hash())
+	buf := make([]byte, 8)
+	if ctx.Fee != nil {
+		h.Write([]byte("fee"))
+		h.Write(ctx.Fee.Coin[:])
+		binary.LittleEndian.PutUint64(buf, ctx.Fee.Amount)
+		h.Write(buf)
+	}
+	if ctx.Validity != nil {
+		h.Write([]byte("validity"))
+		binary.LittleEndian.PutUint64(buf, uint64(ctx.Validity.MaxBlockIndex))
+		h.Write(buf)
+		binary.LittleEndian.PutUint64(buf, uint64(ctx.Validity.MaxTimestamp))
+		h.Write(buf)
+	}
+	return h.Sum(nil)
+}
+
+// ErrTxExpired is returned when a ClientTransaction is processed after the
+// bounds given in its Validity.
+var ErrTxExpired = xerrors.New("transaction expired")
+
+// checkValidity returns ErrTxExpired if the transaction cannot be included
+// in a block with the given index and timestamp.
+func (ctx ClientTransaction) checkValidity(index int, timestamp int64) error {
+	if ctx.Validity == nil {
+		return nil
+	}
+	if ctx.Validity.MaxBlockIndex > 0 && index > ctx.Validity.MaxBlockIndex {
+		return xerrors.Errorf("block index %d > %d: %w", index,
+			ctx.Validity.MaxBlockIndex, ErrTxExpired)
+	}
+	if ctx.Validity.MaxTimestamp > 0 && timestamp > ctx.Validity.MaxTimestamp {
+		return xerrors.Errorf("block timestamp %d > %d: %w", timestamp,
+			ctx.Validity.MaxTimestamp, ErrTxExpired)
+	}
+	return nil
+}
+
 // NewClientTransaction creates a transaction compatible with the version passed
 // in arguments. Depending on the version, the hash will have a different value.
 // Most common usage is:
@@ -566,18 +621,47 @@ func NewTxResults(ct ...ClientTransaction) TxResults {
 }
 
 // Hash returns the sha256 hash of all of the transactions.
+// The used gas is only included if it is set, so that the hash of blocks
+// created without gas metering doesn't change.
 func (txr TxResults) Hash() []byte {
+	return txr.summaries().hash()
+}
+
+// summaries returns the TxSummary of every transaction.
+func (txr TxResults) summaries() txSummaries {
+	out := make(txSummaries, len(txr))
+	for i, tx := range txr {
+		out[i] = TxSummary{
+			ClientTransactionHash: tx.ClientTransaction.Hash(),
+			Accepted:              tx.Accepted,
+			GasUsed:               tx.GasUsed,
+		}
+	}
+	return out
+}
+
+// txSummaries is a list of TxSummary.
+type txSummaries []TxSummary
+
+// hash returns the same hash as TxResults.Hash for the summarized
+// transactions.
+func (txs txSummaries) hash() []byte {
 	one := []byte{1}
 	zero := []byte{0}
 
 	h := sha256.New()
-	for _, tx := range txr {
-		h.Write(tx.ClientTransaction.Instructions.Hash())
+	for _, tx := range txs {
+		h.Write(tx.ClientTransactionHash)
 		if tx.Accepted {
 			h.Write(one[:])
 		} else {
 			h.Write(zero[:])
 		}
+		if tx.GasUsed > 0 {
+			gasBuf := make([]byte, 8)
+			binary.LittleEndian.PutUint64(gasBuf, tx.GasUsed)
+			h.Write(gasBuf)
+		}
 	}
 	return h.Sum(nil)
 }
@@ -655,7 +739,7 @@ func (sc *StateChange) Op() trie.OpType {
 		return trie.OpSet
 	case Remove:
 		return trie.OpDel
-	case GenerateInstruction:
+	case GenerateInstruction, EmitEvent:
 		return trie.Nop
 	}
 	return 0
@@ -704,6 +788,9 @@ const (
 	Remove
 	// GenerateInstruction allows to generate an instruction
 	GenerateInstruction
+	// EmitEvent allows to emit an event, which is stored in the block
+	// without changing the trie.
+	EmitEvent
 )
 
 // String returns a readable output of the action.
@@ -717,6 +804,8 @@ func (sc StateAction) String() string {
 		return "Remove"
 	case GenerateInstruction:
 		return "GenerateInstruction"
+	case EmitEvent:
+		return "EmitEvent"
 	default:
 		return "Invalid stateChange"
 	}
@@ -2,13 +2,13 @@ package byzcoin
 
 import (
 	"encoding/binary"
-	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 	"go.dedis.ch/cothority/v3/byzcoin/trie"
 	"go.dedis.ch/cothority/v3/darc"
 	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
 )
 
 func TestTransaction_Signing(t *testing.T) {
@@ -27,7 +27,7 @@ func TestTransaction_Signing(t *testing.T) {
 	mdb := trie.NewMemDB()
 	tr, err := trie.NewTrie(mdb, []byte("my nonce"))
 	require.NoError(t, err)
-	sst := &stagingStateTrie{*tr.MakeStagingTrie(), trieCache{}, sync.Mutex{}}
+	sst := &stagingStateTrie{StagingTrie: *tr.MakeStagingTrie()}
 
 	// verification should fail because trie is empty
 	ctxHash := ctx.Instructions.Hash()
@@ -76,6 +76,35 @@ func TestTransaction_Signing(t *testing.T) {
 	require.NoError(t, ctx.Instructions[0].Verify(sst, ctxHash))
 }
 
+func TestClientTransaction_Validity(t *testing.T) {
+	ctx := NewClientTransaction(CurrentVersion, Instruction{})
+	require.NoError(t, ctx.checkValidity(1e6, 1e18))
+
+	ctx.Validity = &TxValidity{MaxBlockIndex: 10}
+	hash := ctx.Hash()
+	require.NotEqual(t, ctx.Instructions.Hash(), hash)
+	require.NoError(t, ctx.checkValidity(10, 1e18))
+	err := ctx.checkValidity(11, 0)
+	require.True(t, xerrors.Is(err, ErrTxExpired))
+
+	ctx.Validity.MaxTimestamp = 1000
+	require.NotEqual(t, hash, ctx.Hash())
+	require.NoError(t, ctx.checkValidity(10, 1000))
+	err = ctx.checkValidity(10, 1001)
+	require.True(t, xerrors.Is(err, ErrTxExpired))
+
+	clone := ctx.Clone()
+	require.Equal(t, ctx.Hash(), clone.Hash())
+	clone.Validity.MaxBlockIndex++
+	require.NotEqual(t, ctx.Hash(), clone.Hash())
+
+	// A fee and a validity must both be covered by the hash.
+	ctx.Fee = &TxFee{Amount: 1}
+	hash = ctx.Hash()
+	ctx.Validity = nil
+	require.NotEqual(t, hash, ctx.Hash())
+}
+
 func TestInstruction_DeriveIDArg(t *testing.T) {
 	inst := Instruction{
 		InstanceID: NewInstanceID([]byte("new instance")),
@@ -101,3 +101,65 @@ func testMemAndDisk(t *testing.T, f func(*testing.T, DB)) {
 	defer delDiskDB(t, disk)
 	f(t, disk)
 }
+
+func TestTxDB(t *testing.T) {
+	disk := newDiskDB(t)
+	defer delDiskDB(t, disk)
+	err := disk.Update(func(b Bucket) error {
+		for i := 0; i < 10; i++ {
+			if err := b.Put([]byte{byte(i)}, []byte{byte(i)}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	tx, err := disk.(*diskDB).db.Begin(false)
+	require.NoError(t, err)
+	db := NewTxDB(tx, []byte(bucketName))
+
+	// The changes made after the transaction began are not seen.
+	require.NoError(t, disk.Update(func(b Bucket) error {
+		return b.Delete([]byte{0})
+	}))
+	require.NoError(t, db.View(func(b Bucket) error {
+		if b.Get([]byte{0}) == nil {
+			return xerrors.New("key deleted after the transaction is seen")
+		}
+		return nil
+	}))
+	require.Error(t, db.Update(func(b Bucket) error { return nil }))
+
+	// The dry-run sees its own changes.
+	var keys [][]byte
+	err = db.UpdateDryRun(func(b Bucket) error {
+		if err := b.Delete([]byte{1}); err != nil {
+			return err
+		}
+		if err := b.Put([]byte{20}, []byte{20}); err != nil {
+			return err
+		}
+		if v := b.Get([]byte{20}); !bytes.Equal(v, []byte{20}) {
+			return xerrors.New("got an unexpected value")
+		}
+		return b.ForEach(func(k, v []byte) error {
+			keys = append(keys, k)
+			return nil
+		})
+	})
+	require.NoError(t, err)
+	require.Equal(t, 10, len(keys))
+	require.Equal(t, []byte{0}, keys[0])
+	require.Equal(t, []byte{2}, keys[1])
+	require.Equal(t, []byte{20}, keys[9])
+
+	// But they are discarded afterwards.
+	require.NoError(t, db.View(func(b Bucket) error {
+		if b.Get([]byte{1}) == nil || b.Get([]byte{20}) != nil {
+			return xerrors.New("dry-run changes are kept")
+		}
+		return nil
+	}))
+	require.NoError(t, db.Close())
+}
@@ -0,0 +1,165 @@
+package trie
+
+import (
+	"golang.org/x/xerrors"
+)
+
+// GetMultiProof gets the inclusion/absence proofs for the given keys. The
+// first interior node of the proof is the root.
+func (t *Trie) GetMultiProof(keys [][]byte) (*MultiProof, error) {
+	p := &MultiProof{NoHashKey: t.noHashKey}
+	err := t.db.View(func(b Bucket) error {
+		rootKey := t.GetRootWithBucket(b)
+		if rootKey == nil {
+			return xerrors.New("no root key")
+		}
+		p.Nonce = clone(t.nonce)
+		seen := make(map[string]bool)
+		for _, key := range keys {
+			err := t.getMultiProof(0, rootKey, t.binSlice(key), p, seen, b)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return p, err
+}
+
+// getMultiProof updates MultiProof p as it traverses the tree, adding only
+// the nodes that have not been seen yet.
+func (t *Trie) getMultiProof(depth int, nodeKey []byte, bits []bool,
+	p *MultiProof, seen map[string]bool, b Bucket) error {
+	nodeVal := clone(b.Get(nodeKey))
+	if len(nodeVal) == 0 {
+		return xerrors.New("invalid node key")
+	}
+	isNew := !seen[string(nodeKey)]
+	seen[string(nodeKey)] = true
+	switch nodeType(nodeVal[0]) {
+	case typeEmpty:
+		if !isNew {
+			return nil
+		}
+		node, err := decodeEmptyNode(nodeVal)
+		if err != nil {
+			return err
+		}
+		p.Empties = append(p.Empties, node)
+		return nil
+	case typeLeaf:
+		if !isNew {
+			return nil
+		}
+		node, err := decodeLeafNode(nodeVal)
+		if err != nil {
+			return err
+		}
+		p.Leaves = append(p.Leaves, node)
+		return nil
+	case typeInterior:
+		node, err := decodeInteriorNode(nodeVal)
+		if err != nil {
+			return err
+		}
+		if isNew {
+			p.Interiors = append(p.Interiors, node)
+		}
+		if bits[depth] {
+			return t.getMultiProof(depth+1, node.Left, bits, p, seen, b)
+		}
+		// look right
+		return t.getMultiProof(depth+1, node.Right, bits, p, seen, b)
+	}
+	return xerrors.New("invalid node type")
+}
+
+// GetRoot returns the Merkle root.
+func (p *MultiProof) GetRoot() []byte {
+	if len(p.Interiors) == 0 {
+		return nil
+	}
+	return p.Interiors[0].hash()
+}
+
+// Proof extracts the proof of a single key, which can then be checked like
+// any other proof.
+func (p *MultiProof) Proof(key []byte) (*Proof, error) {
+	proofs, err := p.Proofs([][]byte{key})
+	if err != nil {
+		return nil, err
+	}
+	return &proofs[0], nil
+}
+
+// Proofs extracts the proofs of the given keys, by following the hashes of
+// the nodes from the root. An error is returned if a node of a path is
+// missing.
+func (p *MultiProof) Proofs(keys [][]byte) ([]Proof, error) {
+	if len(p.Interiors) == 0 {
+		return nil, xerrors.New("no interior nodes")
+	}
+	interiors := make(map[string]*interiorNode)
+	for i := range p.Interiors {
+		interiors[string(p.Interiors[i].hash())] = &p.Interiors[i]
+	}
+	leaves := make(map[string]*leafNode)
+	for i := range p.Leaves {
+		leaves[string(p.Leaves[i].hash(p.Nonce))] = &p.Leaves[i]
+	}
+	empties := make(map[string]*emptyNode)
+	for i := range p.Empties {
+		empties[string(p.Empties[i].hash(p.Nonce))] = &p.Empties[i]
+	}
+
+	proofs := make([]Proof, len(keys))
+	for i, key := range keys {
+		proof := &proofs[i]
+		proof.Nonce = p.Nonce
+		proof.noHashKey = p.NoHashKey
+		bits := proof.binSlice(key)
+		hash := p.Interiors[0].hash()
+		for depth := 0; ; depth++ {
+			if node, ok := interiors[string(hash)]; ok {
+				if depth >= len(bits) {
+					return nil, xerrors.New("path is too long")
+				}
+				proof.Interiors = append(proof.Interiors, *node)
+				if bits[depth] {
+					hash = node.Left
+				} else {
+					hash = node.Right
+				}
+			} else if node, ok := leaves[string(hash)]; ok {
+				proof.Leaf = *node
+				break
+			} else if node, ok := empties[string(hash)]; ok {
+				proof.Empty = *node
+				break
+			} else {
+				return nil, xerrors.Errorf("missing node in path of key %x", key)
+			}
+		}
+	}
+	return proofs, nil
+}
+
+// Exists checks the proof for inclusion/absence of the key.
+func (p *MultiProof) Exists(key []byte) (bool, error) {
+	proof, err := p.Proof(key)
+	if err != nil {
+		return false, err
+	}
+	return proof.Exists(key)
+}
+
+// Match returns true if the proof is an existence proof for the given key, any
+// error during the process of verifying the proof or if the key is absent then
+// it returns false.
+func (p *MultiProof) Match(key []byte) bool {
+	ok, err := p.Exists(key)
+	if err != nil {
+		return false
+	}
+	return ok
+}
@@ -71,6 +71,50 @@ func testProof(t *testing.T, db DB) {
 
 }
 
+func TestMultiProof(t *testing.T) {
+	testMemAndDisk(t, testMultiProof)
+}
+
+func testMultiProof(t *testing.T, db DB) {
+	testTrie, err := NewTrie(db, genNonce())
+	require.NoError(t, err)
+
+	var keys [][]byte
+	for i := 0; i < 20; i++ {
+		k := []byte{byte(i)}
+		keys = append(keys, k)
+		if i >= 10 {
+			require.NoError(t, testTrie.Set(k, k))
+		}
+	}
+
+	mp, err := testTrie.GetMultiProof(keys)
+	require.NoError(t, err)
+	require.Equal(t, testTrie.GetRoot(), mp.GetRoot())
+
+	proofs, err := mp.Proofs(keys)
+	require.NoError(t, err)
+	var interiors int
+	for i, k := range keys {
+		p, err := testTrie.GetProof(k)
+		require.NoError(t, err)
+		interiors += len(p.Interiors)
+
+		ok, err := proofs[i].Exists(k)
+		require.NoError(t, err)
+		require.Equal(t, i >= 10, ok)
+		require.Equal(t, i >= 10, mp.Match(k))
+		require.Equal(t, p.GetRoot(), proofs[i].GetRoot())
+	}
+	// The interior nodes shared by the paths are stored only once.
+	require.True(t, len(mp.Interiors) < interiors)
+
+	// A missing node breaks the path of some keys.
+	mp.Interiors = mp.Interiors[:len(mp.Interiors)-1]
+	_, err = mp.Proofs(keys)
+	require.Error(t, err)
+}
+
 type disjointSet struct {
 	A [][]byte
 	B [][]byte
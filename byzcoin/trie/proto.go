@@ -28,3 +28,15 @@ type Proof struct {
 	Nonce     []byte
 	noHashKey bool
 }
+
+// MultiProof contains the inclusion/absence proofs of several keys. The nodes
+// shared by the paths of the keys are stored only once.
+type MultiProof struct {
+	Interiors []interiorNode
+	Leaves    []leafNode
+	Empties   []emptyNode
+	Nonce     []byte
+	// NoHashKey is set if the keys of the trie are not hashed, which must
+	// be checked by the verifier.
+	NoHashKey bool `protobuf:"opt"`
+}
@@ -0,0 +1,294 @@
+package trie
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"sort"
+
+	"golang.org/x/xerrors"
+)
+
+// GetRangeProof gets a proof of all the key/value pairs whose key is in the
+// range [start, end), which are read with MultiProof.Range. If end is nil,
+// the range has no upper bound. The subtrees that cannot hold a key of the
+// range are only given by their hash. This is not possible in a trie with
+// hashed keys, where the proof holds all the nodes of the trie.
+func (t *Trie) GetRangeProof(start, end []byte) (*MultiProof, error) {
+	var p *MultiProof
+	err := t.db.View(func(b Bucket) error {
+		var err error
+		p, err = t.getRangeProofWithBucket(start, end, 0, b)
+		return err
+	})
+	return p, err
+}
+
+// ErrTooManyLeaves is returned by GetRangeProofWithLimit if the proof would
+// hold more leaves than the limit.
+var ErrTooManyLeaves = xerrors.New("too many leaves in the proof")
+
+// GetRangeProofWithLimit gets a proof of the range [start, end) like
+// GetRangeProof, but it stops and returns ErrTooManyLeaves once the proof
+// holds more than maxLeaves leaves.
+func (t *Trie) GetRangeProofWithLimit(start, end []byte,
+	maxLeaves int) (*MultiProof, error) {
+	var p *MultiProof
+	err := t.db.View(func(b Bucket) error {
+		var err error
+		p, err = t.getRangeProofWithBucket(start, end, maxLeaves, b)
+		return err
+	})
+	return p, err
+}
+
+// GetPrefixProof gets a proof of all the key/value pairs whose key starts
+// with the prefix, which are read with MultiProof.Prefix.
+func (t *Trie) GetPrefixProof(prefix []byte) (*MultiProof, error) {
+	return t.GetRangeProof(prefix, PrefixEnd(prefix))
+}
+
+// GetRangeProof gets a proof of all the key/value pairs whose key is in the
+// range [start, end), as Trie.GetRangeProof, including the pending
+// operations.
+func (t *StagingTrie) GetRangeProof(start, end []byte) (*MultiProof, error) {
+	t.Lock()
+	defer t.Unlock()
+	var p *MultiProof
+	err := t.source.db.UpdateDryRun(func(b Bucket) error {
+		// run the pending instructions
+		for _, instr := range t.instrList {
+			switch instr.ty {
+			case OpSet:
+				if err := t.source.SetWithBucket(instr.k, instr.v, b); err != nil {
+					return err
+				}
+			case OpDel:
+				if err := t.source.DeleteWithBucket(instr.k, b); err != nil {
+					return err
+				}
+			default:
+				return xerrors.New("invalid instruction during get range proof")
+			}
+		}
+		var err error
+		p, err = t.source.getRangeProofWithBucket(start, end, 0, b)
+		return err
+	})
+	return p, err
+}
+
+// GetPrefixProof gets a proof of all the key/value pairs whose key starts
+// with the prefix, including the pending operations.
+func (t *StagingTrie) GetPrefixProof(prefix []byte) (*MultiProof, error) {
+	return t.GetRangeProof(prefix, PrefixEnd(prefix))
+}
+
+// getRangeProofWithBucket gets the proof of the range, with at most maxLeaves
+// leaves if it is positive.
+func (t *Trie) getRangeProofWithBucket(start, end []byte, maxLeaves int,
+	b Bucket) (*MultiProof, error) {
+	rootKey := t.GetRootWithBucket(b)
+	if rootKey == nil {
+		return nil, xerrors.New("no root key")
+	}
+	p := &MultiProof{
+		Nonce:     clone(t.nonce),
+		NoHashKey: t.noHashKey,
+	}
+	err := t.getRangeProof([]bool{}, rootKey, start, end, maxLeaves, p, b)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// getRangeProof adds the node at the given path to the proof, and then its
+// children that might hold a key of the range.
+func (t *Trie) getRangeProof(path []bool, nodeKey []byte, start, end []byte,
+	maxLeaves int, p *MultiProof, b Bucket) error {
+	nodeVal := clone(b.Get(nodeKey))
+	if len(nodeVal) == 0 {
+		return xerrors.New("invalid node key")
+	}
+	switch nodeType(nodeVal[0]) {
+	case typeEmpty:
+		node, err := decodeEmptyNode(nodeVal)
+		if err != nil {
+			return err
+		}
+		p.Empties = append(p.Empties, node)
+		return nil
+	case typeLeaf:
+		node, err := decodeLeafNode(nodeVal)
+		if err != nil {
+			return err
+		}
+		p.Leaves = append(p.Leaves, node)
+		if maxLeaves > 0 && len(p.Leaves) > maxLeaves {
+			return ErrTooManyLeaves
+		}
+		return nil
+	case typeInterior:
+		node, err := decodeInteriorNode(nodeVal)
+		if err != nil {
+			return err
+		}
+		p.Interiors = append(p.Interiors, node)
+		left := append(append([]bool{}, path...), true)
+		if p.mightHoldRange(left, start, end) {
+			err := t.getRangeProof(left, node.Left, start, end, maxLeaves, p, b)
+			if err != nil {
+				return err
+			}
+		}
+		right := append(append([]bool{}, path...), false)
+		if p.mightHoldRange(right, start, end) {
+			return t.getRangeProof(right, node.Right, start, end, maxLeaves, p, b)
+		}
+		return nil
+	}
+	return xerrors.New("invalid node type")
+}
+
+// Range verifies that the proof holds all the key/value pairs of the trie
+// whose key is in the range [start, end), and returns them in the order of
+// the keys. If end is nil, the range has no upper bound. An error is
+// returned if a subtree that might hold a key of the range is missing.
+func (p *MultiProof) Range(start, end []byte) (keys, values [][]byte,
+	err error) {
+	if len(p.Interiors) == 0 {
+		return nil, nil, xerrors.New("no interior nodes")
+	}
+	r := rangeVerifier{
+		proof:     p,
+		start:     start,
+		end:       end,
+		interiors: make(map[string]*interiorNode),
+		leaves:    make(map[string]*leafNode),
+		empties:   make(map[string]*emptyNode),
+	}
+	for i := range p.Interiors {
+		r.interiors[string(p.Interiors[i].hash())] = &p.Interiors[i]
+	}
+	for i := range p.Leaves {
+		r.leaves[string(p.Leaves[i].hash(p.Nonce))] = &p.Leaves[i]
+	}
+	for i := range p.Empties {
+		r.empties[string(p.Empties[i].hash(p.Nonce))] = &p.Empties[i]
+	}
+	if err := r.verify([]bool{}, p.Interiors[0].hash()); err != nil {
+		return nil, nil, err
+	}
+
+	sort.Slice(r.found, func(i, j int) bool {
+		return bytes.Compare(r.found[i].Key, r.found[j].Key) < 0
+	})
+	for _, leaf := range r.found {
+		keys = append(keys, leaf.Key)
+		values = append(values, leaf.Value)
+	}
+	return
+}
+
+// Prefix verifies that the proof holds all the key/value pairs of the trie
+// whose key starts with the prefix, and returns them in the order of the
+// keys.
+func (p *MultiProof) Prefix(prefix []byte) (keys, values [][]byte, err error) {
+	return p.Range(prefix, PrefixEnd(prefix))
+}
+
+type rangeVerifier struct {
+	proof      *MultiProof
+	start, end []byte
+	interiors  map[string]*interiorNode
+	leaves     map[string]*leafNode
+	empties    map[string]*emptyNode
+	found      []*leafNode
+}
+
+func (r *rangeVerifier) verify(path []bool, hash []byte) error {
+	if node, ok := r.interiors[string(hash)]; ok {
+		left := append(append([]bool{}, path...), true)
+		if err := r.verifyChild(left, node.Left); err != nil {
+			return err
+		}
+		right := append(append([]bool{}, path...), false)
+		return r.verifyChild(right, node.Right)
+	}
+	if node, ok := r.leaves[string(hash)]; ok {
+		if !equal(path, node.Prefix) {
+			return xerrors.New("invalid prefix in leaf node")
+		}
+		bits := r.proof.binSlice(node.Key)
+		if len(bits) < len(path) || !equal(path, bits[:len(path)]) {
+			return xerrors.New("leaf node at invalid position")
+		}
+		if inRange(node.Key, r.start, r.end) {
+			r.found = append(r.found, node)
+		}
+		return nil
+	}
+	if node, ok := r.empties[string(hash)]; ok {
+		if !equal(path, node.Prefix) {
+			return xerrors.New("invalid prefix in empty node")
+		}
+		return nil
+	}
+	return xerrors.Errorf("missing node %x", hash)
+}
+
+// verifyChild checks the subtree of a child, which can only be left out of
+// the proof if it cannot hold a key of the range.
+func (r *rangeVerifier) verifyChild(path []bool, hash []byte) error {
+	if !r.proof.mightHoldRange(path, r.start, r.end) {
+		return nil
+	}
+	return r.verify(path, hash)
+}
+
+func (p *MultiProof) binSlice(buf []byte) []bool {
+	if p.NoHashKey {
+		return toBinSlice(buf)
+	}
+	hashKey := sha256.Sum256(buf)
+	return toBinSlice(hashKey[:])
+}
+
+// mightHoldRange returns false if none of the keys of the subtree at the
+// given path can be in the range [start, end). If the keys are hashed, any
+// subtree might hold a key of the range.
+func (p *MultiProof) mightHoldRange(path []bool, start, end []byte) bool {
+	if !p.NoHashKey {
+		return true
+	}
+	// All the keys of the subtree are in [lo, PrefixEnd(hi)), where lo and
+	// hi are the bytes of the path padded with zeros and ones.
+	lo := toByteSlice(path)
+	hi := clone(lo)
+	for i := len(path); i < len(hi)*8; i++ {
+		hi[i/8] |= (1 << 7) >> uint(i%8)
+	}
+	if end != nil && bytes.Compare(lo, end) >= 0 {
+		return false
+	}
+	hiEnd := PrefixEnd(hi)
+	return hiEnd == nil || bytes.Compare(start, hiEnd) < 0
+}
+
+func inRange(key, start, end []byte) bool {
+	return bytes.Compare(key, start) >= 0 &&
+		(end == nil || bytes.Compare(key, end) < 0)
+}
+
+// PrefixEnd returns the smallest key that is bigger than all the keys
+// starting with the prefix, or nil if there is none.
+func PrefixEnd(prefix []byte) []byte {
+	end := clone(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
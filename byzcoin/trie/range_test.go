@@ -0,0 +1,150 @@
+package trie
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/protobuf"
+)
+
+func TestRangeProof(t *testing.T) {
+	testMemAndDisk(t, testRangeProof)
+}
+
+func testRangeProof(t *testing.T, db DB) {
+	testTrie, err := NewTrie(db, genNonce())
+	require.NoError(t, err)
+	testTrie.noHashKey = true
+
+	for i := 10; i < 40; i++ {
+		k := []byte{byte(i)}
+		require.NoError(t, testTrie.Set(k, k))
+	}
+
+	p, err := testTrie.GetRangeProof([]byte{15}, []byte{20})
+	require.NoError(t, err)
+	require.Equal(t, testTrie.GetRoot(), p.GetRoot())
+	require.True(t, len(p.Leaves) < 30)
+	keys, values, err := p.Range([]byte{15}, []byte{20})
+	require.NoError(t, err)
+	require.Equal(t, 5, len(keys))
+	for i := range keys {
+		require.Equal(t, []byte{byte(15 + i)}, keys[i])
+		require.Equal(t, keys[i], values[i])
+	}
+
+	// The proof doesn't hold the other keys of the trie.
+	_, _, err = p.Range([]byte{15}, nil)
+	require.Error(t, err)
+
+	// Removing a leaf of the range makes the proof incomplete.
+	for i := range p.Leaves {
+		if p.Leaves[i].Key[0] == 17 {
+			p.Leaves = append(p.Leaves[:i], p.Leaves[i+1:]...)
+			break
+		}
+	}
+	_, _, err = p.Range([]byte{15}, []byte{20})
+	require.Error(t, err)
+
+	p, err = testTrie.GetPrefixProof([]byte{})
+	require.NoError(t, err)
+	keys, _, err = p.Prefix([]byte{})
+	require.NoError(t, err)
+	require.Equal(t, 30, len(keys))
+
+	// The proof is refused once it holds too many leaves.
+	_, err = testTrie.GetRangeProofWithLimit([]byte{}, nil, 29)
+	require.Equal(t, ErrTooManyLeaves, err)
+	p, err = testTrie.GetRangeProofWithLimit([]byte{15}, []byte{20}, 10)
+	require.NoError(t, err)
+	keys, _, err = p.Range([]byte{15}, []byte{20})
+	require.NoError(t, err)
+	require.Equal(t, 5, len(keys))
+}
+
+func TestRangeProof_HashedKeys(t *testing.T) {
+	testMemAndDisk(t, testRangeProofHashedKeys)
+}
+
+func testRangeProofHashedKeys(t *testing.T, db DB) {
+	testTrie, err := NewTrie(db, genNonce())
+	require.NoError(t, err)
+	for i := 10; i < 40; i++ {
+		k := []byte{byte(i)}
+		require.NoError(t, testTrie.Set(k, k))
+	}
+
+	// With hashed keys, the proof holds all the nodes of the trie.
+	p, err := testTrie.GetRangeProof([]byte{15}, []byte{20})
+	require.NoError(t, err)
+	require.Equal(t, 30, len(p.Leaves))
+	keys, _, err := p.Range([]byte{15}, []byte{20})
+	require.NoError(t, err)
+	require.Equal(t, 5, len(keys))
+
+	p.Leaves = p.Leaves[1:]
+	_, _, err = p.Range([]byte{15}, []byte{20})
+	require.Error(t, err)
+}
+
+func TestStagingTrie_RangeProof(t *testing.T) {
+	testMemAndDisk(t, testStagingRangeProof)
+}
+
+func testStagingRangeProof(t *testing.T, db DB) {
+	testTrie, err := NewTrie(db, genNonce())
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, testTrie.Set([]byte{1, byte(i)}, []byte{byte(i)}))
+		require.NoError(t, testTrie.Set([]byte{2, byte(i)}, []byte{byte(i)}))
+	}
+
+	staging := testTrie.MakeStagingTrie()
+	require.NoError(t, staging.Set([]byte{1, 10}, []byte{10}))
+	require.NoError(t, staging.Delete([]byte{1, 0}))
+
+	p, err := staging.GetPrefixProof([]byte{1})
+	require.NoError(t, err)
+	require.Equal(t, staging.GetRoot(), p.GetRoot())
+	keys, _, err := p.Prefix([]byte{1})
+	require.NoError(t, err)
+	require.Equal(t, 10, len(keys))
+	require.Equal(t, []byte{1, 1}, keys[0])
+	require.Equal(t, []byte{1, 10}, keys[9])
+
+	// The source trie is not modified.
+	p, err = testTrie.GetPrefixProof([]byte{1})
+	require.NoError(t, err)
+	keys, _, err = p.Prefix([]byte{1})
+	require.NoError(t, err)
+	require.Equal(t, []byte{1, 0}, keys[0])
+}
+
+func TestRangeProof_Encoding(t *testing.T) {
+	testTrie, err := NewTrieWithoutHashedKeys(NewMemDB(), genNonce())
+	require.NoError(t, err)
+	for i := 10; i < 40; i++ {
+		k := []byte{byte(i)}
+		require.NoError(t, testTrie.Set(k, k))
+	}
+	p, err := testTrie.GetRangeProof([]byte{15}, []byte{20})
+	require.NoError(t, err)
+	require.True(t, p.NoHashKey)
+
+	// The decoded proof still knows that the keys are not hashed, so it
+	// doesn't need the subtrees out of the range.
+	buf, err := protobuf.Encode(p)
+	require.NoError(t, err)
+	var decoded MultiProof
+	require.NoError(t, protobuf.Decode(buf, &decoded))
+	require.True(t, decoded.NoHashKey)
+	keys, _, err := decoded.Range([]byte{15}, []byte{20})
+	require.NoError(t, err)
+	require.Equal(t, 5, len(keys))
+
+	// The same proof of a trie with hashed keys is incomplete.
+	decoded.NoHashKey = false
+	_, _, err = decoded.Range([]byte{15}, []byte{20})
+	require.Error(t, err)
+}
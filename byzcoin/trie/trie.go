@@ -11,12 +11,16 @@ import (
 type Trie struct {
 	nonce []byte
 	db    DB
-	// We need to control the traversal during testing, so it's important
-	// to have a way to specify an actual key for traversal instead of the
-	// hash of it which we cannot predict. So we introduce the noHashKey
-	// flag, which should only be used in the unit test. (There is a copy of
-	// it in Proof as well.)
+	// If noHashKey is set, the keys themselves are used for the traversal
+	// instead of their hash. The unit tests use it to control the
+	// traversal, and a trie created with NewTrieWithoutHashedKeys to keep
+	// the keys of a range next to each other. (There is a copy of it in
+	// Proof and MultiProof as well.)
 	noHashKey bool
+	// If compact is set, deleting a key also replaces the interior nodes
+	// left with a single key by a leaf, so the nodes of the trie only
+	// depend on its content and not on the order of the updates.
+	compact bool
 }
 
 // GetNonce returns the stored nonce.
@@ -90,6 +94,63 @@ func NewTrie(db DB, nonce []byte) (*Trie, error) {
 	}, nil
 }
 
+// NewTrieFromNodes creates a trie with the given nonce and root in a DB that
+// already holds all the nodes of the trie, for example copied from another
+// trie. It returns an error if the DB already holds a trie or doesn't hold
+// the root node.
+func NewTrieFromNodes(db DB, nonce, root []byte) (*Trie, error) {
+	err := db.Update(func(b Bucket) error {
+		if b.Get([]byte(nonceKey)) != nil {
+			return xerrors.New("nonce already exists")
+		}
+		if b.Get([]byte(entryKey)) != nil {
+			return xerrors.New("root already exists")
+		}
+		if b.Get(root) == nil {
+			return xerrors.New("root node does not exist")
+		}
+		if err := b.Put([]byte(nonceKey), nonce); err != nil {
+			return err
+		}
+		return b.Put([]byte(entryKey), root)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Trie{
+		nonce: nonce,
+		db:    db,
+	}, nil
+}
+
+// NewTrieWithoutHashedKeys creates a new trie like NewTrie, but the keys
+// themselves are used as paths instead of their hash. So the keys of a range
+// are in the same subtrees, and a range proof only holds these subtrees. All
+// the keys must have the same length. As the paths are not random, deleting a
+// key also shrinks the trie, so that two tries with the same content have the
+// same root.
+func NewTrieWithoutHashedKeys(db DB, nonce []byte) (*Trie, error) {
+	t, err := NewTrie(db, nonce)
+	if err != nil {
+		return nil, err
+	}
+	t.noHashKey = true
+	t.compact = true
+	return t, nil
+}
+
+// LoadTrieWithoutHashedKeys loads a trie created with
+// NewTrieWithoutHashedKeys, like LoadTrie.
+func LoadTrieWithoutHashedKeys(db DB) (*Trie, error) {
+	t, err := LoadTrie(db)
+	if err != nil {
+		return nil, err
+	}
+	t.noHashKey = true
+	t.compact = true
+	return t, nil
+}
+
 // DB returns the backend DB interface which is needed for creating transaction
 // for use by the *WithBucket methods. Take extreme care when using DB
 // directly, because it offers raw access to the data. A mistake can corrupt
@@ -484,8 +545,10 @@ func (t *Trie) CopyTo(target Bucket) error {
 	})
 }
 
-// TODO for now we just replace leafs with empty nodes, which is ok but it'll
-// be better if we can "shrink" the tree as well.
+// del deletes the key under the node and returns the key of the new node, or
+// nil if the key doesn't exist. The leaf is replaced with an empty node, and
+// the interior nodes are only shrunk in a compact trie, so that the nodes of
+// the existing tries don't change.
 func (t *Trie) del(depth int, nodeKey []byte, bits []bool, key []byte, b Bucket) ([]byte, error) {
 	nodeVal := b.Get(nodeKey)
 	if len(nodeVal) == 0 {
@@ -521,31 +584,12 @@ func (t *Trie) del(depth int, nodeKey []byte, bits []bool, key []byte, b Bucket)
 		if err != nil {
 			return nil, err
 		}
-		// update this interior node
+		child := node.Right
 		if bits[depth] {
 			// look left
-			res, err := t.del(depth+1, node.Left, bits, key, b)
-			if err != nil {
-				return nil, err
-			}
-			if res == nil {
-				// not found, so do nothing
-				return nil, nil
-			}
-			// delete the old interior node
-			if err := b.Delete(node.hash()); err != nil {
-				return nil, err
-			}
-			// update this interior node
-			node.Left = res
-			nodeBuf, err := node.encode()
-			if err != nil {
-				return nil, err
-			}
-			return node.hash(), b.Put(node.hash(), nodeBuf)
+			child = node.Left
 		}
-		// look right
-		res, err := t.del(depth+1, node.Right, bits, key, b)
+		res, err := t.del(depth+1, child, bits, key, b)
 		if err != nil {
 			return nil, err
 		}
@@ -557,8 +601,22 @@ func (t *Trie) del(depth int, nodeKey []byte, bits []bool, key []byte, b Bucket)
 		if err := b.Delete(node.hash()); err != nil {
 			return nil, err
 		}
+		if bits[depth] {
+			node.Left = res
+		} else {
+			node.Right = res
+		}
+		// The root stays an interior node.
+		if t.compact && depth > 0 {
+			shrunk, err := t.shrink(bits[:depth], node, b)
+			if err != nil {
+				return nil, err
+			}
+			if shrunk != nil {
+				return shrunk, nil
+			}
+		}
 		// update this interior node
-		node.Right = res
 		nodeBuf, err := node.encode()
 		if err != nil {
 			return nil, err
@@ -568,6 +626,55 @@ func (t *Trie) del(depth int, nodeKey []byte, bits []bool, key []byte, b Bucket)
 	return nil, xerrors.New("invalid node type")
 }
 
+// shrink replaces the children of an interior node at the given prefix by a
+// single node if they hold at most one key: the leaf of the key moved up to
+// the prefix, or an empty node. This is where Set would put the key in an
+// empty subtree. It returns the key of the new node, or nil if the children
+// are kept.
+func (t *Trie) shrink(prefix []bool, node interiorNode, b Bucket) ([]byte, error) {
+	leftVal := b.Get(node.Left)
+	rightVal := b.Get(node.Right)
+	if len(leftVal) == 0 || len(rightVal) == 0 {
+		return nil, xerrors.New("node key does not exist in shrink")
+	}
+	var leafVal []byte
+	switch {
+	case nodeType(leftVal[0]) == typeEmpty && nodeType(rightVal[0]) == typeEmpty:
+	case nodeType(leftVal[0]) == typeEmpty && nodeType(rightVal[0]) == typeLeaf:
+		leafVal = clone(rightVal)
+	case nodeType(leftVal[0]) == typeLeaf && nodeType(rightVal[0]) == typeEmpty:
+		leafVal = clone(leftVal)
+	default:
+		return nil, nil
+	}
+	if err := b.Delete(node.Left); err != nil {
+		return nil, err
+	}
+	if err := b.Delete(node.Right); err != nil {
+		return nil, err
+	}
+
+	prefix = append([]bool{}, prefix...)
+	if leafVal == nil {
+		empty := newEmptyNode(prefix)
+		emptyBuf, err := empty.encode()
+		if err != nil {
+			return nil, err
+		}
+		return empty.hash(t.nonce), b.Put(empty.hash(t.nonce), emptyBuf)
+	}
+	old, err := decodeLeafNode(leafVal)
+	if err != nil {
+		return nil, err
+	}
+	leaf := newLeafNode(prefix, old.Key, old.Value)
+	leafBuf, err := leaf.encode()
+	if err != nil {
+		return nil, err
+	}
+	return leaf.hash(t.nonce), b.Put(leaf.hash(t.nonce), leafBuf)
+}
+
 // ForEach runs the callback cb on every key/value pair of the trie. The
 // iteration stops and the function returns an error when the callback returns
 // an error.
@@ -582,6 +689,161 @@ func (t *Trie) ForEach(cb func(k, v []byte) error) error {
 	})
 }
 
+// ForEachPathNode runs the callback cb on the key and the value of every node
+// on the paths of the keys, once per node. These are the nodes that are
+// replaced in the DB when the keys are set or deleted, except in a trie
+// created with NewTrieWithoutHashedKeys where a deletion can also replace the
+// sibling of a node on the path.
+func (t *Trie) ForEachPathNode(keys [][]byte, cb func(k, v []byte) error) error {
+	return t.db.View(func(b Bucket) error {
+		rootKey := t.GetRootWithBucket(b)
+		if rootKey == nil {
+			return xerrors.New("no root key")
+		}
+		seen := make(map[string]bool)
+		for _, key := range keys {
+			bits := t.binSlice(key)
+			nodeKey := rootKey
+			for depth := 0; ; depth++ {
+				nodeVal := b.Get(nodeKey)
+				if len(nodeVal) == 0 {
+					return xerrors.New("node key does not exist in ForEachPathNode")
+				}
+				if !seen[string(nodeKey)] {
+					seen[string(nodeKey)] = true
+					if err := cb(clone(nodeKey), clone(nodeVal)); err != nil {
+						return err
+					}
+				}
+				if nodeType(nodeVal[0]) != typeInterior {
+					break
+				}
+				node, err := decodeInteriorNode(nodeVal)
+				if err != nil {
+					return err
+				}
+				if depth >= len(bits) {
+					return xerrors.New("path is too long")
+				}
+				if bits[depth] {
+					nodeKey = node.Left
+				} else {
+					nodeKey = node.Right
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// NodeChildren returns the keys of the left and the right children of an
+// encoded node, or nil if it is not an interior node.
+func NodeChildren(nodeVal []byte) ([][]byte, error) {
+	if len(nodeVal) == 0 {
+		return nil, xerrors.New("empty node")
+	}
+	if nodeType(nodeVal[0]) != typeInterior {
+		return nil, nil
+	}
+	node, err := decodeInteriorNode(nodeVal)
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{node.Left, node.Right}, nil
+}
+
+// errStopIteration stops the traversal of ForEachAfter once the limit is
+// reached.
+var errStopIteration = xerrors.New("stop iteration")
+
+// ForEachAfter runs the callback cb on at most limit key/value pairs of the
+// trie, in the order of their paths, starting after the given key, or at the
+// first pair if after is nil. The key doesn't need to be in the trie, so the
+// trie can be modified between two calls. It returns the key of the last
+// pair, or nil once the end of the trie is reached.
+func (t *Trie) ForEachAfter(after []byte, limit int,
+	cb func(k, v []byte) error) ([]byte, error) {
+	var from []bool
+	if after != nil {
+		from = t.binSlice(after)
+	}
+	var last []byte
+	count := 0
+	err := t.db.View(func(b Bucket) error {
+		rootKey := t.GetRootWithBucket(b)
+		if rootKey == nil {
+			return xerrors.New("no root key")
+		}
+		return t.forEachAfter(0, rootKey, from, b, func(k, v []byte) error {
+			if count == limit {
+				return errStopIteration
+			}
+			if err := cb(k, v); err != nil {
+				return err
+			}
+			count++
+			last = clone(k)
+			return nil
+		})
+	})
+	if err == errStopIteration {
+		return last, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// forEachAfter visits the leaves of the node in the order of their paths. If
+// from is not nil, only the leaves with a path bigger than from are visited.
+func (t *Trie) forEachAfter(depth int, nodeKey []byte, from []bool, b Bucket,
+	cb func(k, v []byte) error) error {
+	nodeVal := b.Get(nodeKey)
+	if len(nodeVal) == 0 {
+		return xerrors.New("node key does not exist in forEachAfter")
+	}
+	switch nodeType(nodeVal[0]) {
+	case typeEmpty:
+		return nil
+	case typeLeaf:
+		node, err := decodeLeafNode(nodeVal)
+		if err != nil {
+			return err
+		}
+		if from != nil && !isAfter(t.binSlice(node.Key), from) {
+			return nil
+		}
+		return cb(node.Key, node.Value)
+	case typeInterior:
+		node, err := decodeInteriorNode(nodeVal)
+		if err != nil {
+			return err
+		}
+		// The left child holds the paths with the bit set, and is visited
+		// first.
+		if from == nil || from[depth] {
+			if err := t.forEachAfter(depth+1, node.Left, from, b, cb); err != nil {
+				return err
+			}
+			from = nil
+		}
+		return t.forEachAfter(depth+1, node.Right, from, b, cb)
+	}
+	return xerrors.New("invalid node type")
+}
+
+// isAfter returns whether the path a comes after the path b in the order of
+// ForEach, which visits the paths with a set bit first.
+func isAfter(a, b []bool) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return !a[i]
+		}
+	}
+	return len(a) > len(b)
+}
+
 // IsValid checks whether the trie is valid.
 func (t *Trie) IsValid() error {
 	p := countNodeProcessor{}
@@ -390,6 +390,166 @@ func TestQuickCheck(t *testing.T) {
 	require.NoError(t, quick.Check(f, nil))
 }
 
+func TestForEachAfter(t *testing.T) {
+	testMemAndDisk(t, testForEachAfter)
+}
+
+func testForEachAfter(t *testing.T, db DB) {
+	testTrie, err := NewTrie(db, genNonce())
+	require.NoError(t, err)
+
+	var all [][]byte
+	require.NoError(t, testTrie.ForEach(func(k, v []byte) error {
+		return xerrors.New("the trie is empty")
+	}))
+	for i := 0; i < 20; i++ {
+		k := []byte{byte(i)}
+		require.NoError(t, testTrie.Set(k, k))
+	}
+	require.NoError(t, testTrie.ForEach(func(k, v []byte) error {
+		all = append(all, k)
+		return nil
+	}))
+
+	// The batches follow the order of ForEach, even if the trie changes
+	// between them.
+	var seen [][]byte
+	var after []byte
+	for i := 0; ; i++ {
+		after, err = testTrie.ForEachAfter(after, 3, func(k, v []byte) error {
+			seen = append(seen, k)
+			return nil
+		})
+		require.NoError(t, err)
+		if after == nil {
+			break
+		}
+		require.Equal(t, after, seen[len(seen)-1])
+		if i == 1 {
+			require.NoError(t, testTrie.Delete(after))
+		}
+	}
+	require.Equal(t, all, seen)
+
+	after, err = testTrie.ForEachAfter(all[len(all)-1], 3,
+		func(k, v []byte) error {
+			return xerrors.New("after the last key")
+		})
+	require.NoError(t, err)
+	require.Nil(t, after)
+}
+
+func TestCopyNodes(t *testing.T) {
+	testMemAndDisk(t, testCopyNodes)
+}
+
+func testCopyNodes(t *testing.T, db DB) {
+	nonce := genNonce()
+	testTrie, err := NewTrie(db, nonce)
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		k := []byte{byte(i)}
+		require.NoError(t, testTrie.Set(k, k))
+	}
+	require.NoError(t, testTrie.Delete([]byte{3}))
+	root := testTrie.GetRoot()
+
+	// Keep the nodes replaced by changing the keys, so the trie at the
+	// root can still be copied afterwards.
+	keys := [][]byte{{1}, {3}, {5}, {42}}
+	replaced := make(map[string][]byte)
+	require.NoError(t, testTrie.ForEachPathNode(keys, func(k, v []byte) error {
+		replaced[string(k)] = v
+		return nil
+	}))
+	require.NoError(t, testTrie.Delete([]byte{1}))
+	require.NoError(t, testTrie.Set([]byte{3}, []byte{3}))
+	require.NoError(t, testTrie.Set([]byte{5}, []byte{6}))
+	require.NoError(t, testTrie.Set([]byte{42}, []byte{42}))
+
+	copied := NewMemDB()
+	defer copied.Close()
+	todo := [][]byte{root}
+	require.NoError(t, db.View(func(b Bucket) error {
+		return copied.Update(func(c Bucket) error {
+			for len(todo) > 0 {
+				key := todo[len(todo)-1]
+				todo = todo[:len(todo)-1]
+				value := b.Get(key)
+				if value == nil {
+					value = replaced[string(key)]
+				}
+				if value == nil {
+					return xerrors.New("missing node")
+				}
+				children, err := NodeChildren(value)
+				if err != nil {
+					return err
+				}
+				todo = append(todo, children...)
+				if err := c.Put(key, clone(value)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}))
+
+	copiedTrie, err := NewTrieFromNodes(copied, nonce, root)
+	require.NoError(t, err)
+	require.NoError(t, copiedTrie.IsValid())
+	require.Equal(t, root, copiedTrie.GetRoot())
+	for i := 0; i < 20; i++ {
+		v, err := copiedTrie.Get([]byte{byte(i)})
+		require.NoError(t, err)
+		if i == 3 {
+			require.Nil(t, v)
+		} else {
+			require.Equal(t, []byte{byte(i)}, v)
+		}
+	}
+	_, err = NewTrieFromNodes(copied, nonce, root)
+	require.Error(t, err)
+	_, err = NewTrieFromNodes(NewMemDB(), nonce, root)
+	require.Error(t, err)
+}
+
+func TestCompactDelete(t *testing.T) {
+	testMemAndDisk(t, testCompactDelete)
+}
+
+func testCompactDelete(t *testing.T, db DB) {
+	nonce := genNonce()
+	testTrie, err := NewTrieWithoutHashedKeys(db, nonce)
+	require.NoError(t, err)
+	emptyRoot := testTrie.GetRoot()
+	for i := 0; i < 64; i++ {
+		k := []byte{byte(i * 37)}
+		require.NoError(t, testTrie.Set(k, k))
+	}
+	for i := 0; i < 64; i += 3 {
+		require.NoError(t, testTrie.Delete([]byte{byte(i * 37)}))
+	}
+	require.NoError(t, testTrie.IsValid())
+
+	// The same keys set in another order give the same root.
+	other, err := NewTrieWithoutHashedKeys(NewMemDB(), nonce)
+	require.NoError(t, err)
+	for i := 63; i >= 0; i-- {
+		if i%3 != 0 {
+			k := []byte{byte(i * 37)}
+			require.NoError(t, other.Set(k, k))
+		}
+	}
+	require.Equal(t, other.GetRoot(), testTrie.GetRoot())
+
+	for i := 0; i < 64; i++ {
+		require.NoError(t, testTrie.Delete([]byte{byte(i * 37)}))
+	}
+	require.NoError(t, testTrie.IsValid())
+	require.Equal(t, emptyRoot, testTrie.GetRoot())
+}
+
 type kvPair struct {
 	op  OpType
 	key []byte
@@ -0,0 +1,112 @@
+package trie
+
+import (
+	"sort"
+	"sync"
+
+	bbolt "go.etcd.io/bbolt"
+	"golang.org/x/xerrors"
+)
+
+// txDB is a read-only DB over an open boltdb transaction. It sees the
+// database as it was when the transaction began, even if it is updated
+// afterwards.
+type txDB struct {
+	tx     *bbolt.Tx
+	bucket []byte
+	sync.Mutex
+}
+
+// NewTxDB creates a read-only database from the bucket of a read-only boltdb
+// transaction. The transaction is rolled back by Close.
+func NewTxDB(tx *bbolt.Tx, bucket []byte) DB {
+	return &txDB{
+		tx:     tx,
+		bucket: bucket,
+	}
+}
+
+func (r *txDB) Update(func(Bucket) error) error {
+	return xerrors.New("cannot update a read-only database")
+}
+
+func (r *txDB) View(f func(Bucket) error) error {
+	r.Lock()
+	defer r.Unlock()
+
+	b := r.tx.Bucket(r.bucket)
+	if b == nil {
+		return xerrors.New("bucket does not exist")
+	}
+	return f(&diskBucket{b})
+}
+
+// UpdateDryRun executes the operations on an overlay of the bucket, which
+// is discarded at the end.
+func (r *txDB) UpdateDryRun(f func(Bucket) error) error {
+	r.Lock()
+	defer r.Unlock()
+
+	b := r.tx.Bucket(r.bucket)
+	if b == nil {
+		return xerrors.New("bucket does not exist")
+	}
+	return f(&overlayBucket{
+		b:       b,
+		storage: make(map[string][]byte),
+	})
+}
+
+// Close rolls back the transaction.
+func (r *txDB) Close() error {
+	return r.tx.Rollback()
+}
+
+// overlayBucket keeps the changes in memory and reads the others from the
+// underlying bucket. A deleted key is stored with a nil value.
+type overlayBucket struct {
+	b       *bbolt.Bucket
+	storage map[string][]byte
+}
+
+func (r *overlayBucket) Delete(k []byte) error {
+	r.storage[string(k)] = nil
+	return nil
+}
+
+func (r *overlayBucket) Put(k, v []byte) error {
+	r.storage[string(k)] = v
+	return nil
+}
+
+func (r *overlayBucket) Get(k []byte) []byte {
+	if v, ok := r.storage[string(k)]; ok {
+		return v
+	}
+	return r.b.Get(k)
+}
+
+func (r *overlayBucket) ForEach(f func(k, v []byte) error) error {
+	var keys []string
+	err := r.b.ForEach(func(k, v []byte) error {
+		if _, ok := r.storage[string(k)]; !ok {
+			keys = append(keys, string(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for k, v := range r.storage {
+		if v != nil {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := f([]byte(k), r.Get([]byte(k))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -8,12 +8,22 @@ import (
 	"go.dedis.ch/protobuf"
 	"golang.org/x/xerrors"
 	"sync"
+	"time"
 )
 
 // maxTxHashes of ClientTransactions are kept to early reject already sent
 // ClientTransactions.
 var maxTxHashes = 1000
 
+// scheduleInterval is how often the leader looks for scheduled instructions
+// that are due.
+var scheduleInterval = time.Second
+
+// scheduleRetry is how long the leader waits before queuing again the
+// transaction of a scheduled instruction that is still due, in case the
+// block with the transaction was not created or refused it.
+var scheduleRetry = 10 * time.Second
+
 // txPipeline gathers new ClientTransactions and VersionUpdate requests,
 // and queues them up to be proposed as new blocks.
 // With VersionRollup and newer,
@@ -57,6 +67,42 @@ func (p *txPipeline) start(currentState *proposedTransactions,
 	// Stores the known transaction-hashes to avoid double-inclusion of the
 	// same transaction.
 	var txHashes [][]byte
+	// Stores when the transactions of the scheduled instructions have been
+	// queued by the leader.
+	scheduled := make(map[string]time.Time)
+	// addTx queues the ClientTransaction if it's unique.
+	addTx := func(tx ClientTransaction) bool {
+		txh := tx.Instructions.HashWithSignatures()
+		// The transactions of the scheduled instructions have no
+		// signatures, so they are always the same and must be accepted
+		// again if a block refused them.
+		if isScheduledTx(tx) {
+			for _, queued := range p.txQueue {
+				if bytes.Equal(queued.Instructions.HashWithSignatures(), txh) {
+					return false
+				}
+			}
+			p.txQueue = append(p.txQueue, tx)
+			return true
+		}
+		for _, txHash := range txHashes {
+			if bytes.Equal(txHash, txh) {
+				return false
+			}
+		}
+		txHashes = append(txHashes, txh)
+		if len(txHashes) > maxTxHashes {
+			txHashes = txHashes[len(txHashes)-maxTxHashes:]
+		}
+
+		p.txQueue = append(p.txQueue, tx)
+		return true
+	}
+
+	// The scheduled instructions are executed by transactions that the
+	// leader adds itself.
+	scheduleTicker := time.NewTicker(scheduleInterval)
+	defer scheduleTicker.Stop()
 
 	// newBlock also serves as cache for the latest proposedTransactions: if the
 	// new block hasn't been produced, it is legit to read the channel,
@@ -92,19 +138,31 @@ leaderLoop:
 		case tx := <-p.ctxChan:
 			// A new ClientTransaction comes in - check if it's unique and
 			// put it in the queue if it is.
-			txh := tx.Instructions.HashWithSignatures()
-			for _, txHash := range txHashes {
-				if bytes.Equal(txHash, txh) {
-					log.Lvl2("Got a duplicate transaction, ignoring it")
-					continue leaderLoop
+			if !addTx(tx) {
+				log.Lvl2("Got a duplicate transaction, ignoring it")
+				continue leaderLoop
+			}
+
+		case now := <-scheduleTicker.C:
+			for h, t := range scheduled {
+				if now.Sub(t) > scheduleRetry {
+					delete(scheduled, h)
+				}
+			}
+			added := false
+			for _, tx := range p.processor.ScheduledTransactions() {
+				txh := string(tx.Instructions.HashWithSignatures())
+				if _, ok := scheduled[txh]; ok {
+					continue
+				}
+				if addTx(tx) {
+					scheduled[txh] = now
+					added = true
 				}
 			}
-			txHashes = append(txHashes, txh)
-			if len(txHashes) > maxTxHashes {
-				txHashes = txHashes[len(txHashes)-maxTxHashes:]
+			if !added {
+				continue leaderLoop
 			}
-
-			p.txQueue = append(p.txQueue, tx)
 		}
 
 		// Check if a block is pending, fetch it if it's the case
@@ -133,6 +191,8 @@ leaderLoop:
 
 		// Add as many ClientTransactions as possible to the proposedTransactions
 		// before the block gets too big, then put it in the channel.
+		// If not all of them fit, the ones paying the highest fee go first.
+		sortByFee(p.txQueue, p.processor.GetBlockSize())
 		p.txQueue = currentState.addTransactions(p.processor, p.txQueue)
 		if !currentState.isEmpty() {
 			newBlock <- currentState.copy()
@@ -186,8 +246,9 @@ type txProcessor interface {
 	// can be used. The function should only return error when there is a
 	// catastrophic failure, if the transaction is refused then it should
 	// not return error, but mark the transaction's Accept flag as false.
+	// The used gas is returned even if the transaction is refused.
 	ProcessTx(*stagingStateTrie, ClientTransaction) (StateChanges,
-		*stagingStateTrie, error)
+		*stagingStateTrie, uint64, error)
 	// ProposeBlock should take the input state and propose the block. The
 	// function should only return when a decision has been made regarding
 	// the proposal.
@@ -197,8 +258,14 @@ type txProcessor interface {
 	ProposeUpgradeBlock(Version) error
 	// GetBlockSize should return the maximum block size.
 	GetBlockSize() int
+	// GetBlockGas should return the maximum gas of a block,
+	// or 0 if the gas is not metered.
+	GetBlockGas() uint64
 	// Returns the current version of ByzCoin as per the stateTrie
 	GetVersion() (Version, error)
+	// ScheduledTransactions returns the transactions executing the
+	// scheduled instructions that are due.
+	ScheduledTransactions() []ClientTransaction
 }
 
 // defaultTxProcessor is an implementation of txProcessor that uses a
@@ -211,21 +278,21 @@ type defaultTxProcessor struct {
 }
 
 func (s *defaultTxProcessor) ProcessTx(sst *stagingStateTrie,
-	tx ClientTransaction) (StateChanges, *stagingStateTrie, error) {
+	tx ClientTransaction) (StateChanges, *stagingStateTrie, uint64, error) {
 	latest, err := s.db().GetLatestByID(s.scID)
 	if err != nil {
-		return nil, nil, xerrors.Errorf("couldn't get latest block: %v", err)
+		return nil, nil, 0, xerrors.Errorf("couldn't get latest block: %v", err)
 	}
 
 	header, err := decodeBlockHeader(latest)
 	if err != nil {
-		return nil, nil, xerrors.Errorf("decoding header: %v", err)
+		return nil, nil, 0, xerrors.Errorf("decoding header: %v", err)
 	}
 
 	tx = tx.Clone()
 	tx.Instructions.SetVersion(header.Version)
 
-	return s.processOneTx(sst, tx, s.scID, header.Timestamp)
+	return s.processOneTx(sst, tx, s.scID, header.Timestamp, nil)
 }
 
 func (s *defaultTxProcessor) ProposeBlock(state *proposedTransactions) error {
@@ -252,6 +319,20 @@ func (s *defaultTxProcessor) GetBlockSize() int {
 	return bcConfig.MaxBlockSize
 }
 
+func (s *defaultTxProcessor) GetBlockGas() uint64 {
+	st, err := s.Service.getStateTrie(s.scID)
+	if err != nil {
+		log.Error(s.ServerIdentity(), "couldn't get state trie:", err)
+		return 0
+	}
+	_, blockGas := gasLimits(st)
+	return blockGas
+}
+
+func (s *defaultTxProcessor) ScheduledTransactions() []ClientTransaction {
+	return s.scheduledTransactions(s.scID)
+}
+
 func (s *defaultTxProcessor) GetVersion() (Version, error) {
 	st, err := s.Service.getStateTrie(s.scID)
 	if err != nil {
@@ -268,6 +349,7 @@ type proposedTransactions struct {
 	scs        StateChanges
 	txs        TxResults
 	newVersion Version
+	gas        uint64
 }
 
 // size returns the size of the transactions in this state,
@@ -295,6 +377,7 @@ func (s *proposedTransactions) reset() {
 	s.scs = []StateChange{}
 	s.txs = []TxResult{}
 	s.newVersion = 0
+	s.gas = 0
 }
 
 // copy creates a shallow copy the state, we don't have the need for deep copy
@@ -305,6 +388,7 @@ func (s proposedTransactions) copy() *proposedTransactions {
 		append([]StateChange{}, s.scs...),
 		append([]TxResult{}, s.txs...),
 		0,
+		s.gas,
 	}
 }
 
@@ -316,18 +400,20 @@ func (s proposedTransactions) isEmpty() bool {
 
 // addTransactions runs the given ClientTransactions on the latest given
 // proposedTransactions.
-// Then it verifies if the resulting block would be too big,
-// and if there is space, it adds the new ClientTransaction and the
+// Then it verifies if the resulting block would be too big or use too much
+// gas, and if there is space, it adds the new ClientTransaction and the
 // StateChanges to the proposedTransactions.
 func (s *proposedTransactions) addTransactions(p txProcessor,
 	txs []ClientTransaction) []ClientTransaction {
 
+	blockGas := p.GetBlockGas()
 	for len(txs) > 0 {
 		tx := txs[0]
-		newScs, newSst, err := p.ProcessTx(s.sst, tx)
+		newScs, newSst, gas, err := p.ProcessTx(s.sst, tx)
 		txRes := TxResult{
 			ClientTransaction: tx,
 			Accepted:          err == nil,
+			GasUsed:           gas,
 		}
 
 		// If the resulting block would be too big,
@@ -336,9 +422,15 @@ func (s *proposedTransactions) addTransactions(p txProcessor,
 			break
 		}
 
+		// The same goes for the gas of accepted transactions.
+		if txRes.Accepted && blockGas > 0 && s.gas+gas > blockGas {
+			break
+		}
+
 		if txRes.Accepted {
 			s.sst = newSst
 			s.scs = append(s.scs, newScs...)
+			s.gas += gas
 		}
 		s.txs = append(s.txs, txRes)
 		txs = txs[1:]
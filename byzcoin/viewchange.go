@@ -108,6 +108,18 @@ func (m *viewChangeManager) waiting(k string) bool {
 	return c.Waiting()
 }
 
+// status returns the state of the controller of the chain, and false if
+// there is no controller.
+func (m *viewChangeManager) status(scID skipchain.SkipBlockID) (viewchange.Status, bool) {
+	m.Lock()
+	defer m.Unlock()
+	c, ok := m.controllers[string(scID)]
+	if !ok {
+		return viewchange.Status{}, false
+	}
+	return c.Status(), true
+}
+
 func (m *viewChangeManager) closeAll() {
 	m.Lock()
 	defer m.Unlock()
@@ -304,6 +316,8 @@ func (s *Service) handleViewChangeReq(env *network.Envelope) error {
 		return xerrors.Errorf("%v: %v", s.ServerIdentity(), err)
 	}
 
+	s.collectEvidence(*req, reqLatest)
+
 	log.Lvlf2("Adding valid view-change from %s: %+v", env.ServerIdentity, req)
 	// Store it in our log.
 	s.viewChangeMan.addReq(*req)
@@ -424,6 +438,7 @@ func (s *Service) verifyViewChange(msg []byte, data []byte) bool {
 			log.Error(s.ServerIdentity(), err)
 			return false
 		}
+		s.collectEvidence(p, sb)
 	}
 	log.Lvl2(s.ServerIdentity(), "view-change verification OK")
 	return true
@@ -492,7 +507,7 @@ func (s *Service) createViewChangeBlock(req viewchange.NewViewReq, multisig []by
 		return xerrors.Errorf("signing tx: %v", err)
 	}
 
-	_, err = s.createNewBlock(req.GetGen(), rotateRoster(sb.Roster, req.GetView().LeaderIndex), []TxResult{{ctx, false}})
+	_, err = s.createNewBlock(req.GetGen(), rotateRoster(sb.Roster, req.GetView().LeaderIndex), []TxResult{{ClientTransaction: ctx}})
 	return cothority.ErrorOrNil(err, "creating block")
 }
 
@@ -90,6 +90,7 @@ type Controller struct {
 	reqChan          chan InitReq
 	doneChan         chan View
 	waiting          chan chan bool
+	status           chan chan Status
 	closeMonitorChan chan bool
 	sendInitReq      SendInitReqFunc
 	sendNewViewReq   SendNewViewReqFunc
@@ -108,6 +109,7 @@ func NewController(sendInitReq SendInitReqFunc, sendNewView SendNewViewReqFunc,
 		reqChan:          make(chan InitReq, 1),
 		doneChan:         make(chan View, 1),
 		waiting:          make(chan chan bool, 1),
+		status:           make(chan chan Status, 1),
 		closeMonitorChan: make(chan bool),
 		sendInitReq:      sendInitReq,
 		sendNewViewReq:   sendNewView,
@@ -140,6 +142,8 @@ func (c *Controller) Start(myID network.ServerIdentityID,
 		<-timer.C
 	}
 	var ctr int
+	// since is when ctr left 0, i.e., when the current view-change started.
+	var since time.Time
 	// The loop below implements the view-change state machine. It can be
 	// in one of three states (defined in state) and four transitions
 	// (close is not a transition) defined in the case statements below.
@@ -261,10 +265,24 @@ func (c *Controller) Start(myID network.ServerIdentityID,
 			} else {
 				ch <- false
 			}
+		case ch := <-c.status:
+			ch <- Status{
+				LeaderIndex: ctr,
+				View:        meta.currOf(ctr),
+				State:       int(meta.stateOf(ctr)),
+				Requests:    meta.countOf(ctr),
+				Threshold:   threshold,
+				Since:       since,
+			}
 		case <-c.closeMonitorChan:
 			stopTimer(timer, c.stopTimerChan, ctr)
 			return
 		}
+		if ctr == 0 {
+			since = time.Time{}
+		} else if since.IsZero() {
+			since = time.Now()
+		}
 	}
 }
 
@@ -316,6 +334,38 @@ func (c *Controller) Waiting() bool {
 	return <-ch
 }
 
+// Status returns the state of the view-change of the controller.
+func (c *Controller) Status() Status {
+	ch := make(chan Status, 1)
+	c.status <- ch
+	return <-ch
+}
+
+// Status describes the view-change a controller is working on.
+type Status struct {
+	// LeaderIndex is the leader index of the current view-change, or 0
+	// if there is none.
+	LeaderIndex int
+	// View is the view of the current view-change.
+	View View
+	// State is StateInitial, StateSentRequest or StateStartedTimer.
+	State int
+	// Requests is the number of requests received for the view.
+	Requests int
+	// Threshold is the number of requests needed to start the timer.
+	Threshold int
+	// Since is when the controller detected the need for the view-change,
+	// or the zero time if there is none.
+	Since time.Time
+}
+
+// The states of the view-change of a controller, as given by Status.
+const (
+	StateInitial      = int(initialState)
+	StateSentRequest  = int(sentReqState)
+	StateStartedTimer = int(startedTimerState)
+)
+
 // InitReq is the request that is sent by SendInitReqFunc. It is the
 // "view-change" message from the PBFT paper.
 type InitReq struct {
@@ -99,6 +99,13 @@ func testNormal(t *testing.T, f int) {
 
 	// Check that view-change is in progress.
 	require.True(t, vcl.Waiting())
+	status := vcl.Status()
+	require.Equal(t, 1, status.LeaderIndex)
+	require.Equal(t, view.ID, status.View.ID)
+	require.Equal(t, StateStartedTimer, status.State)
+	require.Equal(t, 2*f+1, status.Requests)
+	require.Equal(t, 2*f+1, status.Threshold)
+	require.False(t, status.Since.IsZero())
 
 	// If we signal that the view-change completed successfully, then
 	// everything should be reset.
@@ -112,6 +119,9 @@ func testNormal(t *testing.T, f int) {
 
 	// Check that view-change is finsihed.
 	require.False(t, vcl.Waiting())
+	status = vcl.Status()
+	require.Equal(t, 0, status.LeaderIndex)
+	require.True(t, status.Since.IsZero())
 }
 
 func testTimeout(t *testing.T, f int) {
@@ -0,0 +1,169 @@
+package byzcoin
+
+import (
+	"encoding/binary"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin/viewchange"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+	bbolt "go.etcd.io/bbolt"
+	"golang.org/x/xerrors"
+)
+
+var bucketViewChanges = []byte("viewchanges")
+
+// viewChangeStorage stores the ViewChangeRecords of every skipchain in its
+// own bucket, using the block index as the key.
+type viewChangeStorage struct {
+	db     *bbolt.DB
+	bucket []byte
+}
+
+func newViewChangeStorage(c *onet.Context) *viewChangeStorage {
+	db, name := c.GetAdditionalBucket(bucketViewChanges)
+	return &viewChangeStorage{
+		db:     db,
+		bucket: name,
+	}
+}
+
+// store adds the record of a view-change block of the chain.
+func (s *viewChangeStorage) store(scID skipchain.SkipBlockID,
+	record ViewChangeRecord) error {
+	buf, err := protobuf.Encode(&record)
+	if err != nil {
+		return xerrors.Errorf("encoding: %v", err)
+	}
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(record.BlockIndex))
+	err = s.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.Bucket(s.bucket).CreateBucketIfNotExists(scID)
+		if err != nil {
+			return xerrors.Errorf("creating bucket: %v", err)
+		}
+		return cothority.ErrorOrNil(b.Put(key, buf), "writing item")
+	})
+	return cothority.ErrorOrNil(err, "tx error")
+}
+
+// getLatest returns the latest records of the chain, from the oldest to the
+// newest. If count is 0, all the records are returned.
+func (s *viewChangeStorage) getLatest(scID skipchain.SkipBlockID,
+	count int) (records []ViewChangeRecord, err error) {
+	err = s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket).Bucket(scID)
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			if count > 0 && len(records) == count {
+				break
+			}
+			var record ViewChangeRecord
+			err := protobuf.DecodeWithConstructors(v, &record,
+				network.DefaultConstructors(cothority.Suite))
+			if err != nil {
+				return xerrors.Errorf("decoding: %v", err)
+			}
+			records = append(records, record)
+		}
+		return nil
+	})
+	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+		records[i], records[j] = records[j], records[i]
+	}
+	err = cothority.ErrorOrNil(err, "tx error")
+	return
+}
+
+// InitReqs returns the view-change requests of the proof of the record.
+func (r ViewChangeRecord) InitReqs(scID skipchain.SkipBlockID) []viewchange.InitReq {
+	reqs := make([]viewchange.InitReq, len(r.Proof))
+	for i, p := range r.Proof {
+		reqs[i] = viewchange.InitReq{
+			View: viewchange.View{
+				ID:          r.ViewID,
+				Gen:         scID,
+				LeaderIndex: r.LeaderIndex,
+			},
+			Signature: p.Signature,
+		}
+		copy(reqs[i].SignerID[:], p.SignerID)
+	}
+	return reqs
+}
+
+// recordViewChange stores the record of the view-change block. The time at
+// which the view-change started is only known if the controller of the node
+// took part in it.
+func (s *Service) recordViewChange(sb *skipchain.SkipBlock,
+	header *DataHeader, req *viewchange.NewViewReq) {
+	view := req.GetView()
+	prev := s.db().GetByID(sb.BackLinkIDs[0])
+	if view == nil || prev == nil {
+		return
+	}
+	prevHeader, err := decodeBlockHeader(prev)
+	if err != nil {
+		log.Error(s.ServerIdentity(), "couldn't decode header:", err)
+		return
+	}
+
+	record := ViewChangeRecord{
+		BlockIndex:        sb.Index,
+		BlockID:           sb.Hash,
+		ViewID:            view.ID,
+		LeaderIndex:       view.LeaderIndex,
+		OldLeader:         prev.Roster.List[0],
+		NewLeader:         sb.Roster.List[0],
+		PreviousTimestamp: prevHeader.Timestamp,
+		Timestamp:         header.Timestamp,
+	}
+	for _, p := range req.Proof {
+		record.Proof = append(record.Proof, ViewChangeProof{
+			SignerID:  append([]byte{}, p.SignerID[:]...),
+			Signature: p.Signature,
+		})
+	}
+	if status, ok := s.viewChangeMan.status(sb.SkipChainID()); ok &&
+		!status.Since.IsZero() {
+		record.Started = status.Since.UnixNano()
+	}
+
+	if err := s.viewChanges.store(sb.SkipChainID(), record); err != nil {
+		log.Error(s.ServerIdentity(), "couldn't store the view-change:", err)
+	}
+}
+
+// GetViewChanges returns the view-changes of the chain stored by the node,
+// and the state of its view-change controller.
+func (s *Service) GetViewChanges(req *GetViewChanges) (*GetViewChangesResponse, error) {
+	if !s.hasStateTrie(req.SkipChainID) {
+		return nil, xerrors.New("unknown chain")
+	}
+	records, err := s.viewChanges.getLatest(req.SkipChainID, req.Count)
+	if err != nil {
+		return nil, xerrors.Errorf("reading view-changes: %v", err)
+	}
+
+	resp := &GetViewChangesResponse{Records: records}
+	if status, ok := s.viewChangeMan.status(req.SkipChainID); ok {
+		resp.Status = ViewChangeStatus{
+			Running:     true,
+			LeaderIndex: status.LeaderIndex,
+			ViewID:      status.View.ID,
+			State:       status.State,
+			Requests:    status.Requests,
+			Threshold:   status.Threshold,
+		}
+		if !status.Since.IsZero() {
+			resp.Status.Since = status.Since.UnixNano()
+		}
+	}
+	return resp, nil
+}
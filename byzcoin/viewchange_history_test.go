@@ -0,0 +1,77 @@
+package byzcoin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin/viewchange"
+	"go.dedis.ch/kyber/v3/sign/schnorr"
+)
+
+func TestViewChangeStorage(t *testing.T) {
+	b := newBCT(t, nil)
+	defer b.CloseAll()
+
+	s := b.Services[0]
+	scID := getSBID("viewchanges")
+	records, err := s.viewChanges.getLatest(scID, 0)
+	require.NoError(t, err)
+	require.Empty(t, records)
+
+	// The records are returned in the order of the blocks.
+	for _, index := range []int{3, 1, 2} {
+		require.NoError(t, s.viewChanges.store(scID, ViewChangeRecord{
+			BlockIndex: index,
+			OldLeader:  b.Roster.List[0],
+			NewLeader:  b.Roster.List[1],
+		}))
+	}
+	records, err = s.viewChanges.getLatest(scID, 0)
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	for i, r := range records {
+		require.Equal(t, i+1, r.BlockIndex)
+		require.True(t, r.NewLeader.Equal(b.Roster.List[1]))
+	}
+	records, err = s.viewChanges.getLatest(scID, 2)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	require.Equal(t, 2, records[0].BlockIndex)
+	require.Equal(t, 3, records[1].BlockIndex)
+
+	records, err = s.viewChanges.getLatest(getSBID("other"), 0)
+	require.NoError(t, err)
+	require.Empty(t, records)
+}
+
+func TestViewChangeRecord_InitReqs(t *testing.T) {
+	b := newBCT(t, nil)
+	defer b.CloseAll()
+
+	s := b.Services[1]
+	scID := getSBID("viewchanges")
+	req := viewchange.InitReq{
+		View: viewchange.View{
+			ID:          getSBID("block"),
+			Gen:         scID,
+			LeaderIndex: 1,
+		},
+		SignerID: s.ServerIdentity().ID,
+	}
+	require.NoError(t, req.Sign(s.getPrivateKey()))
+
+	record := ViewChangeRecord{
+		ViewID:      req.View.ID,
+		LeaderIndex: req.View.LeaderIndex,
+		Proof: []ViewChangeProof{{
+			SignerID:  req.SignerID[:],
+			Signature: req.Signature,
+		}},
+	}
+	reqs := record.InitReqs(scID)
+	require.Len(t, reqs, 1)
+	require.Equal(t, req.SignerID, reqs[0].SignerID)
+	require.NoError(t, schnorr.Verify(cothority.Suite,
+		s.ServerIdentity().Public, reqs[0].Hash(), reqs[0].Signature))
+}
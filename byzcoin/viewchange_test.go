@@ -110,6 +110,21 @@ func testViewChange(t *testing.T, nHosts, nFailures int) {
 		require.True(t, leader.Equal(b.Services[nFailures].ServerIdentity()), fmt.Sprintf("%v", leader))
 	}
 
+	log.Lvl1("Verifying the view-change is recorded")
+	vcs, err := b.Services[nFailures].GetViewChanges(&GetViewChanges{
+		SkipChainID: b.Genesis.SkipChainID()})
+	require.NoError(t, err)
+	require.Len(t, vcs.Records, 1)
+	record := vcs.Records[0]
+	require.True(t, record.OldLeader.Equal(b.Services[0].ServerIdentity()))
+	require.True(t, record.NewLeader.Equal(
+		b.Services[nFailures].ServerIdentity()))
+	require.Equal(t, nFailures, record.LeaderIndex)
+	require.True(t, len(record.Proof) > 2*nFailures)
+	require.True(t, record.Timestamp > record.PreviousTimestamp)
+	require.True(t, vcs.Status.Running)
+	require.Equal(t, 0, vcs.Status.LeaderIndex)
+
 	log.Lvl1("Creating new TX")
 	b.SpawnDummy(&txArgs)
 
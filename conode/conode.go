@@ -28,6 +28,7 @@ import (
 	_ "go.dedis.ch/cothority/v3/evoting/service"
 	_ "go.dedis.ch/cothority/v3/skipchain"
 	status "go.dedis.ch/cothority/v3/status/service"
+	_ "go.dedis.ch/cothority/v3/wasm"
 	"go.dedis.ch/kyber/v3/util/encoding"
 	"go.dedis.ch/kyber/v3/util/key"
 	"go.dedis.ch/onet/v3/app"
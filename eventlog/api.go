@@ -3,7 +3,6 @@ package eventlog
 import (
 	"bytes"
 	"errors"
-	"sync"
 
 	"go.dedis.ch/cothority/v3"
 	"go.dedis.ch/cothority/v3/byzcoin"
@@ -246,66 +245,60 @@ func (c *Client) StreamEvents(handler StreamHandler) error {
 // event from (inclusive) the given block ID until the connection is closed or
 // the server stops.
 func (c *Client) StreamEventsFrom(handler StreamHandler, id []byte) error {
-	// 1. stream to a buffer (because we don't know which ones will be duplicates yet)
-	blockChan := make(chan blockOrErr, 100)
-	// The done channel is also buffered if a panic occurs in the client handler which
-	// would prevent the wait group to close correctly.
-	streamDone := make(chan error, 1)
-	wg := sync.WaitGroup{}
-	defer wg.Wait()
-	go func() {
-		wg.Add(1)
-		defer wg.Done()
+	es := &eventStream{
+		handler: handler,
+		id:      id,
+		latest:  -1,
+		catchUp: func() ([]*skipchain.SkipBlock, error) {
+			return c.sc.GetUpdateChainLevel(&c.ByzCoin.Roster, id, 0, -1)
+		},
+	}
+	// the service sends the stored blocks before the following blocks
+	return c.ByzCoin.StreamFilteredTransactions(byzcoin.StreamingRequest{
+		StartID: id,
+	}, es.handle)
+}
 
-		err := c.ByzCoin.StreamTransactions(func(resp byzcoin.StreamingResponse, err error) {
-			blockChan <- blockOrErr{resp.Block, err}
-		})
-		streamDone <- err
-	}()
+// eventStream calls the handler on the events of the streamed blocks from
+// the block id. An older service ignores the StartID of the request and
+// only sends the new blocks: if the first block is not the one with id, the
+// missing blocks are fetched with catchUp.
+type eventStream struct {
+	handler StreamHandler
+	id      skipchain.SkipBlockID
+	catchUp func() ([]*skipchain.SkipBlock, error)
+	started bool
+	// latest is the index of the last block handled by the catch-up.
+	latest int
+}
 
-	// 2. use GetUpdateChain to find the missing events and call handler
-	blocks, err := c.sc.GetUpdateChainLevel(&c.ByzCoin.Roster, id, 0, -1)
+func (es *eventStream) handle(resp byzcoin.StreamingResponse, err error) {
 	if err != nil {
-		return err
-	}
-	for _, b := range blocks {
-		// to keep the behaviour of the other streaming functions, we
-		// don't return an error but let the handler decide what to do
-		// with the error
-		_ = handleBlocks(handler, b)
-	}
-
-	var latest *skipchain.SkipBlock
-	if len(blocks) > 0 {
-		latest = blocks[len(blocks)-1]
+		es.handler(Event{}, nil, err)
+		return
 	}
-
-	// 3. read from the buffer, remove duplicates and call the handler
-	var foundLink bool
-	for {
-		select {
-		case bOrErr := <-blockChan:
-			if bOrErr.err != nil {
-				handler(Event{}, nil, bOrErr.err)
-				break
-			}
-			if !foundLink {
-				if bOrErr.block.BackLinkIDs[0].Equal(latest.Hash) {
-					foundLink = true
-				}
+	if !es.started {
+		es.started = true
+		if !resp.Block.Hash.Equal(es.id) {
+			blocks, err := es.catchUp()
+			if err != nil {
+				es.handler(Event{}, nil, err)
 			}
-			if foundLink {
-				_ = handleBlocks(handler, bOrErr.block)
+			for _, b := range blocks {
+				// to keep the behaviour of the other streaming
+				// functions, we don't return an error but let the
+				// handler decide what to do with the error
+				_ = handleBlocks(es.handler, b)
+				es.latest = b.Index
 			}
-		case err := <-streamDone:
-			return err
 		}
 	}
-}
-
-type blockOrErr struct {
-	block *skipchain.SkipBlock
-	err   error
+	// the blocks of the catch-up can be streamed again
+	if resp.Block.Index <= es.latest {
+		return
+	}
+	// don't need to handle error because it's given to the handler
+	_ = handleBlocks(es.handler, resp.Block)
 }
 
 // handleBlocks calls the handler on the events of the block
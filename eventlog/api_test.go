@@ -467,6 +467,71 @@ func TestClient_StreamEventsFrom(t *testing.T) {
 	wg.Wait()
 }
 
+// Checks that the missing blocks are fetched when the service doesn't send
+// the stored blocks, and that they are not handled twice.
+func TestEventStream_CatchUp(t *testing.T) {
+	blocks := make([]*skipchain.SkipBlock, 4)
+	for i := range blocks {
+		blocks[i] = eventBlock(t, i)
+	}
+	var topics []string
+	h := func(e Event, sb []byte, err error) {
+		require.NoError(t, err)
+		topics = append(topics, e.Topic)
+	}
+
+	// A service sending the stored blocks.
+	es := &eventStream{handler: h, id: blocks[1].Hash, latest: -1}
+	for _, sb := range blocks[1:] {
+		es.handle(byzcoin.StreamingResponse{Block: sb}, nil)
+	}
+	require.Equal(t, []string{"1", "2", "3"}, topics)
+
+	// An older service only sending the new blocks.
+	topics = nil
+	es = &eventStream{
+		handler: h,
+		id:      blocks[1].Hash,
+		latest:  -1,
+		catchUp: func() ([]*skipchain.SkipBlock, error) {
+			return blocks[1:3], nil
+		},
+	}
+	for _, sb := range blocks[2:] {
+		es.handle(byzcoin.StreamingResponse{Block: sb}, nil)
+	}
+	require.Equal(t, []string{"1", "2", "3"}, topics)
+}
+
+// eventBlock returns a block with the index i and an event with the topic i.
+func eventBlock(t *testing.T, i int) *skipchain.SkipBlock {
+	eventBuf, err := protobuf.Encode(&Event{Topic: fmt.Sprint(i)})
+	require.NoError(t, err)
+	body := byzcoin.DataBody{TxResults: byzcoin.TxResults{{
+		ClientTransaction: byzcoin.ClientTransaction{
+			Instructions: byzcoin.Instructions{{
+				Invoke: &byzcoin.Invoke{
+					ContractID: contractName,
+					Command:    logCmd,
+					Args: byzcoin.Arguments{{
+						Name:  "event",
+						Value: eventBuf,
+					}},
+				},
+			}},
+		},
+		Accepted: true,
+	}}}
+	sb := skipchain.NewSkipBlock()
+	sb.Index = i
+	sb.Data, err = protobuf.Encode(&byzcoin.DataHeader{})
+	require.NoError(t, err)
+	sb.Payload, err = protobuf.Encode(&body)
+	require.NoError(t, err)
+	sb.Hash = sb.CalculateHash()
+	return sb
+}
+
 func checkProof(t *testing.T, omni *byzcoin.Service, key []byte, scID skipchain.SkipBlockID) []byte {
 	req := &byzcoin.GetProof{
 		Version: byzcoin.CurrentVersion,
@@ -1039,6 +1039,29 @@ func (s *Service) DelFollow(del *DelFollow) (*EmptyReply, error) {
 	return &EmptyReply{}, nil
 }
 
+// FollowIDInternal makes sure that the given skipchain is accepted by the
+// follow rules of the node. It is meant for the other services of the conode,
+// so it doesn't need a signature. If the node doesn't restrict the skipchains
+// it accepts, nothing is changed, as adding an ID would reject all the other
+// skipchains.
+func (s *Service) FollowIDInternal(scID SkipBlockID) {
+	s.storageMutex.Lock()
+	if len(s.Storage.FollowIDs) == 0 {
+		s.storageMutex.Unlock()
+		return
+	}
+	for _, id := range s.Storage.FollowIDs {
+		if id.Equal(scID) {
+			s.storageMutex.Unlock()
+			return
+		}
+	}
+	log.Lvlf2("%s FollowChain %x", s.ServerIdentity(), scID)
+	s.Storage.FollowIDs = append(s.Storage.FollowIDs, scID)
+	s.storageMutex.Unlock()
+	s.save()
+}
+
 // ListFollow returns the skipchain-ids that are followed
 func (s *Service) ListFollow(list *ListFollow) (*ListFollowReply, error) {
 	reply := &ListFollowReply{}
@@ -1347,6 +1347,26 @@ func (db *SkipBlockDB) RemoveBlock(blockID SkipBlockID) error {
 	})
 }
 
+// RemovePayload removes the payload of the given block from the database,
+// but keeps the rest of the block. As the payload is not part of the hash of
+// the block, the block and its links can still be verified.
+func (db *SkipBlockDB) RemovePayload(blockID SkipBlockID) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		sb, err := db.getFromTx(tx, blockID)
+		if err != nil {
+			return err
+		}
+		if sb == nil {
+			return xerrors.New("unknown block")
+		}
+		if sb.Payload == nil {
+			return nil
+		}
+		sb.Payload = nil
+		return db.storeToTx(tx, sb)
+	})
+}
+
 // storeToTx stores the skipblock into the database.
 // An error is returned on failure.
 // The caller must ensure that this function is called from within a valid transaction.
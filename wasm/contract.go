@@ -0,0 +1,324 @@
+package wasm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+	"sort"
+
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/wasm/vm"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// ContractWasmID identifies the ByzCoin contract that executes WebAssembly
+// modules.
+var ContractWasmID = "wasm"
+
+// ContractWasmValueID identifies the ByzCoin contract holding the values
+// stored by a WebAssembly module. It is only a byproduct of the wasm
+// contract and does not support any instruction.
+var ContractWasmValueID = "wasm_value"
+
+const (
+	// DefaultBudget is the budget of a module spawned without a "budget"
+	// argument. The budget counts the executed instructions, the allocated
+	// memory and the work of the host functions.
+	DefaultBudget uint64 = 1e7
+	// MaxBudget is the maximum budget of a module.
+	MaxBudget uint64 = 1e8
+	// MaxCodeSize is the maximum size of a module.
+	MaxCodeSize = 1 << 20
+	// GasCodeByte is the gas charged for every byte of a module decoded by
+	// an instruction.
+	GasCodeByte uint64 = 1
+)
+
+// The exported functions called by the contract. They must have no
+// parameters, and may return an i32 error code, where 0 means success.
+const (
+	exportSpawn  = "spawn"
+	exportInvoke = "invoke"
+	exportDelete = "delete"
+)
+
+func init() {
+	log.ErrFatal(byzcoin.RegisterGlobalContract(ContractWasmID,
+		contractWasmFromBytes))
+	log.ErrFatal(byzcoin.RegisterGlobalContract(ContractWasmValueID,
+		contractWasmValueFromBytes))
+}
+
+// State is stored in a wasm instance.
+type State struct {
+	// Code is the WebAssembly module in the binary format.
+	Code []byte
+	// Budget is the budget of every execution of the module.
+	Budget uint64
+	// Data is the state of the module, given by set_state.
+	Data []byte
+	// Keys are the keys of the values stored by the module.
+	Keys [][]byte
+	// Coins are held by the instance, given to it with coins_take.
+	Coins []byzcoin.Coin
+}
+
+type contractWasm struct {
+	byzcoin.BasicContract
+	State
+}
+
+// contractWasmValue is the contract of the values stored by a module. They
+// can only be changed by the module, so every instruction is refused.
+type contractWasmValue struct {
+	byzcoin.BasicContract
+}
+
+func contractWasmValueFromBytes([]byte) (byzcoin.Contract, error) {
+	return contractWasmValue{}, nil
+}
+
+// VerifyInstruction refuses every instruction.
+func (c contractWasmValue) VerifyInstruction(byzcoin.ReadOnlyStateTrie,
+	byzcoin.Instruction, []byte) error {
+	return xerrors.New("the values of a module cannot be changed by an " +
+		"instruction")
+}
+
+// Delete is refused, as the values are removed by their module.
+func (c contractWasmValue) Delete(byzcoin.ReadOnlyStateTrie,
+	byzcoin.Instruction, []byzcoin.Coin) ([]byzcoin.StateChange,
+	[]byzcoin.Coin, error) {
+	return nil, nil, xerrors.New("the values of a module cannot be deleted")
+}
+
+func contractWasmFromBytes(in []byte) (byzcoin.Contract, error) {
+	c := &contractWasm{}
+	err := protobuf.Decode(in, &c.State)
+	if err != nil {
+		return nil, xerrors.Errorf("decoding wasm state: %v", err)
+	}
+	return c, nil
+}
+
+// Spawn creates a new wasm instance with the module given in the "code"
+// argument. If the module exports a "spawn" function, it is executed to
+// initialize the state.
+func (c *contractWasm) Spawn(rst byzcoin.ReadOnlyStateTrie,
+	inst byzcoin.Instruction, coins []byzcoin.Coin) ([]byzcoin.StateChange,
+	[]byzcoin.Coin, error) {
+	_, _, _, darcID, err := rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return nil, nil, xerrors.Errorf("getting darc: %v", err)
+	}
+
+	state := State{
+		Code:   inst.Spawn.Args.Search("code"),
+		Budget: DefaultBudget,
+	}
+	if len(state.Code) > MaxCodeSize {
+		return nil, nil, xerrors.Errorf("module is bigger than %d bytes",
+			MaxCodeSize)
+	}
+	if buf := inst.Spawn.Args.Search("budget"); buf != nil {
+		if len(buf) != 8 {
+			return nil, nil, xerrors.New("budget must be a 64-bit integer")
+		}
+		state.Budget = binary.LittleEndian.Uint64(buf)
+		if state.Budget > MaxBudget {
+			return nil, nil, xerrors.Errorf("budget is bigger than %d",
+				MaxBudget)
+		}
+	}
+	m, err := decode(rst, state.Code)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, ok := m.ExportedFunction(exportInvoke); !ok {
+		return nil, nil, xerrors.New("module doesn't export invoke")
+	}
+
+	e := newExecution(rst, inst, inst.DeriveID(""), &state, coins)
+	if err = e.run(m, exportSpawn, false); err != nil {
+		return nil, nil, err
+	}
+	return e.stateChanges(byzcoin.Create, darcID)
+}
+
+// Invoke executes the "invoke" function of the module. The command and the
+// arguments of the instruction are given to the module by the host
+// functions.
+func (c *contractWasm) Invoke(rst byzcoin.ReadOnlyStateTrie,
+	inst byzcoin.Instruction, coins []byzcoin.Coin) ([]byzcoin.StateChange,
+	[]byzcoin.Coin, error) {
+	_, _, _, darcID, err := rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return nil, nil, xerrors.Errorf("getting darc: %v", err)
+	}
+	m, err := decode(rst, c.Code)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	e := newExecution(rst, inst, inst.InstanceID, &c.State, coins)
+	if err = e.run(m, exportInvoke, true); err != nil {
+		return nil, nil, err
+	}
+	return e.stateChanges(byzcoin.Update, darcID)
+}
+
+// Delete executes the "delete" function of the module, if it is exported,
+// and removes the instance with all its values. The coins held by the
+// instance are given to the next instruction.
+func (c *contractWasm) Delete(rst byzcoin.ReadOnlyStateTrie,
+	inst byzcoin.Instruction, coins []byzcoin.Coin) ([]byzcoin.StateChange,
+	[]byzcoin.Coin, error) {
+	_, _, _, darcID, err := rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return nil, nil, xerrors.Errorf("getting darc: %v", err)
+	}
+	m, err := decode(rst, c.Code)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	e := newExecution(rst, inst, inst.InstanceID, &c.State, coins)
+	if err = e.run(m, exportDelete, false); err != nil {
+		return nil, nil, err
+	}
+	for _, key := range c.Keys {
+		e.writes[string(key)] = nil
+	}
+	for _, coin := range c.Coins {
+		if err = addCoin(&e.coins, coin.Name, coin.Value); err != nil {
+			return nil, nil, xerrors.Errorf("returning coins: %v", err)
+		}
+	}
+	return e.stateChanges(byzcoin.Remove, darcID)
+}
+
+// decode charges the size of the module to the gas of the transaction and
+// decodes it.
+func decode(rst byzcoin.ReadOnlyStateTrie, code []byte) (*vm.Module, error) {
+	if err := byzcoin.ConsumeGas(rst,
+		uint64(len(code))*GasCodeByte); err != nil {
+		return nil, xerrors.Errorf("decoding module: %w", err)
+	}
+	m, err := vm.Decode(code)
+	if err != nil {
+		return nil, xerrors.Errorf("decoding module: %v", err)
+	}
+	return m, nil
+}
+
+// valueID returns the ID of the instance holding the value of the key.
+func valueID(id byzcoin.InstanceID, key []byte) byzcoin.InstanceID {
+	h := sha256.New()
+	h.Write(id[:])
+	h.Write(key)
+	return byzcoin.NewInstanceID(h.Sum(nil))
+}
+
+// stateChanges returns the changes of the values and of the instance itself,
+// with the given action.
+func (e *execution) stateChanges(action byzcoin.StateAction,
+	darcID darc.ID) ([]byzcoin.StateChange, []byzcoin.Coin, error) {
+	var keys []string
+	for key := range e.writes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var scs []byzcoin.StateChange
+	for _, key := range keys {
+		value := e.writes[key]
+		id := valueID(e.id, []byte(key))
+		exists := e.keys[key]
+		switch {
+		case value == nil && exists:
+			scs = append(scs, byzcoin.NewStateChange(byzcoin.Remove, id,
+				ContractWasmValueID, nil, darcID))
+			e.state.Keys = removeKey(e.state.Keys, []byte(key))
+		case value == nil:
+		case exists:
+			scs = append(scs, byzcoin.NewStateChange(byzcoin.Update, id,
+				ContractWasmValueID, *value, darcID))
+		default:
+			scs = append(scs, byzcoin.NewStateChange(byzcoin.Create, id,
+				ContractWasmValueID, *value, darcID))
+			e.state.Keys = append(e.state.Keys, []byte(key))
+		}
+	}
+
+	var buf []byte
+	if action != byzcoin.Remove {
+		var err error
+		buf, err = protobuf.Encode(e.state)
+		if err != nil {
+			return nil, nil, xerrors.Errorf("encoding state: %v", err)
+		}
+	}
+	sc := byzcoin.NewStateChange(action, e.id, ContractWasmID, buf, darcID)
+	return append([]byzcoin.StateChange{sc}, scs...), e.coins, nil
+}
+
+func removeKey(keys [][]byte, key []byte) [][]byte {
+	for i := range keys {
+		if bytes.Equal(keys[i], key) {
+			return append(keys[:i:i], keys[i+1:]...)
+		}
+	}
+	return keys
+}
+
+// addCoin adds the value to the coin with the given name, or appends a new
+// coin.
+func addCoin(coins *[]byzcoin.Coin, name byzcoin.InstanceID,
+	value uint64) error {
+	for i := range *coins {
+		if (*coins)[i].Name.Equal(name) {
+			return (*coins)[i].SafeAdd(value)
+		}
+	}
+	*coins = append(*coins, byzcoin.Coin{Name: name, Value: value})
+	return nil
+}
+
+// subCoin removes the value from the coins with the given name.
+func subCoin(coins []byzcoin.Coin, name byzcoin.InstanceID,
+	value uint64) error {
+	if balance(coins, name) < value {
+		return xerrors.New("not enough coins")
+	}
+	for i := range coins {
+		if !coins[i].Name.Equal(name) {
+			continue
+		}
+		v := value
+		if coins[i].Value < v {
+			v = coins[i].Value
+		}
+		coins[i].Value -= v
+		value -= v
+	}
+	return nil
+}
+
+// balance returns the sum of the coins with the given name, saturated at the
+// maximum of an uint64.
+func balance(coins []byzcoin.Coin, name byzcoin.InstanceID) uint64 {
+	var sum uint64
+	for _, c := range coins {
+		if c.Name.Equal(name) {
+			if sum > math.MaxUint64-c.Value {
+				return math.MaxUint64
+			}
+			sum += c.Value
+		}
+	}
+	return sum
+}
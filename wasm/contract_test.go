@@ -0,0 +1,197 @@
+package wasm
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/protobuf"
+)
+
+// The modules of the tests are written in the binary format, with the
+// helpers computing the sizes, which must be smaller than 128.
+
+func section(id byte, content ...byte) []byte {
+	return append([]byte{id, byte(len(content))}, content...)
+}
+
+func name(s string) []byte {
+	return append([]byte{byte(len(s))}, s...)
+}
+
+func wasmModule(sections ...[]byte) []byte {
+	buf := []byte{0, 'a', 's', 'm', 1, 0, 0, 0}
+	for _, s := range sections {
+		buf = append(buf, s...)
+	}
+	return buf
+}
+
+func cat(parts ...[]byte) []byte {
+	var buf []byte
+	for _, p := range parts {
+		buf = append(buf, p...)
+	}
+	return buf
+}
+
+// counterModule increments the 64-bit counter stored with the key "counter"
+// at every invoke.
+func counterModule() []byte {
+	code := []byte{
+		0x00, // no locals
+		// store_get("counter", 16, 8)
+		0x41, 0, 0x41, 7, 0x41, 16, 0x41, 8, 0x10, 0,
+		// if it is missing, start with 0
+		0x41, 0x7f, 0x46,
+		0x04, 0x40, 0x41, 16, 0x42, 0, 0x37, 3, 0, 0x0b,
+		// increment the counter
+		0x41, 16, 0x41, 16, 0x29, 3, 0, 0x42, 1, 0x7c, 0x37, 3, 0,
+		// store_set("counter", 16, 8)
+		0x41, 0, 0x41, 7, 0x41, 16, 0x41, 8, 0x10, 1,
+		// return 0
+		0x41, 0, 0x0b,
+	}
+	return wasmModule(
+		section(1, cat([]byte{3},
+			[]byte{0x60, 4, 0x7f, 0x7f, 0x7f, 0x7f, 1, 0x7f},
+			[]byte{0x60, 4, 0x7f, 0x7f, 0x7f, 0x7f, 0},
+			[]byte{0x60, 0, 1, 0x7f})...),
+		section(2, cat([]byte{2},
+			name("env"), name("store_get"), []byte{0, 0},
+			name("env"), name("store_set"), []byte{0, 1})...),
+		section(3, 1, 2),
+		section(5, 1, 0, 1),
+		section(7, cat([]byte{1}, name("invoke"), []byte{0, 2})...),
+		section(10, cat([]byte{1, byte(len(code))}, code)...),
+		section(11, cat([]byte{1, 0, 0x41, 0, 0x0b},
+			name("counter"))...),
+	)
+}
+
+// loopModule never returns from invoke.
+func loopModule() []byte {
+	code := []byte{0x00, 0x03, 0x40, 0x0c, 0, 0x0b, 0x41, 0, 0x0b}
+	return wasmModule(
+		section(1, 1, 0x60, 0, 1, 0x7f),
+		section(3, 1, 0),
+		section(7, cat([]byte{1}, name("invoke"), []byte{0, 0})...),
+		section(10, cat([]byte{1, byte(len(code))}, code)...),
+	)
+}
+
+func spawnWasm(code []byte, args ...byzcoin.Argument) byzcoin.Instruction {
+	return byzcoin.Instruction{
+		Spawn: &byzcoin.Spawn{
+			ContractID: ContractWasmID,
+			Args: append(byzcoin.Arguments{{Name: "code", Value: code}},
+				args...),
+		},
+	}
+}
+
+func invokeWasm(id byzcoin.InstanceID, command string) byzcoin.Instruction {
+	return byzcoin.Instruction{
+		InstanceID: id,
+		Invoke: &byzcoin.Invoke{
+			ContractID: ContractWasmID,
+			Command:    command,
+		},
+	}
+}
+
+func TestContractWasm(t *testing.T) {
+	b := byzcoin.NewBCTestDefault(t)
+	b.AddGenesisRules("spawn:"+ContractWasmID,
+		"invoke:"+ContractWasmID+".increment")
+	b.CreateByzCoin()
+	defer b.CloseAll()
+	darcID := byzcoin.NewInstanceID(b.GenesisDarc.GetBaseID())
+
+	spawn := spawnWasm(counterModule())
+	spawn.InstanceID = darcID
+	ctx, _ := b.SendInst(nil, spawn)
+	id := ctx.Instructions[0].DeriveID("")
+	for i := 0; i < 2; i++ {
+		b.SendInst(nil, invokeWasm(id, "increment"))
+	}
+
+	rst, err := b.Services[0].GetReadOnlyStateTrie(b.Genesis.SkipChainID())
+	require.NoError(t, err)
+	value, _, contractID, _, err := rst.GetValues(
+		valueID(id, []byte("counter")).Slice())
+	require.NoError(t, err)
+	require.Equal(t, ContractWasmValueID, contractID)
+	require.Equal(t, uint64(2), binary.LittleEndian.Uint64(value))
+
+	// The values can only be changed by the module.
+	del := byzcoin.Instruction{
+		InstanceID: valueID(id, []byte("counter")),
+		Delete:     &byzcoin.Delete{ContractID: ContractWasmValueID},
+	}
+	require.NotPanics(t, func() { _ = del.String() })
+	_, resp := b.SendInst(&byzcoin.TxArgs{Wait: 10}, del)
+	require.Contains(t, resp.Error, "cannot be changed")
+	b.SignerCounter--
+
+	buf, _, _, _, err := rst.GetValues(id.Slice())
+	require.NoError(t, err)
+	var state State
+	require.NoError(t, protobuf.Decode(buf, &state))
+	require.Equal(t, [][]byte{[]byte("counter")}, state.Keys)
+	require.Equal(t, DefaultBudget, state.Budget)
+
+	// A module that never returns is stopped by its budget.
+	budget := make([]byte, 8)
+	binary.LittleEndian.PutUint64(budget, 1000)
+	spawn = spawnWasm(loopModule(), byzcoin.Argument{Name: "budget",
+		Value: budget})
+	spawn.InstanceID = darcID
+	ctx, _ = b.SendInst(nil, spawn)
+	id = ctx.Instructions[0].DeriveID("")
+	_, resp = b.SendInst(&byzcoin.TxArgs{Wait: 10},
+		invokeWasm(id, "increment"))
+	require.Contains(t, resp.Error, "out of budget")
+	b.SignerCounter--
+
+	// Invalid modules are refused.
+	spawn = spawnWasm([]byte("not a module"))
+	spawn.InstanceID = darcID
+	_, resp = b.SendInst(&byzcoin.TxArgs{Wait: 10}, spawn)
+	require.Contains(t, resp.Error, "decoding module")
+	b.SignerCounter--
+}
+
+func TestContractWasm_Gas(t *testing.T) {
+	b := byzcoin.NewBCTestDefault(t)
+	b.AddGenesisRules("spawn:"+ContractWasmID,
+		"invoke:"+ContractWasmID+".increment")
+	b.CreateByzCoin()
+	defer b.CloseAll()
+
+	config, err := b.Services[0].LoadConfig(b.Genesis.SkipChainID())
+	require.NoError(t, err)
+	config.GasLimits = &byzcoin.GasLimits{MaxBlockGas: 1e6, MaxTxGas: 1e5}
+	buf, err := protobuf.Encode(config)
+	require.NoError(t, err)
+	b.SendInst(nil, byzcoin.Instruction{
+		InstanceID: byzcoin.ConfigInstanceID,
+		Invoke: &byzcoin.Invoke{
+			ContractID: byzcoin.ContractConfigID,
+			Command:    "update_config",
+			Args:       byzcoin.Arguments{{Name: "config", Value: buf}},
+		},
+	})
+
+	// The execution of the module is charged to the gas of the
+	// transaction, which runs out before the default budget.
+	spawn := spawnWasm(loopModule())
+	spawn.InstanceID = byzcoin.NewInstanceID(b.GenesisDarc.GetBaseID())
+	ctx, _ := b.SendInst(nil, spawn)
+	id := ctx.Instructions[0].DeriveID("")
+	_, resp := b.SendInst(&byzcoin.TxArgs{Wait: 10},
+		invokeWasm(id, "increment"))
+	require.Contains(t, resp.Error, "out of gas")
+	b.SignerCounter--
+}
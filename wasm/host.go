@@ -0,0 +1,430 @@
+package wasm
+
+import (
+	"math"
+
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/wasm/vm"
+	"go.dedis.ch/onet/v3/log"
+	"golang.org/x/xerrors"
+)
+
+// The costs of the host functions, which are charged to the budget of the
+// module in addition to the gas of the transaction used by the reads of the
+// state trie.
+const (
+	// CostHostCall is charged for every call of a host function.
+	CostHostCall uint64 = 100
+	// CostHostByte is charged for every byte copied from or to the memory
+	// of the module.
+	CostHostByte uint64 = 1
+)
+
+// hostModule is the name of the module of the imported host functions.
+const hostModule = "env"
+
+// execution holds what a module can read and change during the execution of
+// an instruction.
+type execution struct {
+	rst   byzcoin.ReadOnlyStateTrie
+	inst  byzcoin.Instruction
+	id    byzcoin.InstanceID
+	state *State
+	coins []byzcoin.Coin
+	// keys are the keys stored before the execution.
+	keys map[string]bool
+	// writes are the values changed by the execution, nil for the deleted
+	// ones.
+	writes map[string]*[]byte
+	// failure is given by the module with fail.
+	failure string
+}
+
+func newExecution(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction,
+	id byzcoin.InstanceID, state *State, coins []byzcoin.Coin) *execution {
+	e := &execution{
+		rst:    rst,
+		inst:   inst,
+		id:     id,
+		state:  state,
+		coins:  append([]byzcoin.Coin{}, coins...),
+		keys:   make(map[string]bool),
+		writes: make(map[string]*[]byte),
+	}
+	for _, key := range state.Keys {
+		e.keys[string(key)] = true
+	}
+	return e
+}
+
+// run executes the exported function of the module, if it exists. If it
+// doesn't, an error is only returned if the function is required.
+func (e *execution) run(m *vm.Module, name string, required bool) error {
+	if _, ok := m.ExportedFunction(name); !ok {
+		if required {
+			return xerrors.Errorf("module doesn't export %s", name)
+		}
+		return nil
+	}
+	// The used budget is charged to the gas of the transaction, so the
+	// execution stops when there is no gas left.
+	budget := e.state.Budget
+	gasLimited := false
+	if left := byzcoin.GasLeft(e.rst); left < budget {
+		budget = left
+		gasLimited = true
+	}
+	outOfGas := func(err error) bool {
+		return gasLimited && xerrors.Is(err, vm.ErrOutOfBudget)
+	}
+	in, err := vm.Instantiate(m, e.imports(), budget)
+	if err != nil {
+		if outOfGas(err) {
+			err = byzcoin.ErrOutOfGas
+		}
+		return xerrors.Errorf("instantiating module: %w", err)
+	}
+	res, err := in.Call(name)
+	log.Lvlf3("%s of wasm instance %x used %d of its budget", name, e.id[:],
+		in.Used())
+	if errGas := byzcoin.ConsumeGas(e.rst, in.Used()); errGas != nil {
+		return xerrors.Errorf("executing %s: %w", name, errGas)
+	}
+	if outOfGas(err) {
+		return xerrors.Errorf("executing %s: %w", name, byzcoin.ErrOutOfGas)
+	}
+	if e.failure != "" {
+		return xerrors.Errorf("%s failed: %s", name, e.failure)
+	}
+	if err != nil {
+		return xerrors.Errorf("executing %s: %v", name, err)
+	}
+	if len(res) == 1 && uint32(res[0]) != 0 {
+		return xerrors.Errorf("%s returned error code %d", name,
+			int32(res[0]))
+	}
+	return nil
+}
+
+// hostFn is a host function working on the execution. The returned value is
+// only given to the module if the signature has a result.
+type hostFn func(e *execution, in *vm.Instance, args []uint64) (uint64, error)
+
+func (e *execution) imports() vm.Imports {
+	i32, i64 := vm.I32, vm.I64
+	fns := map[string]struct {
+		params []vm.ValueType
+		result []vm.ValueType
+		fn     hostFn
+	}{
+		"command":           {[]vm.ValueType{i32, i32}, []vm.ValueType{i32}, hostCommand},
+		"argument":          {[]vm.ValueType{i32, i32, i32, i32}, []vm.ValueType{i32}, hostArgument},
+		"instance_id":       {[]vm.ValueType{i32}, nil, hostInstanceID},
+		"state":             {[]vm.ValueType{i32, i32}, []vm.ValueType{i32}, hostState},
+		"set_state":         {[]vm.ValueType{i32, i32}, nil, hostSetState},
+		"store_get":         {[]vm.ValueType{i32, i32, i32, i32}, []vm.ValueType{i32}, hostStoreGet},
+		"store_set":         {[]vm.ValueType{i32, i32, i32, i32}, nil, hostStoreSet},
+		"store_delete":      {[]vm.ValueType{i32, i32}, nil, hostStoreDelete},
+		"instance_value":    {[]vm.ValueType{i32, i32, i32}, []vm.ValueType{i32}, hostInstanceValue},
+		"instance_contract": {[]vm.ValueType{i32, i32, i32}, []vm.ValueType{i32}, hostInstanceContract},
+		"timestamp":         {nil, []vm.ValueType{i64}, hostTimestamp},
+		"coins_received":    {[]vm.ValueType{i32}, []vm.ValueType{i64}, hostCoinsReceived},
+		"coins_held":        {[]vm.ValueType{i32}, []vm.ValueType{i64}, hostCoinsHeld},
+		"coins_take":        {[]vm.ValueType{i32, i64}, []vm.ValueType{i32}, hostCoinsTake},
+		"coins_give":        {[]vm.ValueType{i32, i64}, []vm.ValueType{i32}, hostCoinsGive},
+		"fail":              {[]vm.ValueType{i32, i32}, nil, hostFail},
+		"log":               {[]vm.ValueType{i32, i32}, nil, hostLog},
+	}
+
+	imports := vm.Imports{hostModule: make(map[string]vm.HostFunc)}
+	for name, f := range fns {
+		fn := f.fn
+		hasResult := len(f.result) > 0
+		imports[hostModule][name] = vm.HostFunc{
+			Type: vm.FuncType{Params: f.params, Results: f.result},
+			Fn: func(in *vm.Instance, args []uint64) ([]uint64, error) {
+				if err := in.Consume(CostHostCall); err != nil {
+					return nil, err
+				}
+				res, err := fn(e, in, args)
+				if err != nil {
+					return nil, err
+				}
+				if hasResult {
+					return []uint64{res}, nil
+				}
+				return nil, nil
+			},
+		}
+	}
+	return imports
+}
+
+// read copies the memory of the module, charging the copied bytes.
+func read(in *vm.Instance, ptr, size uint64) ([]byte, error) {
+	if err := in.Consume(size * CostHostByte); err != nil {
+		return nil, err
+	}
+	return in.Read(uint32(ptr), uint32(size))
+}
+
+// output copies as much of the data as fits in the buffer of the module and
+// returns the size of the data, so that the module can call again with a
+// bigger buffer.
+func output(in *vm.Instance, data []byte, ptr, size uint64) (uint64, error) {
+	if uint64(len(data)) < size {
+		size = uint64(len(data))
+	}
+	if err := in.Consume(size * CostHostByte); err != nil {
+		return 0, err
+	}
+	if err := in.Write(uint32(ptr), data[:size]); err != nil {
+		return 0, err
+	}
+	return uint64(len(data)), nil
+}
+
+// missing is returned as an i32 when the data doesn't exist.
+const missing = math.MaxUint32
+
+func readInstanceID(in *vm.Instance, ptr uint64) (byzcoin.InstanceID, error) {
+	buf, err := read(in, ptr, 32)
+	if err != nil {
+		return byzcoin.InstanceID{}, err
+	}
+	return byzcoin.NewInstanceID(buf), nil
+}
+
+// readInstance returns the value and the contract of an instance, or false
+// if it doesn't exist.
+func (e *execution) readInstance(id byzcoin.InstanceID) ([]byte, string,
+	bool, error) {
+	pr, err := e.rst.GetProof(id.Slice())
+	if err != nil {
+		return nil, "", false, xerrors.Errorf("reading instance: %v", err)
+	}
+	if !pr.Match(id.Slice()) {
+		return nil, "", false, nil
+	}
+	value, contractID, _, err := byzcoin.Proof{InclusionProof: *pr}.Get(
+		id.Slice())
+	if err != nil {
+		return nil, "", false, xerrors.Errorf("reading instance: %v", err)
+	}
+	return value, contractID, true, nil
+}
+
+// command(buf, len) -> size copies the command of the invoke instruction.
+func hostCommand(e *execution, in *vm.Instance, args []uint64) (uint64,
+	error) {
+	var command string
+	if e.inst.Invoke != nil {
+		command = e.inst.Invoke.Command
+	}
+	return output(in, []byte(command), args[0], args[1])
+}
+
+// argument(name, name_len, buf, len) -> size copies the argument with the
+// given name, or returns -1 if it doesn't exist.
+func hostArgument(e *execution, in *vm.Instance, args []uint64) (uint64,
+	error) {
+	name, err := read(in, args[0], args[1])
+	if err != nil {
+		return 0, err
+	}
+	for _, arg := range e.inst.Arguments() {
+		if arg.Name == string(name) {
+			return output(in, arg.Value, args[2], args[3])
+		}
+	}
+	return missing, nil
+}
+
+// instance_id(buf) copies the 32 bytes of the ID of the wasm instance.
+func hostInstanceID(e *execution, in *vm.Instance, args []uint64) (uint64,
+	error) {
+	_, err := output(in, e.id[:], args[0], 32)
+	return 0, err
+}
+
+// state(buf, len) -> size copies the state of the module.
+func hostState(e *execution, in *vm.Instance, args []uint64) (uint64,
+	error) {
+	return output(in, e.state.Data, args[0], args[1])
+}
+
+// set_state(ptr, len) replaces the state of the module.
+func hostSetState(e *execution, in *vm.Instance, args []uint64) (uint64,
+	error) {
+	data, err := read(in, args[0], args[1])
+	if err != nil {
+		return 0, err
+	}
+	e.state.Data = data
+	return 0, nil
+}
+
+// store_get(key, key_len, buf, len) -> size copies the value stored with the
+// key, or returns -1 if it doesn't exist.
+func hostStoreGet(e *execution, in *vm.Instance, args []uint64) (uint64,
+	error) {
+	key, err := read(in, args[0], args[1])
+	if err != nil {
+		return 0, err
+	}
+	if value, ok := e.writes[string(key)]; ok {
+		if value == nil {
+			return missing, nil
+		}
+		return output(in, *value, args[2], args[3])
+	}
+	if !e.keys[string(key)] {
+		return missing, nil
+	}
+	value, _, ok, err := e.readInstance(valueID(e.id, key))
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, xerrors.Errorf("value of key %x is missing", key)
+	}
+	return output(in, value, args[2], args[3])
+}
+
+// store_set(key, key_len, value, value_len) stores the value with the key.
+func hostStoreSet(e *execution, in *vm.Instance, args []uint64) (uint64,
+	error) {
+	key, err := read(in, args[0], args[1])
+	if err != nil {
+		return 0, err
+	}
+	value, err := read(in, args[2], args[3])
+	if err != nil {
+		return 0, err
+	}
+	e.writes[string(key)] = &value
+	return 0, nil
+}
+
+// store_delete(key, key_len) deletes the value stored with the key.
+func hostStoreDelete(e *execution, in *vm.Instance, args []uint64) (uint64,
+	error) {
+	key, err := read(in, args[0], args[1])
+	if err != nil {
+		return 0, err
+	}
+	e.writes[string(key)] = nil
+	return 0, nil
+}
+
+// instance_value(id, buf, len) -> size copies the value of any instance, or
+// returns -1 if it doesn't exist.
+func hostInstanceValue(e *execution, in *vm.Instance, args []uint64) (uint64,
+	error) {
+	id, err := readInstanceID(in, args[0])
+	if err != nil {
+		return 0, err
+	}
+	value, _, ok, err := e.readInstance(id)
+	if err != nil || !ok {
+		return missing, err
+	}
+	return output(in, value, args[1], args[2])
+}
+
+// instance_contract(id, buf, len) -> size copies the contract ID of any
+// instance, or returns -1 if it doesn't exist.
+func hostInstanceContract(e *execution, in *vm.Instance,
+	args []uint64) (uint64, error) {
+	id, err := readInstanceID(in, args[0])
+	if err != nil {
+		return 0, err
+	}
+	_, contractID, ok, err := e.readInstance(id)
+	if err != nil || !ok {
+		return missing, err
+	}
+	return output(in, []byte(contractID), args[1], args[2])
+}
+
+// timestamp() -> ns returns the timestamp of the block.
+func hostTimestamp(e *execution, in *vm.Instance, args []uint64) (uint64,
+	error) {
+	tr, ok := e.rst.(byzcoin.TimeReader)
+	if !ok {
+		return 0, xerrors.New("internal error: cannot convert " +
+			"ReadOnlyStateTrie to TimeReader")
+	}
+	return uint64(tr.GetCurrentBlockTimestamp()), nil
+}
+
+// coins_received(name) -> amount returns the coins of the instruction with
+// the given name.
+func hostCoinsReceived(e *execution, in *vm.Instance, args []uint64) (uint64,
+	error) {
+	name, err := readInstanceID(in, args[0])
+	if err != nil {
+		return 0, err
+	}
+	return balance(e.coins, name), nil
+}
+
+// coins_held(name) -> amount returns the coins held by the instance with the
+// given name.
+func hostCoinsHeld(e *execution, in *vm.Instance, args []uint64) (uint64,
+	error) {
+	name, err := readInstanceID(in, args[0])
+	if err != nil {
+		return 0, err
+	}
+	return balance(e.state.Coins, name), nil
+}
+
+// coins_take(name, amount) -> status moves coins of the instruction to the
+// instance. It returns -1 if there are not enough coins.
+func hostCoinsTake(e *execution, in *vm.Instance, args []uint64) (uint64,
+	error) {
+	name, err := readInstanceID(in, args[0])
+	if err != nil {
+		return 0, err
+	}
+	if subCoin(e.coins, name, args[1]) != nil {
+		return missing, nil
+	}
+	return 0, addCoin(&e.state.Coins, name, args[1])
+}
+
+// coins_give(name, amount) -> status moves coins held by the instance to the
+// instruction, so that the next instruction can store them. It returns -1
+// if the instance doesn't hold enough coins.
+func hostCoinsGive(e *execution, in *vm.Instance, args []uint64) (uint64,
+	error) {
+	name, err := readInstanceID(in, args[0])
+	if err != nil {
+		return 0, err
+	}
+	if subCoin(e.state.Coins, name, args[1]) != nil {
+		return missing, nil
+	}
+	return 0, addCoin(&e.coins, name, args[1])
+}
+
+// fail(msg, len) stops the execution with the message as error.
+func hostFail(e *execution, in *vm.Instance, args []uint64) (uint64, error) {
+	msg, err := read(in, args[0], args[1])
+	if err != nil {
+		return 0, err
+	}
+	e.failure = string(msg)
+	return 0, xerrors.New("module failed")
+}
+
+// log(msg, len) prints the message in the logs of the node.
+func hostLog(e *execution, in *vm.Instance, args []uint64) (uint64, error) {
+	msg, err := read(in, args[0], args[1])
+	if err != nil {
+		return 0, err
+	}
+	log.Lvlf2("wasm instance %x: %s", e.id[:], msg)
+	return 0, nil
+}
@@ -0,0 +1,310 @@
+package vm
+
+import (
+	"golang.org/x/xerrors"
+)
+
+// The supported opcodes.
+const (
+	opUnreachable  = 0x00
+	opNop          = 0x01
+	opBlock        = 0x02
+	opLoop         = 0x03
+	opIf           = 0x04
+	opElse         = 0x05
+	opEnd          = 0x0b
+	opBr           = 0x0c
+	opBrIf         = 0x0d
+	opBrTable      = 0x0e
+	opReturn       = 0x0f
+	opCall         = 0x10
+	opCallIndirect = 0x11
+	opDrop         = 0x1a
+	opSelect       = 0x1b
+	opLocalGet     = 0x20
+	opLocalSet     = 0x21
+	opLocalTee     = 0x22
+	opGlobalGet    = 0x23
+	opGlobalSet    = 0x24
+
+	opI32Load    = 0x28
+	opI64Load    = 0x29
+	opI32Load8S  = 0x2c
+	opI32Load8U  = 0x2d
+	opI32Load16S = 0x2e
+	opI32Load16U = 0x2f
+	opI64Load8S  = 0x30
+	opI64Load8U  = 0x31
+	opI64Load16S = 0x32
+	opI64Load16U = 0x33
+	opI64Load32S = 0x34
+	opI64Load32U = 0x35
+	opI32Store   = 0x36
+	opI64Store   = 0x37
+	opI32Store8  = 0x3a
+	opI32Store16 = 0x3b
+	opI64Store8  = 0x3c
+	opI64Store16 = 0x3d
+	opI64Store32 = 0x3e
+	opMemorySize = 0x3f
+	opMemoryGrow = 0x40
+	opI32Const   = 0x41
+	opI64Const   = 0x42
+
+	opI32Eqz  = 0x45
+	opI32Eq   = 0x46
+	opI32Ne   = 0x47
+	opI32LtS  = 0x48
+	opI32LtU  = 0x49
+	opI32GtS  = 0x4a
+	opI32GtU  = 0x4b
+	opI32LeS  = 0x4c
+	opI32LeU  = 0x4d
+	opI32GeS  = 0x4e
+	opI32GeU  = 0x4f
+	opI64Eqz  = 0x50
+	opI64Eq   = 0x51
+	opI64Ne   = 0x52
+	opI64LtS  = 0x53
+	opI64LtU  = 0x54
+	opI64GtS  = 0x55
+	opI64GtU  = 0x56
+	opI64LeS  = 0x57
+	opI64LeU  = 0x58
+	opI64GeS  = 0x59
+	opI64GeU  = 0x5a
+	opI32Clz  = 0x67
+	opI32Ctz  = 0x68
+	opI32Pop  = 0x69
+	opI32Add  = 0x6a
+	opI32Sub  = 0x6b
+	opI32Mul  = 0x6c
+	opI32DivS = 0x6d
+	opI32DivU = 0x6e
+	opI32RemS = 0x6f
+	opI32RemU = 0x70
+	opI32And  = 0x71
+	opI32Or   = 0x72
+	opI32Xor  = 0x73
+	opI32Shl  = 0x74
+	opI32ShrS = 0x75
+	opI32ShrU = 0x76
+	opI32Rotl = 0x77
+	opI32Rotr = 0x78
+	opI64Clz  = 0x79
+	opI64Ctz  = 0x7a
+	opI64Pop  = 0x7b
+	opI64Add  = 0x7c
+	opI64Sub  = 0x7d
+	opI64Mul  = 0x7e
+	opI64DivS = 0x7f
+	opI64DivU = 0x80
+	opI64RemS = 0x81
+	opI64RemU = 0x82
+	opI64And  = 0x83
+	opI64Or   = 0x84
+	opI64Xor  = 0x85
+	opI64Shl  = 0x86
+	opI64ShrS = 0x87
+	opI64ShrU = 0x88
+	opI64Rotl = 0x89
+	opI64Rotr = 0x8a
+
+	opI32WrapI64    = 0xa7
+	opI64ExtendI32S = 0xac
+	opI64ExtendI32U = 0xad
+	opI32Extend8S   = 0xc0
+	opI32Extend16S  = 0xc1
+	opI64Extend8S   = 0xc2
+	opI64Extend16S  = 0xc3
+	opI64Extend32S  = 0xc4
+)
+
+// blockTypeEmpty is the type of a block without result.
+const blockTypeEmpty = 0x40
+
+// instr is a decoded instruction. The immediate holds the constant, the index
+// or the memory offset of the instruction, and the blocks know where they
+// end, so that the branches don't need to search for it.
+type instr struct {
+	op    byte
+	arity byte
+	imm   uint64
+	// end is the index of the end of a block, loop, if or else.
+	end uint32
+	// elseAt is the index of the else of an if, or of its end if it has no
+	// else.
+	elseAt uint32
+}
+
+// compile decodes the code of a function and checks that it only uses
+// supported instructions with valid indexes.
+func (m *Module) compile(r *reader, numLocals int) ([]instr, [][]uint32,
+	error) {
+	var code []instr
+	var tables [][]uint32
+	var blocks []int
+	for r.pos < len(r.buf) {
+		op, err := r.byte()
+		if err != nil {
+			return nil, nil, err
+		}
+		in := instr{op: op}
+		switch {
+		case op == opBlock || op == opLoop || op == opIf:
+			bt, err := r.byte()
+			if err != nil {
+				return nil, nil, err
+			}
+			switch ValueType(bt) {
+			case blockTypeEmpty:
+			case I32, I64:
+				in.arity = 1
+			default:
+				return nil, nil, xerrors.Errorf("unsupported block type 0x%x",
+					bt)
+			}
+			blocks = append(blocks, len(code))
+		case op == opElse:
+			if len(blocks) == 0 {
+				return nil, nil, xerrors.New("else outside of if")
+			}
+			top := blocks[len(blocks)-1]
+			if code[top].op != opIf || code[top].elseAt != 0 {
+				return nil, nil, xerrors.New("else outside of if")
+			}
+			code[top].elseAt = uint32(len(code))
+		case op == opEnd:
+			if len(blocks) == 0 {
+				// This is the end of the function.
+				if r.pos != len(r.buf) {
+					return nil, nil, xerrors.New("code after end of function")
+				}
+				return append(code, in), tables, nil
+			}
+			top := blocks[len(blocks)-1]
+			blocks = blocks[:len(blocks)-1]
+			code[top].end = uint32(len(code))
+			if code[top].op == opIf {
+				if code[top].elseAt == 0 {
+					code[top].elseAt = uint32(len(code))
+				} else {
+					code[code[top].elseAt].end = uint32(len(code))
+				}
+			}
+		case op == opBr || op == opBrIf:
+			in.imm, err = r.uleb(32)
+			if err != nil {
+				return nil, nil, err
+			}
+			if int(in.imm) > len(blocks) {
+				return nil, nil, xerrors.Errorf("unknown label %d", in.imm)
+			}
+		case op == opBrTable:
+			n, err := r.count()
+			if err != nil {
+				return nil, nil, err
+			}
+			table := make([]uint32, n+1)
+			for i := range table {
+				if table[i], err = r.u32(); err != nil {
+					return nil, nil, err
+				}
+				if int(table[i]) > len(blocks) {
+					return nil, nil, xerrors.Errorf("unknown label %d",
+						table[i])
+				}
+			}
+			in.imm = uint64(len(tables))
+			tables = append(tables, table)
+		case op == opCall:
+			in.imm, err = r.uleb(32)
+			if err != nil {
+				return nil, nil, err
+			}
+			if int(in.imm) >= m.numFunctions() {
+				return nil, nil, xerrors.Errorf("unknown function %d", in.imm)
+			}
+		case op == opCallIndirect:
+			in.imm, err = r.uleb(32)
+			if err != nil {
+				return nil, nil, err
+			}
+			if int(in.imm) >= len(m.Types) {
+				return nil, nil, xerrors.Errorf("unknown type %d", in.imm)
+			}
+			table, err := r.byte()
+			if err != nil {
+				return nil, nil, err
+			}
+			if table != 0 || m.Table == nil {
+				return nil, nil, xerrors.New("call_indirect without table")
+			}
+		case op >= opLocalGet && op <= opLocalTee:
+			in.imm, err = r.uleb(32)
+			if err != nil {
+				return nil, nil, err
+			}
+			if int(in.imm) >= numLocals {
+				return nil, nil, xerrors.Errorf("unknown local %d", in.imm)
+			}
+		case op == opGlobalGet || op == opGlobalSet:
+			in.imm, err = r.uleb(32)
+			if err != nil {
+				return nil, nil, err
+			}
+			if int(in.imm) >= len(m.Globals) {
+				return nil, nil, xerrors.Errorf("unknown global %d", in.imm)
+			}
+			if op == opGlobalSet && !m.Globals[in.imm].Mutable {
+				return nil, nil, xerrors.Errorf("global %d is immutable",
+					in.imm)
+			}
+		case op >= opI32Load && op <= opI64Store32:
+			if op == 0x2a || op == 0x2b || op == 0x38 || op == 0x39 {
+				return nil, nil, xerrors.New(
+					"floating point numbers are not supported")
+			}
+			if m.Memory == nil {
+				return nil, nil, xerrors.New("memory access without memory")
+			}
+			// The alignment is only a hint.
+			if _, err = r.u32(); err != nil {
+				return nil, nil, err
+			}
+			in.imm, err = r.uleb(32)
+			if err != nil {
+				return nil, nil, err
+			}
+		case op == opMemorySize || op == opMemoryGrow:
+			if m.Memory == nil {
+				return nil, nil, xerrors.New("memory access without memory")
+			}
+			if b, err := r.byte(); err != nil || b != 0 {
+				return nil, nil, xerrors.New("invalid memory index")
+			}
+		case op == opI32Const:
+			v, err := r.s32()
+			if err != nil {
+				return nil, nil, err
+			}
+			in.imm = uint64(uint32(v))
+		case op == opI64Const:
+			v, err := r.s64()
+			if err != nil {
+				return nil, nil, err
+			}
+			in.imm = uint64(v)
+		case op == opUnreachable, op == opNop, op == opReturn,
+			op == opDrop, op == opSelect,
+			op >= opI32Eqz && op <= opI64GeU,
+			op >= opI32Clz && op <= opI64Rotr,
+			op == opI32WrapI64, op == opI64ExtendI32S, op == opI64ExtendI32U,
+			op >= opI32Extend8S && op <= opI64Extend32S:
+		default:
+			return nil, nil, xerrors.Errorf("unsupported instruction 0x%x", op)
+		}
+		code = append(code, in)
+	}
+	return nil, nil, xerrors.New("missing end of function")
+}
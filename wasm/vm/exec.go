@@ -0,0 +1,702 @@
+package vm
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+
+	"golang.org/x/xerrors"
+)
+
+// The limits of an instance, which make sure that a module uses a bounded
+// amount of memory, whatever its code.
+const (
+	// PageSize is the size of a page of the memory.
+	PageSize = 1 << 16
+	// MaxPages is the maximum number of pages of the memory.
+	MaxPages = 64
+	// MaxTableSize is the maximum number of elements of the table.
+	MaxTableSize = 1 << 12
+	// maxCallDepth is the maximum number of nested calls.
+	maxCallDepth = 512
+	// maxStackSize is the maximum number of values on the stack, including
+	// the locals.
+	maxStackSize = 1 << 16
+)
+
+// The costs charged to the budget of an instance.
+const (
+	// CostInstruction is charged for every executed instruction.
+	CostInstruction uint64 = 1
+	// CostPage is charged for every page of memory that is allocated.
+	CostPage uint64 = 1000
+)
+
+var (
+	// ErrOutOfBudget is returned when the execution uses more than the
+	// budget of the instance.
+	ErrOutOfBudget = xerrors.New("out of budget")
+	// ErrUnreachable is returned when the unreachable instruction is
+	// executed.
+	ErrUnreachable = xerrors.New("unreachable executed")
+)
+
+// trap is used to stop the execution from deep inside of the interpreter.
+// It is recovered by Call, which returns its error.
+type trap struct {
+	err error
+}
+
+func trapf(format string, args ...interface{}) {
+	panic(trap{xerrors.Errorf(format, args...)})
+}
+
+// HostFunc is a function implemented by the host and imported by a module.
+// The arguments and results are given as uint64, with the 32-bit integers in
+// the lower bits. An error stops the execution of the module.
+type HostFunc struct {
+	Type FuncType
+	Fn   func(in *Instance, args []uint64) ([]uint64, error)
+}
+
+// Imports holds the host functions by module and name.
+type Imports map[string]map[string]HostFunc
+
+// Instance is an instantiated module with its memory, globals and table. It
+// counts all the costs of its executions, and stops as soon as the budget is
+// exceeded.
+type Instance struct {
+	module  *Module
+	host    []HostFunc
+	memory  []byte
+	maxPage uint32
+	globals []uint64
+	table   []int64
+	stack   []uint64
+	locals  int
+	depth   int
+	budget  uint64
+	used    uint64
+}
+
+// Instantiate creates an instance of the module with the given budget. All
+// the imports of the module must be given by the host with the same
+// signature. If the module has a start function, it is executed.
+func Instantiate(m *Module, imports Imports, budget uint64) (*Instance, error) {
+	in := &Instance{module: m, budget: budget}
+	for _, imp := range m.Imports {
+		hf, ok := imports[imp.Module][imp.Name]
+		if !ok {
+			return nil, xerrors.Errorf("unknown import %s.%s", imp.Module,
+				imp.Name)
+		}
+		if !hf.Type.Equal(m.Types[imp.Type]) {
+			return nil, xerrors.Errorf("import %s.%s has the wrong type",
+				imp.Module, imp.Name)
+		}
+		in.host = append(in.host, hf)
+	}
+
+	if m.Memory != nil {
+		in.maxPage = MaxPages
+		if m.Memory.HasMax && m.Memory.Max < in.maxPage {
+			in.maxPage = m.Memory.Max
+		}
+		if m.Memory.Min > in.maxPage {
+			return nil, xerrors.Errorf("memory of %d pages is too big",
+				m.Memory.Min)
+		}
+		if err := in.Consume(uint64(m.Memory.Min) * CostPage); err != nil {
+			return nil, err
+		}
+		in.memory = make([]byte, int(m.Memory.Min)*PageSize)
+	}
+	for _, seg := range m.Data {
+		if uint64(seg.Offset)+uint64(len(seg.Data)) > uint64(len(in.memory)) {
+			return nil, xerrors.New("data segment out of memory")
+		}
+		copy(in.memory[seg.Offset:], seg.Data)
+	}
+
+	if m.Table != nil {
+		if m.Table.Min > MaxTableSize {
+			return nil, xerrors.Errorf("table of %d elements is too big",
+				m.Table.Min)
+		}
+		in.table = make([]int64, m.Table.Min)
+		for i := range in.table {
+			in.table[i] = -1
+		}
+	}
+	for _, seg := range m.Elements {
+		if uint64(seg.Offset)+uint64(len(seg.Funcs)) > uint64(len(in.table)) {
+			return nil, xerrors.New("element segment out of table")
+		}
+		for i, f := range seg.Funcs {
+			in.table[int(seg.Offset)+i] = int64(f)
+		}
+	}
+
+	in.globals = make([]uint64, len(m.Globals))
+	for i, g := range m.Globals {
+		in.globals[i] = g.Init
+	}
+
+	if m.Start != nil {
+		if _, err := in.callIndex(*m.Start); err != nil {
+			return nil, xerrors.Errorf("start function: %w", err)
+		}
+	}
+	return in, nil
+}
+
+// Call executes the exported function with the given arguments and returns
+// its results. The 32-bit integers are given in the lower bits.
+func (in *Instance) Call(name string, args ...uint64) ([]uint64, error) {
+	idx, ok := in.module.ExportedFunction(name)
+	if !ok {
+		return nil, xerrors.Errorf("unknown function %s", name)
+	}
+	if len(args) != len(in.module.funcType(idx).Params) {
+		return nil, xerrors.Errorf("%s needs %d arguments", name,
+			len(in.module.funcType(idx).Params))
+	}
+	in.stack = append(in.stack[:0], args...)
+	return in.callIndex(idx)
+}
+
+func (in *Instance) callIndex(idx uint32) (res []uint64, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			// A module must never crash the node, so the runtime errors
+			// are returned, too.
+			if t, ok := r.(trap); ok {
+				err = t.err
+			} else {
+				err = xerrors.Errorf("trap: %v", r)
+			}
+			res = nil
+			in.stack = in.stack[:0]
+			in.locals = 0
+			in.depth = 0
+		}
+	}()
+	in.call(idx)
+	res = append([]uint64{}, in.stack...)
+	in.stack = in.stack[:0]
+	return res, nil
+}
+
+// Used returns the part of the budget used so far.
+func (in *Instance) Used() uint64 {
+	return in.used
+}
+
+// Consume charges the amount to the budget and returns ErrOutOfBudget if it
+// is exceeded. It can be used by the host functions to charge their work.
+func (in *Instance) Consume(amount uint64) error {
+	if amount > in.budget-in.used {
+		in.used = in.budget
+		return ErrOutOfBudget
+	}
+	in.used += amount
+	return nil
+}
+
+// Read returns a copy of the memory at the given position.
+func (in *Instance) Read(ptr, size uint32) ([]byte, error) {
+	if uint64(ptr)+uint64(size) > uint64(len(in.memory)) {
+		return nil, xerrors.New("read out of memory")
+	}
+	return append([]byte{}, in.memory[ptr:ptr+size]...), nil
+}
+
+// Write copies the data to the memory at the given position.
+func (in *Instance) Write(ptr uint32, data []byte) error {
+	if uint64(ptr)+uint64(len(data)) > uint64(len(in.memory)) {
+		return xerrors.New("write out of memory")
+	}
+	copy(in.memory[ptr:], data)
+	return nil
+}
+
+func (in *Instance) push(v uint64) {
+	in.stack = append(in.stack, v)
+}
+
+func (in *Instance) pop() uint64 {
+	n := len(in.stack)
+	if n == 0 {
+		trapf("stack underflow")
+	}
+	v := in.stack[n-1]
+	in.stack = in.stack[:n-1]
+	return v
+}
+
+func (in *Instance) push32(v uint32) {
+	in.push(uint64(v))
+}
+
+func (in *Instance) pop32() uint32 {
+	return uint32(in.pop())
+}
+
+func (in *Instance) pushBool(b bool) {
+	if b {
+		in.push(1)
+	} else {
+		in.push(0)
+	}
+}
+
+// call executes the function with the given index, taking its arguments
+// from the stack and leaving its results there.
+func (in *Instance) call(idx uint32) {
+	ft := in.module.funcType(idx)
+	np := len(ft.Params)
+	if len(in.stack) < np {
+		trapf("stack underflow")
+	}
+	args := in.stack[len(in.stack)-np:]
+
+	if int(idx) < len(in.host) {
+		hargs := append([]uint64{}, args...)
+		in.stack = in.stack[:len(in.stack)-np]
+		res, err := in.host[idx].Fn(in, hargs)
+		if err != nil {
+			panic(trap{err})
+		}
+		if len(res) != len(ft.Results) {
+			trapf("host function returned %d results", len(res))
+		}
+		in.stack = append(in.stack, res...)
+		return
+	}
+
+	if in.depth >= maxCallDepth {
+		trapf("call stack exhausted")
+	}
+	f := &in.module.Functions[int(idx)-len(in.host)]
+	if len(in.stack)+in.locals+len(f.Locals) > maxStackSize {
+		trapf("stack exhausted")
+	}
+	locals := make([]uint64, np+len(f.Locals))
+	copy(locals, args)
+	in.stack = in.stack[:len(in.stack)-np]
+
+	in.depth++
+	in.locals += len(locals)
+	in.exec(f, locals, len(ft.Results))
+	in.locals -= len(locals)
+	in.depth--
+}
+
+// label is the target of a branch: the stack is reset to its height, keeping
+// arity values, and the execution continues at cont.
+type label struct {
+	arity  int
+	height int
+	cont   int
+}
+
+func (in *Instance) exec(f *Function, locals []uint64, arity int) {
+	code := f.code
+	base := len(in.stack)
+	labels := []label{{arity: arity, height: base, cont: len(code)}}
+	pc := 0
+
+	branch := func(depth uint64) {
+		l := labels[len(labels)-1-int(depth)]
+		if len(in.stack) < l.height+l.arity {
+			trapf("stack underflow")
+		}
+		copy(in.stack[l.height:], in.stack[len(in.stack)-l.arity:])
+		in.stack = in.stack[:l.height+l.arity]
+		labels = labels[:len(labels)-1-int(depth)]
+		pc = l.cont
+	}
+
+	for pc < len(code) {
+		if err := in.Consume(CostInstruction); err != nil {
+			panic(trap{err})
+		}
+		if len(in.stack)+in.locals > maxStackSize {
+			trapf("stack exhausted")
+		}
+		i := &code[pc]
+		pc++
+
+		switch i.op {
+		case opUnreachable:
+			panic(trap{ErrUnreachable})
+		case opNop:
+		case opBlock:
+			labels = append(labels, label{int(i.arity), len(in.stack),
+				int(i.end) + 1})
+		case opLoop:
+			// Branching to a loop executes the loop instruction again.
+			labels = append(labels, label{0, len(in.stack), pc - 1})
+		case opIf:
+			c := in.pop32()
+			labels = append(labels, label{int(i.arity), len(in.stack),
+				int(i.end) + 1})
+			if c == 0 {
+				pc = int(i.elseAt)
+				if code[pc].op == opElse {
+					pc++
+				}
+			}
+		case opElse:
+			// The then branch is done, continue at the end.
+			pc = int(i.end)
+		case opEnd:
+			labels = labels[:len(labels)-1]
+		case opBr:
+			branch(i.imm)
+		case opBrIf:
+			if in.pop32() != 0 {
+				branch(i.imm)
+			}
+		case opBrTable:
+			idx := in.pop32()
+			table := f.tables[i.imm]
+			depth := table[len(table)-1]
+			if int(idx) < len(table)-1 {
+				depth = table[idx]
+			}
+			branch(uint64(depth))
+		case opReturn:
+			branch(uint64(len(labels) - 1))
+		case opCall:
+			in.call(uint32(i.imm))
+		case opCallIndirect:
+			elem := in.pop32()
+			if int(elem) >= len(in.table) || in.table[elem] < 0 {
+				trapf("undefined element %d", elem)
+			}
+			fidx := uint32(in.table[elem])
+			if !in.module.funcType(fidx).Equal(in.module.Types[i.imm]) {
+				trapf("indirect call type mismatch")
+			}
+			in.call(fidx)
+
+		case opDrop:
+			in.pop()
+		case opSelect:
+			c := in.pop32()
+			v2 := in.pop()
+			v1 := in.pop()
+			if c != 0 {
+				in.push(v1)
+			} else {
+				in.push(v2)
+			}
+		case opLocalGet:
+			in.push(locals[i.imm])
+		case opLocalSet:
+			locals[i.imm] = in.pop()
+		case opLocalTee:
+			v := in.pop()
+			locals[i.imm] = v
+			in.push(v)
+		case opGlobalGet:
+			in.push(in.globals[i.imm])
+		case opGlobalSet:
+			in.globals[i.imm] = in.pop()
+
+		case opI32Load:
+			in.push32(binary.LittleEndian.Uint32(in.mem(i.imm, 4)))
+		case opI64Load:
+			in.push(binary.LittleEndian.Uint64(in.mem(i.imm, 8)))
+		case opI32Load8S:
+			in.push32(uint32(int32(int8(in.mem(i.imm, 1)[0]))))
+		case opI32Load8U:
+			in.push32(uint32(in.mem(i.imm, 1)[0]))
+		case opI32Load16S:
+			in.push32(uint32(int32(int16(
+				binary.LittleEndian.Uint16(in.mem(i.imm, 2))))))
+		case opI32Load16U:
+			in.push32(uint32(binary.LittleEndian.Uint16(in.mem(i.imm, 2))))
+		case opI64Load8S:
+			in.push(uint64(int64(int8(in.mem(i.imm, 1)[0]))))
+		case opI64Load8U:
+			in.push(uint64(in.mem(i.imm, 1)[0]))
+		case opI64Load16S:
+			in.push(uint64(int64(int16(
+				binary.LittleEndian.Uint16(in.mem(i.imm, 2))))))
+		case opI64Load16U:
+			in.push(uint64(binary.LittleEndian.Uint16(in.mem(i.imm, 2))))
+		case opI64Load32S:
+			in.push(uint64(int64(int32(
+				binary.LittleEndian.Uint32(in.mem(i.imm, 4))))))
+		case opI64Load32U:
+			in.push(uint64(binary.LittleEndian.Uint32(in.mem(i.imm, 4))))
+		case opI32Store, opI64Store32:
+			v := in.pop32()
+			binary.LittleEndian.PutUint32(in.mem(i.imm, 4), v)
+		case opI64Store:
+			v := in.pop()
+			binary.LittleEndian.PutUint64(in.mem(i.imm, 8), v)
+		case opI32Store8, opI64Store8:
+			v := in.pop()
+			in.mem(i.imm, 1)[0] = byte(v)
+		case opI32Store16, opI64Store16:
+			v := in.pop()
+			binary.LittleEndian.PutUint16(in.mem(i.imm, 2), uint16(v))
+		case opMemorySize:
+			in.push32(uint32(len(in.memory) / PageSize))
+		case opMemoryGrow:
+			in.push32(in.grow(in.pop32()))
+		case opI32Const, opI64Const:
+			in.push(i.imm)
+
+		default:
+			in.numeric(i.op)
+		}
+	}
+
+	if len(in.stack) < base+arity {
+		trapf("stack underflow")
+	}
+	copy(in.stack[base:], in.stack[len(in.stack)-arity:])
+	in.stack = in.stack[:base+arity]
+}
+
+// mem pops an address and returns the size bytes of memory at this address
+// plus the offset.
+func (in *Instance) mem(offset uint64, size uint64) []byte {
+	addr := uint64(in.pop32()) + offset
+	if addr+size > uint64(len(in.memory)) {
+		trapf("memory access out of bounds")
+	}
+	return in.memory[addr : addr+size]
+}
+
+// grow adds pages to the memory and returns the previous number of pages,
+// or -1 if the memory cannot grow.
+func (in *Instance) grow(pages uint32) uint32 {
+	old := uint32(len(in.memory) / PageSize)
+	if uint64(old)+uint64(pages) > uint64(in.maxPage) {
+		return math.MaxUint32
+	}
+	if err := in.Consume(uint64(pages) * CostPage); err != nil {
+		panic(trap{err})
+	}
+	in.memory = append(in.memory, make([]byte, int(pages)*PageSize)...)
+	return old
+}
+
+// numeric executes the instructions working only on the stack.
+func (in *Instance) numeric(op byte) {
+	switch op {
+	case opI32Eqz:
+		in.pushBool(in.pop32() == 0)
+	case opI64Eqz:
+		in.pushBool(in.pop() == 0)
+	case opI32WrapI64:
+		in.push32(uint32(in.pop()))
+	case opI64ExtendI32S:
+		in.push(uint64(int64(int32(in.pop32()))))
+	case opI64ExtendI32U:
+		in.push(uint64(in.pop32()))
+	case opI32Extend8S:
+		in.push32(uint32(int32(int8(in.pop32()))))
+	case opI32Extend16S:
+		in.push32(uint32(int32(int16(in.pop32()))))
+	case opI64Extend8S:
+		in.push(uint64(int64(int8(in.pop()))))
+	case opI64Extend16S:
+		in.push(uint64(int64(int16(in.pop()))))
+	case opI64Extend32S:
+		in.push(uint64(int64(int32(in.pop()))))
+	case opI32Clz:
+		in.push32(uint32(bits.LeadingZeros32(in.pop32())))
+	case opI32Ctz:
+		in.push32(uint32(bits.TrailingZeros32(in.pop32())))
+	case opI32Pop:
+		in.push32(uint32(bits.OnesCount32(in.pop32())))
+	case opI64Clz:
+		in.push(uint64(bits.LeadingZeros64(in.pop())))
+	case opI64Ctz:
+		in.push(uint64(bits.TrailingZeros64(in.pop())))
+	case opI64Pop:
+		in.push(uint64(bits.OnesCount64(in.pop())))
+	default:
+		b := in.pop()
+		a := in.pop()
+		if op <= opI64GeU || (op >= opI64Clz && op <= opI64Rotr) {
+			if op >= opI32Eq && op <= opI32GeU {
+				in.pushBool(compare32(op, uint32(a), uint32(b)))
+			} else if op >= opI64Eq && op <= opI64GeU {
+				in.pushBool(compare64(op-opI64Eq+opI32Eq, a, b))
+			} else {
+				in.push(binary64(op-opI64Clz+opI32Clz, a, b))
+			}
+		} else {
+			in.push32(binary32(op, uint32(a), uint32(b)))
+		}
+	}
+}
+
+func compare32(op byte, a, b uint32) bool {
+	switch op {
+	case opI32Eq:
+		return a == b
+	case opI32Ne:
+		return a != b
+	case opI32LtS:
+		return int32(a) < int32(b)
+	case opI32LtU:
+		return a < b
+	case opI32GtS:
+		return int32(a) > int32(b)
+	case opI32GtU:
+		return a > b
+	case opI32LeS:
+		return int32(a) <= int32(b)
+	case opI32LeU:
+		return a <= b
+	case opI32GeS:
+		return int32(a) >= int32(b)
+	default:
+		return a >= b
+	}
+}
+
+// compare64 uses the opcodes of the 32-bit comparisons.
+func compare64(op byte, a, b uint64) bool {
+	switch op {
+	case opI32Eq:
+		return a == b
+	case opI32Ne:
+		return a != b
+	case opI32LtS:
+		return int64(a) < int64(b)
+	case opI32LtU:
+		return a < b
+	case opI32GtS:
+		return int64(a) > int64(b)
+	case opI32GtU:
+		return a > b
+	case opI32LeS:
+		return int64(a) <= int64(b)
+	case opI32LeU:
+		return a <= b
+	case opI32GeS:
+		return int64(a) >= int64(b)
+	default:
+		return a >= b
+	}
+}
+
+func binary32(op byte, a, b uint32) uint32 {
+	switch op {
+	case opI32Add:
+		return a + b
+	case opI32Sub:
+		return a - b
+	case opI32Mul:
+		return a * b
+	case opI32DivS:
+		if b == 0 {
+			trapf("integer divide by zero")
+		}
+		if int32(a) == math.MinInt32 && int32(b) == -1 {
+			trapf("integer overflow")
+		}
+		return uint32(int32(a) / int32(b))
+	case opI32DivU:
+		if b == 0 {
+			trapf("integer divide by zero")
+		}
+		return a / b
+	case opI32RemS:
+		if b == 0 {
+			trapf("integer divide by zero")
+		}
+		if int32(b) == -1 {
+			return 0
+		}
+		return uint32(int32(a) % int32(b))
+	case opI32RemU:
+		if b == 0 {
+			trapf("integer divide by zero")
+		}
+		return a % b
+	case opI32And:
+		return a & b
+	case opI32Or:
+		return a | b
+	case opI32Xor:
+		return a ^ b
+	case opI32Shl:
+		return a << (b & 31)
+	case opI32ShrS:
+		return uint32(int32(a) >> (b & 31))
+	case opI32ShrU:
+		return a >> (b & 31)
+	case opI32Rotl:
+		return bits.RotateLeft32(a, int(b&31))
+	case opI32Rotr:
+		return bits.RotateLeft32(a, -int(b&31))
+	}
+	trapf("unsupported instruction 0x%x", op)
+	return 0
+}
+
+// binary64 uses the opcodes of the 32-bit operations.
+func binary64(op byte, a, b uint64) uint64 {
+	switch op {
+	case opI32Add:
+		return a + b
+	case opI32Sub:
+		return a - b
+	case opI32Mul:
+		return a * b
+	case opI32DivS:
+		if b == 0 {
+			trapf("integer divide by zero")
+		}
+		if int64(a) == math.MinInt64 && int64(b) == -1 {
+			trapf("integer overflow")
+		}
+		return uint64(int64(a) / int64(b))
+	case opI32DivU:
+		if b == 0 {
+			trapf("integer divide by zero")
+		}
+		return a / b
+	case opI32RemS:
+		if b == 0 {
+			trapf("integer divide by zero")
+		}
+		if int64(b) == -1 {
+			return 0
+		}
+		return uint64(int64(a) % int64(b))
+	case opI32RemU:
+		if b == 0 {
+			trapf("integer divide by zero")
+		}
+		return a % b
+	case opI32And:
+		return a & b
+	case opI32Or:
+		return a | b
+	case opI32Xor:
+		return a ^ b
+	case opI32Shl:
+		return a << (b & 63)
+	case opI32ShrS:
+		return uint64(int64(a) >> (b & 63))
+	case opI32ShrU:
+		return a >> (b & 63)
+	case opI32Rotl:
+		return bits.RotateLeft64(a, int(b&63))
+	case opI32Rotr:
+		return bits.RotateLeft64(a, -int(b&63))
+	}
+	trapf("unsupported instruction 0x%x", op)
+	return 0
+}
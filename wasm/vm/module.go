@@ -0,0 +1,772 @@
+package vm
+
+import (
+	"bytes"
+
+	"golang.org/x/xerrors"
+)
+
+// ValueType is the type of a WebAssembly value. Only the integer types are
+// supported, as the floating point operations are not guaranteed to give the
+// same results on all platforms.
+type ValueType byte
+
+const (
+	// I32 is a 32-bit integer.
+	I32 ValueType = 0x7f
+	// I64 is a 64-bit integer.
+	I64 ValueType = 0x7e
+)
+
+// FuncType is the signature of a function.
+type FuncType struct {
+	Params  []ValueType
+	Results []ValueType
+}
+
+// Equal returns true if both signatures are the same.
+func (ft FuncType) Equal(other FuncType) bool {
+	return bytes.Equal(valueTypes(ft.Params), valueTypes(other.Params)) &&
+		bytes.Equal(valueTypes(ft.Results), valueTypes(other.Results))
+}
+
+func valueTypes(vts []ValueType) []byte {
+	buf := make([]byte, len(vts))
+	for i, vt := range vts {
+		buf[i] = byte(vt)
+	}
+	return buf
+}
+
+// Import is a function that the module needs from the host. Only functions
+// can be imported.
+type Import struct {
+	Module string
+	Name   string
+	Type   uint32
+}
+
+// Export is a function, a memory or a global of the module that is visible to
+// the host.
+type Export struct {
+	Name  string
+	Kind  byte
+	Index uint32
+}
+
+// The kinds of exports.
+const (
+	ExportFunction byte = 0
+	ExportTable    byte = 1
+	ExportMemory   byte = 2
+	ExportGlobal   byte = 3
+)
+
+// Global is a global variable of the module with its initial value.
+type Global struct {
+	Type    ValueType
+	Mutable bool
+	Init    uint64
+}
+
+// Limits are the minimum and maximum sizes of a memory or a table. A maximum
+// of 0 with HasMax false means that there is no maximum.
+type Limits struct {
+	Min    uint32
+	Max    uint32
+	HasMax bool
+}
+
+// Function is a function defined in the module, with its code already
+// decoded.
+type Function struct {
+	Type   uint32
+	Locals []ValueType
+	code   []instr
+	tables [][]uint32
+}
+
+// Segment initializes a part of the memory or of the table.
+type Segment struct {
+	Offset uint32
+	Data   []byte
+	Funcs  []uint32
+}
+
+// Module is a decoded WebAssembly module. Every function has been checked to
+// only use supported instructions and to reference existing indexes.
+type Module struct {
+	Types     []FuncType
+	Imports   []Import
+	Functions []Function
+	Table     *Limits
+	Memory    *Limits
+	Globals   []Global
+	Exports   []Export
+	Start     *uint32
+	Elements  []Segment
+	Data      []Segment
+	numLocals int
+}
+
+// maxItems is the maximum number of items of a vector or of locals of a
+// function, which prevents a small module from allocating a lot of memory.
+const maxItems = 1 << 16
+
+// maxLocals is the maximum number of locals of all the functions.
+const maxLocals = 1 << 20
+
+// The sections of the binary format.
+const (
+	sectionCustom   = 0
+	sectionType     = 1
+	sectionImport   = 2
+	sectionFunction = 3
+	sectionTable    = 4
+	sectionMemory   = 5
+	sectionGlobal   = 6
+	sectionExport   = 7
+	sectionStart    = 8
+	sectionElement  = 9
+	sectionCode     = 10
+	sectionData     = 11
+	// sectionDataCount is only used by the bulk memory operations, which
+	// are not supported, so it is ignored.
+	sectionDataCount = 12
+)
+
+// Decode parses a module in the WebAssembly binary format. It refuses
+// modules using floating point numbers or any of the extensions of the
+// first version of WebAssembly, except for the sign-extension operators.
+func Decode(buf []byte) (*Module, error) {
+	r := &reader{buf: buf}
+	magic, err := r.bytes(8)
+	if err != nil {
+		return nil, xerrors.Errorf("reading header: %v", err)
+	}
+	if !bytes.Equal(magic, []byte{0, 'a', 's', 'm', 1, 0, 0, 0}) {
+		return nil, xerrors.New("not a WebAssembly module of version 1")
+	}
+
+	m := &Module{}
+	var funcTypes []uint32
+	last := byte(0)
+	for r.pos < len(r.buf) {
+		id, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		size, err := r.u32()
+		if err != nil {
+			return nil, xerrors.Errorf("reading section size: %v", err)
+		}
+		content, err := r.bytes(int(size))
+		if err != nil {
+			return nil, xerrors.Errorf("reading section %d: %v", id, err)
+		}
+		if id == sectionCustom || id == sectionDataCount {
+			continue
+		}
+		if id <= last {
+			return nil, xerrors.Errorf("section %d is out of order", id)
+		}
+		last = id
+
+		sr := &reader{buf: content}
+		switch id {
+		case sectionType:
+			err = m.decodeTypes(sr)
+		case sectionImport:
+			err = m.decodeImports(sr)
+		case sectionFunction:
+			funcTypes, err = m.decodeFunctions(sr)
+		case sectionTable:
+			m.Table, err = decodeSingleLimits(sr, true)
+		case sectionMemory:
+			m.Memory, err = decodeSingleLimits(sr, false)
+		case sectionGlobal:
+			err = m.decodeGlobals(sr)
+		case sectionExport:
+			err = m.decodeExports(sr)
+		case sectionStart:
+			err = m.decodeStart(sr)
+		case sectionElement:
+			err = m.decodeElements(sr)
+		case sectionCode:
+			err = m.decodeCode(sr, funcTypes)
+		case sectionData:
+			err = m.decodeData(sr)
+		default:
+			err = xerrors.New("unknown section")
+		}
+		if err != nil {
+			return nil, xerrors.Errorf("section %d: %v", id, err)
+		}
+		if sr.pos != len(sr.buf) {
+			return nil, xerrors.Errorf("section %d: trailing bytes", id)
+		}
+	}
+	if len(funcTypes) != len(m.Functions) {
+		return nil, xerrors.New("functions without code")
+	}
+	if err := m.checkFunctionIndexes(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// checkFunctionIndexes verifies the references to functions outside of the
+// code, which can only be done once all the functions are known.
+func (m *Module) checkFunctionIndexes() error {
+	for _, e := range m.Exports {
+		if e.Kind == ExportFunction && int(e.Index) >= m.numFunctions() {
+			return xerrors.Errorf("export %s: unknown function %d", e.Name,
+				e.Index)
+		}
+	}
+	if m.Start != nil {
+		if int(*m.Start) >= m.numFunctions() {
+			return xerrors.Errorf("unknown start function %d", *m.Start)
+		}
+		if !m.funcType(*m.Start).Equal(FuncType{}) {
+			return xerrors.New("start function must have no parameters " +
+				"and no results")
+		}
+	}
+	for _, seg := range m.Elements {
+		for _, f := range seg.Funcs {
+			if int(f) >= m.numFunctions() {
+				return xerrors.Errorf("element: unknown function %d", f)
+			}
+		}
+	}
+	return nil
+}
+
+// numFunctions returns the number of functions of the module, including the
+// imported ones, which come first.
+func (m *Module) numFunctions() int {
+	return len(m.Imports) + len(m.Functions)
+}
+
+// funcType returns the signature of the function with the given index.
+func (m *Module) funcType(idx uint32) FuncType {
+	if int(idx) < len(m.Imports) {
+		return m.Types[m.Imports[idx].Type]
+	}
+	return m.Types[m.Functions[int(idx)-len(m.Imports)].Type]
+}
+
+// ExportedFunction returns the index of the exported function with the given
+// name.
+func (m *Module) ExportedFunction(name string) (uint32, bool) {
+	for _, e := range m.Exports {
+		if e.Kind == ExportFunction && e.Name == name {
+			return e.Index, true
+		}
+	}
+	return 0, false
+}
+
+func (m *Module) decodeTypes(r *reader) error {
+	n, err := r.count()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		form, err := r.byte()
+		if err != nil {
+			return err
+		}
+		if form != 0x60 {
+			return xerrors.Errorf("unknown type form 0x%x", form)
+		}
+		var ft FuncType
+		if ft.Params, err = r.valueTypes(); err != nil {
+			return err
+		}
+		if ft.Results, err = r.valueTypes(); err != nil {
+			return err
+		}
+		if len(ft.Results) > 1 {
+			return xerrors.New("multiple results are not supported")
+		}
+		m.Types = append(m.Types, ft)
+	}
+	return nil
+}
+
+func (m *Module) decodeImports(r *reader) error {
+	n, err := r.count()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		var imp Import
+		if imp.Module, err = r.name(); err != nil {
+			return err
+		}
+		if imp.Name, err = r.name(); err != nil {
+			return err
+		}
+		kind, err := r.byte()
+		if err != nil {
+			return err
+		}
+		if kind != ExportFunction {
+			return xerrors.Errorf("%s.%s: only functions can be imported",
+				imp.Module, imp.Name)
+		}
+		if imp.Type, err = r.u32(); err != nil {
+			return err
+		}
+		if int(imp.Type) >= len(m.Types) {
+			return xerrors.Errorf("%s.%s: unknown type %d", imp.Module,
+				imp.Name, imp.Type)
+		}
+		m.Imports = append(m.Imports, imp)
+	}
+	return nil
+}
+
+func (m *Module) decodeFunctions(r *reader) ([]uint32, error) {
+	n, err := r.count()
+	if err != nil {
+		return nil, err
+	}
+	types := make([]uint32, n)
+	for i := range types {
+		if types[i], err = r.u32(); err != nil {
+			return nil, err
+		}
+		if int(types[i]) >= len(m.Types) {
+			return nil, xerrors.Errorf("unknown type %d", types[i])
+		}
+	}
+	return types, nil
+}
+
+func decodeSingleLimits(r *reader, table bool) (*Limits, error) {
+	n, err := r.count()
+	if err != nil {
+		return nil, err
+	}
+	if n != 1 {
+		return nil, xerrors.New("only one table or memory is supported")
+	}
+	if table {
+		elemType, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		if elemType != 0x70 {
+			return nil, xerrors.Errorf("unknown element type 0x%x", elemType)
+		}
+	}
+	return r.limits()
+}
+
+func (m *Module) decodeGlobals(r *reader) error {
+	n, err := r.count()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		var g Global
+		if g.Type, err = r.valueType(); err != nil {
+			return err
+		}
+		mut, err := r.byte()
+		if err != nil {
+			return err
+		}
+		if mut > 1 {
+			return xerrors.Errorf("invalid mutability 0x%x", mut)
+		}
+		g.Mutable = mut == 1
+		if g.Init, err = m.constExpr(r); err != nil {
+			return err
+		}
+		m.Globals = append(m.Globals, g)
+	}
+	return nil
+}
+
+func (m *Module) decodeExports(r *reader) error {
+	n, err := r.count()
+	if err != nil {
+		return err
+	}
+	names := make(map[string]bool)
+	for i := 0; i < n; i++ {
+		var e Export
+		if e.Name, err = r.name(); err != nil {
+			return err
+		}
+		if names[e.Name] {
+			return xerrors.Errorf("duplicate export %s", e.Name)
+		}
+		names[e.Name] = true
+		if e.Kind, err = r.byte(); err != nil {
+			return err
+		}
+		if e.Index, err = r.u32(); err != nil {
+			return err
+		}
+		var max int
+		switch e.Kind {
+		case ExportFunction:
+			// The code section comes later, but the function section
+			// gives the number of functions.
+			max = -1
+		case ExportTable:
+			max = boolToInt(m.Table != nil)
+		case ExportMemory:
+			max = boolToInt(m.Memory != nil)
+		case ExportGlobal:
+			max = len(m.Globals)
+		default:
+			return xerrors.Errorf("unknown export kind 0x%x", e.Kind)
+		}
+		if max >= 0 && int(e.Index) >= max {
+			return xerrors.Errorf("export %s: unknown index %d", e.Name,
+				e.Index)
+		}
+		m.Exports = append(m.Exports, e)
+	}
+	return nil
+}
+
+func (m *Module) decodeStart(r *reader) error {
+	idx, err := r.u32()
+	if err != nil {
+		return err
+	}
+	m.Start = &idx
+	return nil
+}
+
+func (m *Module) decodeElements(r *reader) error {
+	n, err := r.count()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		table, err := r.u32()
+		if err != nil {
+			return err
+		}
+		if table != 0 || m.Table == nil {
+			return xerrors.New("element segment without table")
+		}
+		offset, err := m.constExpr(r)
+		if err != nil {
+			return err
+		}
+		count, err := r.count()
+		if err != nil {
+			return err
+		}
+		seg := Segment{Offset: uint32(offset), Funcs: make([]uint32, count)}
+		for j := range seg.Funcs {
+			if seg.Funcs[j], err = r.u32(); err != nil {
+				return err
+			}
+		}
+		m.Elements = append(m.Elements, seg)
+	}
+	return nil
+}
+
+func (m *Module) decodeCode(r *reader, funcTypes []uint32) error {
+	n, err := r.count()
+	if err != nil {
+		return err
+	}
+	if n != len(funcTypes) {
+		return xerrors.New("number of functions and bodies differ")
+	}
+	// All the functions must be known to check the calls.
+	m.Functions = make([]Function, n)
+	for i := range m.Functions {
+		m.Functions[i].Type = funcTypes[i]
+	}
+	for i := range m.Functions {
+		size, err := r.u32()
+		if err != nil {
+			return err
+		}
+		body, err := r.bytes(int(size))
+		if err != nil {
+			return err
+		}
+		if err = m.decodeBody(&m.Functions[i], &reader{buf: body}); err != nil {
+			return xerrors.Errorf("function %d: %v", len(m.Imports)+i, err)
+		}
+	}
+	return nil
+}
+
+func (m *Module) decodeBody(f *Function, r *reader) error {
+	n, err := r.count()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		count, err := r.u32()
+		if err != nil {
+			return err
+		}
+		vt, err := r.valueType()
+		if err != nil {
+			return err
+		}
+		if len(f.Locals)+int(count) > maxItems ||
+			m.numLocals+int(count) > maxLocals {
+			return xerrors.New("too many locals")
+		}
+		m.numLocals += int(count)
+		for j := uint32(0); j < count; j++ {
+			f.Locals = append(f.Locals, vt)
+		}
+	}
+	numLocals := len(m.Types[f.Type].Params) + len(f.Locals)
+	f.code, f.tables, err = m.compile(r, numLocals)
+	return err
+}
+
+func (m *Module) decodeData(r *reader) error {
+	n, err := r.count()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		mem, err := r.u32()
+		if err != nil {
+			return err
+		}
+		if mem != 0 || m.Memory == nil {
+			return xerrors.New("data segment without memory")
+		}
+		offset, err := m.constExpr(r)
+		if err != nil {
+			return err
+		}
+		size, err := r.u32()
+		if err != nil {
+			return err
+		}
+		data, err := r.bytes(int(size))
+		if err != nil {
+			return err
+		}
+		m.Data = append(m.Data, Segment{Offset: uint32(offset), Data: data})
+	}
+	return nil
+}
+
+// constExpr reads a constant expression. As globals cannot be imported,
+// global.get can only refer to an already defined global.
+func (m *Module) constExpr(r *reader) (uint64, error) {
+	op, err := r.byte()
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	switch op {
+	case opI32Const:
+		i, err := r.s32()
+		if err != nil {
+			return 0, err
+		}
+		v = uint64(uint32(i))
+	case opI64Const:
+		i, err := r.s64()
+		if err != nil {
+			return 0, err
+		}
+		v = uint64(i)
+	case opGlobalGet:
+		idx, err := r.u32()
+		if err != nil {
+			return 0, err
+		}
+		if int(idx) >= len(m.Globals) {
+			return 0, xerrors.Errorf("unknown global %d", idx)
+		}
+		v = m.Globals[idx].Init
+	default:
+		return 0, xerrors.Errorf("invalid constant expression 0x%x", op)
+	}
+	end, err := r.byte()
+	if err != nil {
+		return 0, err
+	}
+	if end != opEnd {
+		return 0, xerrors.New("constant expression without end")
+	}
+	return v, nil
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// reader decodes the primitive values of the binary format.
+type reader struct {
+	buf []byte
+	pos int
+}
+
+func (r *reader) byte() (byte, error) {
+	if r.pos >= len(r.buf) {
+		return 0, xerrors.New("unexpected end")
+	}
+	b := r.buf[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *reader) bytes(n int) ([]byte, error) {
+	if n < 0 || n > len(r.buf)-r.pos {
+		return nil, xerrors.New("unexpected end")
+	}
+	b := r.buf[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+func (r *reader) uleb(size uint) (uint64, error) {
+	var result uint64
+	var shift uint
+	for i := uint(0); ; i++ {
+		if i >= (size+6)/7 {
+			return 0, xerrors.New("integer too long")
+		}
+		b, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		result |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		shift += 7
+	}
+	if size < 64 && result >= 1<<size {
+		return 0, xerrors.New("integer too large")
+	}
+	return result, nil
+}
+
+func (r *reader) sleb(size uint) (int64, error) {
+	var result int64
+	var shift uint
+	for i := uint(0); ; i++ {
+		if i >= (size+6)/7 {
+			return 0, xerrors.New("integer too long")
+		}
+		b, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		result |= int64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			if shift < 64 && b&0x40 != 0 {
+				result |= -1 << shift
+			}
+			break
+		}
+	}
+	return result, nil
+}
+
+func (r *reader) u32() (uint32, error) {
+	v, err := r.uleb(32)
+	return uint32(v), err
+}
+
+func (r *reader) s32() (int32, error) {
+	v, err := r.sleb(32)
+	return int32(v), err
+}
+
+func (r *reader) s64() (int64, error) {
+	return r.sleb(64)
+}
+
+// count reads the length of a vector.
+func (r *reader) count() (int, error) {
+	n, err := r.u32()
+	if err != nil {
+		return 0, err
+	}
+	if n > maxItems {
+		return 0, xerrors.New("too many items")
+	}
+	return int(n), nil
+}
+
+func (r *reader) name() (string, error) {
+	n, err := r.u32()
+	if err != nil {
+		return "", err
+	}
+	b, err := r.bytes(int(n))
+	return string(b), err
+}
+
+func (r *reader) valueType() (ValueType, error) {
+	b, err := r.byte()
+	if err != nil {
+		return 0, err
+	}
+	switch ValueType(b) {
+	case I32, I64:
+		return ValueType(b), nil
+	case 0x7d, 0x7c:
+		return 0, xerrors.New("floating point numbers are not supported")
+	}
+	return 0, xerrors.Errorf("unknown value type 0x%x", b)
+}
+
+func (r *reader) valueTypes() ([]ValueType, error) {
+	n, err := r.count()
+	if err != nil {
+		return nil, err
+	}
+	vts := make([]ValueType, n)
+	for i := range vts {
+		if vts[i], err = r.valueType(); err != nil {
+			return nil, err
+		}
+	}
+	return vts, nil
+}
+
+func (r *reader) limits() (*Limits, error) {
+	flag, err := r.byte()
+	if err != nil {
+		return nil, err
+	}
+	if flag > 1 {
+		return nil, xerrors.Errorf("invalid limits flag 0x%x", flag)
+	}
+	l := &Limits{HasMax: flag == 1}
+	if l.Min, err = r.u32(); err != nil {
+		return nil, err
+	}
+	if l.HasMax {
+		if l.Max, err = r.u32(); err != nil {
+			return nil, err
+		}
+		if l.Max < l.Min {
+			return nil, xerrors.New("maximum is smaller than minimum")
+		}
+	}
+	return l, nil
+}
@@ -0,0 +1,327 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// The helpers build modules in the binary format.
+
+func uleb(v uint64) []byte {
+	var buf []byte
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			buf = append(buf, b|0x80)
+		} else {
+			return append(buf, b)
+		}
+	}
+}
+
+func sleb(v int64) []byte {
+	var buf []byte
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && b&0x40 == 0) || (v == -1 && b&0x40 != 0) {
+			return append(buf, b)
+		}
+		buf = append(buf, b|0x80)
+	}
+}
+
+func cat(parts ...[]byte) []byte {
+	var buf []byte
+	for _, p := range parts {
+		buf = append(buf, p...)
+	}
+	return buf
+}
+
+func vec(items ...[]byte) []byte {
+	return cat(uleb(uint64(len(items))), cat(items...))
+}
+
+func str(s string) []byte {
+	return cat(uleb(uint64(len(s))), []byte(s))
+}
+
+func section(id byte, items ...[]byte) []byte {
+	content := vec(items...)
+	return cat([]byte{id}, uleb(uint64(len(content))), content)
+}
+
+func funcType(params, results []byte) []byte {
+	return cat([]byte{0x60}, uleb(uint64(len(params))), params,
+		uleb(uint64(len(results))), results)
+}
+
+func body(locals []byte, code ...byte) []byte {
+	b := cat(locals, code, []byte{opEnd})
+	return cat(uleb(uint64(len(b))), b)
+}
+
+func noLocals() []byte {
+	return vec()
+}
+
+func module(sections ...[]byte) []byte {
+	return cat([]byte{0, 'a', 's', 'm', 1, 0, 0, 0}, cat(sections...))
+}
+
+var (
+	i32 = byte(I32)
+	i64 = byte(I64)
+)
+
+func instantiate(t *testing.T, buf []byte, imports Imports) *Instance {
+	m, err := Decode(buf)
+	require.NoError(t, err)
+	in, err := Instantiate(m, imports, 1e6)
+	require.NoError(t, err)
+	return in
+}
+
+func TestArithmetic(t *testing.T) {
+	buf := module(
+		section(sectionType, funcType([]byte{i32, i32}, []byte{i32}),
+			funcType([]byte{i64}, []byte{i64})),
+		section(sectionFunction, uleb(0), uleb(0), uleb(1)),
+		section(sectionExport,
+			cat(str("add"), []byte{ExportFunction}, uleb(0)),
+			cat(str("div"), []byte{ExportFunction}, uleb(1)),
+			cat(str("fac"), []byte{ExportFunction}, uleb(2))),
+		section(sectionCode,
+			body(noLocals(), opLocalGet, 0, opLocalGet, 1, opI32Add),
+			body(noLocals(), opLocalGet, 0, opLocalGet, 1, opI32DivS),
+			// fac(n) = n <= 1 ? 1 : n * fac(n-1)
+			body(noLocals(), cat([]byte{
+				opLocalGet, 0, opI64Const, 1, opI64LeS,
+				opIf, i64, opI64Const, 1,
+				opElse, opLocalGet, 0, opLocalGet, 0, opI64Const, 1,
+				opI64Sub, opCall, 2, opI64Mul,
+				opEnd})...)))
+	in := instantiate(t, buf, nil)
+
+	res, err := in.Call("add", 40, 2)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{42}, res)
+	res, err = in.Call("add", 0xffffffff, 2)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{1}, res)
+
+	res, err = in.Call("div", uint64(uint32(0xfffffff6)), 3)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{uint64(uint32(0xfffffffd))}, res)
+	_, err = in.Call("div", 1, 0)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "divide by zero")
+
+	res, err = in.Call("fac", 20)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{2432902008176640000}, res)
+
+	_, err = in.Call("add", 1)
+	require.Error(t, err)
+	_, err = in.Call("sub", 1, 2)
+	require.Error(t, err)
+}
+
+func TestLoopAndBudget(t *testing.T) {
+	// sum(n) adds the numbers from 1 to n in a loop.
+	buf := module(
+		section(sectionType, funcType([]byte{i32}, []byte{i32})),
+		section(sectionFunction, uleb(0)),
+		section(sectionExport,
+			cat(str("sum"), []byte{ExportFunction}, uleb(0))),
+		section(sectionCode,
+			body(vec(cat(uleb(1), []byte{i32})),
+				opBlock, blockTypeEmpty,
+				opLoop, blockTypeEmpty,
+				opLocalGet, 0, opI32Eqz, opBrIf, 1,
+				opLocalGet, 1, opLocalGet, 0, opI32Add, opLocalSet, 1,
+				opLocalGet, 0, opI32Const, 1, opI32Sub, opLocalSet, 0,
+				opBr, 0,
+				opEnd, opEnd,
+				opLocalGet, 1)))
+	m, err := Decode(buf)
+	require.NoError(t, err)
+
+	in, err := Instantiate(m, nil, 1e5)
+	require.NoError(t, err)
+	res, err := in.Call("sum", 100)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{5050}, res)
+	used := in.Used()
+	require.True(t, used > 100)
+
+	// The same execution always uses the same budget.
+	in, err = Instantiate(m, nil, 1e5)
+	require.NoError(t, err)
+	_, err = in.Call("sum", 100)
+	require.NoError(t, err)
+	require.Equal(t, used, in.Used())
+
+	_, err = in.Call("sum", 1e6)
+	require.Equal(t, ErrOutOfBudget, err)
+}
+
+func TestMemoryAndHost(t *testing.T) {
+	// The module stores the argument in memory and gives the address to
+	// the host.
+	buf := module(
+		section(sectionType, funcType([]byte{i32, i32}, nil),
+			funcType([]byte{i64}, []byte{i32})),
+		section(sectionImport,
+			cat(str("env"), str("output"), []byte{ExportFunction}, uleb(0))),
+		section(sectionFunction, uleb(1)),
+		section(sectionMemory, cat([]byte{1}, uleb(1), uleb(2))),
+		section(sectionExport,
+			cat(str("run"), []byte{ExportFunction}, uleb(1))),
+		section(sectionCode,
+			body(noLocals(),
+				opI32Const, 16, opLocalGet, 0, opI64Store, 3, 0,
+				opI32Const, 16, opI32Const, 8, opCall, 0,
+				opI32Const, 1, opMemoryGrow, 0, opDrop,
+				opI32Const, 1, opMemoryGrow, 0)),
+		section(sectionData, cat(uleb(0), []byte{opI32Const}, sleb(0),
+			[]byte{opEnd}, str("hi"))))
+
+	var output []byte
+	imports := Imports{"env": {"output": HostFunc{
+		Type: FuncType{Params: []ValueType{I32, I32}},
+		Fn: func(in *Instance, args []uint64) ([]uint64, error) {
+			var err error
+			output, err = in.Read(uint32(args[0]), uint32(args[1]))
+			return nil, err
+		},
+	}}}
+	in := instantiate(t, buf, imports)
+	res, err := in.Call("run", 0x0102030405060708)
+	require.NoError(t, err)
+	require.Equal(t, []byte{8, 7, 6, 5, 4, 3, 2, 1}, output)
+	// The memory can only grow to its maximum of 2 pages.
+	require.Equal(t, []uint64{0xffffffff}, res)
+
+	data, err := in.Read(0, 2)
+	require.NoError(t, err)
+	require.Equal(t, []byte("hi"), data)
+	_, err = in.Read(2*PageSize-1, 2)
+	require.Error(t, err)
+
+	_, err = Instantiate(in.module, nil, 1e6)
+	require.Error(t, err)
+	imports["env"]["output"] = HostFunc{Type: FuncType{}}
+	_, err = Instantiate(in.module, imports, 1e6)
+	require.Error(t, err)
+}
+
+func TestTableAndBranches(t *testing.T) {
+	// pick(i) calls the function i of the table, or returns i if it is
+	// not 0 or 1.
+	buf := module(
+		section(sectionType, funcType(nil, []byte{i32}),
+			funcType([]byte{i32}, []byte{i32})),
+		section(sectionFunction, uleb(0), uleb(0), uleb(1)),
+		section(sectionTable, cat([]byte{0x70, 0}, uleb(3))),
+		section(sectionExport,
+			cat(str("pick"), []byte{ExportFunction}, uleb(2))),
+		section(sectionElement, cat(uleb(0), []byte{opI32Const}, sleb(0),
+			[]byte{opEnd}, vec(uleb(0), uleb(1)))),
+		section(sectionCode,
+			body(noLocals(), opI32Const, 10),
+			body(noLocals(), opI32Const, 20),
+			body(noLocals(),
+				opBlock, blockTypeEmpty,
+				opLocalGet, 0, opLocalGet, 0, opBrTable, 2, 0, 0, 1,
+				opEnd,
+				opLocalGet, 0, opCallIndirect, 0, 0)))
+	in := instantiate(t, buf, nil)
+
+	res, err := in.Call("pick", 0)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{10}, res)
+	res, err = in.Call("pick", 1)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{20}, res)
+	// br_table leaves the function, which returns the argument.
+	res, err = in.Call("pick", 5)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{5}, res)
+}
+
+func TestDecodeErrors(t *testing.T) {
+	_, err := Decode([]byte("not wasm"))
+	require.Error(t, err)
+
+	// Floating point numbers are refused.
+	_, err = Decode(module(section(sectionType,
+		funcType([]byte{0x7c}, nil))))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "floating point")
+
+	noArgs := section(sectionType, funcType(nil, nil))
+	oneFunc := section(sectionFunction, uleb(0))
+	// f32.add
+	_, err = Decode(module(noArgs, oneFunc, section(sectionCode,
+		body(noLocals(), 0x92))))
+	require.Error(t, err)
+	// Unknown local, function and label.
+	_, err = Decode(module(noArgs, oneFunc, section(sectionCode,
+		body(noLocals(), opLocalGet, 0, opDrop))))
+	require.Error(t, err)
+	_, err = Decode(module(noArgs, oneFunc, section(sectionCode,
+		body(noLocals(), opCall, 1))))
+	require.Error(t, err)
+	_, err = Decode(module(noArgs, oneFunc, section(sectionCode,
+		body(noLocals(), opBr, 1))))
+	require.Error(t, err)
+	// Memory access without memory.
+	_, err = Decode(module(noArgs, oneFunc, section(sectionCode,
+		body(noLocals(), opI32Const, 0, opI32Load, 2, 0, opDrop))))
+	require.Error(t, err)
+	// Missing end of block.
+	_, err = Decode(module(noArgs, oneFunc, section(sectionCode,
+		body(noLocals(), opBlock, blockTypeEmpty))))
+	require.Error(t, err)
+	// Missing code.
+	_, err = Decode(module(noArgs, oneFunc))
+	require.Error(t, err)
+
+	_, err = Decode(module(noArgs, oneFunc, section(sectionCode,
+		body(noLocals(), opUnreachable))))
+	require.NoError(t, err)
+}
+
+func TestTraps(t *testing.T) {
+	buf := module(
+		section(sectionType, funcType(nil, nil)),
+		section(sectionFunction, uleb(0), uleb(0), uleb(0)),
+		section(sectionMemory, cat([]byte{0}, uleb(1))),
+		section(sectionExport,
+			cat(str("unreachable"), []byte{ExportFunction}, uleb(0)),
+			cat(str("recurse"), []byte{ExportFunction}, uleb(1)),
+			cat(str("oob"), []byte{ExportFunction}, uleb(2))),
+		section(sectionCode,
+			body(noLocals(), opUnreachable),
+			body(noLocals(), opCall, 1),
+			body(noLocals(), opI32Const, 0x7f, opI32Load, 2, 0x80, 0x80,
+				0x04, opDrop)))
+	in := instantiate(t, buf, nil)
+
+	_, err := in.Call("unreachable")
+	require.Equal(t, ErrUnreachable, err)
+	_, err = in.Call("recurse")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "call stack exhausted")
+	_, err = in.Call("oob")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "out of bounds")
+
+	// The instance can still be used after a trap.
+	_, err = in.Call("unreachable")
+	require.Equal(t, ErrUnreachable, err)
+}